@@ -6,4 +6,21 @@
 // @ts-ignore: Unused imports
 import { Create as $Create } from "@wailsio/runtime";
 
-Object.freeze($Create.Events);
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as services$0 from "../../../../../wmail/services/models.js";
+
+function configure() {
+    Object.freeze(Object.assign($Create.Events, {
+        "mail:connectivity": $$createType0,
+        "mail:folder-synced": $$createType1,
+        "mail:rule-notification": $$createType2,
+    }));
+}
+
+// Private type creation functions
+const $$createType0 = services$0.ConnectivityState.createFrom;
+const $$createType1 = services$0.FolderSynced.createFrom;
+const $$createType2 = services$0.RuleNotification.createFrom;
+
+configure();
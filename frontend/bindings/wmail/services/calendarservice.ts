@@ -0,0 +1,76 @@
+// Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
+// This file is automatically generated. DO NOT EDIT
+
+/**
+ * CalendarService keeps the events the user accepted from invitations so
+ * new invitations can show what they clash with
+ * @module
+ */
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Create } from "@wailsio/runtime";
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as $models from "./models.js";
+
+/**
+ * DeleteEvent removes an event from the local calendar. Nothing is sent
+ * to the organizer.
+ */
+export function DeleteEvent(id: string): $CancellablePromise<void> {
+    return $Call.ByID(166049918, id);
+}
+
+/**
+ * ExportICS returns every event as an iCalendar file
+ */
+export function ExportICS(): $CancellablePromise<string> {
+    return $Call.ByID(1691345044);
+}
+
+/**
+ * GetConflicts returns the timed events overlapping start to end
+ */
+export function GetConflicts(start: string, end: string): $CancellablePromise<($models.EventOccurrence | null)[]> {
+    return $Call.ByID(3152459066, start, end).then(($result: any) => {
+        return $$createType2($result);
+    });
+}
+
+/**
+ * GetEvent returns one event
+ */
+export function GetEvent(id: string): $CancellablePromise<$models.CalendarEvent | null> {
+    return $Call.ByID(2286362381, id).then(($result: any) => {
+        return $$createType4($result);
+    });
+}
+
+/**
+ * GetEvents returns every stored event
+ */
+export function GetEvents(): $CancellablePromise<($models.CalendarEvent | null)[]> {
+    return $Call.ByID(100045658).then(($result: any) => {
+        return $$createType5($result);
+    });
+}
+
+/**
+ * GetOccurrences returns the occurrences of all events between from and
+ * to (RFC3339 or YYYY-MM-DD), with recurrences expanded
+ */
+export function GetOccurrences($from: string, to: string): $CancellablePromise<($models.EventOccurrence | null)[]> {
+    return $Call.ByID(2644340903, $from, to).then(($result: any) => {
+        return $$createType2($result);
+    });
+}
+
+// Private type creation functions
+const $$createType0 = $models.EventOccurrence.createFrom;
+const $$createType1 = $Create.Nullable($$createType0);
+const $$createType2 = $Create.Array($$createType1);
+const $$createType3 = $models.CalendarEvent.createFrom;
+const $$createType4 = $Create.Nullable($$createType3);
+const $$createType5 = $Create.Array($$createType4);
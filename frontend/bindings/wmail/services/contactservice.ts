@@ -0,0 +1,208 @@
+// Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
+// This file is automatically generated. DO NOT EDIT
+
+/**
+ * ContactService manages the address book and recipient suggestions
+ * @module
+ */
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Create } from "@wailsio/runtime";
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as $models from "./models.js";
+
+/**
+ * AddContactToAddressBook uploads a local contact to a profile's address
+ * book; from then on it is kept in sync
+ */
+export function AddContactToAddressBook(profileID: string, contactID: string): $CancellablePromise<void> {
+    return $Call.ByID(1416957112, profileID, contactID);
+}
+
+/**
+ * CreateGroup adds a contact group
+ */
+export function CreateGroup(name: string): $CancellablePromise<$models.ContactGroup | null> {
+    return $Call.ByID(1263963294, name).then(($result: any) => {
+        return $$createType1($result);
+    });
+}
+
+/**
+ * DeleteCardDAVProfile stops syncing an address book and removes the
+ * contacts that came from it
+ */
+export function DeleteCardDAVProfile(id: string): $CancellablePromise<void> {
+    return $Call.ByID(2233162326, id);
+}
+
+/**
+ * DeleteContact removes a contact
+ */
+export function DeleteContact(id: string): $CancellablePromise<void> {
+    return $Call.ByID(349451408, id);
+}
+
+/**
+ * DeleteGroup removes a group without deleting its contacts
+ */
+export function DeleteGroup(id: string): $CancellablePromise<void> {
+    return $Call.ByID(1320226367, id);
+}
+
+/**
+ * DiscoverAddressBooks lists the address books a profile's URL and
+ * credentials give access to
+ */
+export function DiscoverAddressBooks(profile: $models.CardDAVProfile | null): $CancellablePromise<($models.CardDAVAddressBook | null)[]> {
+    return $Call.ByID(1803905398, profile).then(($result: any) => {
+        return $$createType4($result);
+    });
+}
+
+/**
+ * ExportVCards writes every contact as vCard 3.0 or 4.0
+ */
+export function ExportVCards(version: string): $CancellablePromise<string> {
+    return $Call.ByID(1574819872, version);
+}
+
+/**
+ * GetCardDAVProfiles returns the configured address book syncs
+ */
+export function GetCardDAVProfiles(): $CancellablePromise<($models.CardDAVProfile | null)[]> {
+    return $Call.ByID(1949137704).then(($result: any) => {
+        return $$createType7($result);
+    });
+}
+
+/**
+ * GetContact returns one contact
+ */
+export function GetContact(id: string): $CancellablePromise<$models.Contact | null> {
+    return $Call.ByID(1312806815, id).then(($result: any) => {
+        return $$createType9($result);
+    });
+}
+
+/**
+ * GetContacts returns every contact
+ */
+export function GetContacts(): $CancellablePromise<($models.Contact | null)[]> {
+    return $Call.ByID(444655748).then(($result: any) => {
+        return $$createType10($result);
+    });
+}
+
+/**
+ * GetGroupMembers returns the contacts of a group
+ */
+export function GetGroupMembers(groupID: string): $CancellablePromise<($models.Contact | null)[]> {
+    return $Call.ByID(1577239603, groupID).then(($result: any) => {
+        return $$createType10($result);
+    });
+}
+
+/**
+ * GetGroups returns every contact group
+ */
+export function GetGroups(): $CancellablePromise<($models.ContactGroup | null)[]> {
+    return $Call.ByID(1100261001).then(($result: any) => {
+        return $$createType11($result);
+    });
+}
+
+/**
+ * ImportVCards adds or updates contacts from vCard 3.0 or 4.0 data.
+ * Cards are matched to existing contacts by UID, then by email address.
+ */
+export function ImportVCards(data: string): $CancellablePromise<$models.ContactImportResult | null> {
+    return $Call.ByID(2808790751, data).then(($result: any) => {
+        return $$createType13($result);
+    });
+}
+
+/**
+ * RefreshFromMail rebuilds the address statistics from the email cache now
+ */
+export function RefreshFromMail(): $CancellablePromise<void> {
+    return $Call.ByID(1420203781);
+}
+
+/**
+ * RenameGroup renames a contact group
+ */
+export function RenameGroup(id: string, name: string): $CancellablePromise<void> {
+    return $Call.ByID(1940315724, id, name);
+}
+
+/**
+ * SaveCardDAVProfile adds or updates a profile. Without an address book the
+ * first one discovered is used. Switching address books drops the contacts
+ * synced from the old one.
+ */
+export function SaveCardDAVProfile(profile: $models.CardDAVProfile | null): $CancellablePromise<$models.CardDAVProfile | null> {
+    return $Call.ByID(2582560492, profile).then(($result: any) => {
+        return $$createType6($result);
+    });
+}
+
+/**
+ * SaveContact creates a contact, or updates it when the ID is set
+ */
+export function SaveContact(contact: $models.Contact | null): $CancellablePromise<$models.Contact | null> {
+    return $Call.ByID(2221193394, contact).then(($result: any) => {
+        return $$createType9($result);
+    });
+}
+
+/**
+ * SetGroupMember adds a contact to a group or removes it
+ */
+export function SetGroupMember(groupID: string, contactID: string, member: boolean): $CancellablePromise<void> {
+    return $Call.ByID(3461316686, groupID, contactID, member);
+}
+
+/**
+ * SuggestRecipients returns the best matches for what the user typed in a
+ * recipient field. Addresses are ranked by how often and how recently they
+ * were written to or heard from; saved contacts get a bonus.
+ */
+export function SuggestRecipients(prefix: string): $CancellablePromise<($models.RecipientSuggestion | null)[]> {
+    return $Call.ByID(3015089583, prefix).then(($result: any) => {
+        return $$createType16($result);
+    });
+}
+
+/**
+ * SyncCardDAV syncs one address book both ways now
+ */
+export function SyncCardDAV(id: string): $CancellablePromise<$models.CardDAVSyncResult | null> {
+    return $Call.ByID(3039711529, id).then(($result: any) => {
+        return $$createType18($result);
+    });
+}
+
+// Private type creation functions
+const $$createType0 = $models.ContactGroup.createFrom;
+const $$createType1 = $Create.Nullable($$createType0);
+const $$createType2 = $models.CardDAVAddressBook.createFrom;
+const $$createType3 = $Create.Nullable($$createType2);
+const $$createType4 = $Create.Array($$createType3);
+const $$createType5 = $models.CardDAVProfile.createFrom;
+const $$createType6 = $Create.Nullable($$createType5);
+const $$createType7 = $Create.Array($$createType6);
+const $$createType8 = $models.Contact.createFrom;
+const $$createType9 = $Create.Nullable($$createType8);
+const $$createType10 = $Create.Array($$createType9);
+const $$createType11 = $Create.Array($$createType1);
+const $$createType12 = $models.ContactImportResult.createFrom;
+const $$createType13 = $Create.Nullable($$createType12);
+const $$createType14 = $models.RecipientSuggestion.createFrom;
+const $$createType15 = $Create.Nullable($$createType14);
+const $$createType16 = $Create.Array($$createType15);
+const $$createType17 = $models.CardDAVSyncResult.createFrom;
+const $$createType18 = $Create.Nullable($$createType17);
@@ -1,25 +1,77 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+import * as CalendarService from "./calendarservice.js";
+import * as ContactService from "./contactservice.js";
 import * as GreetService from "./greetservice.js";
 import * as MailAccountService from "./mailaccountservice.js";
 import * as MailService from "./mailservice.js";
 import * as NoteService from "./noteservice.js";
 import * as OsService from "./osservice.js";
+import * as RulesService from "./rulesservice.js";
 export {
+    CalendarService,
+    ContactService,
     GreetService,
     MailAccountService,
     MailService,
     NoteService,
-    OsService
+    OsService,
+    RulesService
 };
 
 export {
+    ARCHop,
     Account,
+    AccountCacheStats,
+    AccountLifecycleHook,
+    Address,
+    Attendee,
+    AuthVerdict,
+    AutocryptPeer,
+    CacheLimits,
+    CacheStats,
+    CalendarEvent,
+    CardDAVAddressBook,
+    CardDAVProfile,
+    CardDAVSyncResult,
+    ConnectivityState,
+    Contact,
+    ContactGroup,
+    ContactImportResult,
+    DKIMResult,
     Email,
+    EmailPage,
+    EncryptionRecommendation,
+    EventOccurrence,
     Folder,
+    FolderCacheStats,
+    FolderSynced,
+    Invitation,
+    InvitationHook,
+    MailSettings,
+    MessageSecurity,
+    MessageWarning,
+    NewMailHook,
     Note,
     NoteConfig,
     NoteFolder,
-    SendEmailRequest
+    OAuthConfig,
+    PGPKey,
+    PendingOp,
+    RecipientRecommendation,
+    RecipientSuggestion,
+    Rule,
+    RuleAction,
+    RuleCondition,
+    RuleDryRun,
+    RuleNotification,
+    SMIMECertificate,
+    SendEmailRequest,
+    SpamClue,
+    SpamExplanation,
+    UnifiedEmail,
+    UnifiedEmailPage,
+    ValidationResult,
+    ValidationStage
 } from "./models.js";
@@ -24,12 +24,45 @@ export function AddAccount(account: $models.Account | null): $CancellablePromise
 }
 
 /**
- * DeleteAccount deletes an account
+ * AuthorizeOAuth runs the browser-based OAuth flow for an account and stores the token
+ */
+export function AuthorizeOAuth(accountID: string): $CancellablePromise<void> {
+    return $Call.ByID(4214923643, accountID);
+}
+
+/**
+ * DeleteAccount deletes an account and cascades through every subsystem
+ * holding data for it, see RegisterLifecycleHook
  */
 export function DeleteAccount(id: string): $CancellablePromise<void> {
     return $Call.ByID(2548784873, id);
 }
 
+/**
+ * DeleteAccountWithExport exports an account's data before deleting it.
+ * Nothing is deleted if the export fails.
+ */
+export function DeleteAccountWithExport(id: string, exportDir: string): $CancellablePromise<string> {
+    return $Call.ByID(4021419915, id, exportDir);
+}
+
+/**
+ * DiscoverSettings returns ranked account templates for an email address
+ */
+export function DiscoverSettings(email: string): $CancellablePromise<($models.Account | null)[]> {
+    return $Call.ByID(740375631, email).then(($result: any) => {
+        return $$createType2($result);
+    });
+}
+
+/**
+ * ExportAccount writes the account settings and all local data to a new
+ * directory inside exportDir and returns its path
+ */
+export function ExportAccount(id: string, exportDir: string): $CancellablePromise<string> {
+    return $Call.ByID(4199550578, id, exportDir);
+}
+
 /**
  * GetAccount returns an account by ID
  */
@@ -57,6 +90,39 @@ export function GetFolders(accountID: string): $CancellablePromise<($models.Fold
     });
 }
 
+/**
+ * GetOAuthProviders returns the built-in OAuth provider presets
+ */
+export function GetOAuthProviders(): $CancellablePromise<$models.OAuthConfig[]> {
+    return $Call.ByID(1342355668).then(($result: any) => {
+        return $$createType7($result);
+    });
+}
+
+/**
+ * RegisterLifecycleHook adds a hook that runs on account export and deletion
+ */
+export function RegisterLifecycleHook(hook: $models.AccountLifecycleHook): $CancellablePromise<void> {
+    return $Call.ByID(3178307031, hook);
+}
+
+/**
+ * SetAuthServID sets the authserv-id of the account's server, whose
+ * Authentication-Results are trusted. Empty means guess it from the
+ * account's server names.
+ */
+export function SetAuthServID(accountID: string, authServID: string): $CancellablePromise<void> {
+    return $Call.ByID(1348208896, accountID, authServID);
+}
+
+/**
+ * SetCacheLimits updates an account's cache limits.
+ * They take effect on the next eviction pass.
+ */
+export function SetCacheLimits(accountID: string, limits: $models.CacheLimits): $CancellablePromise<void> {
+    return $Call.ByID(247701647, accountID, limits);
+}
+
 /**
  * SyncFolders fetches and saves folders for an account
  */
@@ -71,6 +137,16 @@ export function UpdateAccount(account: $models.Account | null): $CancellableProm
     return $Call.ByID(975806943, account);
 }
 
+/**
+ * ValidateAccount checks that an account can connect and log in to both servers.
+ * It does not touch stored accounts, so it can be called before AddAccount.
+ */
+export function ValidateAccount(account: $models.Account | null): $CancellablePromise<$models.ValidationResult | null> {
+    return $Call.ByID(23541306, account).then(($result: any) => {
+        return $$createType9($result);
+    });
+}
+
 // Private type creation functions
 const $$createType0 = $models.Account.createFrom;
 const $$createType1 = $Create.Nullable($$createType0);
@@ -78,3 +154,7 @@ const $$createType2 = $Create.Array($$createType1);
 const $$createType3 = $models.Folder.createFrom;
 const $$createType4 = $Create.Nullable($$createType3);
 const $$createType5 = $Create.Array($$createType4);
+const $$createType6 = $models.OAuthConfig.createFrom;
+const $$createType7 = $Create.Array($$createType6);
+const $$createType8 = $models.ValidationResult.createFrom;
+const $$createType9 = $Create.Nullable($$createType8);
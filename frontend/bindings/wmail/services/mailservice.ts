@@ -15,28 +15,307 @@ import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Cr
 import * as $models from "./models.js";
 
 /**
- * GetEmail retrieves a specific email with body, with cache support
+ * DeleteEmail permanently deletes an email
+ */
+export function DeleteEmail(accountID: string, folder: string, uid: number): $CancellablePromise<void> {
+    return $Call.ByID(2751214393, accountID, folder, uid);
+}
+
+/**
+ * DeletePGPKey removes a key from the keyring
+ */
+export function DeletePGPKey(fingerprint: string): $CancellablePromise<void> {
+    return $Call.ByID(2859618637, fingerprint);
+}
+
+/**
+ * DeleteSMIMECertificate removes an identity or trusted certificate
+ */
+export function DeleteSMIMECertificate(fingerprint: string): $CancellablePromise<void> {
+    return $Call.ByID(2110281439, fingerprint);
+}
+
+/**
+ * ExplainSpamScore scores a message with the current model and lists the
+ * tokens that pushed the score towards spam and towards good mail
+ */
+export function ExplainSpamScore(accountID: string, folder: string, uid: number): $CancellablePromise<$models.SpamExplanation | null> {
+    return $Call.ByID(2002935332, accountID, folder, uid).then(($result: any) => {
+        return $$createType1($result);
+    });
+}
+
+/**
+ * ExportPGPKey returns a key armored. Secret keys are exported still
+ * protected by their passphrase.
+ */
+export function ExportPGPKey(fingerprint: string, includeSecret: boolean): $CancellablePromise<string> {
+    return $Call.ByID(483432596, fingerprint, includeSecret);
+}
+
+/**
+ * GeneratePGPKey creates a key pair for an address, protected by passphrase
+ */
+export function GeneratePGPKey(name: string, email: string, passphrase: string): $CancellablePromise<$models.PGPKey | null> {
+    return $Call.ByID(572265937, name, email, passphrase).then(($result: any) => {
+        return $$createType3($result);
+    });
+}
+
+/**
+ * GetAutocryptPeers lists the Autocrypt state learned for an account
+ */
+export function GetAutocryptPeers(accountID: string): $CancellablePromise<($models.AutocryptPeer | null)[]> {
+    return $Call.ByID(4104650114, accountID).then(($result: any) => {
+        return $$createType6($result);
+    });
+}
+
+/**
+ * GetCacheStats reports how much space the cache uses per account and folder
+ */
+export function GetCacheStats(): $CancellablePromise<$models.CacheStats | null> {
+    return $Call.ByID(412983373).then(($result: any) => {
+        return $$createType8($result);
+    });
+}
+
+/**
+ * GetConnectivityState returns the last known connectivity state
+ */
+export function GetConnectivityState(): $CancellablePromise<$models.ConnectivityState> {
+    return $Call.ByID(3679686076).then(($result: any) => {
+        return $$createType9($result);
+    });
+}
+
+/**
+ * GetEmail retrieves a specific email with body, with cache support, and
+ * lets invitation hooks annotate a meeting request it carries
  */
 export function GetEmail(accountID: string, folder: string, uid: number): $CancellablePromise<$models.Email | null> {
     return $Call.ByID(55669524, accountID, folder, uid).then(($result: any) => {
-        return $$createType1($result);
+        return $$createType11($result);
     });
 }
 
 /**
- * GetEmails retrieves emails from a folder with cache support
- * forceRefresh: if true, bypass cache and fetch from server
+ * GetEmailSource returns the raw RFC 5322 source of an email,
+ * downloading it first if it is not stored locally
+ */
+export function GetEmailSource(accountID: string, folder: string, uid: number): $CancellablePromise<string> {
+    return $Call.ByID(2699096721, accountID, folder, uid);
+}
+
+/**
+ * GetEmails returns a page of a folder, newest first, with cache support.
+ * cursor is empty for the first page and NextCursor of the previous page after that.
+ * forceRefresh: if true, sync the folder with the server before reading it
  */
-export function GetEmails(accountID: string, folder: string, page: number, pageSize: number, forceRefresh: boolean): $CancellablePromise<($models.Email | null)[]> {
-    return $Call.ByID(2688068389, accountID, folder, page, pageSize, forceRefresh).then(($result: any) => {
-        return $$createType2($result);
+export function GetEmails(accountID: string, folder: string, cursor: string, pageSize: number, forceRefresh: boolean): $CancellablePromise<$models.EmailPage | null> {
+    return $Call.ByID(2688068389, accountID, folder, cursor, pageSize, forceRefresh).then(($result: any) => {
+        return $$createType13($result);
     });
 }
 
+/**
+ * GetEncryptionRecommendation tells compose whether to offer encryption to
+ * a set of recipients, and whether to turn it on by default
+ */
+export function GetEncryptionRecommendation(accountID: string, recipients: string[]): $CancellablePromise<$models.EncryptionRecommendation | null> {
+    return $Call.ByID(628955030, accountID, recipients).then(($result: any) => {
+        return $$createType15($result);
+    });
+}
+
+/**
+ * GetMailSettings returns the reading preferences
+ */
+export function GetMailSettings(): $CancellablePromise<$models.MailSettings> {
+    return $Call.ByID(3275714958).then(($result: any) => {
+        return $$createType16($result);
+    });
+}
+
+/**
+ * GetPGPKeys lists the keys in the local keyring
+ */
+export function GetPGPKeys(): $CancellablePromise<($models.PGPKey | null)[]> {
+    return $Call.ByID(2268650003).then(($result: any) => {
+        return $$createType17($result);
+    });
+}
+
+/**
+ * GetPendingOps returns the operations not yet applied on the server
+ */
+export function GetPendingOps(): $CancellablePromise<($models.PendingOp | null)[]> {
+    return $Call.ByID(1916698545).then(($result: any) => {
+        return $$createType20($result);
+    });
+}
+
+/**
+ * GetSMIMECertificates lists S/MIME identities and trusted certificates
+ */
+export function GetSMIMECertificates(): $CancellablePromise<($models.SMIMECertificate | null)[]> {
+    return $Call.ByID(315931321).then(($result: any) => {
+        return $$createType23($result);
+    });
+}
+
+/**
+ * GetUnifiedEmails merges a folder role (inbox, sent, drafts or flagged)
+ * across every account, newest first, from the cache. Pages follow cursor,
+ * which is empty for the first page.
+ */
+export function GetUnifiedEmails(role: string, cursor: string, pageSize: number): $CancellablePromise<$models.UnifiedEmailPage | null> {
+    return $Call.ByID(2413787355, role, cursor, pageSize).then(($result: any) => {
+        return $$createType25($result);
+    });
+}
+
+/**
+ * GetUnifiedUnreadCounts returns the unread count of every unified view,
+ * keyed by role, as last reported by the servers
+ */
+export function GetUnifiedUnreadCounts(): $CancellablePromise<{ [_ in string]?: number }> {
+    return $Call.ByID(4043018861).then(($result: any) => {
+        return $$createType26($result);
+    });
+}
+
+/**
+ * ImportPGPKeys adds armored or binary public or secret keys to the keyring
+ */
+export function ImportPGPKeys(data: string): $CancellablePromise<($models.PGPKey | null)[]> {
+    return $Call.ByID(1024516292, data).then(($result: any) => {
+        return $$createType17($result);
+    });
+}
+
+/**
+ * ImportSMIMEIdentity adds a PKCS#12 (.p12/.pfx) file, given base64
+ * encoded, and unlocks it with password
+ */
+export function ImportSMIMEIdentity(data: string, password: string): $CancellablePromise<$models.SMIMECertificate | null> {
+    return $Call.ByID(2296012096, data, password).then(($result: any) => {
+        return $$createType22($result);
+    });
+}
+
+/**
+ * LockPGPKeys forgets every cached passphrase
+ */
+export function LockPGPKeys(): $CancellablePromise<void> {
+    return $Call.ByID(3752661246);
+}
+
+/**
+ * LockSMIMEIdentities forgets every cached identity password
+ */
+export function LockSMIMEIdentities(): $CancellablePromise<void> {
+    return $Call.ByID(1389159494);
+}
+
+/**
+ * MarkAsRead marks an email as read or unread
+ */
+export function MarkAsRead(accountID: string, folder: string, uid: number, read: boolean): $CancellablePromise<void> {
+    return $Call.ByID(2293755433, accountID, folder, uid, read);
+}
+
+/**
+ * MoveEmail moves an email to another folder
+ */
+export function MoveEmail(accountID: string, folder: string, uid: number, targetFolder: string): $CancellablePromise<void> {
+    return $Call.ByID(3848095951, accountID, folder, uid, targetFolder);
+}
+
+/**
+ * RegisterInvitationHook adds a hook that runs when invitations are opened or answered
+ */
+export function RegisterInvitationHook(hook: $models.InvitationHook): $CancellablePromise<void> {
+    return $Call.ByID(2817531533, hook);
+}
+
+/**
+ * RegisterNewMailHook adds a hook that runs after a sync found new messages
+ */
+export function RegisterNewMailHook(hook: $models.NewMailHook): $CancellablePromise<void> {
+    return $Call.ByID(3939459705, hook);
+}
+
+/**
+ * RespondToInvite answers a meeting request with accept, tentative or
+ * decline by mailing an iTIP REPLY to the organizer
+ */
+export function RespondToInvite(accountID: string, folder: string, uid: number, response: string): $CancellablePromise<void> {
+    return $Call.ByID(984222733, accountID, folder, uid, response);
+}
+
+/**
+ * RunCacheEviction applies all cache limits immediately
+ */
+export function RunCacheEviction(): $CancellablePromise<void> {
+    return $Call.ByID(2884284824);
+}
+
 export function SendEmail(req: $models.SendEmailRequest | null): $CancellablePromise<void> {
     return $Call.ByID(1988209338, req);
 }
 
+/**
+ * SetAutocryptPreferEncrypt sets whether our Autocrypt header asks peers to
+ * encrypt by default
+ */
+export function SetAutocryptPreferEncrypt(mutual: boolean): $CancellablePromise<void> {
+    return $Call.ByID(3095455348, mutual);
+}
+
+/**
+ * SetPassphraseTimeout sets how many minutes PGP passphrases stay cached
+ */
+export function SetPassphraseTimeout(minutes: number): $CancellablePromise<void> {
+    return $Call.ByID(1800924543, minutes);
+}
+
+/**
+ * SetSortOrder chooses whether message lists are sorted by received or sent time
+ */
+export function SetSortOrder(sortBy: string): $CancellablePromise<void> {
+    return $Call.ByID(2833658948, sortBy);
+}
+
+/**
+ * SetSpamFilter sets whether new messages scoring at least threshold are
+ * moved to Junk
+ */
+export function SetSpamFilter(autoMove: boolean, threshold: number): $CancellablePromise<void> {
+    return $Call.ByID(1786582171, autoMove, threshold);
+}
+
+/**
+ * SetStarred stars or unstars an email
+ */
+export function SetStarred(accountID: string, folder: string, uid: number, starred: boolean): $CancellablePromise<void> {
+    return $Call.ByID(201418439, accountID, folder, uid, starred);
+}
+
+/**
+ * SetVerifyDKIM sets whether DKIM signatures are also checked locally
+ */
+export function SetVerifyDKIM(enabled: boolean): $CancellablePromise<void> {
+    return $Call.ByID(1436382066, enabled);
+}
+
+/**
+ * SyncPendingOps retries the journal right away, e.g. when the UI detects the network is back
+ */
+export function SyncPendingOps(): $CancellablePromise<void> {
+    return $Call.ByID(3109228378);
+}
+
 /**
  * TestConnection tests if an account's connection works
  */
@@ -44,7 +323,56 @@ export function TestConnection(accountID: string): $CancellablePromise<void> {
     return $Call.ByID(1501221972, accountID);
 }
 
+/**
+ * TrustSMIMECertificates adds root certificates (PEM) to trust for S/MIME
+ * on top of the system ones
+ */
+export function TrustSMIMECertificates(data: string): $CancellablePromise<($models.SMIMECertificate | null)[]> {
+    return $Call.ByID(1669558979, data).then(($result: any) => {
+        return $$createType23($result);
+    });
+}
+
+/**
+ * UnlockPGPKey caches the passphrase of a secret key for the configured timeout
+ */
+export function UnlockPGPKey(fingerprint: string, passphrase: string): $CancellablePromise<void> {
+    return $Call.ByID(1301522162, fingerprint, passphrase);
+}
+
+/**
+ * UnlockSMIMEIdentity caches the password of an identity for the
+ * configured timeout
+ */
+export function UnlockSMIMEIdentity(fingerprint: string, password: string): $CancellablePromise<void> {
+    return $Call.ByID(98619291, fingerprint, password);
+}
+
 // Private type creation functions
-const $$createType0 = $models.Email.createFrom;
+const $$createType0 = $models.SpamExplanation.createFrom;
 const $$createType1 = $Create.Nullable($$createType0);
-const $$createType2 = $Create.Array($$createType1);
+const $$createType2 = $models.PGPKey.createFrom;
+const $$createType3 = $Create.Nullable($$createType2);
+const $$createType4 = $models.AutocryptPeer.createFrom;
+const $$createType5 = $Create.Nullable($$createType4);
+const $$createType6 = $Create.Array($$createType5);
+const $$createType7 = $models.CacheStats.createFrom;
+const $$createType8 = $Create.Nullable($$createType7);
+const $$createType9 = $models.ConnectivityState.createFrom;
+const $$createType10 = $models.Email.createFrom;
+const $$createType11 = $Create.Nullable($$createType10);
+const $$createType12 = $models.EmailPage.createFrom;
+const $$createType13 = $Create.Nullable($$createType12);
+const $$createType14 = $models.EncryptionRecommendation.createFrom;
+const $$createType15 = $Create.Nullable($$createType14);
+const $$createType16 = $models.MailSettings.createFrom;
+const $$createType17 = $Create.Array($$createType3);
+const $$createType18 = $models.PendingOp.createFrom;
+const $$createType19 = $Create.Nullable($$createType18);
+const $$createType20 = $Create.Array($$createType19);
+const $$createType21 = $models.SMIMECertificate.createFrom;
+const $$createType22 = $Create.Nullable($$createType21);
+const $$createType23 = $Create.Array($$createType22);
+const $$createType24 = $models.UnifiedEmailPage.createFrom;
+const $$createType25 = $Create.Nullable($$createType24);
+const $$createType26 = $Create.Map($Create.Any, $Create.Any);
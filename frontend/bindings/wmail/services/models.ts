@@ -5,6 +5,50 @@
 // @ts-ignore: Unused imports
 import { Create as $Create } from "@wailsio/runtime";
 
+/**
+ * ARCHop is one instance of an ARC chain (RFC 8617)
+ */
+export class ARCHop {
+    "instance": number;
+    "authServId": string;
+
+    /**
+     * d= of the ARC-Seal
+     */
+    "domain": string;
+
+    /**
+     * cv= of the ARC-Seal
+     */
+    "chainValidation": string;
+
+    /** Creates a new ARCHop instance. */
+    constructor($$source: Partial<ARCHop> = {}) {
+        if (!("instance" in $$source)) {
+            this["instance"] = 0;
+        }
+        if (!("authServId" in $$source)) {
+            this["authServId"] = "";
+        }
+        if (!("domain" in $$source)) {
+            this["domain"] = "";
+        }
+        if (!("chainValidation" in $$source)) {
+            this["chainValidation"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ARCHop instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ARCHop {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ARCHop($$parsedSource as Partial<ARCHop>);
+    }
+}
+
 /**
  * Account represents an email account configuration
  */
@@ -24,6 +68,23 @@ export class Account {
      * In production, this should be encrypted
      */
     "password": string;
+
+    /**
+     * password, xoauth2 or oauthbearer
+     */
+    "authMethod": string;
+    "oauth"?: OAuthConfig | null;
+    "cacheLimits"?: CacheLimits | null;
+
+    /**
+     * trusted Authentication-Results, guessed when empty
+     */
+    "authServId"?: string;
+
+    /**
+     * other addresses delivered to this account
+     */
+    "aliases"?: string[];
     "createdAt": string;
     "folders": Folder[];
 
@@ -62,6 +123,9 @@ export class Account {
         if (!("password" in $$source)) {
             this["password"] = "";
         }
+        if (!("authMethod" in $$source)) {
+            this["authMethod"] = "";
+        }
         if (!("createdAt" in $$source)) {
             this["createdAt"] = "";
         }
@@ -77,311 +141,2762 @@ export class Account {
      */
     static createFrom($$source: any = {}): Account {
         const $$createField12_0 = $$createType1;
+        const $$createField13_0 = $$createType3;
+        const $$createField15_0 = $$createType4;
+        const $$createField17_0 = $$createType6;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("oauth" in $$parsedSource) {
+            $$parsedSource["oauth"] = $$createField12_0($$parsedSource["oauth"]);
+        }
+        if ("cacheLimits" in $$parsedSource) {
+            $$parsedSource["cacheLimits"] = $$createField13_0($$parsedSource["cacheLimits"]);
+        }
+        if ("aliases" in $$parsedSource) {
+            $$parsedSource["aliases"] = $$createField15_0($$parsedSource["aliases"]);
+        }
         if ("folders" in $$parsedSource) {
-            $$parsedSource["folders"] = $$createField12_0($$parsedSource["folders"]);
+            $$parsedSource["folders"] = $$createField17_0($$parsedSource["folders"]);
         }
         return new Account($$parsedSource as Partial<Account>);
     }
 }
 
 /**
- * Email represents an email message
+ * AccountCacheStats is the cache usage of one account
  */
-export class Email {
-    "id": string;
+export class AccountCacheStats {
     "accountId": string;
-    "folder": string;
-    "uid": number;
-    "from": string;
-    "to": string[];
-    "cc": string[];
-    "subject": string;
-    "date": string;
-    "body": string;
-    "isRead": boolean;
-    "isStarred": boolean;
-    "createdAt": string;
+    "messages": number;
+    "totalBytes": number;
+    "folders": (FolderCacheStats | null)[];
 
-    /** Creates a new Email instance. */
-    constructor($$source: Partial<Email> = {}) {
-        if (!("id" in $$source)) {
-            this["id"] = "";
-        }
+    /** Creates a new AccountCacheStats instance. */
+    constructor($$source: Partial<AccountCacheStats> = {}) {
         if (!("accountId" in $$source)) {
             this["accountId"] = "";
         }
-        if (!("folder" in $$source)) {
-            this["folder"] = "";
-        }
-        if (!("uid" in $$source)) {
-            this["uid"] = 0;
-        }
-        if (!("from" in $$source)) {
-            this["from"] = "";
-        }
-        if (!("to" in $$source)) {
-            this["to"] = [];
-        }
-        if (!("cc" in $$source)) {
-            this["cc"] = [];
+        if (!("messages" in $$source)) {
+            this["messages"] = 0;
         }
-        if (!("subject" in $$source)) {
-            this["subject"] = "";
+        if (!("totalBytes" in $$source)) {
+            this["totalBytes"] = 0;
         }
-        if (!("date" in $$source)) {
-            this["date"] = "";
+        if (!("folders" in $$source)) {
+            this["folders"] = [];
         }
-        if (!("body" in $$source)) {
-            this["body"] = "";
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new AccountCacheStats instance from a string or object.
+     */
+    static createFrom($$source: any = {}): AccountCacheStats {
+        const $$createField3_0 = $$createType9;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("folders" in $$parsedSource) {
+            $$parsedSource["folders"] = $$createField3_0($$parsedSource["folders"]);
         }
-        if (!("isRead" in $$source)) {
-            this["isRead"] = false;
+        return new AccountCacheStats($$parsedSource as Partial<AccountCacheStats>);
+    }
+}
+
+/**
+ * AccountLifecycleHook lets subsystems that keep per-account data (the email
+ * cache, stored credentials, outbox, search index...) react to account
+ * lifecycle events. Either callback may be nil.
+ */
+export class AccountLifecycleHook {
+    "Name": string;
+
+    /**
+     * OnExport writes the subsystem's data for the account into dir
+     */
+    "OnExport": any;
+
+    /**
+     * OnDelete removes the subsystem's data for a deleted account
+     */
+    "OnDelete": any;
+
+    /** Creates a new AccountLifecycleHook instance. */
+    constructor($$source: Partial<AccountLifecycleHook> = {}) {
+        if (!("Name" in $$source)) {
+            this["Name"] = "";
         }
-        if (!("isStarred" in $$source)) {
-            this["isStarred"] = false;
+        if (!("OnExport" in $$source)) {
+            this["OnExport"] = null;
         }
-        if (!("createdAt" in $$source)) {
-            this["createdAt"] = "";
+        if (!("OnDelete" in $$source)) {
+            this["OnDelete"] = null;
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new Email instance from a string or object.
+     * Creates a new AccountLifecycleHook instance from a string or object.
      */
-    static createFrom($$source: any = {}): Email {
-        const $$createField5_0 = $$createType2;
-        const $$createField6_0 = $$createType2;
+    static createFrom($$source: any = {}): AccountLifecycleHook {
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        if ("to" in $$parsedSource) {
-            $$parsedSource["to"] = $$createField5_0($$parsedSource["to"]);
+        return new AccountLifecycleHook($$parsedSource as Partial<AccountLifecycleHook>);
+    }
+}
+
+/**
+ * Address is a mailbox with an optional display name
+ */
+export class Address {
+    "name": string;
+    "email": string;
+
+    /** Creates a new Address instance. */
+    constructor($$source: Partial<Address> = {}) {
+        if (!("name" in $$source)) {
+            this["name"] = "";
         }
-        if ("cc" in $$parsedSource) {
-            $$parsedSource["cc"] = $$createField6_0($$parsedSource["cc"]);
+        if (!("email" in $$source)) {
+            this["email"] = "";
         }
-        return new Email($$parsedSource as Partial<Email>);
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new Address instance from a string or object.
+     */
+    static createFrom($$source: any = {}): Address {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new Address($$parsedSource as Partial<Address>);
     }
 }
 
 /**
- * Folder represents a mailbox folder
+ * Attendee is an ORGANIZER or ATTENDEE of an event
  */
-export class Folder {
+export class Attendee {
     "name": string;
-    "unread": number;
-    "total": number;
+    "email": string;
+    "role"?: string;
 
-    /** Creates a new Folder instance. */
-    constructor($$source: Partial<Folder> = {}) {
+    /**
+     * PARTSTAT
+     */
+    "status"?: string;
+    "rsvp": boolean;
+
+    /** Creates a new Attendee instance. */
+    constructor($$source: Partial<Attendee> = {}) {
         if (!("name" in $$source)) {
             this["name"] = "";
         }
-        if (!("unread" in $$source)) {
-            this["unread"] = 0;
+        if (!("email" in $$source)) {
+            this["email"] = "";
         }
-        if (!("total" in $$source)) {
-            this["total"] = 0;
+        if (!("rsvp" in $$source)) {
+            this["rsvp"] = false;
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new Folder instance from a string or object.
+     * Creates a new Attendee instance from a string or object.
      */
-    static createFrom($$source: any = {}): Folder {
+    static createFrom($$source: any = {}): Attendee {
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        return new Folder($$parsedSource as Partial<Folder>);
+        return new Attendee($$parsedSource as Partial<Attendee>);
     }
 }
 
 /**
- * Note represents a note with TipTap content
+ * AuthVerdict summarizes how well the sender of a message is authenticated.
+ * Results are only taken from headers added by the account's own server.
  */
-export class Note {
-    "id": string;
-    "title": string;
+export class AuthVerdict {
+    "verdict": string;
 
     /**
-     * TipTap JSON content
+     * RFC 8601 result, empty when unknown
      */
-    "content": string;
+    "spf"?: string;
+    "dkim"?: string;
+    "dmarc"?: string;
+    "arc"?: string;
+    "source"?: string;
 
     /**
-     * Short preview text
+     * the server whose results were used
      */
-    "preview": string;
-    "createdAt": string;
-    "updatedAt": string;
+    "authServId"?: string;
+    "fromDomain": string;
+    "spfDomain"?: string;
 
     /**
-     * Folder name this note belongs to
+     * domains of passing signatures
      */
-    "folder": string;
+    "dkimDomains"?: string[];
+    "localDkim"?: (DKIMResult | null)[];
 
-    /** Creates a new Note instance. */
-    constructor($$source: Partial<Note> = {}) {
-        if (!("id" in $$source)) {
-            this["id"] = "";
-        }
-        if (!("title" in $$source)) {
-            this["title"] = "";
-        }
-        if (!("content" in $$source)) {
-            this["content"] = "";
-        }
-        if (!("preview" in $$source)) {
-            this["preview"] = "";
-        }
-        if (!("createdAt" in $$source)) {
-            this["createdAt"] = "";
+    /**
+     * unverified, shown for information only
+     */
+    "arcHops"?: (ARCHop | null)[];
+    "knownSender": boolean;
+    "warning"?: string;
+
+    /** Creates a new AuthVerdict instance. */
+    constructor($$source: Partial<AuthVerdict> = {}) {
+        if (!("verdict" in $$source)) {
+            this["verdict"] = "";
         }
-        if (!("updatedAt" in $$source)) {
-            this["updatedAt"] = "";
+        if (!("fromDomain" in $$source)) {
+            this["fromDomain"] = "";
         }
-        if (!("folder" in $$source)) {
-            this["folder"] = "";
+        if (!("knownSender" in $$source)) {
+            this["knownSender"] = false;
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new Note instance from a string or object.
+     * Creates a new AuthVerdict instance from a string or object.
      */
-    static createFrom($$source: any = {}): Note {
+    static createFrom($$source: any = {}): AuthVerdict {
+        const $$createField9_0 = $$createType4;
+        const $$createField10_0 = $$createType12;
+        const $$createField11_0 = $$createType15;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        return new Note($$parsedSource as Partial<Note>);
+        if ("dkimDomains" in $$parsedSource) {
+            $$parsedSource["dkimDomains"] = $$createField9_0($$parsedSource["dkimDomains"]);
+        }
+        if ("localDkim" in $$parsedSource) {
+            $$parsedSource["localDkim"] = $$createField10_0($$parsedSource["localDkim"]);
+        }
+        if ("arcHops" in $$parsedSource) {
+            $$parsedSource["arcHops"] = $$createField11_0($$parsedSource["arcHops"]);
+        }
+        return new AuthVerdict($$parsedSource as Partial<AuthVerdict>);
     }
 }
 
 /**
- * NoteConfig represents the notes configuration
+ * AutocryptPeer is the Autocrypt state kept for a correspondent
  */
-export class NoteConfig {
-    "defaultDir": string;
+export class AutocryptPeer {
+    "address": string;
+    "lastSeen": string;
+    "autocryptTimestamp"?: string;
+    "preferEncrypt": string;
+    "fingerprint"?: string;
+    "gossipTimestamp"?: string;
+    "gossipFingerprint"?: string;
 
-    /** Creates a new NoteConfig instance. */
-    constructor($$source: Partial<NoteConfig> = {}) {
-        if (!("defaultDir" in $$source)) {
-            this["defaultDir"] = "";
+    /** Creates a new AutocryptPeer instance. */
+    constructor($$source: Partial<AutocryptPeer> = {}) {
+        if (!("address" in $$source)) {
+            this["address"] = "";
+        }
+        if (!("lastSeen" in $$source)) {
+            this["lastSeen"] = "";
+        }
+        if (!("preferEncrypt" in $$source)) {
+            this["preferEncrypt"] = "";
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new NoteConfig instance from a string or object.
+     * Creates a new AutocryptPeer instance from a string or object.
      */
-    static createFrom($$source: any = {}): NoteConfig {
+    static createFrom($$source: any = {}): AutocryptPeer {
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        return new NoteConfig($$parsedSource as Partial<NoteConfig>);
+        return new AutocryptPeer($$parsedSource as Partial<AutocryptPeer>);
     }
 }
 
 /**
- * NoteFolder represents a folder containing notes
+ * CacheLimits bounds how much mail content is kept locally for an account.
+ * Zero values mean unlimited. Envelopes are never evicted, so evicted mail
+ * stays listed and searchable and its body is downloaded again on demand.
  */
-export class NoteFolder {
-    "name": string;
+export class CacheLimits {
+    /**
+     * total size of bodies and raw sources
+     */
+    "maxBytes": number;
 
     /**
-     * Full path to the folder
+     * drop content not opened for this long
      */
-    "path": string;
-    "createdAt": string;
-    "updatedAt": string;
+    "maxBodyAgeDays": number;
 
-    /** Creates a new NoteFolder instance. */
-    constructor($$source: Partial<NoteFolder> = {}) {
-        if (!("name" in $$source)) {
-            this["name"] = "";
-        }
-        if (!("path" in $$source)) {
-            this["path"] = "";
+    /**
+     * keep only headers for mail older than this many days
+     */
+    "headersOnlyAfter": number;
+
+    /** Creates a new CacheLimits instance. */
+    constructor($$source: Partial<CacheLimits> = {}) {
+        if (!("maxBytes" in $$source)) {
+            this["maxBytes"] = 0;
         }
-        if (!("createdAt" in $$source)) {
-            this["createdAt"] = "";
+        if (!("maxBodyAgeDays" in $$source)) {
+            this["maxBodyAgeDays"] = 0;
         }
-        if (!("updatedAt" in $$source)) {
-            this["updatedAt"] = "";
+        if (!("headersOnlyAfter" in $$source)) {
+            this["headersOnlyAfter"] = 0;
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new NoteFolder instance from a string or object.
+     * Creates a new CacheLimits instance from a string or object.
      */
-    static createFrom($$source: any = {}): NoteFolder {
+    static createFrom($$source: any = {}): CacheLimits {
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        return new NoteFolder($$parsedSource as Partial<NoteFolder>);
+        return new CacheLimits($$parsedSource as Partial<CacheLimits>);
     }
 }
 
 /**
- * SendEmail sends an email
+ * CacheStats is the cache usage across all accounts
  */
-export class SendEmailRequest {
-    "accountId": string;
-    "to": string[];
-    "cc": string[];
-    "bcc": string[];
-    "subject": string;
-    "body": string;
-    "isHTML": boolean;
+export class CacheStats {
+    "accounts": (AccountCacheStats | null)[];
+    "databaseBytes": number;
 
-    /** Creates a new SendEmailRequest instance. */
-    constructor($$source: Partial<SendEmailRequest> = {}) {
-        if (!("accountId" in $$source)) {
-            this["accountId"] = "";
-        }
-        if (!("to" in $$source)) {
-            this["to"] = [];
-        }
-        if (!("cc" in $$source)) {
-            this["cc"] = [];
-        }
-        if (!("bcc" in $$source)) {
-            this["bcc"] = [];
-        }
-        if (!("subject" in $$source)) {
-            this["subject"] = "";
+    /**
+     * compressed size on disk
+     */
+    "blobBytes": number;
+
+    /** Creates a new CacheStats instance. */
+    constructor($$source: Partial<CacheStats> = {}) {
+        if (!("accounts" in $$source)) {
+            this["accounts"] = [];
         }
-        if (!("body" in $$source)) {
-            this["body"] = "";
+        if (!("databaseBytes" in $$source)) {
+            this["databaseBytes"] = 0;
         }
-        if (!("isHTML" in $$source)) {
-            this["isHTML"] = false;
+        if (!("blobBytes" in $$source)) {
+            this["blobBytes"] = 0;
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new SendEmailRequest instance from a string or object.
+     * Creates a new CacheStats instance from a string or object.
      */
-    static createFrom($$source: any = {}): SendEmailRequest {
-        const $$createField1_0 = $$createType2;
-        const $$createField2_0 = $$createType2;
-        const $$createField3_0 = $$createType2;
+    static createFrom($$source: any = {}): CacheStats {
+        const $$createField0_0 = $$createType18;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        if ("to" in $$parsedSource) {
-            $$parsedSource["to"] = $$createField1_0($$parsedSource["to"]);
+        if ("accounts" in $$parsedSource) {
+            $$parsedSource["accounts"] = $$createField0_0($$parsedSource["accounts"]);
         }
-        if ("cc" in $$parsedSource) {
-            $$parsedSource["cc"] = $$createField2_0($$parsedSource["cc"]);
+        return new CacheStats($$parsedSource as Partial<CacheStats>);
+    }
+}
+
+/**
+ * CalendarEvent is an event the user accepted, stored from its invitation
+ */
+export class CalendarEvent {
+    "id": string;
+    "uid": string;
+
+    /**
+     * set on overrides of one occurrence
+     */
+    "recurrenceId"?: string;
+    "accountId": string;
+    "sequence": number;
+    "summary": string;
+    "description"?: string;
+    "location"?: string;
+
+    /**
+     * RFC3339 in UTC, or YYYY-MM-DD for all-day events
+     */
+    "start": string;
+    "end": string;
+    "allDay": boolean;
+    "timeZone"?: string;
+    "recurrence"?: string;
+    "exDates"?: string[];
+    "organizer"?: Attendee | null;
+    "attendees": (Attendee | null)[];
+    "status"?: string;
+
+    /**
+     * ACCEPTED or TENTATIVE
+     */
+    "response": string;
+    "createdAt": string;
+    "updatedAt": string;
+
+    /** Creates a new CalendarEvent instance. */
+    constructor($$source: Partial<CalendarEvent> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = "";
+        }
+        if (!("uid" in $$source)) {
+            this["uid"] = "";
+        }
+        if (!("accountId" in $$source)) {
+            this["accountId"] = "";
+        }
+        if (!("sequence" in $$source)) {
+            this["sequence"] = 0;
+        }
+        if (!("summary" in $$source)) {
+            this["summary"] = "";
+        }
+        if (!("start" in $$source)) {
+            this["start"] = "";
+        }
+        if (!("end" in $$source)) {
+            this["end"] = "";
+        }
+        if (!("allDay" in $$source)) {
+            this["allDay"] = false;
+        }
+        if (!("attendees" in $$source)) {
+            this["attendees"] = [];
+        }
+        if (!("response" in $$source)) {
+            this["response"] = "";
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+        if (!("updatedAt" in $$source)) {
+            this["updatedAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new CalendarEvent instance from a string or object.
+     */
+    static createFrom($$source: any = {}): CalendarEvent {
+        const $$createField13_0 = $$createType4;
+        const $$createField14_0 = $$createType20;
+        const $$createField15_0 = $$createType21;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("exDates" in $$parsedSource) {
+            $$parsedSource["exDates"] = $$createField13_0($$parsedSource["exDates"]);
+        }
+        if ("organizer" in $$parsedSource) {
+            $$parsedSource["organizer"] = $$createField14_0($$parsedSource["organizer"]);
+        }
+        if ("attendees" in $$parsedSource) {
+            $$parsedSource["attendees"] = $$createField15_0($$parsedSource["attendees"]);
+        }
+        return new CalendarEvent($$parsedSource as Partial<CalendarEvent>);
+    }
+}
+
+/**
+ * CardDAVAddressBook is an address book collection on a CardDAV server
+ */
+export class CardDAVAddressBook {
+    "href": string;
+    "name": string;
+    "description": string;
+
+    /** Creates a new CardDAVAddressBook instance. */
+    constructor($$source: Partial<CardDAVAddressBook> = {}) {
+        if (!("href" in $$source)) {
+            this["href"] = "";
+        }
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("description" in $$source)) {
+            this["description"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new CardDAVAddressBook instance from a string or object.
+     */
+    static createFrom($$source: any = {}): CardDAVAddressBook {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new CardDAVAddressBook($$parsedSource as Partial<CardDAVAddressBook>);
+    }
+}
+
+/**
+ * CardDAVProfile configures syncing one CardDAV address book into the contacts.
+ * With an AccountID and no Username the account's credentials are used,
+ * including OAuth for providers such as Google.
+ */
+export class CardDAVProfile {
+    "id": string;
+    "name": string;
+
+    /**
+     * server, principal or address book URL
+     */
+    "url": string;
+    "accountId"?: string;
+    "username"?: string;
+
+    /**
+     * only set when saving; kept in the secret store
+     */
+    "password"?: string;
+
+    /**
+     * URL of the synced address book
+     */
+    "addressBook": string;
+    "lastSync"?: string;
+    "lastError"?: string;
+
+    /** Creates a new CardDAVProfile instance. */
+    constructor($$source: Partial<CardDAVProfile> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = "";
+        }
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("url" in $$source)) {
+            this["url"] = "";
+        }
+        if (!("addressBook" in $$source)) {
+            this["addressBook"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new CardDAVProfile instance from a string or object.
+     */
+    static createFrom($$source: any = {}): CardDAVProfile {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new CardDAVProfile($$parsedSource as Partial<CardDAVProfile>);
+    }
+}
+
+/**
+ * CardDAVSyncResult summarizes one sync
+ */
+export class CardDAVSyncResult {
+    "added": number;
+    "updated": number;
+    "deleted": number;
+    "uploaded": number;
+    "fullSync": boolean;
+
+    /** Creates a new CardDAVSyncResult instance. */
+    constructor($$source: Partial<CardDAVSyncResult> = {}) {
+        if (!("added" in $$source)) {
+            this["added"] = 0;
+        }
+        if (!("updated" in $$source)) {
+            this["updated"] = 0;
+        }
+        if (!("deleted" in $$source)) {
+            this["deleted"] = 0;
+        }
+        if (!("uploaded" in $$source)) {
+            this["uploaded"] = 0;
+        }
+        if (!("fullSync" in $$source)) {
+            this["fullSync"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new CardDAVSyncResult instance from a string or object.
+     */
+    static createFrom($$source: any = {}): CardDAVSyncResult {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new CardDAVSyncResult($$parsedSource as Partial<CardDAVSyncResult>);
+    }
+}
+
+/**
+ * ConnectivityState describes whether queued changes can reach the servers
+ */
+export class ConnectivityState {
+    "online": boolean;
+    "pendingOps": number;
+    "lastError": string;
+    "checkedAt": string;
+
+    /** Creates a new ConnectivityState instance. */
+    constructor($$source: Partial<ConnectivityState> = {}) {
+        if (!("online" in $$source)) {
+            this["online"] = false;
+        }
+        if (!("pendingOps" in $$source)) {
+            this["pendingOps"] = 0;
+        }
+        if (!("lastError" in $$source)) {
+            this["lastError"] = "";
+        }
+        if (!("checkedAt" in $$source)) {
+            this["checkedAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ConnectivityState instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ConnectivityState {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ConnectivityState($$parsedSource as Partial<ConnectivityState>);
+    }
+}
+
+/**
+ * Contact is an address book entry
+ */
+export class Contact {
+    "id": string;
+
+    /**
+     * vCard UID, stable across import and export
+     */
+    "uid": string;
+    "name": string;
+    "emails": string[];
+    "phones": string[];
+    "organization": string;
+    "notes": string;
+
+    /**
+     * group IDs
+     */
+    "groups": string[];
+    "source": string;
+    "createdAt": string;
+    "updatedAt": string;
+
+    /** Creates a new Contact instance. */
+    constructor($$source: Partial<Contact> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = "";
+        }
+        if (!("uid" in $$source)) {
+            this["uid"] = "";
+        }
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("emails" in $$source)) {
+            this["emails"] = [];
+        }
+        if (!("phones" in $$source)) {
+            this["phones"] = [];
+        }
+        if (!("organization" in $$source)) {
+            this["organization"] = "";
+        }
+        if (!("notes" in $$source)) {
+            this["notes"] = "";
+        }
+        if (!("groups" in $$source)) {
+            this["groups"] = [];
+        }
+        if (!("source" in $$source)) {
+            this["source"] = "";
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+        if (!("updatedAt" in $$source)) {
+            this["updatedAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new Contact instance from a string or object.
+     */
+    static createFrom($$source: any = {}): Contact {
+        const $$createField3_0 = $$createType4;
+        const $$createField4_0 = $$createType4;
+        const $$createField7_0 = $$createType4;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("emails" in $$parsedSource) {
+            $$parsedSource["emails"] = $$createField3_0($$parsedSource["emails"]);
+        }
+        if ("phones" in $$parsedSource) {
+            $$parsedSource["phones"] = $$createField4_0($$parsedSource["phones"]);
+        }
+        if ("groups" in $$parsedSource) {
+            $$parsedSource["groups"] = $$createField7_0($$parsedSource["groups"]);
+        }
+        return new Contact($$parsedSource as Partial<Contact>);
+    }
+}
+
+/**
+ * ContactGroup is a named list of contacts, exported as vCard CATEGORIES
+ */
+export class ContactGroup {
+    "id": string;
+    "name": string;
+    "members": number;
+
+    /** Creates a new ContactGroup instance. */
+    constructor($$source: Partial<ContactGroup> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = "";
+        }
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("members" in $$source)) {
+            this["members"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ContactGroup instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ContactGroup {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ContactGroup($$parsedSource as Partial<ContactGroup>);
+    }
+}
+
+/**
+ * ContactImportResult summarizes a vCard import
+ */
+export class ContactImportResult {
+    "created": number;
+    "updated": number;
+
+    /** Creates a new ContactImportResult instance. */
+    constructor($$source: Partial<ContactImportResult> = {}) {
+        if (!("created" in $$source)) {
+            this["created"] = 0;
+        }
+        if (!("updated" in $$source)) {
+            this["updated"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ContactImportResult instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ContactImportResult {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ContactImportResult($$parsedSource as Partial<ContactImportResult>);
+    }
+}
+
+/**
+ * DKIMResult is the outcome of checking one DKIM-Signature locally
+ */
+export class DKIMResult {
+    "domain": string;
+    "selector": string;
+    "result": string;
+    "reason"?: string;
+
+    /** Creates a new DKIMResult instance. */
+    constructor($$source: Partial<DKIMResult> = {}) {
+        if (!("domain" in $$source)) {
+            this["domain"] = "";
+        }
+        if (!("selector" in $$source)) {
+            this["selector"] = "";
+        }
+        if (!("result" in $$source)) {
+            this["result"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new DKIMResult instance from a string or object.
+     */
+    static createFrom($$source: any = {}): DKIMResult {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new DKIMResult($$parsedSource as Partial<DKIMResult>);
+    }
+}
+
+/**
+ * Email represents an email message
+ */
+export class Email {
+    "id": string;
+    "accountId": string;
+    "folder": string;
+    "uid": number;
+    "messageId": string;
+    "from": Address[];
+
+    /**
+     * only set when it differs from From
+     */
+    "sender": Address[];
+    "replyTo": Address[];
+    "to": Address[];
+    "cc": Address[];
+    "subject": string;
+    "date": string;
+
+    /**
+     * when the server received it (RFC3339, UTC)
+     */
+    "internalDate": string;
+    "body": string;
+
+    /**
+     * meeting request, cancellation or reply
+     */
+    "invitation"?: Invitation | null;
+
+    /**
+     * set on signed or encrypted messages
+     */
+    "security"?: MessageSecurity | null;
+
+    /**
+     * SPF, DKIM and DMARC verdict on the sender
+     */
+    "authentication"?: AuthVerdict | null;
+
+    /**
+     * phishing indicators for the reader view
+     */
+    "warnings"?: (MessageWarning | null)[];
+
+    /**
+     * 0 (good) to 1 (spam), unset until the filter is trained
+     */
+    "spamScore"?: number | null;
+    "isRead": boolean;
+    "isStarred": boolean;
+    "createdAt": string;
+
+    /** Creates a new Email instance. */
+    constructor($$source: Partial<Email> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = "";
+        }
+        if (!("accountId" in $$source)) {
+            this["accountId"] = "";
+        }
+        if (!("folder" in $$source)) {
+            this["folder"] = "";
+        }
+        if (!("uid" in $$source)) {
+            this["uid"] = 0;
+        }
+        if (!("messageId" in $$source)) {
+            this["messageId"] = "";
+        }
+        if (!("from" in $$source)) {
+            this["from"] = [];
+        }
+        if (!("sender" in $$source)) {
+            this["sender"] = [];
+        }
+        if (!("replyTo" in $$source)) {
+            this["replyTo"] = [];
+        }
+        if (!("to" in $$source)) {
+            this["to"] = [];
+        }
+        if (!("cc" in $$source)) {
+            this["cc"] = [];
+        }
+        if (!("subject" in $$source)) {
+            this["subject"] = "";
+        }
+        if (!("date" in $$source)) {
+            this["date"] = "";
+        }
+        if (!("internalDate" in $$source)) {
+            this["internalDate"] = "";
+        }
+        if (!("body" in $$source)) {
+            this["body"] = "";
+        }
+        if (!("isRead" in $$source)) {
+            this["isRead"] = false;
+        }
+        if (!("isStarred" in $$source)) {
+            this["isStarred"] = false;
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new Email instance from a string or object.
+     */
+    static createFrom($$source: any = {}): Email {
+        const $$createField5_0 = $$createType23;
+        const $$createField6_0 = $$createType23;
+        const $$createField7_0 = $$createType23;
+        const $$createField8_0 = $$createType23;
+        const $$createField9_0 = $$createType23;
+        const $$createField14_0 = $$createType25;
+        const $$createField15_0 = $$createType27;
+        const $$createField16_0 = $$createType29;
+        const $$createField17_0 = $$createType32;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("from" in $$parsedSource) {
+            $$parsedSource["from"] = $$createField5_0($$parsedSource["from"]);
+        }
+        if ("sender" in $$parsedSource) {
+            $$parsedSource["sender"] = $$createField6_0($$parsedSource["sender"]);
+        }
+        if ("replyTo" in $$parsedSource) {
+            $$parsedSource["replyTo"] = $$createField7_0($$parsedSource["replyTo"]);
+        }
+        if ("to" in $$parsedSource) {
+            $$parsedSource["to"] = $$createField8_0($$parsedSource["to"]);
+        }
+        if ("cc" in $$parsedSource) {
+            $$parsedSource["cc"] = $$createField9_0($$parsedSource["cc"]);
+        }
+        if ("invitation" in $$parsedSource) {
+            $$parsedSource["invitation"] = $$createField14_0($$parsedSource["invitation"]);
+        }
+        if ("security" in $$parsedSource) {
+            $$parsedSource["security"] = $$createField15_0($$parsedSource["security"]);
+        }
+        if ("authentication" in $$parsedSource) {
+            $$parsedSource["authentication"] = $$createField16_0($$parsedSource["authentication"]);
+        }
+        if ("warnings" in $$parsedSource) {
+            $$parsedSource["warnings"] = $$createField17_0($$parsedSource["warnings"]);
+        }
+        return new Email($$parsedSource as Partial<Email>);
+    }
+}
+
+/**
+ * EmailPage is one page of a folder, newest first
+ */
+export class EmailPage {
+    "emails": (Email | null)[];
+    "total": number;
+    "hasMore": boolean;
+
+    /**
+     * pass back to get the following page
+     */
+    "nextCursor": string;
+
+    /** Creates a new EmailPage instance. */
+    constructor($$source: Partial<EmailPage> = {}) {
+        if (!("emails" in $$source)) {
+            this["emails"] = [];
+        }
+        if (!("total" in $$source)) {
+            this["total"] = 0;
+        }
+        if (!("hasMore" in $$source)) {
+            this["hasMore"] = false;
+        }
+        if (!("nextCursor" in $$source)) {
+            this["nextCursor"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new EmailPage instance from a string or object.
+     */
+    static createFrom($$source: any = {}): EmailPage {
+        const $$createField0_0 = $$createType35;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("emails" in $$parsedSource) {
+            $$parsedSource["emails"] = $$createField0_0($$parsedSource["emails"]);
+        }
+        return new EmailPage($$parsedSource as Partial<EmailPage>);
+    }
+}
+
+/**
+ * EncryptionRecommendation says whether compose should offer or default
+ * to encryption for a set of recipients
+ */
+export class EncryptionRecommendation {
+    "recommendation": string;
+    "recipients": (RecipientRecommendation | null)[];
+
+    /** Creates a new EncryptionRecommendation instance. */
+    constructor($$source: Partial<EncryptionRecommendation> = {}) {
+        if (!("recommendation" in $$source)) {
+            this["recommendation"] = "";
+        }
+        if (!("recipients" in $$source)) {
+            this["recipients"] = [];
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new EncryptionRecommendation instance from a string or object.
+     */
+    static createFrom($$source: any = {}): EncryptionRecommendation {
+        const $$createField1_0 = $$createType38;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("recipients" in $$parsedSource) {
+            $$parsedSource["recipients"] = $$createField1_0($$parsedSource["recipients"]);
+        }
+        return new EncryptionRecommendation($$parsedSource as Partial<EncryptionRecommendation>);
+    }
+}
+
+/**
+ * EventOccurrence is one occurrence of a calendar event
+ */
+export class EventOccurrence {
+    "eventId": string;
+    "uid": string;
+    "summary": string;
+    "location"?: string;
+
+    /**
+     * RFC3339 in UTC, or YYYY-MM-DD for all-day events
+     */
+    "start": string;
+    "end": string;
+    "allDay": boolean;
+    "recurring": boolean;
+    "response": string;
+
+    /** Creates a new EventOccurrence instance. */
+    constructor($$source: Partial<EventOccurrence> = {}) {
+        if (!("eventId" in $$source)) {
+            this["eventId"] = "";
+        }
+        if (!("uid" in $$source)) {
+            this["uid"] = "";
+        }
+        if (!("summary" in $$source)) {
+            this["summary"] = "";
+        }
+        if (!("start" in $$source)) {
+            this["start"] = "";
+        }
+        if (!("end" in $$source)) {
+            this["end"] = "";
+        }
+        if (!("allDay" in $$source)) {
+            this["allDay"] = false;
+        }
+        if (!("recurring" in $$source)) {
+            this["recurring"] = false;
+        }
+        if (!("response" in $$source)) {
+            this["response"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new EventOccurrence instance from a string or object.
+     */
+    static createFrom($$source: any = {}): EventOccurrence {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new EventOccurrence($$parsedSource as Partial<EventOccurrence>);
+    }
+}
+
+/**
+ * Folder represents a mailbox folder
+ */
+export class Folder {
+    "name": string;
+
+    /**
+     * special-use role, empty for ordinary folders
+     */
+    "role"?: string;
+    "unread": number;
+    "total": number;
+
+    /** Creates a new Folder instance. */
+    constructor($$source: Partial<Folder> = {}) {
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("unread" in $$source)) {
+            this["unread"] = 0;
+        }
+        if (!("total" in $$source)) {
+            this["total"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new Folder instance from a string or object.
+     */
+    static createFrom($$source: any = {}): Folder {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new Folder($$parsedSource as Partial<Folder>);
+    }
+}
+
+/**
+ * FolderCacheStats is the cache usage of one folder
+ */
+export class FolderCacheStats {
+    "folder": string;
+    "messages": number;
+    "bodies": number;
+    "bodyBytes": number;
+    "rawBytes": number;
+    "totalBytes": number;
+
+    /** Creates a new FolderCacheStats instance. */
+    constructor($$source: Partial<FolderCacheStats> = {}) {
+        if (!("folder" in $$source)) {
+            this["folder"] = "";
+        }
+        if (!("messages" in $$source)) {
+            this["messages"] = 0;
+        }
+        if (!("bodies" in $$source)) {
+            this["bodies"] = 0;
+        }
+        if (!("bodyBytes" in $$source)) {
+            this["bodyBytes"] = 0;
+        }
+        if (!("rawBytes" in $$source)) {
+            this["rawBytes"] = 0;
+        }
+        if (!("totalBytes" in $$source)) {
+            this["totalBytes"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new FolderCacheStats instance from a string or object.
+     */
+    static createFrom($$source: any = {}): FolderCacheStats {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new FolderCacheStats($$parsedSource as Partial<FolderCacheStats>);
+    }
+}
+
+/**
+ * FolderSynced is the payload of EventFolderSynced
+ */
+export class FolderSynced {
+    "accountId": string;
+    "folder": string;
+
+    /** Creates a new FolderSynced instance. */
+    constructor($$source: Partial<FolderSynced> = {}) {
+        if (!("accountId" in $$source)) {
+            this["accountId"] = "";
+        }
+        if (!("folder" in $$source)) {
+            this["folder"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new FolderSynced instance from a string or object.
+     */
+    static createFrom($$source: any = {}): FolderSynced {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new FolderSynced($$parsedSource as Partial<FolderSynced>);
+    }
+}
+
+/**
+ * Invitation is a meeting request, cancellation or reply found in a message
+ */
+export class Invitation {
+    "method": string;
+    "uid": string;
+    "sequence": number;
+
+    /**
+     * the occurrence this is about, for one-off changes
+     */
+    "recurrenceId"?: string;
+
+    /**
+     * CONFIRMED, TENTATIVE or CANCELLED
+     */
+    "status"?: string;
+    "summary": string;
+    "description"?: string;
+    "location"?: string;
+
+    /**
+     * RFC3339 in UTC, or YYYY-MM-DD for all-day events
+     */
+    "start": string;
+    "end"?: string;
+    "allDay": boolean;
+
+    /**
+     * TZID the organizer used
+     */
+    "timeZone"?: string;
+
+    /**
+     * RRULE value
+     */
+    "recurrence"?: string;
+    "recurrenceText"?: string;
+
+    /**
+     * cancelled occurrences
+     */
+    "exDates"?: string[];
+    "organizer"?: Attendee | null;
+    "attendees": (Attendee | null)[];
+
+    /**
+     * our PARTSTAT once we replied
+     */
+    "response"?: string;
+
+    /**
+     * Conflicts are calendar events the invitation overlaps, worked out
+     * each time it is opened and never cached
+     */
+    "conflicts"?: (EventOccurrence | null)[];
+
+    /**
+     * Unverified is set, like Conflicts, when a cancellation or update was
+     * not applied because it could not be verified to come from the organizer
+     */
+    "unverified"?: boolean;
+
+    /** Creates a new Invitation instance. */
+    constructor($$source: Partial<Invitation> = {}) {
+        if (!("method" in $$source)) {
+            this["method"] = "";
+        }
+        if (!("uid" in $$source)) {
+            this["uid"] = "";
+        }
+        if (!("sequence" in $$source)) {
+            this["sequence"] = 0;
+        }
+        if (!("summary" in $$source)) {
+            this["summary"] = "";
+        }
+        if (!("start" in $$source)) {
+            this["start"] = "";
+        }
+        if (!("allDay" in $$source)) {
+            this["allDay"] = false;
+        }
+        if (!("attendees" in $$source)) {
+            this["attendees"] = [];
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new Invitation instance from a string or object.
+     */
+    static createFrom($$source: any = {}): Invitation {
+        const $$createField14_0 = $$createType4;
+        const $$createField15_0 = $$createType20;
+        const $$createField16_0 = $$createType21;
+        const $$createField18_0 = $$createType41;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("exDates" in $$parsedSource) {
+            $$parsedSource["exDates"] = $$createField14_0($$parsedSource["exDates"]);
+        }
+        if ("organizer" in $$parsedSource) {
+            $$parsedSource["organizer"] = $$createField15_0($$parsedSource["organizer"]);
+        }
+        if ("attendees" in $$parsedSource) {
+            $$parsedSource["attendees"] = $$createField16_0($$parsedSource["attendees"]);
+        }
+        if ("conflicts" in $$parsedSource) {
+            $$parsedSource["conflicts"] = $$createField18_0($$parsedSource["conflicts"]);
+        }
+        return new Invitation($$parsedSource as Partial<Invitation>);
+    }
+}
+
+/**
+ * InvitationHook lets another service follow the invitations the user
+ * opens and answers. Either callback may be nil.
+ */
+export class InvitationHook {
+    "Name": string;
+
+    /**
+     * OnView runs each time a message with an invitation is opened and may
+     * annotate its Invitation
+     */
+    "OnView": any;
+
+    /**
+     * OnRespond runs after the user answered a meeting request
+     */
+    "OnRespond": any;
+
+    /** Creates a new InvitationHook instance. */
+    constructor($$source: Partial<InvitationHook> = {}) {
+        if (!("Name" in $$source)) {
+            this["Name"] = "";
+        }
+        if (!("OnView" in $$source)) {
+            this["OnView"] = null;
+        }
+        if (!("OnRespond" in $$source)) {
+            this["OnRespond"] = null;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new InvitationHook instance from a string or object.
+     */
+    static createFrom($$source: any = {}): InvitationHook {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new InvitationHook($$parsedSource as Partial<InvitationHook>);
+    }
+}
+
+/**
+ * MailSettings holds user preferences for reading mail
+ */
+export class MailSettings {
+    "sortBy": string;
+
+    /**
+     * PassphraseTimeout is how many minutes an unlocked PGP key stays unlocked
+     */
+    "passphraseTimeout": number;
+
+    /**
+     * AutocryptPreferEncrypt advertises prefer-encrypt=mutual, so peers who
+     * also prefer it encrypt by default
+     */
+    "autocryptPreferEncrypt": boolean;
+
+    /**
+     * VerifyDKIM checks DKIM signatures locally, looking keys up in DNS,
+     * in addition to the results reported by the server
+     */
+    "verifyDkim": boolean;
+
+    /**
+     * SpamAutoMove moves new Inbox messages scoring at least SpamThreshold
+     * to the Junk folder
+     */
+    "spamAutoMove": boolean;
+    "spamThreshold": number;
+
+    /** Creates a new MailSettings instance. */
+    constructor($$source: Partial<MailSettings> = {}) {
+        if (!("sortBy" in $$source)) {
+            this["sortBy"] = "";
+        }
+        if (!("passphraseTimeout" in $$source)) {
+            this["passphraseTimeout"] = 0;
+        }
+        if (!("autocryptPreferEncrypt" in $$source)) {
+            this["autocryptPreferEncrypt"] = false;
+        }
+        if (!("verifyDkim" in $$source)) {
+            this["verifyDkim"] = false;
+        }
+        if (!("spamAutoMove" in $$source)) {
+            this["spamAutoMove"] = false;
+        }
+        if (!("spamThreshold" in $$source)) {
+            this["spamThreshold"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new MailSettings instance from a string or object.
+     */
+    static createFrom($$source: any = {}): MailSettings {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new MailSettings($$parsedSource as Partial<MailSettings>);
+    }
+}
+
+/**
+ * MessageSecurity says how a message was encrypted and signed
+ */
+export class MessageSecurity {
+    "protocol": string;
+    "encrypted": boolean;
+    "decrypted": boolean;
+
+    /**
+     * fingerprint to unlock to read the message
+     */
+    "lockedKey"?: string;
+    "signed": boolean;
+    "signature"?: string;
+    "signerKeyId"?: string;
+    "signerFingerprint"?: string;
+    "signerName"?: string;
+    "signerEmail"?: string;
+
+    /**
+     * S/MIME certificate issuer
+     */
+    "signerIssuer"?: string;
+
+    /**
+     * S/MIME certificate expiry
+     */
+    "signerValidUntil"?: string;
+    "error"?: string;
+
+    /** Creates a new MessageSecurity instance. */
+    constructor($$source: Partial<MessageSecurity> = {}) {
+        if (!("protocol" in $$source)) {
+            this["protocol"] = "";
+        }
+        if (!("encrypted" in $$source)) {
+            this["encrypted"] = false;
+        }
+        if (!("decrypted" in $$source)) {
+            this["decrypted"] = false;
+        }
+        if (!("signed" in $$source)) {
+            this["signed"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new MessageSecurity instance from a string or object.
+     */
+    static createFrom($$source: any = {}): MessageSecurity {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new MessageSecurity($$parsedSource as Partial<MessageSecurity>);
+    }
+}
+
+/**
+ * MessageWarning is a phishing indicator found in a message
+ */
+export class MessageWarning {
+    "kind": string;
+    "message": string;
+
+    /**
+     * link text or display name
+     */
+    "text"?: string;
+
+    /**
+     * link target
+     */
+    "href"?: string;
+
+    /**
+     * the suspicious domain, in Unicode
+     */
+    "domain"?: string;
+
+    /**
+     * LooksLike is the domain or address the message imitates
+     */
+    "looksLike"?: string;
+
+    /** Creates a new MessageWarning instance. */
+    constructor($$source: Partial<MessageWarning> = {}) {
+        if (!("kind" in $$source)) {
+            this["kind"] = "";
+        }
+        if (!("message" in $$source)) {
+            this["message"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new MessageWarning instance from a string or object.
+     */
+    static createFrom($$source: any = {}): MessageWarning {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new MessageWarning($$parsedSource as Partial<MessageWarning>);
+    }
+}
+
+/**
+ * NewMailHook lets another service act on the messages a sync finds
+ * arrived since the previous sync of a folder
+ */
+export class NewMailHook {
+    "Name": string;
+    "OnNewMail": any;
+
+    /** Creates a new NewMailHook instance. */
+    constructor($$source: Partial<NewMailHook> = {}) {
+        if (!("Name" in $$source)) {
+            this["Name"] = "";
+        }
+        if (!("OnNewMail" in $$source)) {
+            this["OnNewMail"] = null;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new NewMailHook instance from a string or object.
+     */
+    static createFrom($$source: any = {}): NewMailHook {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new NewMailHook($$parsedSource as Partial<NewMailHook>);
+    }
+}
+
+/**
+ * Note represents a note with TipTap content
+ */
+export class Note {
+    "id": string;
+    "title": string;
+
+    /**
+     * TipTap JSON content
+     */
+    "content": string;
+
+    /**
+     * Short preview text
+     */
+    "preview": string;
+    "createdAt": string;
+    "updatedAt": string;
+
+    /**
+     * Folder name this note belongs to
+     */
+    "folder": string;
+
+    /** Creates a new Note instance. */
+    constructor($$source: Partial<Note> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = "";
+        }
+        if (!("title" in $$source)) {
+            this["title"] = "";
+        }
+        if (!("content" in $$source)) {
+            this["content"] = "";
+        }
+        if (!("preview" in $$source)) {
+            this["preview"] = "";
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+        if (!("updatedAt" in $$source)) {
+            this["updatedAt"] = "";
+        }
+        if (!("folder" in $$source)) {
+            this["folder"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new Note instance from a string or object.
+     */
+    static createFrom($$source: any = {}): Note {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new Note($$parsedSource as Partial<Note>);
+    }
+}
+
+/**
+ * NoteConfig represents the notes configuration
+ */
+export class NoteConfig {
+    "defaultDir": string;
+
+    /** Creates a new NoteConfig instance. */
+    constructor($$source: Partial<NoteConfig> = {}) {
+        if (!("defaultDir" in $$source)) {
+            this["defaultDir"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new NoteConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): NoteConfig {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new NoteConfig($$parsedSource as Partial<NoteConfig>);
+    }
+}
+
+/**
+ * NoteFolder represents a folder containing notes
+ */
+export class NoteFolder {
+    "name": string;
+
+    /**
+     * Full path to the folder
+     */
+    "path": string;
+    "createdAt": string;
+    "updatedAt": string;
+
+    /** Creates a new NoteFolder instance. */
+    constructor($$source: Partial<NoteFolder> = {}) {
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("path" in $$source)) {
+            this["path"] = "";
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+        if (!("updatedAt" in $$source)) {
+            this["updatedAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new NoteFolder instance from a string or object.
+     */
+    static createFrom($$source: any = {}): NoteFolder {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new NoteFolder($$parsedSource as Partial<NoteFolder>);
+    }
+}
+
+/**
+ * OAuthConfig describes the OAuth2 provider endpoints used by an account.
+ * Endpoints are stored per account so they can point at a local mock server.
+ */
+export class OAuthConfig {
+    "provider": string;
+    "clientId": string;
+    "clientSecret": string;
+    "authUrl": string;
+    "tokenUrl": string;
+    "scopes": string[];
+
+    /**
+     * 0 picks a free loopback port
+     */
+    "redirectPort": number;
+
+    /** Creates a new OAuthConfig instance. */
+    constructor($$source: Partial<OAuthConfig> = {}) {
+        if (!("provider" in $$source)) {
+            this["provider"] = "";
+        }
+        if (!("clientId" in $$source)) {
+            this["clientId"] = "";
+        }
+        if (!("clientSecret" in $$source)) {
+            this["clientSecret"] = "";
+        }
+        if (!("authUrl" in $$source)) {
+            this["authUrl"] = "";
+        }
+        if (!("tokenUrl" in $$source)) {
+            this["tokenUrl"] = "";
+        }
+        if (!("scopes" in $$source)) {
+            this["scopes"] = [];
+        }
+        if (!("redirectPort" in $$source)) {
+            this["redirectPort"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new OAuthConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): OAuthConfig {
+        const $$createField5_0 = $$createType4;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("scopes" in $$parsedSource) {
+            $$parsedSource["scopes"] = $$createField5_0($$parsedSource["scopes"]);
+        }
+        return new OAuthConfig($$parsedSource as Partial<OAuthConfig>);
+    }
+}
+
+/**
+ * PGPKey describes a key in the local keyring
+ */
+export class PGPKey {
+    "fingerprint": string;
+    "keyId": string;
+    "userIds": string[];
+    "emails": string[];
+    "secret": boolean;
+
+    /**
+     * the passphrase is cached
+     */
+    "unlocked": boolean;
+    "revoked": boolean;
+    "canEncrypt": boolean;
+    "createdAt": string;
+    "expiresAt"?: string;
+    "source": string;
+    "addedAt": string;
+
+    /** Creates a new PGPKey instance. */
+    constructor($$source: Partial<PGPKey> = {}) {
+        if (!("fingerprint" in $$source)) {
+            this["fingerprint"] = "";
+        }
+        if (!("keyId" in $$source)) {
+            this["keyId"] = "";
+        }
+        if (!("userIds" in $$source)) {
+            this["userIds"] = [];
+        }
+        if (!("emails" in $$source)) {
+            this["emails"] = [];
+        }
+        if (!("secret" in $$source)) {
+            this["secret"] = false;
+        }
+        if (!("unlocked" in $$source)) {
+            this["unlocked"] = false;
+        }
+        if (!("revoked" in $$source)) {
+            this["revoked"] = false;
+        }
+        if (!("canEncrypt" in $$source)) {
+            this["canEncrypt"] = false;
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+        if (!("source" in $$source)) {
+            this["source"] = "";
+        }
+        if (!("addedAt" in $$source)) {
+            this["addedAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new PGPKey instance from a string or object.
+     */
+    static createFrom($$source: any = {}): PGPKey {
+        const $$createField2_0 = $$createType4;
+        const $$createField3_0 = $$createType4;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("userIds" in $$parsedSource) {
+            $$parsedSource["userIds"] = $$createField2_0($$parsedSource["userIds"]);
+        }
+        if ("emails" in $$parsedSource) {
+            $$parsedSource["emails"] = $$createField3_0($$parsedSource["emails"]);
+        }
+        return new PGPKey($$parsedSource as Partial<PGPKey>);
+    }
+}
+
+/**
+ * PendingOp is a local change waiting to be applied on the server
+ */
+export class PendingOp {
+    "id": number;
+    "accountId": string;
+    "folder": string;
+    "uid": number;
+    "op": string;
+    "targetFolder": string;
+
+    /**
+     * the IMAP keyword of OpAddKeyword
+     */
+    "keyword"?: string;
+    "attempts": number;
+    "lastError": string;
+    "createdAt": string;
+
+    /** Creates a new PendingOp instance. */
+    constructor($$source: Partial<PendingOp> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = 0;
+        }
+        if (!("accountId" in $$source)) {
+            this["accountId"] = "";
+        }
+        if (!("folder" in $$source)) {
+            this["folder"] = "";
+        }
+        if (!("uid" in $$source)) {
+            this["uid"] = 0;
+        }
+        if (!("op" in $$source)) {
+            this["op"] = "";
+        }
+        if (!("targetFolder" in $$source)) {
+            this["targetFolder"] = "";
+        }
+        if (!("attempts" in $$source)) {
+            this["attempts"] = 0;
+        }
+        if (!("lastError" in $$source)) {
+            this["lastError"] = "";
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new PendingOp instance from a string or object.
+     */
+    static createFrom($$source: any = {}): PendingOp {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new PendingOp($$parsedSource as Partial<PendingOp>);
+    }
+}
+
+/**
+ * RecipientRecommendation is the recommendation for one recipient
+ */
+export class RecipientRecommendation {
+    "email": string;
+    "recommendation": string;
+    "keySource"?: string;
+
+    /** Creates a new RecipientRecommendation instance. */
+    constructor($$source: Partial<RecipientRecommendation> = {}) {
+        if (!("email" in $$source)) {
+            this["email"] = "";
+        }
+        if (!("recommendation" in $$source)) {
+            this["recommendation"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RecipientRecommendation instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RecipientRecommendation {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new RecipientRecommendation($$parsedSource as Partial<RecipientRecommendation>);
+    }
+}
+
+/**
+ * RecipientSuggestion is a compose autocomplete entry: an address or a group
+ */
+export class RecipientSuggestion {
+    "name": string;
+    "email": string;
+    "contactId"?: string;
+    "groupId"?: string;
+
+    /**
+     * addresses a group expands to
+     */
+    "members"?: Address[];
+    "score": number;
+
+    /** Creates a new RecipientSuggestion instance. */
+    constructor($$source: Partial<RecipientSuggestion> = {}) {
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("email" in $$source)) {
+            this["email"] = "";
+        }
+        if (!("score" in $$source)) {
+            this["score"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RecipientSuggestion instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RecipientSuggestion {
+        const $$createField4_0 = $$createType23;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("members" in $$parsedSource) {
+            $$parsedSource["members"] = $$createField4_0($$parsedSource["members"]);
+        }
+        return new RecipientSuggestion($$parsedSource as Partial<RecipientSuggestion>);
+    }
+}
+
+/**
+ * Rule files new mail: when the conditions match, the actions run
+ */
+export class Rule {
+    "id": string;
+    "name": string;
+
+    /**
+     * empty applies to every account
+     */
+    "accountId": string;
+
+    /**
+     * folder watched; empty watches the Inbox
+     */
+    "folder": string;
+    "enabled": boolean;
+
+    /**
+     * all or any of the conditions
+     */
+    "match": string;
+
+    /**
+     * Stop keeps later rules from seeing messages this one matched
+     */
+    "stop": boolean;
+    "conditions": RuleCondition[];
+    "actions": RuleAction[];
+    "position": number;
+    "createdAt": string;
+    "updatedAt": string;
+
+    /** Creates a new Rule instance. */
+    constructor($$source: Partial<Rule> = {}) {
+        if (!("id" in $$source)) {
+            this["id"] = "";
+        }
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("accountId" in $$source)) {
+            this["accountId"] = "";
+        }
+        if (!("folder" in $$source)) {
+            this["folder"] = "";
+        }
+        if (!("enabled" in $$source)) {
+            this["enabled"] = false;
+        }
+        if (!("match" in $$source)) {
+            this["match"] = "";
+        }
+        if (!("stop" in $$source)) {
+            this["stop"] = false;
+        }
+        if (!("conditions" in $$source)) {
+            this["conditions"] = [];
+        }
+        if (!("actions" in $$source)) {
+            this["actions"] = [];
+        }
+        if (!("position" in $$source)) {
+            this["position"] = 0;
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = "";
+        }
+        if (!("updatedAt" in $$source)) {
+            this["updatedAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new Rule instance from a string or object.
+     */
+    static createFrom($$source: any = {}): Rule {
+        const $$createField7_0 = $$createType43;
+        const $$createField8_0 = $$createType45;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("conditions" in $$parsedSource) {
+            $$parsedSource["conditions"] = $$createField7_0($$parsedSource["conditions"]);
+        }
+        if ("actions" in $$parsedSource) {
+            $$parsedSource["actions"] = $$createField8_0($$parsedSource["actions"]);
+        }
+        return new Rule($$parsedSource as Partial<Rule>);
+    }
+}
+
+/**
+ * RuleAction is what a rule does to a matching message
+ */
+export class RuleAction {
+    "type": string;
+
+    /**
+     * move and copy
+     */
+    "folder"?: string;
+
+    /**
+     * add-keyword
+     */
+    "keyword"?: string;
+
+    /**
+     * forward
+     */
+    "to"?: Address[];
+
+    /**
+     * auto-reply; empty answers "Re: <subject>"
+     */
+    "subject"?: string;
+
+    /**
+     * auto-reply text, or the notification message
+     */
+    "body"?: string;
+
+    /** Creates a new RuleAction instance. */
+    constructor($$source: Partial<RuleAction> = {}) {
+        if (!("type" in $$source)) {
+            this["type"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RuleAction instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RuleAction {
+        const $$createField3_0 = $$createType23;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("to" in $$parsedSource) {
+            $$parsedSource["to"] = $$createField3_0($$parsedSource["to"]);
+        }
+        return new RuleAction($$parsedSource as Partial<RuleAction>);
+    }
+}
+
+/**
+ * RuleCondition tests one property of a message
+ */
+export class RuleCondition {
+    "field": string;
+
+    /**
+     * the header name when Field is header
+     */
+    "header"?: string;
+
+    /**
+     * contains or matches; larger or smaller for size
+     */
+    "operator": string;
+
+    /**
+     * text or pattern; a byte count for size
+     */
+    "value": string;
+    "negate": boolean;
+
+    /** Creates a new RuleCondition instance. */
+    constructor($$source: Partial<RuleCondition> = {}) {
+        if (!("field" in $$source)) {
+            this["field"] = "";
+        }
+        if (!("operator" in $$source)) {
+            this["operator"] = "";
+        }
+        if (!("value" in $$source)) {
+            this["value"] = "";
+        }
+        if (!("negate" in $$source)) {
+            this["negate"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RuleCondition instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RuleCondition {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new RuleCondition($$parsedSource as Partial<RuleCondition>);
+    }
+}
+
+/**
+ * RuleDryRun reports which cached messages a rule would match
+ */
+export class RuleDryRun {
+    /**
+     * newest first, at most maxDryRunMatches
+     */
+    "matches": (Email | null)[];
+    "matched": number;
+    "scanned": number;
+
+    /**
+     * Unevaluated counts messages the rule needs the source of that is not
+     * stored locally
+     */
+    "unevaluated": number;
+
+    /** Creates a new RuleDryRun instance. */
+    constructor($$source: Partial<RuleDryRun> = {}) {
+        if (!("matches" in $$source)) {
+            this["matches"] = [];
+        }
+        if (!("matched" in $$source)) {
+            this["matched"] = 0;
+        }
+        if (!("scanned" in $$source)) {
+            this["scanned"] = 0;
+        }
+        if (!("unevaluated" in $$source)) {
+            this["unevaluated"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RuleDryRun instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RuleDryRun {
+        const $$createField0_0 = $$createType35;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("matches" in $$parsedSource) {
+            $$parsedSource["matches"] = $$createField0_0($$parsedSource["matches"]);
+        }
+        return new RuleDryRun($$parsedSource as Partial<RuleDryRun>);
+    }
+}
+
+/**
+ * RuleNotification is the payload of EventRuleNotification
+ */
+export class RuleNotification {
+    "ruleId": string;
+    "ruleName": string;
+    "accountId": string;
+    "folder": string;
+    "uid": number;
+    "from": Address[];
+    "subject": string;
+    "message": string;
+
+    /** Creates a new RuleNotification instance. */
+    constructor($$source: Partial<RuleNotification> = {}) {
+        if (!("ruleId" in $$source)) {
+            this["ruleId"] = "";
+        }
+        if (!("ruleName" in $$source)) {
+            this["ruleName"] = "";
+        }
+        if (!("accountId" in $$source)) {
+            this["accountId"] = "";
+        }
+        if (!("folder" in $$source)) {
+            this["folder"] = "";
+        }
+        if (!("uid" in $$source)) {
+            this["uid"] = 0;
+        }
+        if (!("from" in $$source)) {
+            this["from"] = [];
+        }
+        if (!("subject" in $$source)) {
+            this["subject"] = "";
+        }
+        if (!("message" in $$source)) {
+            this["message"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RuleNotification instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RuleNotification {
+        const $$createField5_0 = $$createType23;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("from" in $$parsedSource) {
+            $$parsedSource["from"] = $$createField5_0($$parsedSource["from"]);
+        }
+        return new RuleNotification($$parsedSource as Partial<RuleNotification>);
+    }
+}
+
+/**
+ * SMIMECertificate describes an S/MIME identity or trusted certificate
+ */
+export class SMIMECertificate {
+    /**
+     * SHA-256 of the certificate
+     */
+    "fingerprint": string;
+    "subject": string;
+    "emails": string[];
+    "issuer": string;
+    "notBefore": string;
+    "notAfter": string;
+
+    /**
+     * has a private key
+     */
+    "identity": boolean;
+    "unlocked": boolean;
+    "addedAt": string;
+
+    /** Creates a new SMIMECertificate instance. */
+    constructor($$source: Partial<SMIMECertificate> = {}) {
+        if (!("fingerprint" in $$source)) {
+            this["fingerprint"] = "";
+        }
+        if (!("subject" in $$source)) {
+            this["subject"] = "";
+        }
+        if (!("emails" in $$source)) {
+            this["emails"] = [];
+        }
+        if (!("issuer" in $$source)) {
+            this["issuer"] = "";
+        }
+        if (!("notBefore" in $$source)) {
+            this["notBefore"] = "";
+        }
+        if (!("notAfter" in $$source)) {
+            this["notAfter"] = "";
+        }
+        if (!("identity" in $$source)) {
+            this["identity"] = false;
+        }
+        if (!("unlocked" in $$source)) {
+            this["unlocked"] = false;
+        }
+        if (!("addedAt" in $$source)) {
+            this["addedAt"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new SMIMECertificate instance from a string or object.
+     */
+    static createFrom($$source: any = {}): SMIMECertificate {
+        const $$createField2_0 = $$createType4;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("emails" in $$parsedSource) {
+            $$parsedSource["emails"] = $$createField2_0($$parsedSource["emails"]);
+        }
+        return new SMIMECertificate($$parsedSource as Partial<SMIMECertificate>);
+    }
+}
+
+/**
+ * SendEmail sends an email
+ */
+export class SendEmailRequest {
+    "accountId": string;
+    "to": Address[];
+    "cc": Address[];
+    "bcc": Address[];
+    "replyTo": Address[];
+    "subject": string;
+    "body": string;
+    "isHTML": boolean;
+
+    /**
+     * PGP sign with the account's key
+     */
+    "sign": boolean;
+
+    /**
+     * PGP encrypt to every recipient
+     */
+    "encrypt": boolean;
+
+    /**
+     * S/MIME sign with the account's identity
+     */
+    "smimeSign": boolean;
+
+    /** Creates a new SendEmailRequest instance. */
+    constructor($$source: Partial<SendEmailRequest> = {}) {
+        if (!("accountId" in $$source)) {
+            this["accountId"] = "";
+        }
+        if (!("to" in $$source)) {
+            this["to"] = [];
+        }
+        if (!("cc" in $$source)) {
+            this["cc"] = [];
+        }
+        if (!("bcc" in $$source)) {
+            this["bcc"] = [];
+        }
+        if (!("replyTo" in $$source)) {
+            this["replyTo"] = [];
+        }
+        if (!("subject" in $$source)) {
+            this["subject"] = "";
+        }
+        if (!("body" in $$source)) {
+            this["body"] = "";
+        }
+        if (!("isHTML" in $$source)) {
+            this["isHTML"] = false;
+        }
+        if (!("sign" in $$source)) {
+            this["sign"] = false;
+        }
+        if (!("encrypt" in $$source)) {
+            this["encrypt"] = false;
+        }
+        if (!("smimeSign" in $$source)) {
+            this["smimeSign"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new SendEmailRequest instance from a string or object.
+     */
+    static createFrom($$source: any = {}): SendEmailRequest {
+        const $$createField1_0 = $$createType23;
+        const $$createField2_0 = $$createType23;
+        const $$createField3_0 = $$createType23;
+        const $$createField4_0 = $$createType23;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("to" in $$parsedSource) {
+            $$parsedSource["to"] = $$createField1_0($$parsedSource["to"]);
+        }
+        if ("cc" in $$parsedSource) {
+            $$parsedSource["cc"] = $$createField2_0($$parsedSource["cc"]);
         }
         if ("bcc" in $$parsedSource) {
             $$parsedSource["bcc"] = $$createField3_0($$parsedSource["bcc"]);
         }
+        if ("replyTo" in $$parsedSource) {
+            $$parsedSource["replyTo"] = $$createField4_0($$parsedSource["replyTo"]);
+        }
         return new SendEmailRequest($$parsedSource as Partial<SendEmailRequest>);
     }
 }
 
+/**
+ * SpamClue is a token that moved a message's spam score
+ */
+export class SpamClue {
+    "token": string;
+
+    /**
+     * spam probability of the token
+     */
+    "probability": number;
+
+    /**
+     * trained spam messages containing it
+     */
+    "spamCount": number;
+
+    /**
+     * trained good messages containing it
+     */
+    "hamCount": number;
+
+    /** Creates a new SpamClue instance. */
+    constructor($$source: Partial<SpamClue> = {}) {
+        if (!("token" in $$source)) {
+            this["token"] = "";
+        }
+        if (!("probability" in $$source)) {
+            this["probability"] = 0;
+        }
+        if (!("spamCount" in $$source)) {
+            this["spamCount"] = 0;
+        }
+        if (!("hamCount" in $$source)) {
+            this["hamCount"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new SpamClue instance from a string or object.
+     */
+    static createFrom($$source: any = {}): SpamClue {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new SpamClue($$parsedSource as Partial<SpamClue>);
+    }
+}
+
+/**
+ * SpamExplanation tells why a message got its spam score
+ */
+export class SpamExplanation {
+    /**
+     * with the model as trained now
+     */
+    "score": number;
+
+    /**
+     * CachedScore is the score given when the message was synced
+     */
+    "cachedScore"?: number | null;
+    "verdict": string;
+    "spamTrained": number;
+    "hamTrained": number;
+
+    /**
+     * strongest first
+     */
+    "spamClues": (SpamClue | null)[];
+
+    /**
+     * strongest first
+     */
+    "hamClues": (SpamClue | null)[];
+
+    /** Creates a new SpamExplanation instance. */
+    constructor($$source: Partial<SpamExplanation> = {}) {
+        if (!("score" in $$source)) {
+            this["score"] = 0;
+        }
+        if (!("verdict" in $$source)) {
+            this["verdict"] = "";
+        }
+        if (!("spamTrained" in $$source)) {
+            this["spamTrained"] = 0;
+        }
+        if (!("hamTrained" in $$source)) {
+            this["hamTrained"] = 0;
+        }
+        if (!("spamClues" in $$source)) {
+            this["spamClues"] = [];
+        }
+        if (!("hamClues" in $$source)) {
+            this["hamClues"] = [];
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new SpamExplanation instance from a string or object.
+     */
+    static createFrom($$source: any = {}): SpamExplanation {
+        const $$createField5_0 = $$createType48;
+        const $$createField6_0 = $$createType48;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("spamClues" in $$parsedSource) {
+            $$parsedSource["spamClues"] = $$createField5_0($$parsedSource["spamClues"]);
+        }
+        if ("hamClues" in $$parsedSource) {
+            $$parsedSource["hamClues"] = $$createField6_0($$parsedSource["hamClues"]);
+        }
+        return new SpamExplanation($$parsedSource as Partial<SpamExplanation>);
+    }
+}
+
+/**
+ * UnifiedEmail is a message in a unified view together with its account
+ */
+export class UnifiedEmail {
+    "email": Email | null;
+    "accountName": string;
+    "accountEmail": string;
+
+    /** Creates a new UnifiedEmail instance. */
+    constructor($$source: Partial<UnifiedEmail> = {}) {
+        if (!("email" in $$source)) {
+            this["email"] = null;
+        }
+        if (!("accountName" in $$source)) {
+            this["accountName"] = "";
+        }
+        if (!("accountEmail" in $$source)) {
+            this["accountEmail"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new UnifiedEmail instance from a string or object.
+     */
+    static createFrom($$source: any = {}): UnifiedEmail {
+        const $$createField0_0 = $$createType34;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("email" in $$parsedSource) {
+            $$parsedSource["email"] = $$createField0_0($$parsedSource["email"]);
+        }
+        return new UnifiedEmail($$parsedSource as Partial<UnifiedEmail>);
+    }
+}
+
+/**
+ * UnifiedEmailPage is one page of a unified view
+ */
+export class UnifiedEmailPage {
+    "role": string;
+    "emails": (UnifiedEmail | null)[];
+    "total": number;
+    "hasMore": boolean;
+
+    /**
+     * pass back to get the following page
+     */
+    "nextCursor": string;
+    "unread": number;
+    "unreadByAccount": { [_ in string]?: number };
+
+    /** Creates a new UnifiedEmailPage instance. */
+    constructor($$source: Partial<UnifiedEmailPage> = {}) {
+        if (!("role" in $$source)) {
+            this["role"] = "";
+        }
+        if (!("emails" in $$source)) {
+            this["emails"] = [];
+        }
+        if (!("total" in $$source)) {
+            this["total"] = 0;
+        }
+        if (!("hasMore" in $$source)) {
+            this["hasMore"] = false;
+        }
+        if (!("nextCursor" in $$source)) {
+            this["nextCursor"] = "";
+        }
+        if (!("unread" in $$source)) {
+            this["unread"] = 0;
+        }
+        if (!("unreadByAccount" in $$source)) {
+            this["unreadByAccount"] = {};
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new UnifiedEmailPage instance from a string or object.
+     */
+    static createFrom($$source: any = {}): UnifiedEmailPage {
+        const $$createField1_0 = $$createType51;
+        const $$createField6_0 = $$createType52;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("emails" in $$parsedSource) {
+            $$parsedSource["emails"] = $$createField1_0($$parsedSource["emails"]);
+        }
+        if ("unreadByAccount" in $$parsedSource) {
+            $$parsedSource["unreadByAccount"] = $$createField6_0($$parsedSource["unreadByAccount"]);
+        }
+        return new UnifiedEmailPage($$parsedSource as Partial<UnifiedEmailPage>);
+    }
+}
+
+/**
+ * ValidationResult collects the diagnostics of ValidateAccount
+ */
+export class ValidationResult {
+    "valid": boolean;
+    "stages": ValidationStage[];
+    "imapCapabilities": string[];
+    "smtpExtensions": string[];
+
+    /** Creates a new ValidationResult instance. */
+    constructor($$source: Partial<ValidationResult> = {}) {
+        if (!("valid" in $$source)) {
+            this["valid"] = false;
+        }
+        if (!("stages" in $$source)) {
+            this["stages"] = [];
+        }
+        if (!("imapCapabilities" in $$source)) {
+            this["imapCapabilities"] = [];
+        }
+        if (!("smtpExtensions" in $$source)) {
+            this["smtpExtensions"] = [];
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ValidationResult instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ValidationResult {
+        const $$createField1_0 = $$createType54;
+        const $$createField2_0 = $$createType4;
+        const $$createField3_0 = $$createType4;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("stages" in $$parsedSource) {
+            $$parsedSource["stages"] = $$createField1_0($$parsedSource["stages"]);
+        }
+        if ("imapCapabilities" in $$parsedSource) {
+            $$parsedSource["imapCapabilities"] = $$createField2_0($$parsedSource["imapCapabilities"]);
+        }
+        if ("smtpExtensions" in $$parsedSource) {
+            $$parsedSource["smtpExtensions"] = $$createField3_0($$parsedSource["smtpExtensions"]);
+        }
+        return new ValidationResult($$parsedSource as Partial<ValidationResult>);
+    }
+}
+
+/**
+ * ValidationStage is the outcome of one validation step
+ */
+export class ValidationStage {
+    "stage": string;
+    "success": boolean;
+    "skipped": boolean;
+    "message": string;
+    "durationMs": number;
+
+    /** Creates a new ValidationStage instance. */
+    constructor($$source: Partial<ValidationStage> = {}) {
+        if (!("stage" in $$source)) {
+            this["stage"] = "";
+        }
+        if (!("success" in $$source)) {
+            this["success"] = false;
+        }
+        if (!("skipped" in $$source)) {
+            this["skipped"] = false;
+        }
+        if (!("message" in $$source)) {
+            this["message"] = "";
+        }
+        if (!("durationMs" in $$source)) {
+            this["durationMs"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ValidationStage instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ValidationStage {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ValidationStage($$parsedSource as Partial<ValidationStage>);
+    }
+}
+
 // Private type creation functions
-const $$createType0 = Folder.createFrom;
-const $$createType1 = $Create.Array($$createType0);
-const $$createType2 = $Create.Array($Create.Any);
+const $$createType0 = OAuthConfig.createFrom;
+const $$createType1 = $Create.Nullable($$createType0);
+const $$createType2 = CacheLimits.createFrom;
+const $$createType3 = $Create.Nullable($$createType2);
+const $$createType4 = $Create.Array($Create.Any);
+const $$createType5 = Folder.createFrom;
+const $$createType6 = $Create.Array($$createType5);
+const $$createType7 = FolderCacheStats.createFrom;
+const $$createType8 = $Create.Nullable($$createType7);
+const $$createType9 = $Create.Array($$createType8);
+const $$createType10 = DKIMResult.createFrom;
+const $$createType11 = $Create.Nullable($$createType10);
+const $$createType12 = $Create.Array($$createType11);
+const $$createType13 = ARCHop.createFrom;
+const $$createType14 = $Create.Nullable($$createType13);
+const $$createType15 = $Create.Array($$createType14);
+const $$createType16 = AccountCacheStats.createFrom;
+const $$createType17 = $Create.Nullable($$createType16);
+const $$createType18 = $Create.Array($$createType17);
+const $$createType19 = Attendee.createFrom;
+const $$createType20 = $Create.Nullable($$createType19);
+const $$createType21 = $Create.Array($$createType20);
+const $$createType22 = Address.createFrom;
+const $$createType23 = $Create.Array($$createType22);
+const $$createType24 = Invitation.createFrom;
+const $$createType25 = $Create.Nullable($$createType24);
+const $$createType26 = MessageSecurity.createFrom;
+const $$createType27 = $Create.Nullable($$createType26);
+const $$createType28 = AuthVerdict.createFrom;
+const $$createType29 = $Create.Nullable($$createType28);
+const $$createType30 = MessageWarning.createFrom;
+const $$createType31 = $Create.Nullable($$createType30);
+const $$createType32 = $Create.Array($$createType31);
+const $$createType33 = Email.createFrom;
+const $$createType34 = $Create.Nullable($$createType33);
+const $$createType35 = $Create.Array($$createType34);
+const $$createType36 = RecipientRecommendation.createFrom;
+const $$createType37 = $Create.Nullable($$createType36);
+const $$createType38 = $Create.Array($$createType37);
+const $$createType39 = EventOccurrence.createFrom;
+const $$createType40 = $Create.Nullable($$createType39);
+const $$createType41 = $Create.Array($$createType40);
+const $$createType42 = RuleCondition.createFrom;
+const $$createType43 = $Create.Array($$createType42);
+const $$createType44 = RuleAction.createFrom;
+const $$createType45 = $Create.Array($$createType44);
+const $$createType46 = SpamClue.createFrom;
+const $$createType47 = $Create.Nullable($$createType46);
+const $$createType48 = $Create.Array($$createType47);
+const $$createType49 = UnifiedEmail.createFrom;
+const $$createType50 = $Create.Nullable($$createType49);
+const $$createType51 = $Create.Array($$createType50);
+const $$createType52 = $Create.Map($Create.Any, $Create.Any);
+const $$createType53 = ValidationStage.createFrom;
+const $$createType54 = $Create.Array($$createType53);
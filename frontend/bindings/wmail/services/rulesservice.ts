@@ -0,0 +1,66 @@
+// Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
+// This file is automatically generated. DO NOT EDIT
+
+/**
+ * RulesService runs the user's rules on mail that arrives during a sync
+ * @module
+ */
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Create } from "@wailsio/runtime";
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as $models from "./models.js";
+
+/**
+ * DeleteRule removes a rule
+ */
+export function DeleteRule(id: string): $CancellablePromise<void> {
+    return $Call.ByID(1463735509, id);
+}
+
+/**
+ * DryRunRule reports the cached messages of the rule's folder a rule would
+ * match, without running its actions. The rule need not be saved.
+ * Conditions on headers, size and attachments only see messages whose
+ * source is stored; nothing is downloaded.
+ */
+export function DryRunRule(rule: $models.Rule | null): $CancellablePromise<$models.RuleDryRun | null> {
+    return $Call.ByID(3989570576, rule).then(($result: any) => {
+        return $$createType1($result);
+    });
+}
+
+/**
+ * GetRules returns every rule in the order they run
+ */
+export function GetRules(): $CancellablePromise<($models.Rule | null)[]> {
+    return $Call.ByID(3276195347).then(($result: any) => {
+        return $$createType4($result);
+    });
+}
+
+/**
+ * ReorderRules sets the order rules run in
+ */
+export function ReorderRules(ids: string[]): $CancellablePromise<void> {
+    return $Call.ByID(1520076978, ids);
+}
+
+/**
+ * SaveRule creates a rule, or updates the one with the same ID
+ */
+export function SaveRule(rule: $models.Rule | null): $CancellablePromise<$models.Rule | null> {
+    return $Call.ByID(2193288107, rule).then(($result: any) => {
+        return $$createType3($result);
+    });
+}
+
+// Private type creation functions
+const $$createType0 = $models.RuleDryRun.createFrom;
+const $$createType1 = $Create.Nullable($$createType0);
+const $$createType2 = $models.Rule.createFrom;
+const $$createType3 = $Create.Nullable($$createType2);
+const $$createType4 = $Create.Array($$createType3);
@@ -2,11 +2,11 @@ import { Email } from '#/wmail/services'
 import clsx from 'clsx'
 import { Component } from 'solid-js'
 import { fullDate } from '~/utils/date'
-import { getEmailSender } from '~/utils/email-sender'
+import { formatAddresses, getEmailSender } from '~/utils/email-sender'
 
 const EmailContent: Component<{ email: Email }> = (props) => {
-  const sender = props.email.from
-  const initials = getEmailSender(sender).slice(0, 2).toUpperCase()
+  const sender = getEmailSender(props.email.from)
+  const initials = sender.slice(0, 2).toUpperCase()
 
   return (
     <main>
@@ -24,7 +24,9 @@ const EmailContent: Component<{ email: Email }> = (props) => {
         </div>
         <div class="min-w-0 flex-1">
           <div class="flex items-start justify-between gap-4">
-            <h3 class="text-base font-600 truncate">{sender}</h3>
+            <h3 class="text-base font-600 truncate" title={formatAddresses(props.email.from)}>
+              {sender}
+            </h3>
             <span class="text-sm text-mut-foreground whitespace-nowrap">
               {fullDate(props.email.date)}
             </span>
@@ -32,7 +34,7 @@ const EmailContent: Component<{ email: Email }> = (props) => {
           <div class="text-lg font-500 mt-1 whitespace-break-spaces break-all">
             {props.email.subject}
           </div>
-          <div class="text-sm">To: {props.email.to}</div>
+          <div class="text-sm">To: {formatAddresses(props.email.to)}</div>
         </div>
       </header>
       <pre class="p-4" style="white-space: pre-wrap; word-break: break-word;">
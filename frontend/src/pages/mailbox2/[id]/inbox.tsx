@@ -26,11 +26,11 @@ export default function InboxPage() {
 
     setLoading(true)
     try {
-      console.log('Calling GetEmails with:', params.id, 'INBOX', 1, 50, forceRefresh)
-      const result = await MailService.GetEmails(params.id, 'INBOX', 1, 50, forceRefresh)
-      console.log('GetEmails result length:', result?.length)
-      console.log('GetEmails result sample:', result?.[0])
-      setEmails(result.filter((e) => e !== null))
+      console.log('Calling GetEmails with:', params.id, 'INBOX', '', 50, forceRefresh)
+      const result = await MailService.GetEmails(params.id, 'INBOX', '', 50, forceRefresh)
+      console.log('GetEmails result length:', result?.emails.length, 'of', result?.total)
+      console.log('GetEmails result sample:', result?.emails[0])
+      setEmails((result?.emails ?? []).filter((e) => e !== null))
       console.log('emails signal after set:', emails())
     } catch (error) {
       console.error('Failed to load emails:', error)
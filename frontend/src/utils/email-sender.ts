@@ -1,11 +1,23 @@
+import { Address } from '#/wmail/services'
+
 function capitalize(word: string) {
   return word[0].toUpperCase() + word.slice(1)
 }
 
-function getEmailSender(email: string) {
-  const domain = email.split('@')[1]
+function getEmailSender(addrs: (Address | null)[] | null) {
+  const first = addrs?.[0]
+  if (!first) return ''
+  if (first.name) return first.name
+  const domain = first.email.split('@')[1] ?? first.email
   const slices = domain.split('.')
-  return capitalize(slices[slices.length - 2])
+  return capitalize(slices[Math.max(slices.length - 2, 0)])
+}
+
+function formatAddresses(addrs: (Address | null)[] | null) {
+  return (addrs ?? [])
+    .filter((a): a is Address => a !== null)
+    .map((a) => (a.name ? `${a.name} <${a.email}>` : a.email))
+    .join(', ')
 }
 
-export { getEmailSender, capitalize }
+export { getEmailSender, formatAddresses, capitalize }
@@ -21,6 +21,9 @@ var assets embed.FS
 func init() {
 	// Register custom events here for frontend communication
 	// Example: application.RegisterEvent[string]("email:received")
+	application.RegisterEvent[services.ConnectivityState](services.EventConnectivityChanged)
+	application.RegisterEvent[services.RuleNotification](services.EventRuleNotification)
+	application.RegisterEvent[services.FolderSynced](services.EventFolderSynced)
 }
 
 // main function serves as the application's entry point. It initializes the application, creates a window,
@@ -31,6 +34,9 @@ func main() {
 	accountService := services.NewMailAccountService()
 	mailService := services.NewMailService(accountService)
 	noteService := services.NewNoteService()
+	contactService := services.NewContactService(accountService, mailService)
+	calendarService := services.NewCalendarService(accountService, mailService)
+	rulesService := services.NewRulesService(accountService, mailService)
 
 	// Create a new Wails application by providing the necessary options.
 	// Variables 'Name' and 'Description' are for application metadata.
@@ -46,6 +52,9 @@ func main() {
 			application.NewService(accountService),
 			application.NewService(mailService),
 			application.NewService(noteService),
+			application.NewService(contactService),
+			application.NewService(calendarService),
+			application.NewService(rulesService),
 		},
 		Assets: application.AssetOptions{
 			Handler: application.AssetFileServerFS(assets),
@@ -55,6 +64,10 @@ func main() {
 		},
 	})
 
+	services.SetEventEmitter(func(name string, data interface{}) {
+		app.Event.Emit(name, data)
+	})
+
 	// Create a new window with the necessary options.
 	// 'Title' is the title of the window.
 	// 'Mac' options tailor the window when running on macOS.
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AccountLifecycleHook lets subsystems that keep per-account data (the email
+// cache, stored credentials, outbox, search index...) react to account
+// lifecycle events. Either callback may be nil.
+type AccountLifecycleHook struct {
+	Name string
+	// OnExport writes the subsystem's data for the account into dir
+	OnExport func(account *Account, dir string) error
+	// OnDelete removes the subsystem's data for a deleted account
+	OnDelete func(account *Account) error
+}
+
+// RegisterLifecycleHook adds a hook that runs on account export and deletion
+func (s *MailAccountService) RegisterLifecycleHook(hook AccountLifecycleHook) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// lifecycleHooks returns a snapshot of the registered hooks
+func (s *MailAccountService) lifecycleHooks() []AccountLifecycleHook {
+	s.hooksMutex.RLock()
+	defer s.hooksMutex.RUnlock()
+	return append([]AccountLifecycleHook(nil), s.hooks...)
+}
+
+// ExportAccount writes the account settings and all local data to a new
+// directory inside exportDir and returns its path
+func (s *MailAccountService) ExportAccount(id, exportDir string) (string, error) {
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return "", err
+	}
+	return s.exportAccount(account, exportDir)
+}
+
+// DeleteAccountWithExport exports an account's data before deleting it.
+// Nothing is deleted if the export fails.
+func (s *MailAccountService) DeleteAccountWithExport(id, exportDir string) (string, error) {
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := s.exportAccount(account, exportDir)
+	if err != nil {
+		return "", fmt.Errorf("export failed, account was not deleted: %w", err)
+	}
+
+	return path, s.DeleteAccount(id)
+}
+
+func (s *MailAccountService) exportAccount(account *Account, exportDir string) (string, error) {
+	if exportDir == "" {
+		return "", fmt.Errorf("export directory is empty")
+	}
+
+	name := sanitizeFileName(account.Email)
+	if name == "" {
+		name = account.ID
+	}
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+	// Exports within the same second get their own directory, since the
+	// mbox files are appended to
+	base := filepath.Join(exportDir, fmt.Sprintf("%s-%s", name, time.Now().Format("20060102-150405")))
+	dir := base
+	for n := 2; ; n++ {
+		err := os.Mkdir(dir, 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create export directory: %w", err)
+		}
+		dir = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	// Settings only; secrets stay behind
+	settings := *account
+	settings.Password = ""
+	if settings.OAuth != nil {
+		oauth := *settings.OAuth
+		oauth.ClientSecret = ""
+		settings.OAuth = &oauth
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "account.json"), data, 0600); err != nil {
+		return "", err
+	}
+
+	for _, hook := range s.lifecycleHooks() {
+		if hook.OnExport == nil {
+			continue
+		}
+		if err := hook.OnExport(account, dir); err != nil {
+			return "", fmt.Errorf("%s export failed: %w", hook.Name, err)
+		}
+	}
+
+	fmt.Printf("[MailAccountService] Exported account %s to %s\n", account.Email, dir)
+	return dir, nil
+}
+
+// runDeleteHooks cascades an account deletion through every subsystem.
+// All hooks run even if one fails.
+func (s *MailAccountService) runDeleteHooks(account *Account) error {
+	var errs []error
+	for _, hook := range s.lifecycleHooks() {
+		if hook.OnDelete == nil {
+			continue
+		}
+		if err := hook.OnDelete(account); err != nil {
+			fmt.Printf("[MailAccountService] %s cleanup failed for %s: %v\n", hook.Name, account.Email, err)
+			errs = append(errs, fmt.Errorf("%s: %w", hook.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._@+-]+`)
+
+// sanitizeFileName makes a string safe to use as a file name
+func sanitizeFileName(name string) string {
+	return strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "._")
+}
+
+// credentialsLifecycleHook removes stored OAuth tokens
+func credentialsLifecycleHook() AccountLifecycleHook {
+	return AccountLifecycleHook{
+		Name: "credentials",
+		OnDelete: func(account *Account) error {
+			return getTokenStore().Delete(account.ID)
+		},
+	}
+}
+
+// lifecycleHook exports cached mail as mbox files and drops it and the
+// offline journal on delete
+func (c *EmailCache) lifecycleHook() AccountLifecycleHook {
+	return AccountLifecycleHook{
+		Name: "email cache",
+		OnExport: func(account *Account, dir string) error {
+			return c.ExportAccountMbox(account.ID, dir)
+		},
+		OnDelete: func(account *Account) error {
+			if err := c.DeletePendingOps(account.ID); err != nil {
+				return err
+			}
+			return c.DeleteAccountData(account.ID)
+		},
+	}
+}
+
+// DeleteAccountData removes every cached email of an account and reclaims its blobs
+func (c *EmailCache) DeleteAccountData(accountID string) error {
+	c.lock.Lock()
+	res, err := c.db.Exec(`DELETE FROM emails WHERE account_id = ?`, accountID)
+	if err == nil {
+		err = c.deleteAutocryptPeers(accountID)
+	}
+	if err == nil {
+		err = c.deleteSpamTraining(accountID)
+	}
+	if err == nil {
+		_, err = c.db.Exec(`DELETE FROM folder_state WHERE account_id = ?`, accountID)
+	}
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil {
+		fmt.Printf("[EmailCache] Deleted %d cached emails for account %s\n", n, accountID)
+	}
+
+	_, _, err = c.CollectGarbage()
+	return err
+}
+
+// ExportAccountMbox writes one mboxrd file per cached folder into dir.
+// Messages with a stored source are exported verbatim; the rest are
+// rebuilt from the cached headers and body.
+func (c *EmailCache) ExportAccountMbox(accountID, dir string) error {
+	emails, hashes, err := c.accountEmails(accountID)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]*bufio.Writer)
+	// Folder names that sanitize alike, or differ only in case, must not
+	// share a file: it is opened for appending
+	used := make(map[string]bool)
+	var handles []*os.File
+	defer func() {
+		for _, f := range handles {
+			f.Close()
+		}
+	}()
+
+	for i, email := range emails {
+		w, ok := files[email.Folder]
+		if !ok {
+			base := sanitizeFileName(email.Folder)
+			if base == "" {
+				base = "folder"
+			}
+			name := base
+			for n := 2; used[strings.ToLower(name)]; n++ {
+				name = fmt.Sprintf("%s-%d", base, n)
+			}
+			used[strings.ToLower(name)] = true
+			f, err := os.OpenFile(filepath.Join(dir, name+".mbox"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return err
+			}
+			handles = append(handles, f)
+			w = bufio.NewWriter(f)
+			files[email.Folder] = w
+		}
+
+		var raw []byte
+		if hashes[i] != "" {
+			raw, err = c.blobs.Get(hashes[i])
+			if err != nil {
+				fmt.Printf("[EmailCache] Stored source of %s unavailable, rebuilding: %v\n", email.ID, err)
+			}
+		}
+		if raw == nil {
+			raw = synthesizeMessage(email)
+		}
+
+		if err := writeMboxMessage(w, email, raw); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range files {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, f := range handles {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountEmails loads all cached emails of an account with their blob hashes
+func (c *EmailCache) accountEmails(accountID string) ([]*Email, []string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	rows, err := c.db.Query(`
+		SELECT `+emailColumns+`, raw_hash
+		FROM emails
+		WHERE account_id = ?
+		ORDER BY folder, uid
+	`, accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var emails []*Email
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		email, err := scanEmail(&extraColumnScanner{rows: rows, extra: []interface{}{&hash}})
+		if err != nil {
+			return nil, nil, err
+		}
+		emails = append(emails, email)
+		hashes = append(hashes, hash)
+	}
+	return emails, hashes, rows.Err()
+}
+
+// extraColumnScanner appends destinations for columns selected after emailColumns
+type extraColumnScanner struct {
+	rows  rowScanner
+	extra []interface{}
+}
+
+func (s *extraColumnScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.extra...)...)
+}
+
+// synthesizeMessage rebuilds a minimal RFC 5322 message from cached fields
+func synthesizeMessage(email *Email) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", formatAddressHeader(email.From))
+	if len(email.Sender) > 0 {
+		fmt.Fprintf(&b, "Sender: %s\r\n", formatAddressHeader(email.Sender))
+	}
+	if len(email.ReplyTo) > 0 {
+		fmt.Fprintf(&b, "Reply-To: %s\r\n", formatAddressHeader(email.ReplyTo))
+	}
+	if len(email.To) > 0 {
+		fmt.Fprintf(&b, "To: %s\r\n", formatAddressHeader(email.To))
+	}
+	if len(email.CC) > 0 {
+		fmt.Fprintf(&b, "Cc: %s\r\n", formatAddressHeader(email.CC))
+	}
+	// A cached subject is decoded text: line breaks would start new header
+	// fields, and anything but ASCII needs encoded-words
+	subject := strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(email.Subject)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	if t, err := time.Parse(time.RFC3339, email.Date); err == nil {
+		fmt.Fprintf(&b, "Date: %s\r\n", t.Format(time.RFC1123Z))
+	}
+	if email.MessageID != "" {
+		fmt.Fprintf(&b, "Message-ID: <%s>\r\n", strings.Trim(email.MessageID, "<>"))
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+var mboxFromLine = regexp.MustCompile(`^>*From `)
+
+// writeMboxMessage appends one message in mboxrd format
+func writeMboxMessage(w *bufio.Writer, email *Email, raw []byte) error {
+	date := time.Now()
+	if t, err := time.Parse(time.RFC3339, email.Date); err == nil {
+		date = t
+	}
+
+	sender := "MAILER-DAEMON"
+	if len(email.From) > 0 && email.From[0].Email != "" {
+		sender = email.From[0].Email
+	}
+	if _, err := fmt.Fprintf(w, "From %s %s\n", sender, date.UTC().Format(time.ANSIC)); err != nil {
+		return err
+	}
+
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	for _, line := range strings.Split(strings.TrimRight(string(raw), "\n"), "\n") {
+		if mboxFromLine.MatchString(line) {
+			line = ">" + line
+		}
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("\n")
+	return err
+}
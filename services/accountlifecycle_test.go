@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDeleteAccountWithExportCascades(t *testing.T) {
+	cache := newTestCache(t)
+	accounts := &MailAccountService{
+		accounts: map[string]*Account{
+			"a": {ID: "a", Email: "me@example.org", Password: "secret"},
+			"b": {ID: "b", Email: "other@example.org"},
+		},
+		accountsFile: NewConfigFile(filepath.Join(t.TempDir(), "accounts.json"), 0600),
+	}
+	accounts.RegisterLifecycleHook(cache.lifecycleHook())
+
+	var emails []*Email
+	for i, folder := range []string{"INBOX", "Inbox", "a/b", "a_b"} {
+		emails = append(emails, &Email{ID: generateUUID(), AccountID: "a", Folder: folder, UID: uint32(i + 1),
+			From: []Address{{Email: "jane@shop.example"}}, Subject: "Grüße\r\nBcc: everyone@example.net",
+			Date: "2024-01-01T00:00:00Z", Body: "From the shop\nhello", CreatedAt: getCurrentTime()})
+	}
+	emails = append(emails, &Email{ID: generateUUID(), AccountID: "b", Folder: "INBOX", UID: 1,
+		Date: "2024-01-01T00:00:00Z", CreatedAt: getCurrentTime()})
+	if err := cache.CacheEmails(emails); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed export leaves everything in place
+	exportDir := t.TempDir()
+	accounts.RegisterLifecycleHook(AccountLifecycleHook{Name: "broken", OnExport: func(*Account, string) error {
+		return errors.New("disk full")
+	}})
+	if _, err := accounts.DeleteAccountWithExport("a", exportDir); err == nil {
+		t.Fatal("deleted despite a failed export")
+	}
+	if _, err := accounts.GetAccount("a"); err != nil {
+		t.Fatal("account deleted despite a failed export")
+	}
+	if n, _ := cache.GetCachedCount("a", "INBOX"); n != 1 {
+		t.Fatal("cache cleared despite a failed export")
+	}
+	accounts.hooks = accounts.hooks[:1]
+
+	var deleted []string
+	accounts.RegisterLifecycleHook(AccountLifecycleHook{Name: "recorder", OnDelete: func(account *Account) error {
+		deleted = append(deleted, account.ID)
+		return nil
+	}})
+	dir, err := accounts.DeleteAccountWithExport("a", exportDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := os.ReadFile(filepath.Join(dir, "account.json"))
+	if err != nil || strings.Contains(string(settings), "secret") {
+		t.Errorf("exported settings %s, %v", settings, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.mbox"))
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+		data, _ := os.ReadFile(file)
+		mbox := string(data)
+		if n := strings.Count(mbox, "\nFrom ") + 1; !strings.HasPrefix(mbox, "From ") || n != 1 {
+			t.Errorf("%s holds %d messages", filepath.Base(file), n)
+		}
+		if !strings.Contains(mbox, "\n>From the shop\n") {
+			t.Errorf("%s: body From line not quoted", filepath.Base(file))
+		}
+		if strings.Contains(mbox, "\nBcc:") || !strings.Contains(mbox, "\nSubject: =?utf-8?q?Gr=C3=BC=C3=9Fe_Bcc:_everyone@example.net?=\n") {
+			t.Errorf("%s: subject not encoded:\n%s", filepath.Base(file), mbox)
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "INBOX.mbox Inbox-2.mbox a_b-2.mbox a_b.mbox" {
+		t.Errorf("mbox files %v", names)
+	}
+
+	if _, err := accounts.GetAccount("a"); err == nil {
+		t.Error("account still present")
+	}
+	if len(deleted) != 1 || deleted[0] != "a" {
+		t.Errorf("delete hooks ran for %v", deleted)
+	}
+	for _, folder := range []string{"INBOX", "Inbox", "a/b", "a_b"} {
+		if n, _ := cache.GetCachedCount("a", folder); n != 0 {
+			t.Errorf("%d cached emails left in %s", n, folder)
+		}
+	}
+	if n, _ := cache.GetCachedCount("b", "INBOX"); n != 1 {
+		t.Error("another account's mail was deleted")
+	}
+}
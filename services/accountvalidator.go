@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Validation stages, in the order they run
+const (
+	StageIMAPConnect      = "imap_connect"
+	StageIMAPLogin        = "imap_login"
+	StageIMAPCapabilities = "imap_capabilities"
+	StageSMTPConnect      = "smtp_connect"
+	StageSMTPLogin        = "smtp_login"
+	StageSMTPCapabilities = "smtp_capabilities"
+)
+
+// ValidationStage is the outcome of one validation step
+type ValidationStage struct {
+	Stage      string `json:"stage"`
+	Success    bool   `json:"success"`
+	Skipped    bool   `json:"skipped"`
+	Message    string `json:"message"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// ValidationResult collects the diagnostics of ValidateAccount
+type ValidationResult struct {
+	Valid            bool              `json:"valid"`
+	Stages           []ValidationStage `json:"stages"`
+	IMAPCapabilities []string          `json:"imapCapabilities"`
+	SMTPExtensions   []string          `json:"smtpExtensions"`
+}
+
+// smtpExtensionNames are the extensions reported in diagnostics
+var smtpExtensionNames = []string{"STARTTLS", "AUTH", "SIZE", "8BITMIME", "SMTPUTF8", "PIPELINING", "CHUNKING", "DSN"}
+
+// ValidateAccount checks that an account can connect and log in to both servers.
+// It does not touch stored accounts, so it can be called before AddAccount.
+func (s *MailAccountService) ValidateAccount(account *Account) *ValidationResult {
+	result := &ValidationResult{}
+
+	run := func(stage string, fn func() (string, error)) bool {
+		start := time.Now()
+		msg, err := fn()
+		vs := ValidationStage{
+			Stage:      stage,
+			Success:    err == nil,
+			Message:    msg,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			vs.Message = err.Error()
+		}
+		fmt.Printf("[ValidateAccount] %s: success=%t %s\n", stage, vs.Success, vs.Message)
+		result.Stages = append(result.Stages, vs)
+		return err == nil
+	}
+	skip := func(stages ...string) {
+		for _, stage := range stages {
+			result.Stages = append(result.Stages, ValidationStage{
+				Stage:   stage,
+				Skipped: true,
+				Message: "skipped because a previous stage failed",
+			})
+		}
+	}
+
+	imapOK := s.validateIMAP(account, result, run, skip)
+	smtpOK := s.validateSMTP(account, result, run, skip)
+	result.Valid = imapOK && smtpOK
+
+	return result
+}
+
+type stageRunner func(stage string, fn func() (string, error)) bool
+
+func (s *MailAccountService) validateIMAP(account *Account, result *ValidationResult, run stageRunner, skip func(...string)) bool {
+	if account.IMAPHost == "" || account.IMAPPort == 0 {
+		run(StageIMAPConnect, func() (string, error) {
+			return "", fmt.Errorf("IMAP host and port are required")
+		})
+		skip(StageIMAPLogin, StageIMAPCapabilities)
+		return false
+	}
+
+	c, err := dialIMAP(account)
+	if !run(StageIMAPConnect, func() (string, error) {
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("connected to %s:%d", account.IMAPHost, account.IMAPPort), nil
+	}) {
+		skip(StageIMAPLogin, StageIMAPCapabilities)
+		return false
+	}
+	defer c.Logout()
+
+	if !run(StageIMAPLogin, func() (string, error) {
+		if err := authenticateIMAP(c, account); err != nil {
+			return "", err
+		}
+		return "authenticated", nil
+	}) {
+		skip(StageIMAPCapabilities)
+		return false
+	}
+
+	return run(StageIMAPCapabilities, func() (string, error) {
+		caps, err := c.Capability()
+		if err != nil {
+			return "", err
+		}
+		for name, ok := range caps {
+			if ok {
+				result.IMAPCapabilities = append(result.IMAPCapabilities, name)
+			}
+		}
+		sort.Strings(result.IMAPCapabilities)
+		if !caps["IMAP4rev1"] && !caps["IMAP4REV1"] && !caps["IMAP4rev2"] {
+			return "", fmt.Errorf("server does not advertise IMAP4rev1")
+		}
+		return strings.Join(result.IMAPCapabilities, " "), nil
+	})
+}
+
+func (s *MailAccountService) validateSMTP(account *Account, result *ValidationResult, run stageRunner, skip func(...string)) bool {
+	if account.SMTPHost == "" || account.SMTPPort == 0 {
+		run(StageSMTPConnect, func() (string, error) {
+			return "", fmt.Errorf("SMTP host and port are required")
+		})
+		skip(StageSMTPLogin, StageSMTPCapabilities)
+		return false
+	}
+
+	c, err := dialSMTP(account)
+	if !run(StageSMTPConnect, func() (string, error) {
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("connected to %s:%d", account.SMTPHost, account.SMTPPort), nil
+	}) {
+		skip(StageSMTPLogin, StageSMTPCapabilities)
+		return false
+	}
+	defer c.Close()
+
+	if !run(StageSMTPLogin, func() (string, error) {
+		if err := authenticateSMTP(c, account); err != nil {
+			return "", err
+		}
+		return "authenticated", nil
+	}) {
+		skip(StageSMTPCapabilities)
+		return false
+	}
+
+	ok := run(StageSMTPCapabilities, func() (string, error) {
+		for _, ext := range smtpExtensionNames {
+			if ok, param := c.Extension(ext); ok {
+				if param != "" {
+					ext += " " + param
+				}
+				result.SMTPExtensions = append(result.SMTPExtensions, ext)
+			}
+		}
+		return strings.Join(result.SMTPExtensions, ", "), nil
+	})
+	c.Quit()
+	return ok
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+)
+
+func init() {
+	// Let go-imap decode encoded words in any charset, not just UTF-8
+	imap.CharsetReader = charset.Reader
+}
+
+// Address is a mailbox with an optional display name
+type Address struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// String formats the address for a message header, RFC 2047 encoding the
+// name when it is not plain ASCII
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Email
+	}
+	return (&mail.Address{Name: a.Name, Address: a.Email}).String()
+}
+
+// Display returns the name if there is one, else the address
+func (a Address) Display() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Email
+}
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// decodeHeaderText decodes RFC 2047 encoded words, leaving malformed input as is
+func decodeHeaderText(s string) string {
+	decoded, err := headerDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+// addressesFromIMAP converts envelope addresses, skipping group markers.
+// go-imap already decoded the names: decoding again would turn a name that
+// merely shows an encoded word into something else.
+func addressesFromIMAP(addrs []*imap.Address) []Address {
+	result := make([]Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == nil || addr.MailboxName == "" || addr.HostName == "" {
+			continue
+		}
+		result = append(result, Address{
+			Name:  strings.TrimSpace(addr.PersonalName),
+			Email: addr.Address(),
+		})
+	}
+	return result
+}
+
+// parseAddressList parses a header value such as `"Doe, Jane" <jane@example.com>, bob@example.com`.
+// Unparseable input is kept as a single address so nothing is silently lost.
+func parseAddressList(s string) []Address {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return []Address{}
+	}
+
+	list, err := mail.ParseAddressList(s)
+	if err != nil {
+		return []Address{{Email: s}}
+	}
+	result := make([]Address, 0, len(list))
+	for _, addr := range list {
+		result = append(result, Address{Name: addr.Name, Email: addr.Address})
+	}
+	return result
+}
+
+// formatAddressHeader formats addresses as a header value
+func formatAddressHeader(addrs []Address) string {
+	formatted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		formatted[i] = addr.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// sameAddresses reports whether two lists hold the same mailboxes
+func sameAddresses(a, b []Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i].Email, b[i].Email) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeAddresses serializes addresses for the cache
+func encodeAddresses(addrs []Address) string {
+	if len(addrs) == 0 {
+		return "[]"
+	}
+	data, err := json.Marshal(addrs)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+// decodeAddresses reads addresses stored by encodeAddresses. Values written
+// before addresses were structured are bare, comma-separated addresses.
+func decodeAddresses(s string) []Address {
+	if strings.HasPrefix(s, "[") {
+		var addrs []Address
+		if err := json.Unmarshal([]byte(s), &addrs); err == nil {
+			return addrs
+		}
+	}
+	return legacyAddresses(s)
+}
+
+// legacyAddresses splits the comma-separated format of older caches
+func legacyAddresses(s string) []Address {
+	result := []Address{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, Address{Email: part})
+		}
+	}
+	return result
+}
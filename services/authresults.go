@@ -0,0 +1,647 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// Overall sender authentication verdicts
+const (
+	AuthPass = "pass" // the From domain is authenticated
+	AuthFail = "fail" // DMARC or SPF rejected the sender
+	AuthNone = "none" // nothing ties the message to its From domain
+)
+
+// Where a verdict's results came from
+const (
+	AuthSourceServer = "server" // the account's server, via Authentication-Results
+	AuthSourceLocal  = "local"  // local DKIM verification only
+)
+
+// Warnings shown on a message
+const (
+	AuthWarningFailed = "failed"
+	// AuthWarningUnverifiedContact flags mail claiming to be from a known
+	// contact that is not authenticated
+	AuthWarningUnverifiedContact = "unverified-contact"
+)
+
+// AuthVerdict summarizes how well the sender of a message is authenticated.
+// Results are only taken from headers added by the account's own server.
+type AuthVerdict struct {
+	Verdict     string        `json:"verdict"`
+	SPF         string        `json:"spf,omitempty"` // RFC 8601 result, empty when unknown
+	DKIM        string        `json:"dkim,omitempty"`
+	DMARC       string        `json:"dmarc,omitempty"`
+	ARC         string        `json:"arc,omitempty"`
+	Source      string        `json:"source,omitempty"`
+	AuthServID  string        `json:"authServId,omitempty"` // the server whose results were used
+	FromDomain  string        `json:"fromDomain"`
+	SPFDomain   string        `json:"spfDomain,omitempty"`
+	DKIMDomains []string      `json:"dkimDomains,omitempty"` // domains of passing signatures
+	LocalDKIM   []*DKIMResult `json:"localDkim,omitempty"`
+	ARCHops     []*ARCHop     `json:"arcHops,omitempty"` // unverified, shown for information only
+	KnownSender bool          `json:"knownSender"`
+	Warning     string        `json:"warning,omitempty"`
+}
+
+// ARCHop is one instance of an ARC chain (RFC 8617)
+type ARCHop struct {
+	Instance        int    `json:"instance"`
+	AuthServID      string `json:"authServId"`
+	Domain          string `json:"domain"`          // d= of the ARC-Seal
+	ChainValidation string `json:"chainValidation"` // cv= of the ARC-Seal
+}
+
+// authResults is one parsed Authentication-Results header (RFC 8601)
+type authResults struct {
+	authServID string
+	results    []authResult
+}
+
+type authResult struct {
+	method string
+	result string
+	reason string
+	props  map[string]string // ptype.property, e.g. header.d
+}
+
+// providerAuthServDomains maps mailbox domains to the domain their
+// provider's servers stamp results with
+var providerAuthServDomains = map[string]string{
+	"gmail.com":      "google.com",
+	"googlemail.com": "google.com",
+	"me.com":         "icloud.com",
+	"mac.com":        "icloud.com",
+}
+
+// secondLevelLabels are the common labels under two-letter country codes
+// that registrable domains sit below, as in example.co.uk
+var secondLevelLabels = map[string]bool{
+	"co": true, "com": true, "net": true, "org": true, "ac": true,
+	"gov": true, "edu": true, "ne": true, "or": true,
+}
+
+// baseDomain approximates the registrable domain of a host name
+func baseDomain(host string) string {
+	labels := strings.Split(strings.Trim(strings.ToLower(host), "."), ".")
+	n := 2
+	if len(labels) > 2 && len(labels[len(labels)-1]) == 2 && secondLevelLabels[labels[len(labels)-2]] {
+		n = 3
+	}
+	if len(labels) <= n {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// alignedDomain reports DMARC relaxed alignment of two domains
+func alignedDomain(a, b string) bool {
+	return a != "" && b != "" && baseDomain(a) == baseDomain(b)
+}
+
+// mailDomain returns the domain of an address, or ""
+func mailDomain(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		return strings.ToLower(strings.Trim(address[i+1:], "> "))
+	}
+	return ""
+}
+
+// stripComments removes RFC 5322 comments outside quoted strings
+func stripComments(s string) string {
+	var b strings.Builder
+	depth, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (quoted || depth > 0):
+			if depth == 0 {
+				b.WriteByte(c)
+				b.WriteByte(s[i+1])
+			}
+			i++
+		case quoted:
+			b.WriteByte(c)
+			quoted = c != '"'
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+			if depth == 0 {
+				b.WriteByte(' ')
+			}
+		case depth > 0:
+		default:
+			b.WriteByte(c)
+			quoted = c == '"'
+		}
+	}
+	return b.String()
+}
+
+// splitUnquoted splits s on sep outside quoted strings
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// authTokens splits a resinfo into name=value tokens, unquoting values and
+// tolerating whitespace around "="
+func authTokens(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	quoted := false
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			cur.WriteByte(c)
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			flush()
+		case c == '=':
+			if cur.Len() == 0 && len(tokens) > 0 {
+				cur.WriteString(tokens[len(tokens)-1])
+				tokens = tokens[:len(tokens)-1]
+			}
+			cur.WriteByte(c)
+			for i+1 < len(s) && (s[i+1] == ' ' || s[i+1] == '\t') {
+				i++
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// parseAuthResults parses an Authentication-Results value
+func parseAuthResults(value string) (*authResults, error) {
+	parts := splitUnquoted(stripComments(value), ';')
+	head := strings.Fields(parts[0])
+	if len(head) == 0 {
+		return nil, fmt.Errorf("missing authserv-id")
+	}
+	ar := &authResults{authServID: strings.ToLower(strings.Trim(head[0], `"`))}
+
+	for _, part := range parts[1:] {
+		tokens := authTokens(part)
+		if len(tokens) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(tokens[0], "=")
+		if !ok {
+			continue // "none"
+		}
+		if slash := strings.IndexByte(method, '/'); slash >= 0 {
+			method = method[:slash]
+		}
+		r := authResult{
+			method: strings.ToLower(method),
+			result: strings.ToLower(result),
+			props:  make(map[string]string),
+		}
+		for _, token := range tokens[1:] {
+			name, val, ok := strings.Cut(token, "=")
+			if !ok {
+				continue
+			}
+			if name = strings.ToLower(name); name == "reason" {
+				r.reason = val
+			} else {
+				r.props[name] = val
+			}
+		}
+		ar.results = append(ar.results, r)
+	}
+	return ar, nil
+}
+
+// parseARCResults parses an ARC-Authentication-Results value, which is an
+// Authentication-Results value preceded by the instance tag
+func parseARCResults(value string) (int, *authResults, error) {
+	tag, rest, ok := strings.Cut(stripComments(value), ";")
+	name, instance, _ := strings.Cut(strings.TrimSpace(tag), "=")
+	if !ok || strings.TrimSpace(name) != "i" {
+		return 0, nil, fmt.Errorf("missing instance tag")
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(instance))
+	if err != nil || i < 1 {
+		return 0, nil, fmt.Errorf("malformed instance tag")
+	}
+	ar, err := parseAuthResults(rest)
+	return i, ar, err
+}
+
+// arcHops reads the ARC sets of a message, oldest first
+func arcHops(fields []headerField) []*ARCHop {
+	hops := make(map[int]*ARCHop)
+	hop := func(i int) *ARCHop {
+		if hops[i] == nil {
+			hops[i] = &ARCHop{Instance: i}
+		}
+		return hops[i]
+	}
+	for _, f := range fields {
+		switch f.key {
+		case "arc-authentication-results":
+			if i, ar, err := parseARCResults(f.value()); err == nil && hop(i).AuthServID == "" {
+				hop(i).AuthServID = ar.authServID
+			}
+		case "arc-seal":
+			tags, err := parseTagList(f.value())
+			if err != nil {
+				continue
+			}
+			if i, err := strconv.Atoi(tags["i"]); err == nil && i > 0 && hop(i).Domain == "" {
+				hop(i).Domain = strings.ToLower(tags["d"])
+				hop(i).ChainValidation = strings.ToLower(tags["cv"])
+			}
+		}
+	}
+
+	list := make([]*ARCHop, 0, len(hops))
+	for _, h := range hops {
+		list = append(list, h)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Instance < list[b].Instance })
+	return list
+}
+
+// authServRelated reports whether an authserv-id belongs to the provider of
+// the account, judged by its server names and address domain
+func authServRelated(account *Account, id string) bool {
+	if id == "" {
+		return false
+	}
+	domain := baseDomain(id)
+	for _, host := range []string{account.IMAPHost, account.SMTPHost, mailDomain(account.Email)} {
+		if host == "" {
+			continue
+		}
+		base := baseDomain(host)
+		if base == domain || providerAuthServDomains[base] == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// receivedBy returns the host of the by clause of a Received field
+func receivedBy(value string) string {
+	words := strings.Fields(stripComments(value))
+	for i := 0; i+1 < len(words); i++ {
+		if strings.EqualFold(words[i], "by") {
+			return strings.ToLower(strings.TrimRight(words[i+1], ";"))
+		}
+	}
+	return ""
+}
+
+// serverBoundary returns the index of the Received field the account's
+// server added on accepting the message from outside: the lowest of the
+// leading Received fields added by the provider's hosts. Every header
+// below it came with the message and may be forged. It returns -1 when the
+// topmost Received is not from the provider.
+func serverBoundary(account *Account, fields []headerField) int {
+	boundary := -1
+	for i, f := range fields {
+		if f.key != "received" {
+			continue
+		}
+		if !authServRelated(account, receivedBy(f.value())) {
+			break
+		}
+		boundary = i
+	}
+	return boundary
+}
+
+// trustedRegion returns how many of the topmost header fields were added by
+// the account's server, and whether that was established from the
+// provider's Received fields. Without them, only the fields above the
+// first Received are the server's; a message without any is not trusted.
+func trustedRegion(account *Account, fields []headerField) (int, bool) {
+	if boundary := serverBoundary(account, fields); boundary >= 0 {
+		return boundary, true
+	}
+	for i, f := range fields {
+		if f.key == "received" {
+			return i, false
+		}
+	}
+	return 0, false
+}
+
+// trustedAuthServID returns the authserv-id whose results are believed: the
+// account setting, or else one of the provider's, discovered in the headers
+// its servers added. Without either no results are believed.
+func trustedAuthServID(account *Account, fields []headerField, limit int, bounded bool) string {
+	if account.AuthServID != "" {
+		return strings.ToLower(account.AuthServID)
+	}
+	if !bounded {
+		return ""
+	}
+	for _, f := range fields[:limit] {
+		if f.key != "authentication-results" {
+			continue
+		}
+		if ar, err := parseAuthResults(f.value()); err == nil && authServRelated(account, ar.authServID) {
+			return ar.authServID
+		}
+	}
+	return ""
+}
+
+// trustedResults collects the results stamped with the trusted authserv-id
+// among the first limit header fields, by method. Each method is taken
+// from the topmost header reporting it; headers below limit came with the
+// message and are never read.
+func trustedResults(fields []headerField, limit int, trusted string) map[string][]authResult {
+	byMethod := make(map[string][]authResult)
+	for _, f := range fields[:limit] {
+		if f.key != "authentication-results" {
+			continue
+		}
+		ar, err := parseAuthResults(f.value())
+		if err != nil || ar.authServID != trusted {
+			continue
+		}
+		addResults(byMethod, ar)
+	}
+	return byMethod
+}
+
+// addResults merges the methods of ar that are not known yet
+func addResults(byMethod map[string][]authResult, ar *authResults) {
+	fresh := make(map[string][]authResult)
+	for _, r := range ar.results {
+		if _, known := byMethod[r.method]; !known {
+			fresh[r.method] = append(fresh[r.method], r)
+		}
+	}
+	for method, results := range fresh {
+		byMethod[method] = results
+	}
+}
+
+// authenticate computes the verdict of a raw message received by account
+func (s *MailService) authenticate(account *Account, raw []byte) *AuthVerdict {
+	fields, body := rawHeaderFields(canonicalLineEndings(raw))
+	v := &AuthVerdict{ARCHops: arcHops(fields)}
+
+	// DMARC has no From domain for a message with several From fields or
+	// addresses (RFC 7489 section 6.6.1), so nothing can align with it
+	var from []headerField
+	for _, f := range fields {
+		if f.key == "from" {
+			from = append(from, f)
+		}
+	}
+	if len(from) == 1 {
+		if addrs, err := mail.ParseAddressList(from[0].value()); err == nil && len(addrs) == 1 {
+			v.FromDomain = mailDomain(addrs[0].Address)
+		}
+	}
+
+	limit, bounded := trustedRegion(account, fields)
+	if trusted := trustedAuthServID(account, fields, limit, bounded); trusted != "" {
+		if byMethod := trustedResults(fields, limit, trusted); len(byMethod) > 0 {
+			v.Source = AuthSourceServer
+			v.AuthServID = trusted
+			applyResults(v, byMethod)
+		}
+	}
+
+	if s.settings.get().VerifyDKIM {
+		v.LocalDKIM = s.verifyDKIM(fields, body)
+		// The server's DKIM result stands; local checks fill in for it
+		serverDKIM := v.DKIM != ""
+		for _, r := range v.LocalDKIM {
+			if !serverDKIM && (v.DKIM == "" || r.Result == DKIMPass) {
+				v.DKIM = r.Result
+			}
+			if r.Result == DKIMPass && !containsDomain(v.DKIMDomains, r.Domain) {
+				v.DKIMDomains = append(v.DKIMDomains, r.Domain)
+			}
+		}
+		if v.Source == "" && len(v.LocalDKIM) > 0 {
+			v.Source = AuthSourceLocal
+		}
+	}
+
+	v.Verdict = authVerdict(v)
+	return v
+}
+
+// applyResults copies the trusted results into the verdict
+func applyResults(v *AuthVerdict, byMethod map[string][]authResult) {
+	if r := byMethod["spf"]; len(r) > 0 {
+		v.SPF = r[0].result
+		// smtp.mailfrom may hold a bare domain
+		mailFrom := r[0].props["smtp.mailfrom"]
+		if v.SPFDomain = mailDomain(mailFrom); v.SPFDomain == "" {
+			v.SPFDomain = strings.ToLower(strings.Trim(mailFrom, "<>"))
+		}
+		if v.SPFDomain == "" {
+			v.SPFDomain = strings.ToLower(r[0].props["smtp.helo"])
+		}
+	}
+	if r := byMethod["dmarc"]; len(r) > 0 {
+		v.DMARC = r[0].result
+		if from := strings.ToLower(r[0].props["header.from"]); from != "" && v.FromDomain == "" {
+			v.FromDomain = from
+		}
+	}
+	if r := byMethod["arc"]; len(r) > 0 {
+		v.ARC = r[0].result
+	}
+	for _, r := range byMethod["dkim"] {
+		if v.DKIM == "" || r.result == DKIMPass {
+			v.DKIM = r.result
+		}
+		domain := strings.ToLower(r.props["header.d"])
+		if domain == "" {
+			domain = mailDomain(r.props["header.i"])
+		}
+		if r.result == DKIMPass && domain != "" && !containsDomain(v.DKIMDomains, domain) {
+			v.DKIMDomains = append(v.DKIMDomains, domain)
+		}
+	}
+}
+
+// authVerdict follows DMARC when the server evaluated it, and otherwise
+// accepts a passing DKIM signature or SPF check aligned with From
+func authVerdict(v *AuthVerdict) string {
+	switch v.DMARC {
+	case "pass":
+		return AuthPass
+	case "fail":
+		return AuthFail
+	}
+	for _, domain := range v.DKIMDomains {
+		if alignedDomain(domain, v.FromDomain) {
+			return AuthPass
+		}
+	}
+	if v.SPF == "pass" && alignedDomain(v.SPFDomain, v.FromDomain) {
+		return AuthPass
+	}
+	if v.SPF == "fail" {
+		return AuthFail
+	}
+	return AuthNone
+}
+
+func containsDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateMessage computes the verdict of a message and caches it.
+// Mail the user wrote is not judged.
+func (s *MailService) authenticateMessage(accountID, folder string, uid uint32, raw []byte) *AuthVerdict {
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil {
+		return nil
+	}
+	if role := account.roleOf(folder); role == RoleSent || role == RoleDrafts {
+		return nil
+	}
+
+	verdict := s.authenticate(account, raw)
+	if s.cache != nil {
+		if err := s.cache.UpdateAuthenticationByUID(accountID, folder, uid, verdict); err != nil {
+			fmt.Printf("[GetEmail] Failed to cache authentication: %v\n", err)
+		}
+	}
+	return verdict
+}
+
+// contactDirectory tells the mail service which senders the user knows.
+// The contact service provides it.
+type contactDirectory interface {
+	isKnownAddress(email string) bool
+	// addressesNamed returns the addresses of contacts and correspondents
+	// going by a display name
+	addressesNamed(name string) []string
+	// receivedFrom counts the messages seen from an address
+	receivedFrom(email string) int
+}
+
+func (s *MailService) setContactDirectory(contacts contactDirectory) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.contacts = contacts
+}
+
+// checkSender flags unauthenticated mail, and stresses it when the From
+// address belongs to someone the user knows
+func (s *MailService) checkSender(email *Email) {
+	v := email.Authentication
+	if v == nil || len(email.From) == 0 {
+		return
+	}
+
+	s.hooksMutex.RLock()
+	contacts := s.contacts
+	s.hooksMutex.RUnlock()
+
+	v.KnownSender = contacts != nil && contacts.isKnownAddress(email.From[0].Email)
+	switch {
+	case v.Verdict == AuthFail:
+		v.Warning = AuthWarningFailed
+	case v.KnownSender && v.Verdict != AuthPass:
+		v.Warning = AuthWarningUnverifiedContact
+	}
+}
+
+// encodeAuthVerdict serializes a verdict for the cache, without the parts
+// that depend on the address book
+func encodeAuthVerdict(v *AuthVerdict) string {
+	if v == nil {
+		return ""
+	}
+	stored := *v
+	stored.KnownSender = false
+	stored.Warning = ""
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodeAuthVerdict reads a verdict stored by encodeAuthVerdict
+func decodeAuthVerdict(s string) *AuthVerdict {
+	if s == "" {
+		return nil
+	}
+	var v AuthVerdict
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil
+	}
+	return &v
+}
+
+// SetAuthServID sets the authserv-id of the account's server, whose
+// Authentication-Results are trusted. Empty means guess it from the
+// account's server names.
+func (s *MailAccountService) SetAuthServID(accountID, authServID string) error {
+	authServID = strings.TrimSpace(authServID)
+	if strings.ContainsAny(authServID, " \t;") {
+		return fmt.Errorf("invalid authserv-id: %s", authServID)
+	}
+
+	s.accountsMutex.Lock()
+	acc, exists := s.accounts[accountID]
+	if !exists {
+		s.accountsMutex.Unlock()
+		return fmt.Errorf("account not found")
+	}
+	previous := acc.AuthServID
+	acc.AuthServID = authServID
+	err := s.saveAccounts()
+	if err != nil {
+		acc.AuthServID = previous
+	}
+	s.accountsMutex.Unlock()
+
+	return err
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func newAuthTestMailService(verifyDKIM bool) *MailService {
+	settings := defaultMailSettings()
+	settings.VerifyDKIM = verifyDKIM
+	return &MailService{TXTResolver: rfc8463Resolver, settings: &mailSettingsStore{settings: settings}}
+}
+
+func TestAuthenticateLocalDKIM(t *testing.T) {
+	s := newAuthTestMailService(true)
+	v := s.authenticate(&Account{ID: "a"}, []byte(rfc8463Message))
+
+	if v.FromDomain != "football.example.com" || v.Source != AuthSourceLocal {
+		t.Errorf("from domain %q, source %q", v.FromDomain, v.Source)
+	}
+	if v.DKIM != DKIMPass || len(v.DKIMDomains) != 1 || v.Verdict != AuthPass {
+		t.Errorf("dkim %q %v, verdict %q", v.DKIM, v.DKIMDomains, v.Verdict)
+	}
+}
+
+func TestAuthenticateNeedsASingleFrom(t *testing.T) {
+	s := newAuthTestMailService(false)
+	account := &Account{ID: "a", AuthServID: "mx.example.net"}
+	results := "Authentication-Results: mx.example.net; dkim=pass header.d=football.example.com\r\n" +
+		"Received: from mail.football.example.com by mx.example.net; Fri, 11 Jul 2003 21:00:40 -0700\r\n"
+	joe := "From: Joe SixPack <joe@football.example.com>\r\n"
+
+	cases := []struct {
+		name       string
+		from       string
+		fromDomain string
+		verdict    string
+	}{
+		{"one From", joe, "football.example.com", AuthPass},
+		{"a second From field", joe + "From: Mallory <mallory@attacker.example>\r\n", "", AuthNone},
+		{"two addresses in one From", "From: joe@football.example.com, mallory@attacker.example\r\n", "", AuthNone},
+	}
+	for _, tc := range cases {
+		raw := results + strings.Replace(rfc8463Message, joe, tc.from, 1)
+		v := s.authenticate(account, []byte(raw))
+		if v.Source != AuthSourceServer {
+			t.Fatalf("%s: server results not used", tc.name)
+		}
+		if v.FromDomain != tc.fromDomain || v.Verdict != tc.verdict {
+			t.Errorf("%s: from domain %q, verdict %q; want %q, %q", tc.name, v.FromDomain, v.Verdict, tc.fromDomain, tc.verdict)
+		}
+	}
+}
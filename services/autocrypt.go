@@ -0,0 +1,618 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Encryption recommendations of Autocrypt Level 1 (https://autocrypt.org/level1.html),
+// weakest first
+const (
+	AutocryptDisable    = "disable"    // no usable key
+	AutocryptDiscourage = "discourage" // a key that may be out of date, or only gossiped
+	AutocryptAvailable  = "available"  // encryption works, but is not the default
+	AutocryptEncrypt    = "encrypt"    // both sides prefer encryption
+)
+
+// Autocrypt prefer-encrypt values
+const (
+	autocryptMutual       = "mutual"
+	autocryptNoPreference = "nopreference"
+)
+
+// autocryptStaleAfter is how long a peer may keep writing without an
+// Autocrypt header before its key is considered out of date
+const autocryptStaleAfter = 35 * 24 * time.Hour
+
+// Where a recipient's key comes from
+const (
+	KeySourceKeyring   = "keyring"   // imported or generated in the keyring
+	KeySourceAutocrypt = "autocrypt" // the peer's own Autocrypt header
+	KeySourceGossip    = "gossip"    // an Autocrypt-Gossip header from a third party
+)
+
+// AutocryptPeer is the Autocrypt state kept for a correspondent
+type AutocryptPeer struct {
+	Address            string `json:"address"`
+	LastSeen           string `json:"lastSeen"`
+	AutocryptTimestamp string `json:"autocryptTimestamp,omitempty"`
+	PreferEncrypt      string `json:"preferEncrypt"`
+	Fingerprint        string `json:"fingerprint,omitempty"`
+	GossipTimestamp    string `json:"gossipTimestamp,omitempty"`
+	GossipFingerprint  string `json:"gossipFingerprint,omitempty"`
+}
+
+// RecipientRecommendation is the recommendation for one recipient
+type RecipientRecommendation struct {
+	Email          string `json:"email"`
+	Recommendation string `json:"recommendation"`
+	KeySource      string `json:"keySource,omitempty"`
+}
+
+// EncryptionRecommendation says whether compose should offer or default
+// to encryption for a set of recipients
+type EncryptionRecommendation struct {
+	Recommendation string                     `json:"recommendation"`
+	Recipients     []*RecipientRecommendation `json:"recipients"`
+}
+
+// autocryptPeer is a row of autocrypt_peers
+type autocryptPeer struct {
+	lastSeen           time.Time
+	autocryptTimestamp time.Time
+	publicKey          []byte
+	preferEncrypt      string
+	gossipTimestamp    time.Time
+	gossipKey          []byte
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
+
+func unixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func formatPeerTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+const autocryptPeerColumns = `last_seen, autocrypt_timestamp, public_key, prefer_encrypt, gossip_timestamp, gossip_key`
+
+func scanAutocryptPeer(row rowScanner, extra ...interface{}) (*autocryptPeer, error) {
+	var peer autocryptPeer
+	var lastSeen, autocryptTimestamp, gossipTimestamp int64
+	dest := append(extra, &lastSeen, &autocryptTimestamp, &peer.publicKey, &peer.preferEncrypt, &gossipTimestamp, &peer.gossipKey)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	peer.lastSeen = unixTime(lastSeen)
+	peer.autocryptTimestamp = unixTime(autocryptTimestamp)
+	peer.gossipTimestamp = unixTime(gossipTimestamp)
+	return &peer, nil
+}
+
+// sqlQueryer is satisfied by both *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getAutocryptPeer returns the state of a peer, or nil when there is none;
+// callers hold the lock
+func getAutocryptPeer(q sqlQueryer, accountID, addr string) (*autocryptPeer, error) {
+	row := q.QueryRow(`SELECT `+autocryptPeerColumns+` FROM autocrypt_peers WHERE account_id = ? AND addr = ?`,
+		accountID, normalizeEmail(addr))
+	peer, err := scanAutocryptPeer(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return peer, err
+}
+
+func putAutocryptPeer(tx *sql.Tx, accountID, addr string, peer *autocryptPeer) error {
+	_, err := tx.Exec(`
+		INSERT INTO autocrypt_peers (account_id, addr, `+autocryptPeerColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, addr) DO UPDATE SET
+			last_seen = excluded.last_seen,
+			autocrypt_timestamp = excluded.autocrypt_timestamp,
+			public_key = excluded.public_key,
+			prefer_encrypt = excluded.prefer_encrypt,
+			gossip_timestamp = excluded.gossip_timestamp,
+			gossip_key = excluded.gossip_key
+	`, accountID, normalizeEmail(addr), unixSeconds(peer.lastSeen), unixSeconds(peer.autocryptTimestamp),
+		peer.publicKey, peer.preferEncrypt, unixSeconds(peer.gossipTimestamp), peer.gossipKey)
+	return err
+}
+
+// autocryptPeerState returns the Autocrypt state of a peer, or nil
+func (c *EmailCache) autocryptPeerState(accountID, addr string) (*autocryptPeer, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return getAutocryptPeer(c.db, accountID, addr)
+}
+
+// UpdateAutocryptPeer applies one incoming message to a peer's state
+// (Level 1, section 2.3). keydata is nil when the message had no valid
+// Autocrypt header. Peers are only recorded once they sent a header: until
+// then there is nothing to recommend anyway.
+func (c *EmailCache) UpdateAutocryptPeer(accountID, addr string, date time.Time, keydata []byte, preferEncrypt string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	peer, err := getAutocryptPeer(tx, accountID, addr)
+	if err != nil {
+		return err
+	}
+	if peer == nil {
+		if keydata == nil {
+			return nil
+		}
+		peer = &autocryptPeer{preferEncrypt: autocryptNoPreference}
+	}
+
+	// Older than the header we already have
+	if date.Before(peer.autocryptTimestamp) {
+		return nil
+	}
+	if date.After(peer.lastSeen) {
+		peer.lastSeen = date
+	}
+	if keydata != nil {
+		peer.autocryptTimestamp = date
+		peer.publicKey = keydata
+		peer.preferEncrypt = preferEncrypt
+	}
+
+	if err := putAutocryptPeer(tx, accountID, addr, peer); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateAutocryptGossip records a key gossiped about a peer (Level 1,
+// section 2.7) unless a newer one is known
+func (c *EmailCache) UpdateAutocryptGossip(accountID, addr string, date time.Time, keydata []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	peer, err := getAutocryptPeer(tx, accountID, addr)
+	if err != nil {
+		return err
+	}
+	if peer == nil {
+		peer = &autocryptPeer{preferEncrypt: autocryptNoPreference}
+	}
+	if !date.After(peer.gossipTimestamp) {
+		return nil
+	}
+	peer.gossipTimestamp = date
+	peer.gossipKey = keydata
+
+	if err := putAutocryptPeer(tx, accountID, addr, peer); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAutocryptPeers lists the Autocrypt state of an account's peers
+func (c *EmailCache) GetAutocryptPeers(accountID string) ([]*AutocryptPeer, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	rows, err := c.db.Query(`SELECT addr, `+autocryptPeerColumns+` FROM autocrypt_peers
+		WHERE account_id = ? ORDER BY addr`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	peers := []*AutocryptPeer{}
+	for rows.Next() {
+		var addr string
+		peer, err := scanAutocryptPeer(rows, &addr)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, &AutocryptPeer{
+			Address:            addr,
+			LastSeen:           formatPeerTime(peer.lastSeen),
+			AutocryptTimestamp: formatPeerTime(peer.autocryptTimestamp),
+			PreferEncrypt:      peer.preferEncrypt,
+			Fingerprint:        keyFingerprint(peer.publicKey),
+			GossipTimestamp:    formatPeerTime(peer.gossipTimestamp),
+			GossipFingerprint:  keyFingerprint(peer.gossipKey),
+		})
+	}
+	return peers, rows.Err()
+}
+
+// deleteAutocryptPeers forgets an account's peers; callers hold the lock
+func (c *EmailCache) deleteAutocryptPeers(accountID string) error {
+	_, err := c.db.Exec(`DELETE FROM autocrypt_peers WHERE account_id = ?`, accountID)
+	return err
+}
+
+// autocryptEntity parses Autocrypt keydata: a single key able to encrypt
+func autocryptEntity(keydata []byte) *openpgp.Entity {
+	if len(keydata) == 0 {
+		return nil
+	}
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(keydata))
+	if err != nil || len(entities) != 1 {
+		return nil
+	}
+	now := time.Now()
+	if _, ok := entities[0].EncryptionKey(now); !ok || entities[0].Revoked(now) {
+		return nil
+	}
+	return entities[0]
+}
+
+func keyFingerprint(keydata []byte) string {
+	if e := autocryptEntity(keydata); e != nil {
+		return fingerprintOf(e)
+	}
+	return ""
+}
+
+// parseAutocryptHeader reads the attributes of an Autocrypt header. Headers
+// with unknown critical attributes (those not starting with _) are invalid.
+func parseAutocryptHeader(value string) (map[string]string, bool) {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(value, ";") {
+		name, val, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "addr", "prefer-encrypt":
+			attrs[name] = strings.TrimSpace(val)
+		case "keydata":
+			attrs[name] = strings.Join(strings.Fields(val), "")
+		default:
+			if !strings.HasPrefix(name, "_") {
+				return nil, false
+			}
+		}
+	}
+	if attrs["addr"] == "" || attrs["keydata"] == "" {
+		return nil, false
+	}
+	return attrs, true
+}
+
+// autocryptKeydata decodes and checks the key of a parsed header
+func autocryptKeydata(attrs map[string]string) []byte {
+	keydata, err := base64.StdEncoding.DecodeString(attrs["keydata"])
+	if err != nil || autocryptEntity(keydata) == nil {
+		return nil
+	}
+	return keydata
+}
+
+// effectiveDate is the Date of a message, unless it claims to be from the future
+func effectiveDate(date time.Time) time.Time {
+	now := time.Now().UTC().Truncate(time.Second)
+	if date.IsZero() || date.After(now) {
+		return now
+	}
+	return date.UTC().Truncate(time.Second)
+}
+
+// processAutocrypt updates the sender's Autocrypt state from the header of
+// an incoming message. from lists the From addresses.
+func (s *MailService) processAutocrypt(account *Account, from []string, date time.Time, header textproto.Header) {
+	if s.cache == nil || len(from) != 1 || strings.EqualFold(from[0], account.Email) {
+		return
+	}
+	// Delivery reports carry the headers of someone else's message
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "multipart/report" {
+		return
+	}
+
+	// More than one valid header for the sender makes them all invalid
+	var keydata []byte
+	var preferEncrypt string
+	valid := 0
+	for _, value := range header.Values("Autocrypt") {
+		attrs, ok := parseAutocryptHeader(value)
+		if !ok || !strings.EqualFold(attrs["addr"], from[0]) {
+			continue
+		}
+		if key := autocryptKeydata(attrs); key != nil {
+			valid++
+			keydata = key
+			preferEncrypt = autocryptNoPreference
+			if strings.EqualFold(attrs["prefer-encrypt"], autocryptMutual) {
+				preferEncrypt = autocryptMutual
+			}
+		}
+	}
+	if valid > 1 {
+		keydata = nil
+	}
+
+	if err := s.cache.UpdateAutocryptPeer(account.ID, from[0], effectiveDate(date), keydata, preferEncrypt); err != nil {
+		fmt.Printf("[Autocrypt] Failed to update peer %s: %v\n", from[0], err)
+	}
+}
+
+// processAutocryptGossip records the keys an encrypted message's sender
+// gossiped about its other recipients. outer is the message header, inner
+// the header of the decrypted entity.
+func (s *MailService) processAutocryptGossip(account *Account, outer, inner textproto.Header) {
+	values := inner.Values("Autocrypt-Gossip")
+	if s.cache == nil || len(values) == 0 {
+		return
+	}
+	mh := mail.Header{Header: message.Header{Header: outer}}
+	from, _ := mh.AddressList("From")
+	recipients := make(map[string]bool)
+	for _, key := range []string{"To", "Cc"} {
+		addrs, _ := mh.AddressList(key)
+		for _, addr := range addrs {
+			recipients[normalizeEmail(addr.Address)] = true
+		}
+	}
+	for _, addr := range from {
+		delete(recipients, normalizeEmail(addr.Address))
+	}
+	delete(recipients, normalizeEmail(account.Email))
+
+	date, _ := mh.Date()
+	for _, value := range values {
+		attrs, ok := parseAutocryptHeader(value)
+		if !ok || !recipients[normalizeEmail(attrs["addr"])] {
+			continue
+		}
+		if keydata := autocryptKeydata(attrs); keydata != nil {
+			if err := s.cache.UpdateAutocryptGossip(account.ID, attrs["addr"], effectiveDate(date), keydata); err != nil {
+				fmt.Printf("[Autocrypt] Failed to record gossip for %s: %v\n", attrs["addr"], err)
+			}
+		}
+	}
+}
+
+// autocryptSection fetches just the headers Autocrypt needs during a sync
+var autocryptSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{
+		Specifier: imap.HeaderSpecifier,
+		Fields:    []string{"Autocrypt", "Content-Type"},
+	},
+	Peek: true,
+}
+
+// autocryptAccount returns the account when mail synced into folder should
+// update Autocrypt state: not without a cache, and not from spam
+func (s *MailService) autocryptAccount(accountID, folder string) *Account {
+	if s.cache == nil {
+		return nil
+	}
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil || account.roleOf(folder) == RoleJunk {
+		return nil
+	}
+	return account
+}
+
+// syncAutocrypt processes the Autocrypt header of a message fetched by a sync
+func (s *MailService) syncAutocrypt(account *Account, msg *imap.Message) {
+	r := msg.GetBody(autocryptSection)
+	if r == nil || msg.Envelope == nil {
+		return
+	}
+	header, err := textproto.ReadHeader(bufio.NewReader(r))
+	if err != nil {
+		return
+	}
+	var from []string
+	for _, addr := range msg.Envelope.From {
+		from = append(from, addr.Address())
+	}
+	s.processAutocrypt(account, from, msg.Envelope.Date, header)
+}
+
+// readAutocrypt processes the Autocrypt headers of a message being opened,
+// and the gossip inside it once decrypted
+func (s *MailService) readAutocrypt(accountID, folder string, raw, decrypted []byte) {
+	account := s.autocryptAccount(accountID, folder)
+	if account == nil {
+		return
+	}
+	header, _, err := splitEntity(raw)
+	if err != nil {
+		return
+	}
+	mh := mail.Header{Header: message.Header{Header: header}}
+	var from []string
+	if addrs, err := mh.AddressList("From"); err == nil {
+		for _, addr := range addrs {
+			from = append(from, addr.Address)
+		}
+	}
+	date, _ := mh.Date()
+	s.processAutocrypt(account, from, date, header)
+
+	if decrypted != nil {
+		if inner, _, err := splitEntity(decrypted); err == nil {
+			s.processAutocryptGossip(account, header, inner)
+		}
+	}
+}
+
+// autocryptHeader returns the Autocrypt header advertising our key for an
+// address, or "" when we have none
+func (s *MailService) autocryptHeader(email string) string {
+	e := s.keyring.ownPublicKeyFor(email)
+	if e == nil {
+		return ""
+	}
+	attrs := ""
+	if s.settings.get().AutocryptPreferEncrypt {
+		attrs = "prefer-encrypt=" + autocryptMutual + "; "
+	}
+	return autocryptValue(email, attrs, e)
+}
+
+// autocryptValue formats addr, extra attributes and keydata, with the key
+// split so the header folds within 76 columns
+func autocryptValue(email, attrs string, e *openpgp.Entity) string {
+	keydata, err := minimalKey(e, email)
+	if err != nil {
+		return ""
+	}
+	encoded := base64.StdEncoding.EncodeToString(keydata)
+	var b strings.Builder
+	fmt.Fprintf(&b, "addr=%s; %skeydata=", email, attrs)
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + " ")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded)
+	return b.String()
+}
+
+// recipientKey finds a key to encrypt to an address: an explicitly
+// imported key first, then the peer's Autocrypt key, then a gossiped one
+func (s *MailService) recipientKey(accountID, email string) (*openpgp.Entity, string) {
+	if keys, err := s.keyring.publicKeysFor([]string{email}); err == nil {
+		return keys[0], KeySourceKeyring
+	}
+	if s.cache == nil {
+		return nil, ""
+	}
+	peer, err := s.cache.autocryptPeerState(accountID, email)
+	if err != nil || peer == nil {
+		return nil, ""
+	}
+	if e := autocryptEntity(peer.publicKey); e != nil {
+		return e, KeySourceAutocrypt
+	}
+	if e := autocryptEntity(peer.gossipKey); e != nil {
+		return e, KeySourceGossip
+	}
+	return nil, ""
+}
+
+// recipientKeys returns a key for every address, or an error naming those
+// without one
+func (s *MailService) recipientKeys(accountID string, emails []string) ([]*openpgp.Entity, error) {
+	var keys []*openpgp.Entity
+	var missing []string
+	for _, email := range emails {
+		e, _ := s.recipientKey(accountID, email)
+		if e == nil {
+			missing = append(missing, email)
+			continue
+		}
+		keys = append(keys, e)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no PGP key for %s", strings.Join(missing, ", "))
+	}
+	return keys, nil
+}
+
+// recommendFor computes the recommendation for one recipient (Level 1,
+// section 2.4)
+func (s *MailService) recommendFor(accountID, email string, mutual bool) *RecipientRecommendation {
+	rec := &RecipientRecommendation{Email: email, Recommendation: AutocryptDisable}
+	e, source := s.recipientKey(accountID, email)
+	if e == nil {
+		return rec
+	}
+	rec.KeySource = source
+
+	var peer *autocryptPeer
+	if s.cache != nil {
+		peer, _ = s.cache.autocryptPeerState(accountID, email)
+	}
+
+	switch source {
+	case KeySourceKeyring:
+		// A key the user imported is trusted as is
+		rec.Recommendation = AutocryptAvailable
+	case KeySourceGossip:
+		rec.Recommendation = AutocryptDiscourage
+	case KeySourceAutocrypt:
+		rec.Recommendation = AutocryptAvailable
+		if peer.lastSeen.Sub(peer.autocryptTimestamp) > autocryptStaleAfter {
+			rec.Recommendation = AutocryptDiscourage
+		}
+	}
+	if rec.Recommendation == AutocryptAvailable && mutual && peer != nil &&
+		peer.preferEncrypt == autocryptMutual && autocryptEntity(peer.publicKey) != nil {
+		rec.Recommendation = AutocryptEncrypt
+	}
+	return rec
+}
+
+// GetEncryptionRecommendation tells compose whether to offer encryption to
+// a set of recipients, and whether to turn it on by default
+func (s *MailService) GetEncryptionRecommendation(accountID string, recipients []string) (*EncryptionRecommendation, error) {
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EncryptionRecommendation{Recommendation: AutocryptDisable, Recipients: []*RecipientRecommendation{}}
+	if s.keyring.ownPublicKeyFor(account.Email) == nil || len(recipients) == 0 {
+		return result, nil
+	}
+
+	mutual := s.settings.get().AutocryptPreferEncrypt
+	rank := map[string]int{AutocryptDisable: 0, AutocryptDiscourage: 1, AutocryptAvailable: 2, AutocryptEncrypt: 3}
+	result.Recommendation = AutocryptEncrypt
+	for _, email := range recipients {
+		rec := s.recommendFor(accountID, email, mutual)
+		result.Recipients = append(result.Recipients, rec)
+		if rank[rec.Recommendation] < rank[result.Recommendation] {
+			result.Recommendation = rec.Recommendation
+		}
+	}
+	return result, nil
+}
+
+// GetAutocryptPeers lists the Autocrypt state learned for an account
+func (s *MailService) GetAutocryptPeers(accountID string) ([]*AutocryptPeer, error) {
+	if s.cache == nil {
+		return []*AutocryptPeer{}, nil
+	}
+	return s.cache.GetAutocryptPeers(accountID)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/emersion/go-message/textproto"
+)
+
+const peerAddr = "peer@example.net"
+
+func newAutocryptTestService(t *testing.T) *MailService {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	return &MailService{
+		cache:    newTestCache(t),
+		keyring:  newPGPKeyring(func() time.Duration { return time.Minute }),
+		settings: &mailSettingsStore{settings: defaultMailSettings()},
+	}
+}
+
+// autocryptMessage is the header of a message from peerAddr carrying an
+// Autocrypt header for each key
+func autocryptMessage(attrs string, keys ...*openpgp.Entity) textproto.Header {
+	var h textproto.Header
+	for _, e := range keys {
+		h.Add("Autocrypt", autocryptValue(peerAddr, attrs, e))
+	}
+	return h
+}
+
+// peerKey returns the fingerprint of the key used for peerAddr, and where it came from
+func peerKey(s *MailService) (string, string) {
+	e, source := s.recipientKey("a", peerAddr)
+	if e == nil {
+		return "", source
+	}
+	return fingerprintOf(e), source
+}
+
+func TestAutocryptKeepsTheNewestHeader(t *testing.T) {
+	s := newAutocryptTestService(t)
+	account := &Account{ID: "a", Email: "me@example.com"}
+	first := newTestEntity(t, "Peer", peerAddr)
+	second := newTestEntity(t, "Peer", peerAddr)
+	base := time.Now().Add(-100 * 24 * time.Hour).UTC().Truncate(time.Second)
+
+	s.processAutocrypt(account, []string{peerAddr}, base.Add(time.Hour), autocryptMessage("", second))
+	// Delivered late: an older message must not bring back an older key
+	s.processAutocrypt(account, []string{peerAddr}, base, autocryptMessage("", first))
+	if fp, _ := peerKey(s); fp != fingerprintOf(second) {
+		t.Errorf("older message replaced the key")
+	}
+
+	// A message from the future is dated now, so it cannot pin its key
+	s.processAutocrypt(account, []string{peerAddr}, time.Now().Add(365*24*time.Hour), autocryptMessage("", first))
+	peer, err := s.cache.autocryptPeerState("a", peerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.autocryptTimestamp.After(time.Now()) {
+		t.Errorf("autocrypt timestamp %v is in the future", peer.autocryptTimestamp)
+	}
+	s.processAutocrypt(account, []string{peerAddr}, time.Now().Add(time.Second), autocryptMessage("", second))
+	if fp, _ := peerKey(s); fp != fingerprintOf(second) {
+		t.Errorf("a future Date kept its key over a later message")
+	}
+}
+
+func TestAutocryptIgnoredHeaders(t *testing.T) {
+	key := newTestEntity(t, "Peer", peerAddr)
+	other := newTestEntity(t, "Peer", peerAddr)
+	date := time.Now().Add(-time.Hour)
+
+	report := autocryptMessage("", key)
+	report.Set("Content-Type", `multipart/report; report-type=delivery-status; boundary="b"`)
+	unknownCritical := autocryptMessage("color=blue; ", key)
+
+	cases := []struct {
+		name   string
+		from   []string
+		header textproto.Header
+	}{
+		{"duplicate valid headers", []string{peerAddr}, autocryptMessage("", key, other)},
+		{"delivery report", []string{peerAddr}, report},
+		{"header for another address", []string{"someone@example.net"}, autocryptMessage("", key)},
+		{"several From addresses", []string{peerAddr, "someone@example.net"}, autocryptMessage("", key)},
+		{"unknown critical attribute", []string{peerAddr}, unknownCritical},
+	}
+	for _, tc := range cases {
+		s := newAutocryptTestService(t)
+		s.processAutocrypt(&Account{ID: "a", Email: "me@example.com"}, tc.from, date, tc.header)
+		if peers, _ := s.GetAutocryptPeers("a"); len(peers) != 0 {
+			t.Errorf("%s: recorded %+v", tc.name, peers[0])
+		}
+	}
+}
+
+func TestAutocryptGossipDoesNotOverrideDirectKey(t *testing.T) {
+	s := newAutocryptTestService(t)
+	account := &Account{ID: "a", Email: "me@example.com"}
+	direct := newTestEntity(t, "Peer", peerAddr)
+	gossiped := newTestEntity(t, "Peer", peerAddr)
+	gossipKey, err := minimalKey(gossiped, peerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Gossip alone is used, but only discouraged
+	if err := s.cache.UpdateAutocryptGossip("a", peerAddr, time.Now().Add(-2*time.Hour), gossipKey); err != nil {
+		t.Fatal(err)
+	}
+	if fp, source := peerKey(s); fp != fingerprintOf(gossiped) || source != KeySourceGossip {
+		t.Errorf("gossip only: key from %s", source)
+	}
+	if rec := s.recommendFor("a", peerAddr, false); rec.Recommendation != AutocryptDiscourage {
+		t.Errorf("gossip only: %s", rec.Recommendation)
+	}
+
+	s.processAutocrypt(account, []string{peerAddr}, time.Now().Add(-time.Hour), autocryptMessage("", direct))
+	// Newer gossip is recorded but the peer's own key still wins
+	if err := s.cache.UpdateAutocryptGossip("a", peerAddr, time.Now().Add(-time.Minute), gossipKey); err != nil {
+		t.Fatal(err)
+	}
+	if fp, source := peerKey(s); fp != fingerprintOf(direct) || source != KeySourceAutocrypt {
+		t.Errorf("gossip replaced the direct key (source %s)", source)
+	}
+}
+
+func TestAutocryptRecommendation(t *testing.T) {
+	key := newTestEntity(t, "Peer", peerAddr)
+	base := time.Now().Add(-100 * 24 * time.Hour)
+	day := 24 * time.Hour
+
+	cases := []struct {
+		name     string
+		attrs    string
+		mutual   bool
+		lastSeen time.Duration // after the Autocrypt header, in a message without one
+		want     string
+	}{
+		{"fresh key", "", false, 0, AutocryptAvailable},
+		{"both prefer encryption", "prefer-encrypt=mutual; ", true, 0, AutocryptEncrypt},
+		{"only the peer prefers encryption", "prefer-encrypt=mutual; ", false, 0, AutocryptAvailable},
+		{"recent plain message", "", false, 10 * day, AutocryptAvailable},
+		{"stale key", "", false, autocryptStaleAfter + day, AutocryptDiscourage},
+		{"stale key, both prefer encryption", "prefer-encrypt=mutual; ", true, autocryptStaleAfter + day, AutocryptDiscourage},
+	}
+	for _, tc := range cases {
+		s := newAutocryptTestService(t)
+		account := &Account{ID: "a", Email: "me@example.com"}
+		s.processAutocrypt(account, []string{peerAddr}, base, autocryptMessage(tc.attrs, key))
+		if tc.lastSeen > 0 {
+			s.processAutocrypt(account, []string{peerAddr}, base.Add(tc.lastSeen), textproto.Header{})
+		}
+		if rec := s.recommendFor("a", peerAddr, tc.mutual); rec.Recommendation != tc.want {
+			t.Errorf("%s: %s, want %s", tc.name, rec.Recommendation, tc.want)
+		}
+	}
+}
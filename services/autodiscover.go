@@ -0,0 +1,649 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSResolver looks up the DNS records used for autodiscovery.
+// *net.Resolver satisfies it.
+type DNSResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// HTTPClient fetches autoconfig documents. *http.Client satisfies it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// EndpointProber checks whether a server endpoint accepts connections
+type EndpointProber func(ctx context.Context, host string, port int, useSSL bool) error
+
+// Discoverer finds IMAP/SMTP settings for an email address
+type Discoverer struct {
+	Resolver   DNSResolver
+	HTTPClient HTTPClient
+	Probe      EndpointProber // nil disables probing
+	Timeout    time.Duration
+}
+
+// NewDiscoverer creates a discoverer backed by the system resolver and network
+func NewDiscoverer() *Discoverer {
+	return &Discoverer{
+		Resolver:   net.DefaultResolver,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Probe:      probeEndpoint,
+		Timeout:    20 * time.Second,
+	}
+}
+
+// Sources of discovered settings, in order of trust
+const (
+	discoverySourceAutoconfig = "autoconfig"
+	discoverySourceWellKnown  = "well-known"
+	discoverySourceISPDB      = "ispdb"
+	discoverySourceProviderDB = "provider"
+	discoverySourceSRV        = "srv"
+	discoverySourceMX         = "mx"
+	discoverySourceGuess      = "guess"
+)
+
+var discoverySourceScore = map[string]int{
+	discoverySourceAutoconfig: 100,
+	discoverySourceWellKnown:  95,
+	discoverySourceISPDB:      90,
+	discoverySourceProviderDB: 85,
+	discoverySourceSRV:        80,
+	discoverySourceMX:         70,
+	discoverySourceGuess:      40,
+}
+
+// serverSettings is one incoming or outgoing endpoint
+type serverSettings struct {
+	Host   string
+	Port   int
+	UseSSL bool
+	// Username is a template using %EMAILADDRESS% or %EMAILLOCALPART%
+	Username string
+	OAuth    bool
+}
+
+// providerSettings is a complete set of endpoints for a provider
+type providerSettings struct {
+	Name     string
+	IMAP     serverSettings
+	SMTP     serverSettings
+	OAuthKey string // key into oauthProviders
+}
+
+// discoveryCandidate is a provider settings entry with ranking information
+type discoveryCandidate struct {
+	providerSettings
+	source        string
+	score         int
+	imapReachable bool
+	smtpReachable bool
+}
+
+// knownProviders is the bundled provider database keyed by mail domain
+var knownProviders = map[string]providerSettings{
+	"gmail.com": {
+		Name:     "Gmail",
+		IMAP:     serverSettings{Host: "imap.gmail.com", Port: 993, UseSSL: true, OAuth: true},
+		SMTP:     serverSettings{Host: "smtp.gmail.com", Port: 465, UseSSL: true, OAuth: true},
+		OAuthKey: "google",
+	},
+	"outlook.com": {
+		Name:     "Outlook.com",
+		IMAP:     serverSettings{Host: "outlook.office365.com", Port: 993, UseSSL: true, OAuth: true},
+		SMTP:     serverSettings{Host: "smtp.office365.com", Port: 587, OAuth: true},
+		OAuthKey: "microsoft",
+	},
+	"yahoo.com": {
+		Name: "Yahoo Mail",
+		IMAP: serverSettings{Host: "imap.mail.yahoo.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.mail.yahoo.com", Port: 465, UseSSL: true},
+	},
+	"icloud.com": {
+		Name: "iCloud Mail",
+		IMAP: serverSettings{Host: "imap.mail.me.com", Port: 993, UseSSL: true, Username: "%EMAILLOCALPART%"},
+		SMTP: serverSettings{Host: "smtp.mail.me.com", Port: 587},
+	},
+	"fastmail.com": {
+		Name: "Fastmail",
+		IMAP: serverSettings{Host: "imap.fastmail.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.fastmail.com", Port: 465, UseSSL: true},
+	},
+	"zoho.com": {
+		Name: "Zoho Mail",
+		IMAP: serverSettings{Host: "imap.zoho.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.zoho.com", Port: 465, UseSSL: true},
+	},
+	"yandex.com": {
+		Name: "Yandex Mail",
+		IMAP: serverSettings{Host: "imap.yandex.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.yandex.com", Port: 465, UseSSL: true},
+	},
+	"qq.com": {
+		Name: "QQ Mail",
+		IMAP: serverSettings{Host: "imap.qq.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.qq.com", Port: 465, UseSSL: true},
+	},
+	"exmail.qq.com": {
+		Name: "Tencent Exmail",
+		IMAP: serverSettings{Host: "imap.exmail.qq.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.exmail.qq.com", Port: 465, UseSSL: true},
+	},
+	"163.com": {
+		Name: "NetEase 163",
+		IMAP: serverSettings{Host: "imap.163.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.163.com", Port: 465, UseSSL: true},
+	},
+	"126.com": {
+		Name: "NetEase 126",
+		IMAP: serverSettings{Host: "imap.126.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.126.com", Port: 465, UseSSL: true},
+	},
+	"aliyun.com": {
+		Name: "Aliyun Mail",
+		IMAP: serverSettings{Host: "imap.aliyun.com", Port: 993, UseSSL: true},
+		SMTP: serverSettings{Host: "smtp.aliyun.com", Port: 465, UseSSL: true},
+	},
+}
+
+// providerAliases maps additional domains onto a knownProviders entry
+var providerAliases = map[string]string{
+	"googlemail.com": "gmail.com",
+	"hotmail.com":    "outlook.com",
+	"live.com":       "outlook.com",
+	"msn.com":        "outlook.com",
+	"office365.com":  "outlook.com",
+	"me.com":         "icloud.com",
+	"mac.com":        "icloud.com",
+	"fastmail.fm":    "fastmail.com",
+	"yandex.ru":      "yandex.com",
+	"foxmail.com":    "qq.com",
+	"yeah.net":       "126.com",
+}
+
+// mxProviders maps MX host suffixes to a knownProviders entry, for custom
+// domains. Longer suffixes come first so the most specific one wins.
+var mxProviders = []struct {
+	suffix   string
+	provider string
+}{
+	{"protection.outlook.com", "outlook.com"},
+	{"messagingengine.com", "fastmail.com"},
+	{"googlemail.com", "gmail.com"},
+	{"exmail.qq.com", "exmail.qq.com"},
+	{"yahoodns.net", "yahoo.com"},
+	{"google.com", "gmail.com"},
+	{"icloud.com", "icloud.com"},
+	{"yandex.net", "yandex.com"},
+	{"zoho.com", "zoho.com"},
+	{"163.com", "163.com"},
+	{"qq.com", "qq.com"},
+}
+
+// lookupProvider finds a bundled provider entry for a domain
+func lookupProvider(domain string) (providerSettings, bool) {
+	if alias, ok := providerAliases[domain]; ok {
+		domain = alias
+	}
+	p, ok := knownProviders[domain]
+	return p, ok
+}
+
+// DiscoverSettings returns ranked account templates for an email address
+func (s *MailAccountService) DiscoverSettings(email string) ([]*Account, error) {
+	return NewDiscoverer().Discover(context.Background(), email)
+}
+
+// Discover runs all discovery methods and returns ranked account templates
+func (d *Discoverer) Discover(ctx context.Context, email string) ([]*Account, error) {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return nil, fmt.Errorf("invalid email address: %s", email)
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	var (
+		mu         sync.Mutex
+		candidates []*discoveryCandidate
+		wg         sync.WaitGroup
+	)
+	add := func(source string, settings ...providerSettings) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, p := range settings {
+			candidates = append(candidates, &discoveryCandidate{
+				providerSettings: p,
+				source:           source,
+				score:            discoverySourceScore[source],
+			})
+		}
+	}
+
+	if p, ok := lookupProvider(domain); ok {
+		add(discoverySourceProviderDB, p)
+	}
+
+	autoconfigURLs := []struct {
+		source string
+		url    string
+	}{
+		{discoverySourceAutoconfig, fmt.Sprintf("https://autoconfig.%s/mail/config-v1.1.xml?emailaddress=%s", domain, url.QueryEscape(email))},
+		{discoverySourceWellKnown, fmt.Sprintf("https://%s/.well-known/autoconfig/mail/config-v1.1.xml?emailaddress=%s", domain, url.QueryEscape(email))},
+		{discoverySourceISPDB, fmt.Sprintf("https://autoconfig.thunderbird.net/v1.1/%s", domain)},
+	}
+	if d.HTTPClient != nil {
+		for _, ac := range autoconfigURLs {
+			wg.Add(1)
+			go func(source, u string) {
+				defer wg.Done()
+				settings, err := d.fetchAutoconfig(ctx, u)
+				if err != nil {
+					fmt.Printf("[Discover] Autoconfig %s failed: %v\n", u, err)
+					return
+				}
+				add(source, settings...)
+			}(ac.source, ac.url)
+		}
+	}
+
+	if d.Resolver != nil {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if p, ok := d.lookupSRV(ctx, domain); ok {
+				add(discoverySourceSRV, p)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if p, ok := d.lookupMX(ctx, domain); ok {
+				add(discoverySourceMX, p)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	add(discoverySourceGuess,
+		providerSettings{
+			Name: domain,
+			IMAP: serverSettings{Host: "imap." + domain, Port: 993, UseSSL: true},
+			SMTP: serverSettings{Host: "smtp." + domain, Port: 465, UseSSL: true},
+		},
+		providerSettings{
+			Name: domain,
+			IMAP: serverSettings{Host: "mail." + domain, Port: 993, UseSSL: true},
+			SMTP: serverSettings{Host: "mail." + domain, Port: 587},
+		},
+	)
+
+	candidates = dedupeCandidates(candidates)
+	d.probeCandidates(ctx, candidates)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	accounts := make([]*Account, 0, len(candidates))
+	for _, c := range candidates {
+		// Guesses nobody answered on are noise
+		if c.source == discoverySourceGuess && d.Probe != nil && !c.imapReachable {
+			continue
+		}
+		accounts = append(accounts, c.toAccount(email))
+	}
+
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("no settings found for %s", domain)
+	}
+	return accounts, nil
+}
+
+// fetchAutoconfig downloads and parses a Mozilla autoconfig document
+func (d *Discoverer) fetchAutoconfig(ctx context.Context, u string) ([]providerSettings, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return parseAutoconfig(data)
+}
+
+// autoconfigServer mirrors an incomingServer/outgoingServer element
+type autoconfigServer struct {
+	Type           string   `xml:"type,attr"`
+	Hostname       string   `xml:"hostname"`
+	Port           int      `xml:"port"`
+	SocketType     string   `xml:"socketType"`
+	Username       string   `xml:"username"`
+	Authentication []string `xml:"authentication"`
+}
+
+// autoconfigDocument mirrors the clientConfig document (config-v1.1.xml)
+type autoconfigDocument struct {
+	XMLName  xml.Name `xml:"clientConfig"`
+	Provider struct {
+		ID          string             `xml:"id,attr"`
+		DisplayName string             `xml:"displayName"`
+		Incoming    []autoconfigServer `xml:"incomingServer"`
+		Outgoing    []autoconfigServer `xml:"outgoingServer"`
+	} `xml:"emailProvider"`
+	// OAuth2 names the authorization server of OAuth2 logins, as in the
+	// Thunderbird ISPDB
+	OAuth2 struct {
+		Issuer   string `xml:"issuer"`
+		AuthURL  string `xml:"authURL"`
+		TokenURL string `xml:"tokenURL"`
+	} `xml:"oAuth2"`
+}
+
+// parseAutoconfig turns an autoconfig document into provider settings.
+// Every IMAP server is paired with the first SMTP server, keeping document order.
+func parseAutoconfig(data []byte) ([]providerSettings, error) {
+	var doc autoconfigDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid autoconfig: %w", err)
+	}
+
+	var smtpServer *autoconfigServer
+	for i := range doc.Provider.Outgoing {
+		if strings.EqualFold(doc.Provider.Outgoing[i].Type, "smtp") {
+			smtpServer = &doc.Provider.Outgoing[i]
+			break
+		}
+	}
+	if smtpServer == nil {
+		return nil, fmt.Errorf("autoconfig has no SMTP server")
+	}
+
+	name := doc.Provider.DisplayName
+	if name == "" {
+		name = doc.Provider.ID
+	}
+
+	var settings []providerSettings
+	for _, in := range doc.Provider.Incoming {
+		if !strings.EqualFold(in.Type, "imap") {
+			continue
+		}
+		p := providerSettings{
+			Name: name,
+			IMAP: in.toSettings(),
+			SMTP: smtpServer.toSettings(),
+		}
+		if p.IMAP.OAuth {
+			p.OAuthKey = doc.oauthKey(p.IMAP.Host)
+		}
+		settings = append(settings, p)
+	}
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("autoconfig has no IMAP server")
+	}
+	return settings, nil
+}
+
+// oauthKey names the oauthProviders preset for an OAuth2 server of the
+// document: the one its oAuth2 element points at, or else the one of the
+// bundled provider with the same IMAP host. It is empty when neither is known.
+func (doc *autoconfigDocument) oauthKey(imapHost string) string {
+	hosts := []string{strings.TrimSpace(doc.OAuth2.Issuer), urlHost(doc.OAuth2.AuthURL), urlHost(doc.OAuth2.TokenURL)}
+	for key, preset := range oauthProviders {
+		presetHosts := []string{urlHost(preset.AuthURL), urlHost(preset.TokenURL)}
+		for _, host := range hosts {
+			for _, presetHost := range presetHosts {
+				if host != "" && strings.EqualFold(host, presetHost) {
+					return key
+				}
+			}
+		}
+	}
+	for _, p := range knownProviders {
+		if p.OAuthKey != "" && strings.EqualFold(p.IMAP.Host, imapHost) {
+			return p.OAuthKey
+		}
+	}
+	return ""
+}
+
+// urlHost returns the host of a URL, or "" when it does not parse
+func urlHost(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+func (s autoconfigServer) toSettings() serverSettings {
+	settings := serverSettings{
+		Host:     strings.TrimSpace(s.Hostname),
+		Port:     s.Port,
+		UseSSL:   strings.EqualFold(s.SocketType, "SSL"),
+		Username: strings.TrimSpace(s.Username),
+	}
+	for _, auth := range s.Authentication {
+		if strings.EqualFold(strings.TrimSpace(auth), "OAuth2") {
+			settings.OAuth = true
+		}
+	}
+	return settings
+}
+
+// lookupSRV builds settings from RFC 6186 / RFC 8314 SRV records
+func (d *Discoverer) lookupSRV(ctx context.Context, domain string) (providerSettings, bool) {
+	imapSettings, imapOK := d.firstSRV(ctx, domain, []string{"imaps", "imap"})
+	smtpSettings, smtpOK := d.firstSRV(ctx, domain, []string{"submissions", "submission"})
+	if !imapOK || !smtpOK {
+		return providerSettings{}, false
+	}
+	return providerSettings{Name: domain, IMAP: imapSettings, SMTP: smtpSettings}, true
+}
+
+// firstSRV returns the best target of the first service that has records
+func (d *Discoverer) firstSRV(ctx context.Context, domain string, services []string) (serverSettings, bool) {
+	for _, service := range services {
+		_, records, err := d.Resolver.LookupSRV(ctx, service, "tcp", domain)
+		if err != nil || len(records) == 0 {
+			continue
+		}
+		// net.LookupSRV already sorts by priority and weight
+		for _, r := range records {
+			target := strings.TrimSuffix(r.Target, ".")
+			// A target of "." means the service is explicitly unavailable
+			if target == "" || r.Port == 0 {
+				continue
+			}
+			return serverSettings{
+				Host:   target,
+				Port:   int(r.Port),
+				UseSSL: strings.HasSuffix(service, "s"),
+			}, true
+		}
+	}
+	return serverSettings{}, false
+}
+
+// lookupMX guesses the provider from the domain's MX hosts
+func (d *Discoverer) lookupMX(ctx context.Context, domain string) (providerSettings, bool) {
+	records, err := d.Resolver.LookupMX(ctx, domain)
+	if err != nil {
+		return providerSettings{}, false
+	}
+
+	for _, mx := range records {
+		host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+		for _, mp := range mxProviders {
+			if host == mp.suffix || strings.HasSuffix(host, "."+mp.suffix) {
+				if p, ok := knownProviders[mp.provider]; ok {
+					return p, true
+				}
+			}
+		}
+	}
+	return providerSettings{}, false
+}
+
+// dedupeCandidates merges identical endpoint pairs, keeping the best source
+func dedupeCandidates(candidates []*discoveryCandidate) []*discoveryCandidate {
+	seen := make(map[string]*discoveryCandidate)
+	var result []*discoveryCandidate
+	for _, c := range candidates {
+		key := fmt.Sprintf("%s:%d:%t|%s:%d:%t",
+			strings.ToLower(c.IMAP.Host), c.IMAP.Port, c.IMAP.UseSSL,
+			strings.ToLower(c.SMTP.Host), c.SMTP.Port, c.SMTP.UseSSL)
+		if existing, ok := seen[key]; ok {
+			// Agreement between sources is a good sign
+			existing.score += 5
+			if c.score > existing.score {
+				existing.score = c.score
+				existing.source = c.source
+			}
+			continue
+		}
+		seen[key] = c
+		result = append(result, c)
+	}
+	return result
+}
+
+// probeCandidates checks reachability of every candidate endpoint in parallel
+func (d *Discoverer) probeCandidates(ctx context.Context, candidates []*discoveryCandidate) {
+	if d.Probe == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for _, c := range candidates {
+		wg.Add(1)
+		go func(c *discoveryCandidate) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			c.imapReachable = d.Probe(ctx, c.IMAP.Host, c.IMAP.Port, c.IMAP.UseSSL) == nil
+			c.smtpReachable = d.Probe(ctx, c.SMTP.Host, c.SMTP.Port, c.SMTP.UseSSL) == nil
+			if c.imapReachable {
+				c.score += 20
+			} else {
+				c.score -= 30
+			}
+			if c.smtpReachable {
+				c.score += 10
+			} else {
+				c.score -= 15
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// toAccount converts a candidate into an account template
+func (c *discoveryCandidate) toAccount(email string) *Account {
+	account := &Account{
+		Name:       c.Name,
+		Email:      email,
+		IMAPHost:   c.IMAP.Host,
+		IMAPPort:   c.IMAP.Port,
+		IMAPUseSSL: c.IMAP.UseSSL,
+		SMTPHost:   c.SMTP.Host,
+		SMTPPort:   c.SMTP.Port,
+		SMTPUseSSL: c.SMTP.UseSSL,
+		Username:   expandUsername(c.IMAP.Username, email),
+		AuthMethod: AuthMethodPassword,
+	}
+
+	if c.IMAP.OAuth && c.OAuthKey != "" {
+		if preset, ok := oauthProviders[c.OAuthKey]; ok {
+			account.AuthMethod = AuthMethodXOAuth2
+			account.OAuth = &OAuthConfig{Provider: preset.Provider}
+		}
+	}
+	return account
+}
+
+// expandUsername substitutes autoconfig placeholders
+func expandUsername(template, email string) string {
+	if template == "" {
+		return ""
+	}
+	local := email
+	domain := ""
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+		domain = email[at+1:]
+	}
+	r := strings.NewReplacer(
+		"%EMAILADDRESS%", email,
+		"%EMAILLOCALPART%", local,
+		"%EMAILDOMAIN%", domain,
+	)
+	return r.Replace(template)
+}
+
+// probeEndpoint opens a connection and waits for the server greeting
+func probeEndpoint(ctx context.Context, host string, port int, useSSL bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	addr := net.JoinHostPort(host, fmt.Sprint(port))
+	var conn net.Conn
+	var err error
+	if useSSL {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	greeting := make([]byte, 64)
+	if _, err := conn.Read(greeting); err != nil {
+		return fmt.Errorf("no greeting from %s: %w", addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers MX lookups from a table and has no SRV records
+type fakeResolver struct {
+	mx map[string][]*net.MX
+}
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", nil, errors.New("no such host")
+}
+
+func (r fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records, ok := r.mx[name]; ok {
+		return records, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+// fakeHTTP serves autoconfig documents by URL without the query string
+type fakeHTTP struct {
+	docs map[string]string
+}
+
+func (c fakeHTTP) Do(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	u.RawQuery = ""
+	doc, ok := c.docs[u.String()]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(doc))}, nil
+}
+
+func autoconfigXML(imapHost, auth, oauth string) string {
+	return `<?xml version="1.0"?>
+<clientConfig version="1.1">
+  <emailProvider id="example.org">
+    <displayName>Example</displayName>
+    <incomingServer type="imap">
+      <hostname>` + imapHost + `</hostname>
+      <port>993</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILADDRESS%</username>
+      <authentication>` + auth + `</authentication>
+    </incomingServer>
+    <outgoingServer type="smtp">
+      <hostname>smtp.example.org</hostname>
+      <port>465</port>
+      <socketType>SSL</socketType>
+      <authentication>` + auth + `</authentication>
+    </outgoingServer>
+  </emailProvider>` + oauth + `
+</clientConfig>`
+}
+
+func TestDiscover(t *testing.T) {
+	cases := []struct {
+		name      string
+		email     string
+		docs      map[string]string
+		mx        map[string][]*net.MX
+		wantHost  string
+		wantOAuth string // OAuth provider of the best account, "" for password
+	}{
+		{
+			name:  "autoconfig",
+			email: "me@example.org",
+			docs: map[string]string{
+				"https://autoconfig.example.org/mail/config-v1.1.xml": autoconfigXML("mail.example.org", "password-cleartext", ""),
+			},
+			wantHost: "mail.example.org",
+		},
+		{
+			name:  "autoconfig OAuth2 with issuer",
+			email: "me@example.org",
+			docs: map[string]string{
+				"https://autoconfig.example.org/mail/config-v1.1.xml": autoconfigXML("imap.example.org", "OAuth2",
+					"<oAuth2><issuer>login.microsoftonline.com</issuer></oAuth2>"),
+			},
+			wantHost:  "imap.example.org",
+			wantOAuth: "microsoft",
+		},
+		{
+			name:  "ISPDB OAuth2 on a bundled provider's host",
+			email: "me@workspace.example",
+			docs: map[string]string{
+				"https://autoconfig.thunderbird.net/v1.1/workspace.example": autoconfigXML("imap.gmail.com", "OAuth2", ""),
+			},
+			wantHost:  "imap.gmail.com",
+			wantOAuth: "google",
+		},
+		{
+			name:      "MX fallback",
+			email:     "me@corp.example",
+			mx:        map[string][]*net.MX{"corp.example": {{Host: "aspmx.l.google.com.", Pref: 1}}},
+			wantHost:  "imap.gmail.com",
+			wantOAuth: "google",
+		},
+		{
+			name:     "MX on the longer suffix",
+			email:    "me@corp.example",
+			mx:       map[string][]*net.MX{"corp.example": {{Host: "mxbiz1.exmail.qq.com.", Pref: 5}}},
+			wantHost: "imap.exmail.qq.com",
+		},
+		{
+			name:     "MX on the shorter suffix",
+			email:    "me@corp.example",
+			mx:       map[string][]*net.MX{"corp.example": {{Host: "mx3.qq.com.", Pref: 5}}},
+			wantHost: "imap.qq.com",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Discoverer{Resolver: fakeResolver{mx: tc.mx}, HTTPClient: fakeHTTP{docs: tc.docs}}
+			accounts, err := d.Discover(context.Background(), tc.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			best := accounts[0]
+			if best.IMAPHost != tc.wantHost {
+				t.Errorf("best IMAP host %s, want %s", best.IMAPHost, tc.wantHost)
+			}
+			var provider string
+			if best.OAuth != nil {
+				provider = best.OAuth.Provider
+			}
+			if provider != tc.wantOAuth {
+				t.Errorf("OAuth provider %q, want %q", provider, tc.wantOAuth)
+			}
+			if tc.wantOAuth != "" && best.AuthMethod != AuthMethodXOAuth2 {
+				t.Errorf("auth method %s, want %s", best.AuthMethod, AuthMethodXOAuth2)
+			}
+		})
+	}
+}
+
+func TestMXProvidersLongestSuffixFirst(t *testing.T) {
+	for i := 1; i < len(mxProviders); i++ {
+		if len(mxProviders[i].suffix) > len(mxProviders[i-1].suffix) {
+			t.Errorf("%s comes after the shorter %s", mxProviders[i].suffix, mxProviders[i-1].suffix)
+		}
+	}
+	for _, mp := range mxProviders {
+		if _, ok := knownProviders[mp.provider]; !ok {
+			t.Errorf("%s maps to unknown provider %s", mp.suffix, mp.provider)
+		}
+	}
+}
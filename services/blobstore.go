@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps raw RFC 5322 messages as gzip files keyed by their SHA-256.
+// Identical messages (e.g. the same mail in two folders) are stored once.
+type BlobStore struct {
+	dir string
+}
+
+// NewBlobStore creates a blob store rooted at dir
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob dir: %w", err)
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+// blobHash returns the content address of data
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// validBlobHash reports whether s looks like a hex SHA-256
+func validBlobHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// path shards blobs by the first two hex characters
+func (b *BlobStore) path(hash string) string {
+	return filepath.Join(b.dir, hash[:2], hash+".gz")
+}
+
+// Put stores data and returns its hash
+func (b *BlobStore) Put(data []byte) (string, error) {
+	hash := blobHash(data)
+	path := b.path(hash)
+
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, buf.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get reads a blob and checks it still matches its hash
+func (b *BlobStore) Get(hash string) ([]byte, error) {
+	if !validBlobHash(hash) {
+		return nil, fmt.Errorf("invalid blob hash: %q", hash)
+	}
+
+	f, err := os.Open(b.path(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s is corrupted: %w", hash, err)
+	}
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s is corrupted: %w", hash, err)
+	}
+
+	if got := blobHash(data); got != hash {
+		return nil, fmt.Errorf("blob %s is corrupted: content hash is %s", hash, got)
+	}
+	return data, nil
+}
+
+// Size returns the compressed size of a blob on disk
+func (b *BlobStore) Size(hash string) (int64, error) {
+	info, err := os.Stat(b.path(hash))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Delete removes a blob; deleting a missing blob is not an error
+func (b *BlobStore) Delete(hash string) error {
+	if !validBlobHash(hash) {
+		return fmt.Errorf("invalid blob hash: %q", hash)
+	}
+	if err := os.Remove(b.path(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the hashes of all stored blobs
+func (b *BlobStore) List() ([]string, error) {
+	var hashes []string
+	err := filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if !strings.HasSuffix(name, ".gz") {
+			return nil
+		}
+		hash := strings.TrimSuffix(name, ".gz")
+		if validBlobHash(hash) {
+			hashes = append(hashes, hash)
+		}
+		return nil
+	})
+	return hashes, err
+}
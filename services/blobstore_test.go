@@ -0,0 +1,169 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+)
+
+func rawMessage(subject string) []byte {
+	return []byte("From: a@example.com\r\nSubject: " + subject + "\r\n\r\nbody of " + subject + "\r\n")
+}
+
+func TestBlobStoreDeduplicates(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := blobs.Put(rawMessage("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := blobs.Put(rawMessage("one"))
+	other, _ := blobs.Put(rawMessage("two"))
+	if first != again || first == other {
+		t.Fatalf("hashes %s, %s, %s", first, again, other)
+	}
+
+	stored, err := blobs.List()
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("List = %v, %v, want 2 blobs", stored, err)
+	}
+	data, err := blobs.Get(first)
+	if err != nil || !bytes.Equal(data, rawMessage("one")) {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if _, err := blobs.Get("../../etc/passwd"); err == nil {
+		t.Error("Get accepted a path as hash")
+	}
+}
+
+// storeRaw caches a message and stores its source, returning the blob hash
+func storeRaw(t *testing.T, cache *EmailCache, folder string, uid uint32, raw []byte) string {
+	t.Helper()
+	email := &Email{
+		ID:        fmt.Sprintf("%s-%d", folder, uid),
+		AccountID: "a",
+		Folder:    folder,
+		UID:       uid,
+		Date:      "2024-01-01T00:00:00Z",
+		CreatedAt: "2024-01-01T00:00:00Z",
+	}
+	if err := cache.CacheEmails([]*Email{email}); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := cache.StoreRawMessage("a", folder, uid, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestVerifyRawMessages(t *testing.T) {
+	cache := newTestCache(t)
+
+	// The same message in two folders shares one blob
+	shared := storeRaw(t, cache, "INBOX", 1, rawMessage("shared"))
+	storeRaw(t, cache, "Archive", 1, rawMessage("shared"))
+	missing := storeRaw(t, cache, "INBOX", 2, rawMessage("missing"))
+	storeRaw(t, cache, "INBOX", 3, rawMessage("intact"))
+
+	// Valid gzip whose content no longer matches the hash
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("bit rot"))
+	zw.Close()
+	if err := os.WriteFile(cache.blobs.path(shared), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(cache.blobs.path(missing)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := cache.VerifyRawMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 3 {
+		t.Errorf("checked %d blobs, want 3", report.Checked)
+	}
+	if len(report.Corrupt) != 1 || report.Corrupt[0] != shared {
+		t.Errorf("corrupt = %v, want [%s]", report.Corrupt, shared)
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != missing {
+		t.Errorf("missing = %v, want [%s]", report.Missing, missing)
+	}
+	if _, err := os.Stat(cache.blobs.path(shared)); !os.IsNotExist(err) {
+		t.Errorf("corrupt blob kept on disk: %v", err)
+	}
+
+	// Broken references are cleared so the source is fetched again
+	for _, m := range []struct {
+		folder string
+		uid    uint32
+	}{{"INBOX", 1}, {"Archive", 1}, {"INBOX", 2}} {
+		if _, err := cache.GetRawMessage("a", m.folder, m.uid); err == nil {
+			t.Errorf("%s/%d still has a raw message", m.folder, m.uid)
+		}
+	}
+	if raw, err := cache.GetRawMessage("a", "INBOX", 3); err != nil || !bytes.Equal(raw, rawMessage("intact")) {
+		t.Errorf("intact message = %q, %v", raw, err)
+	}
+
+	report, err = cache.VerifyRawMessages()
+	if err != nil || report.Checked != 1 || len(report.Corrupt)+len(report.Missing) != 0 {
+		t.Errorf("second pass = %+v, %v", report, err)
+	}
+}
+
+func TestCollectGarbageKeepsReferencedBlobs(t *testing.T) {
+	cache := newTestCache(t)
+
+	shared := storeRaw(t, cache, "INBOX", 1, rawMessage("shared"))
+	storeRaw(t, cache, "Archive", 1, rawMessage("shared"))
+	dropped := storeRaw(t, cache, "INBOX", 2, rawMessage("dropped"))
+	kept := storeRaw(t, cache, "INBOX", 3, rawMessage("kept"))
+
+	// A blob whose email row was never written
+	orphan, err := cache.blobs.Put(rawMessage("orphan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.StoreRawMessage("a", "INBOX", 99, rawMessage("uncached")); err == nil {
+		t.Error("storing the source of an uncached email succeeded")
+	}
+
+	if err := cache.DeleteEmailsByUIDs("a", "INBOX", []uint32{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, freed, err := cache.CollectGarbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dropped, orphan and uncached go; shared is still used by Archive
+	if removed != 3 || freed <= 0 {
+		t.Errorf("removed %d blobs (%d bytes), want 3", removed, freed)
+	}
+
+	stored, _ := cache.blobs.List()
+	sort.Strings(stored)
+	want := []string{shared, kept}
+	sort.Strings(want)
+	if len(stored) != 2 || stored[0] != want[0] || stored[1] != want[1] {
+		t.Errorf("blobs left %v, want %v", stored, want)
+	}
+	for _, hash := range []string{dropped, orphan} {
+		if _, err := cache.blobs.Get(hash); !os.IsNotExist(err) {
+			t.Errorf("blob %s: %v, want deleted", hash, err)
+		}
+	}
+
+	if removed, _, _ := cache.CollectGarbage(); removed != 0 {
+		t.Errorf("second pass removed %d blobs", removed)
+	}
+}
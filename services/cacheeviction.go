@@ -0,0 +1,335 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheLimits bounds how much mail content is kept locally for an account.
+// Zero values mean unlimited. Envelopes are never evicted, so evicted mail
+// stays listed and searchable and its body is downloaded again on demand.
+type CacheLimits struct {
+	MaxBytes         int64 `json:"maxBytes"`         // total size of bodies and raw sources
+	MaxBodyAgeDays   int   `json:"maxBodyAgeDays"`   // drop content not opened for this long
+	HeadersOnlyAfter int   `json:"headersOnlyAfter"` // keep only headers for mail older than this many days
+}
+
+// EvictionResult reports what an eviction pass removed
+type EvictionResult struct {
+	AccountID     string `json:"accountId"`
+	BodiesEvicted int    `json:"bodiesEvicted"`
+	BytesFreed    int64  `json:"bytesFreed"`
+}
+
+// FolderCacheStats is the cache usage of one folder
+type FolderCacheStats struct {
+	Folder     string `json:"folder"`
+	Messages   int    `json:"messages"`
+	Bodies     int    `json:"bodies"`
+	BodyBytes  int64  `json:"bodyBytes"`
+	RawBytes   int64  `json:"rawBytes"`
+	TotalBytes int64  `json:"totalBytes"`
+}
+
+// AccountCacheStats is the cache usage of one account
+type AccountCacheStats struct {
+	AccountID  string              `json:"accountId"`
+	Messages   int                 `json:"messages"`
+	TotalBytes int64               `json:"totalBytes"`
+	Folders    []*FolderCacheStats `json:"folders"`
+}
+
+// CacheStats is the cache usage across all accounts
+type CacheStats struct {
+	Accounts      []*AccountCacheStats `json:"accounts"`
+	DatabaseBytes int64                `json:"databaseBytes"`
+	BlobBytes     int64                `json:"blobBytes"` // compressed size on disk
+}
+
+// cacheEvictionInterval is how often the background evictor runs
+const cacheEvictionInterval = 30 * time.Minute
+
+// contentSize is the per-row size counted against MaxBytes
+const contentSize = `(length(CAST(body AS BLOB)) + raw_size)`
+
+// TouchEmail records that an email's content was read, for LRU eviction
+func (c *EmailCache) TouchEmail(accountID, folder string, uid uint32) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(`
+		UPDATE emails SET last_accessed = ? WHERE account_id = ? AND folder = ? AND uid = ?
+	`, time.Now().Unix(), accountID, folder, uid)
+	return err
+}
+
+// EvictContent drops bodies and raw sources that exceed an account's limits,
+// oldest access first. Blobs are reclaimed by the following garbage collection.
+func (c *EmailCache) EvictContent(accountID string, limits CacheLimits) (*EvictionResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := &EvictionResult{AccountID: accountID}
+	now := time.Now()
+
+	evictWhere := func(where string, args ...interface{}) error {
+		var count int
+		var freed int64
+		query := `SELECT COUNT(*), COALESCE(SUM(` + contentSize + `), 0) FROM emails
+			WHERE account_id = ? AND (body != '' OR raw_hash != '') AND ` + where
+		if err := c.db.QueryRow(query, append([]interface{}{accountID}, args...)...).Scan(&count, &freed); err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+
+		update := `UPDATE emails SET body = '', raw_hash = '', raw_size = 0, updated_at = ?
+			WHERE account_id = ? AND (body != '' OR raw_hash != '') AND ` + where
+		if _, err := c.db.Exec(update, append([]interface{}{getCurrentTime(), accountID}, args...)...); err != nil {
+			return err
+		}
+		result.BodiesEvicted += count
+		result.BytesFreed += freed
+		return nil
+	}
+
+	if limits.HeadersOnlyAfter > 0 {
+		cutoff := now.AddDate(0, 0, -limits.HeadersOnlyAfter).Unix()
+		if err := evictWhere(`internal_date > 0 AND internal_date < ?`, cutoff); err != nil {
+			return result, fmt.Errorf("headers-only eviction failed: %w", err)
+		}
+	}
+
+	if limits.MaxBodyAgeDays > 0 {
+		cutoff := now.AddDate(0, 0, -limits.MaxBodyAgeDays).Unix()
+		// Rows cached before access tracking fall back to their last update
+		if err := evictWhere(`COALESCE(NULLIF(last_accessed, 0), CAST(strftime('%s', updated_at) AS INTEGER)) < ?`, cutoff); err != nil {
+			return result, fmt.Errorf("age eviction failed: %w", err)
+		}
+	}
+
+	if limits.MaxBytes > 0 {
+		if err := c.evictToSize(accountID, limits.MaxBytes, result); err != nil {
+			return result, fmt.Errorf("size eviction failed: %w", err)
+		}
+	}
+
+	if result.BodiesEvicted > 0 {
+		fmt.Printf("[EmailCache] Evicted %d bodies (%d bytes) for account %s\n",
+			result.BodiesEvicted, result.BytesFreed, accountID)
+	}
+	return result, nil
+}
+
+// evictToSize drops least recently used content until the account fits in maxBytes.
+// Caller must hold the lock.
+func (c *EmailCache) evictToSize(accountID string, maxBytes int64, result *EvictionResult) error {
+	var total int64
+	err := c.db.QueryRow(`
+		SELECT COALESCE(SUM(`+contentSize+`), 0) FROM emails WHERE account_id = ?
+	`, accountID).Scan(&total)
+	if err != nil || total <= maxBytes {
+		return err
+	}
+
+	rows, err := c.db.Query(`
+		SELECT id, `+contentSize+` FROM emails
+		WHERE account_id = ? AND (body != '' OR raw_hash != '')
+		ORDER BY last_accessed ASC, updated_at ASC
+	`, accountID)
+	if err != nil {
+		return err
+	}
+
+	var victims []string
+	for rows.Next() && total > maxBytes {
+		var id string
+		var size int64
+		if err := rows.Scan(&id, &size); err != nil {
+			rows.Close()
+			return err
+		}
+		victims = append(victims, id)
+		total -= size
+		result.BytesFreed += size
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE emails SET body = '', raw_hash = '', raw_size = 0, updated_at = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := getCurrentTime()
+	for _, id := range victims {
+		if _, err := stmt.Exec(now, id); err != nil {
+			return err
+		}
+	}
+	result.BodiesEvicted += len(victims)
+
+	return tx.Commit()
+}
+
+// GetStats reports cache usage per account and folder
+func (c *EmailCache) GetStats() (*CacheStats, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	rows, err := c.db.Query(`
+		SELECT account_id, folder, COUNT(*),
+		       SUM(CASE WHEN body != '' THEN 1 ELSE 0 END),
+		       COALESCE(SUM(length(CAST(body AS BLOB))), 0),
+		       COALESCE(SUM(raw_size), 0)
+		FROM emails
+		GROUP BY account_id, folder
+		ORDER BY account_id, folder
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &CacheStats{}
+	byAccount := make(map[string]*AccountCacheStats)
+	for rows.Next() {
+		var accountID string
+		folder := &FolderCacheStats{}
+		if err := rows.Scan(&accountID, &folder.Folder, &folder.Messages, &folder.Bodies, &folder.BodyBytes, &folder.RawBytes); err != nil {
+			return nil, err
+		}
+		folder.TotalBytes = folder.BodyBytes + folder.RawBytes
+
+		acc, ok := byAccount[accountID]
+		if !ok {
+			acc = &AccountCacheStats{AccountID: accountID}
+			byAccount[accountID] = acc
+			stats.Accounts = append(stats.Accounts, acc)
+		}
+		acc.Folders = append(acc.Folders, folder)
+		acc.Messages += folder.Messages
+		acc.TotalBytes += folder.TotalBytes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var dbPath string
+	if err := c.db.QueryRow(`SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&dbPath); err == nil && dbPath != "" {
+		for _, p := range []string{dbPath, dbPath + "-wal"} {
+			if info, err := os.Stat(p); err == nil {
+				stats.DatabaseBytes += info.Size()
+			}
+		}
+	}
+
+	stats.BlobBytes = blobDirSize(c.blobs.dir)
+
+	return stats, nil
+}
+
+// GetCacheStats reports how much space the cache uses per account and folder
+func (s *MailService) GetCacheStats() (*CacheStats, error) {
+	if s.cache == nil {
+		return nil, fmt.Errorf("cache is not available")
+	}
+	return s.cache.GetStats()
+}
+
+// RunCacheEviction applies all cache limits immediately
+func (s *MailService) RunCacheEviction() error {
+	if s.cache == nil {
+		return fmt.Errorf("cache is not available")
+	}
+	s.evictCache()
+	return nil
+}
+
+// runCacheEvictor applies every account's cache limits periodically
+func (s *MailService) runCacheEvictor() {
+	ticker := time.NewTicker(cacheEvictionInterval)
+	defer ticker.Stop()
+
+	for {
+		s.evictCache()
+		<-ticker.C
+	}
+}
+
+// evictCache runs one eviction pass over all accounts
+func (s *MailService) evictCache() {
+	if s.cache == nil {
+		return
+	}
+
+	evicted := false
+	for _, acc := range s.accountService.GetAccounts() {
+		if acc.CacheLimits == nil {
+			continue
+		}
+		result, err := s.cache.EvictContent(acc.ID, *acc.CacheLimits)
+		if err != nil {
+			fmt.Printf("[MailService] Cache eviction failed for %s: %v\n", acc.Email, err)
+			continue
+		}
+		if result.BodiesEvicted > 0 {
+			evicted = true
+		}
+	}
+
+	if evicted {
+		if _, _, err := s.cache.CollectGarbage(); err != nil {
+			fmt.Printf("[MailService] Blob garbage collection failed: %v\n", err)
+		}
+	}
+}
+
+// SetCacheLimits updates an account's cache limits.
+// They take effect on the next eviction pass.
+func (s *MailAccountService) SetCacheLimits(accountID string, limits CacheLimits) error {
+	if limits.MaxBytes < 0 || limits.MaxBodyAgeDays < 0 || limits.HeadersOnlyAfter < 0 {
+		return fmt.Errorf("cache limits cannot be negative")
+	}
+
+	s.accountsMutex.Lock()
+	acc, exists := s.accounts[accountID]
+	if !exists {
+		s.accountsMutex.Unlock()
+		return fmt.Errorf("account not found")
+	}
+	previous := acc.CacheLimits
+	acc.CacheLimits = &limits
+	err := s.saveAccounts()
+	if err != nil {
+		acc.CacheLimits = previous
+	}
+	s.accountsMutex.Unlock()
+
+	return err
+}
+
+// blobDirSize returns the on-disk size of the blob store
+func blobDirSize(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
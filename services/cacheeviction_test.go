@@ -0,0 +1,165 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cacheBodies caches one email per entry in account, with a 100 byte body
+// and the given internal date
+func cacheBodies(t *testing.T, cache *EmailCache, accountID string, received ...time.Time) {
+	t.Helper()
+	var emails []*Email
+	for i, date := range received {
+		uid := uint32(i + 1)
+		emails = append(emails, &Email{
+			ID:           fmt.Sprintf("%s-%d", accountID, uid),
+			AccountID:    accountID,
+			Folder:       "INBOX",
+			UID:          uid,
+			Subject:      fmt.Sprintf("message %d", uid),
+			Date:         date.Format(time.RFC3339),
+			InternalDate: date.Format(time.RFC3339),
+			Body:         strings.Repeat("x", 100),
+			CreatedAt:    getCurrentTime(),
+		})
+	}
+	if err := cache.CacheEmails(emails); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setAccessed backdates the LRU timestamp of a cached email
+func setAccessed(t *testing.T, cache *EmailCache, accountID string, uid uint32, at time.Time) {
+	t.Helper()
+	if _, err := cache.db.Exec(`UPDATE emails SET last_accessed = ? WHERE account_id = ? AND uid = ?`,
+		at.Unix(), accountID, uid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bodiesLeft returns which UIDs of an account still have content cached
+func bodiesLeft(t *testing.T, cache *EmailCache, accountID string, uids ...uint32) map[uint32]bool {
+	t.Helper()
+	emails, err := cache.GetCachedEmailsByUIDs(accountID, "INBOX", uids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := make(map[uint32]bool)
+	for _, uid := range uids {
+		e := emails[uid]
+		if e == nil {
+			t.Fatalf("uid %d lost its envelope", uid)
+		}
+		if e.Subject != fmt.Sprintf("message %d", uid) {
+			t.Errorf("uid %d subject %q", uid, e.Subject)
+		}
+		left[uid] = e.Body != ""
+	}
+	return left
+}
+
+func TestEvictContentHeadersOnlyAfter(t *testing.T) {
+	cache := newTestCache(t)
+	now := time.Now()
+	cacheBodies(t, cache, "a", now.AddDate(0, 0, -100), now.AddDate(0, 0, -10), now)
+	// Reading old mail does not exempt it from the headers-only limit
+	setAccessed(t, cache, "a", 1, now)
+
+	result, err := cache.EvictContent("a", CacheLimits{HeadersOnlyAfter: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BodiesEvicted != 1 || result.BytesFreed != 100 {
+		t.Errorf("result = %+v, want 1 body and 100 bytes", result)
+	}
+	left := bodiesLeft(t, cache, "a", 1, 2, 3)
+	if left[1] || !left[2] || !left[3] {
+		t.Errorf("bodies left = %v, want only the 100 day old one gone", left)
+	}
+}
+
+func TestEvictContentByAge(t *testing.T) {
+	cache := newTestCache(t)
+	now := time.Now()
+	cacheBodies(t, cache, "a", now, now, now, now)
+
+	setAccessed(t, cache, "a", 1, now.AddDate(0, 0, -40))
+	setAccessed(t, cache, "a", 2, now.AddDate(0, 0, -5))
+	// Cached before access tracking and not updated since
+	if _, err := cache.db.Exec(`UPDATE emails SET updated_at = ? WHERE account_id = 'a' AND uid = 3`,
+		now.AddDate(0, 0, -60).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	// uid 4 was never opened but was cached just now
+
+	result, err := cache.EvictContent("a", CacheLimits{MaxBodyAgeDays: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BodiesEvicted != 2 {
+		t.Errorf("evicted %d bodies, want 2", result.BodiesEvicted)
+	}
+	left := bodiesLeft(t, cache, "a", 1, 2, 3, 4)
+	if left[1] || !left[2] || left[3] || !left[4] {
+		t.Errorf("bodies left = %v, want uids 2 and 4", left)
+	}
+}
+
+func TestEvictContentToSize(t *testing.T) {
+	cache := newTestCache(t)
+	now := time.Now()
+	cacheBodies(t, cache, "a", now, now, now, now)
+	cacheBodies(t, cache, "b", now)
+
+	// uid 2 also has its raw source, so it weighs more than its body alone
+	raw := rawMessage("sized")
+	if _, err := cache.StoreRawMessage("a", "INBOX", 2, raw); err != nil {
+		t.Fatal(err)
+	}
+	for uid, ago := range map[uint32]int{1: 3, 2: 4, 3: 1, 4: 2} {
+		setAccessed(t, cache, "a", uid, now.Add(-time.Duration(ago)*time.Hour))
+	}
+
+	// Already under the limit: nothing to do
+	if result, err := cache.EvictContent("a", CacheLimits{MaxBytes: 1000}); err != nil || result.BodiesEvicted != 0 {
+		t.Fatalf("under limit: %+v, %v", result, err)
+	}
+
+	result, err := cache.EvictContent("a", CacheLimits{MaxBytes: 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Least recently used first: uid 2 (100 + raw) then uid 1 bring 400+raw
+	// down to 200
+	if want := int64(200 + len(raw)); result.BodiesEvicted != 2 || result.BytesFreed != want {
+		t.Errorf("result = %+v, want 2 bodies and %d bytes", result, want)
+	}
+	left := bodiesLeft(t, cache, "a", 1, 2, 3, 4)
+	if left[1] || left[2] || !left[3] || !left[4] {
+		t.Errorf("bodies left = %v, want uids 3 and 4", left)
+	}
+	if _, err := cache.GetRawMessage("a", "INBOX", 2); err == nil {
+		t.Error("raw source of an evicted email is still referenced")
+	}
+	if removed, _, err := cache.CollectGarbage(); err != nil || removed != 1 {
+		t.Errorf("garbage collection removed %d blobs, %v, want 1", removed, err)
+	}
+
+	// Other accounts are not counted or evicted
+	if left := bodiesLeft(t, cache, "b", 1); !left[1] {
+		t.Error("account b lost its body")
+	}
+
+	stats, err := cache.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range stats.Accounts {
+		if want := map[string]int64{"a": 200, "b": 100}[acc.AccountID]; acc.TotalBytes != want {
+			t.Errorf("account %s uses %d bytes, want %d", acc.AccountID, acc.TotalBytes, want)
+		}
+	}
+}
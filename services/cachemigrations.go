@@ -0,0 +1,393 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// schemaMigration is one ordered step of a SQLite schema.
+// The schema version is stored in PRAGMA user_version.
+type schemaMigration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// cacheMigrations must be sorted by version and never edited once released;
+// add a new step instead
+var cacheMigrations = []schemaMigration{
+	{
+		version:     1,
+		description: "initial emails table",
+		up: func(tx *sql.Tx) error {
+			// IF NOT EXISTS keeps this a no-op for databases created before
+			// migrations existed, which share this schema at user_version 0
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS emails (
+					id TEXT PRIMARY KEY,
+					account_id TEXT NOT NULL,
+					folder TEXT NOT NULL,
+					uid INTEGER NOT NULL,
+					from_addr TEXT,
+					to_addresses TEXT,
+					cc_addresses TEXT,
+					subject TEXT,
+					date TEXT,
+					body TEXT,
+					is_read INTEGER DEFAULT 0,
+					is_starred INTEGER DEFAULT 0,
+					created_at TEXT NOT NULL,
+					updated_at TEXT NOT NULL,
+					UNIQUE(account_id, folder, uid)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_emails_account_folder ON emails(account_id, folder);
+				CREATE INDEX IF NOT EXISTS idx_emails_date ON emails(date DESC);
+				CREATE INDEX IF NOT EXISTS idx_emails_is_read ON emails(is_read);
+			`)
+			return err
+		},
+	},
+	{
+		version:     2,
+		description: "store Message-ID",
+		up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "emails", "message_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_message_id ON emails(message_id)`)
+			return err
+		},
+	},
+	{
+		version:     3,
+		description: "reference raw messages in the blob store",
+		up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "emails", "raw_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			if err := addColumnIfMissing(tx, "emails", "raw_size", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_raw_hash ON emails(raw_hash)`)
+			return err
+		},
+	},
+	{
+		version:     4,
+		description: "track last access for cache eviction",
+		up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "emails", "last_accessed", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_account_accessed ON emails(account_id, last_accessed)`)
+			return err
+		},
+	},
+	{
+		version:     5,
+		description: "offline operation journal",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS pending_ops (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					account_id TEXT NOT NULL,
+					folder TEXT NOT NULL,
+					uid INTEGER NOT NULL,
+					op TEXT NOT NULL,
+					target_folder TEXT NOT NULL DEFAULT '',
+					attempts INTEGER NOT NULL DEFAULT 0,
+					last_error TEXT NOT NULL DEFAULT '',
+					created_at TEXT NOT NULL
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_pending_ops_account ON pending_ops(account_id, id);
+			`)
+			return err
+		},
+	},
+	{
+		version:     6,
+		description: "INTERNALDATE as a unix timestamp for cursor pagination",
+		up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "emails", "internal_date", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			// Rows cached earlier only know their Date header; it is the best
+			// approximation until the folder is synced again
+			_, err := tx.Exec(`
+				UPDATE emails SET internal_date = COALESCE(CAST(strftime('%s', date) AS INTEGER), 0)
+				WHERE internal_date = 0;
+
+				CREATE INDEX IF NOT EXISTS idx_emails_folder_internal_date
+					ON emails(account_id, folder, internal_date DESC, uid DESC);
+			`)
+			return err
+		},
+	},
+	{
+		version:     7,
+		description: "sent date as a unix timestamp",
+		up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "emails", "sent_date", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			// Same rules as messageSentDate: unparseable, pre-1970 or far-future
+			// Date headers fall back to the received time
+			_, err := tx.Exec(`
+				UPDATE emails SET sent_date = COALESCE(CAST(strftime('%s', date) AS INTEGER), 0);
+				UPDATE emails SET sent_date = internal_date
+				WHERE sent_date <= 0 OR (internal_date > 0 AND sent_date > internal_date + 86400);
+
+				CREATE INDEX IF NOT EXISTS idx_emails_folder_sent_date
+					ON emails(account_id, folder, sent_date DESC, uid DESC);
+			`)
+			return err
+		},
+	},
+	{
+		version:     8,
+		description: "structured addresses stored as JSON, with Sender and Reply-To",
+		up: func(tx *sql.Tx) error {
+			for _, column := range []string{"sender_addr", "reply_to"} {
+				if err := addColumnIfMissing(tx, "emails", column, "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+					return err
+				}
+			}
+			return convertLegacyAddresses(tx)
+		},
+	},
+	{
+		version:     9,
+		description: "meeting invitations parsed from calendar parts",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "emails", "invitation", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		version:     10,
+		description: "Autocrypt peer state",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS autocrypt_peers (
+					account_id TEXT NOT NULL,
+					addr TEXT NOT NULL,
+					last_seen INTEGER NOT NULL DEFAULT 0,
+					autocrypt_timestamp INTEGER NOT NULL DEFAULT 0,
+					public_key BLOB,
+					prefer_encrypt TEXT NOT NULL DEFAULT 'nopreference',
+					gossip_timestamp INTEGER NOT NULL DEFAULT 0,
+					gossip_key BLOB,
+					PRIMARY KEY (account_id, addr)
+				)
+			`)
+			return err
+		},
+	},
+	{
+		version:     11,
+		description: "sender authentication verdicts",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "emails", "authentication", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		version:     12,
+		description: "phishing warnings found in message bodies",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "emails", "warnings", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		version:     13,
+		description: "spam filter model and scores",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS spam_tokens (
+					token TEXT PRIMARY KEY,
+					spam_count INTEGER NOT NULL DEFAULT 0,
+					ham_count INTEGER NOT NULL DEFAULT 0
+				);
+				CREATE TABLE IF NOT EXISTS spam_trained (
+					account_id TEXT NOT NULL,
+					message_key TEXT NOT NULL,
+					is_spam INTEGER NOT NULL,
+					tokens TEXT NOT NULL,
+					trained_at INTEGER NOT NULL,
+					PRIMARY KEY (account_id, message_key)
+				);
+			`)
+			if err != nil {
+				return err
+			}
+			// -1 marks a message the filter has not scored
+			return addColumnIfMissing(tx, "emails", "spam_score", "REAL NOT NULL DEFAULT -1")
+		},
+	},
+	{
+		version:     14,
+		description: "keywords added by journaled operations",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "pending_ops", "keyword", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		version:     15,
+		description: "UIDVALIDITY of synced folders",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS folder_state (
+					account_id TEXT NOT NULL,
+					folder TEXT NOT NULL,
+					uid_validity INTEGER NOT NULL,
+					PRIMARY KEY (account_id, folder)
+				)
+			`)
+			return err
+		},
+	},
+}
+
+// schemaVersion returns the latest version of a migration list
+func schemaVersion(migrations []schemaMigration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// migrateCache brings emails.db up to the latest schema
+func migrateCache(db *sql.DB) error {
+	return migrateSchema(db, "EmailCache", cacheMigrations)
+}
+
+// migrateSchema brings a database up to the latest schema.
+// Each step runs in its own transaction together with the user_version bump,
+// so a failed step leaves the database at the previous version.
+func migrateSchema(db *sql.DB, tag string, migrations []schemaMigration) error {
+	var current int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	latest := schemaVersion(migrations)
+	if current > latest {
+		return fmt.Errorf("schema version %d is newer than supported version %d", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		fmt.Printf("[%s] Migrating schema to version %d: %s\n", tag, m.version, m.description)
+		if err := runMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		current = m.version
+	}
+
+	return nil
+}
+
+func runMigration(db *sql.DB, m schemaMigration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	// PRAGMA does not accept bound parameters
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// columnExists reports whether a table already has a column, for migrations
+// that must tolerate databases patched by hand or by older builds
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			ctype      string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumnIfMissing adds a column unless it already exists
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// convertLegacyAddresses rewrites comma-separated address columns as JSON
+func convertLegacyAddresses(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT id, COALESCE(from_addr, ''), COALESCE(to_addresses, ''), COALESCE(cc_addresses, '')
+		FROM emails
+	`)
+	if err != nil {
+		return err
+	}
+
+	type converted struct{ id, from, to, cc string }
+	var updates []converted
+	for rows.Next() {
+		var id, from, to, cc string
+		if err := rows.Scan(&id, &from, &to, &cc); err != nil {
+			rows.Close()
+			return err
+		}
+		updates = append(updates, converted{
+			id:   id,
+			from: encodeAddresses(decodeAddresses(from)),
+			to:   encodeAddresses(decodeAddresses(to)),
+			cc:   encodeAddresses(decodeAddresses(cc)),
+		})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`UPDATE emails SET from_addr = ?, to_addresses = ?, cc_addresses = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, u := range updates {
+		if _, err := stmt.Exec(u.from, u.to, u.cc, u.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
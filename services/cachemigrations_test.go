@@ -0,0 +1,190 @@
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// baselineSchema is emails.db as created before migrations existed, at
+// user_version 0
+const baselineSchema = `
+	CREATE TABLE emails (
+		id TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		from_addr TEXT,
+		to_addresses TEXT,
+		cc_addresses TEXT,
+		subject TEXT,
+		date TEXT,
+		body TEXT,
+		is_read INTEGER DEFAULT 0,
+		is_starred INTEGER DEFAULT 0,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		UNIQUE(account_id, folder, uid)
+	);
+	CREATE INDEX idx_emails_account_folder ON emails(account_id, folder);
+	CREATE INDEX idx_emails_date ON emails(date DESC);
+	CREATE INDEX idx_emails_is_read ON emails(is_read);
+`
+
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "emails.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func queryStrings(t *testing.T, db *sql.DB, query string) map[string]bool {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func TestMigrateBaselineCache(t *testing.T) {
+	db, path := openTestDB(t)
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`
+		INSERT INTO emails (id, account_id, folder, uid, from_addr, to_addresses, cc_addresses,
+			subject, date, body, is_read, is_starred, created_at, updated_at)
+		VALUES
+			('e1', 'a', 'INBOX', 1, 'alice@example.com', 'bob@example.com, carol@example.com', '',
+				'Hello', '2024-03-01T10:00:00Z', 'body one', 1, 0, 'c', 'u'),
+			('e2', 'a', 'INBOX', 2, '', 'bob@example.com', 'dave@example.com',
+				'No date', 'not a date', '', 0, 1, 'c', 'u')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateCache(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if want := schemaVersion(cacheMigrations); version != want {
+		t.Errorf("user_version %d, want %d", version, want)
+	}
+
+	columns := queryStrings(t, db, `SELECT name FROM pragma_table_info('emails')`)
+	for _, column := range []string{"message_id", "raw_hash", "raw_size", "last_accessed", "internal_date",
+		"sent_date", "sender_addr", "reply_to", "invitation", "authentication", "warnings", "spam_score"} {
+		if !columns[column] {
+			t.Errorf("emails.%s missing", column)
+		}
+	}
+	tables := queryStrings(t, db, `SELECT name FROM sqlite_master WHERE type = 'table'`)
+	for _, table := range []string{"pending_ops", "autocrypt_peers", "spam_tokens", "spam_trained", "folder_state"} {
+		if !tables[table] {
+			t.Errorf("table %s missing", table)
+		}
+	}
+	indexes := queryStrings(t, db, `SELECT name FROM sqlite_master WHERE type = 'index'`)
+	for _, index := range []string{"idx_emails_account_folder", "idx_emails_message_id", "idx_emails_raw_hash",
+		"idx_emails_account_accessed", "idx_pending_ops_account", "idx_emails_folder_internal_date",
+		"idx_emails_folder_sent_date"} {
+		if !indexes[index] {
+			t.Errorf("index %s missing", index)
+		}
+	}
+
+	// Migration 7 backfills sent_date from the Date header
+	var sent1, sent2 int64
+	if err := db.QueryRow(`SELECT sent_date FROM emails WHERE id = 'e1'`).Scan(&sent1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT sent_date FROM emails WHERE id = 'e2'`).Scan(&sent2); err != nil {
+		t.Fatal(err)
+	}
+	if sent1 != 1709287200 || sent2 != 0 {
+		t.Errorf("sent_date %d and %d, want 1709287200 and 0", sent1, sent2)
+	}
+
+	// Migration 8 turns comma-separated addresses into JSON
+	var to string
+	if err := db.QueryRow(`SELECT to_addresses FROM emails WHERE id = 'e1'`).Scan(&to); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"name":"","email":"bob@example.com"},{"name":"","email":"carol@example.com"}]`; to != want {
+		t.Errorf("to_addresses %s, want %s", to, want)
+	}
+	db.Close()
+
+	// The migrated rows read back through the cache
+	cache, err := NewEmailCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	emails, err := cache.GetCachedEmailsByUIDs("a", "INBOX", []uint32{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e1, e2 := emails[1], emails[2]
+	if e1 == nil || e2 == nil {
+		t.Fatalf("rows lost: %v", emails)
+	}
+	if len(e1.From) != 1 || e1.From[0].Email != "alice@example.com" || len(e1.To) != 2 || len(e1.CC) != 0 ||
+		e1.Subject != "Hello" || e1.Body != "body one" || !e1.IsRead {
+		t.Errorf("e1 = %+v", e1)
+	}
+	if len(e2.From) != 0 || len(e2.CC) != 1 || e2.CC[0].Email != "dave@example.com" || !e2.IsStarred {
+		t.Errorf("e2 = %+v", e2)
+	}
+}
+
+func TestMigrateSentDateFallsBackToInternalDate(t *testing.T) {
+	db, _ := openTestDB(t)
+	if err := migrateSchema(db, "test", cacheMigrations[:6]); err != nil {
+		t.Fatal(err)
+	}
+	// A far-future Date header and a pre-1970 one both give way to INTERNALDATE
+	_, err := db.Exec(`
+		INSERT INTO emails (id, account_id, folder, uid, date, internal_date, created_at, updated_at)
+		VALUES
+			('e1', 'a', 'INBOX', 1, '2099-01-01T00:00:00Z', 1700000000, 'c', 'u'),
+			('e2', 'a', 'INBOX', 2, '1960-01-01T00:00:00Z', 1700000000, 'c', 'u'),
+			('e3', 'a', 'INBOX', 3, '2023-11-14T20:00:00Z', 1700000000, 'c', 'u')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateCache(db); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{"e1": 1700000000, "e2": 1700000000, "e3": 1699992000}
+	for id, sent := range want {
+		var got int64
+		if err := db.QueryRow(`SELECT sent_date FROM emails WHERE id = ?`, id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != sent {
+			t.Errorf("%s: sent_date %d, want %d", id, got, sent)
+		}
+	}
+}
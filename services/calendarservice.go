@@ -0,0 +1,633 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// conflictHorizon is how far ahead the occurrences of a recurring
+	// invitation are checked against the calendar
+	conflictHorizon = 90 * 24 * time.Hour
+	// maxOccurrenceRange bounds GetOccurrences
+	maxOccurrenceRange = 366 * 24 * time.Hour
+)
+
+// EventOccurrence is one occurrence of a calendar event
+type EventOccurrence struct {
+	EventID   string `json:"eventId"`
+	UID       string `json:"uid"`
+	Summary   string `json:"summary"`
+	Location  string `json:"location,omitempty"`
+	Start     string `json:"start"` // RFC3339 in UTC, or YYYY-MM-DD for all-day events
+	End       string `json:"end"`
+	AllDay    bool   `json:"allDay"`
+	Recurring bool   `json:"recurring"`
+	Response  string `json:"response"`
+}
+
+// CalendarService keeps the events the user accepted from invitations so
+// new invitations can show what they clash with
+type CalendarService struct {
+	store *CalendarStore
+}
+
+// NewCalendarService creates the calendar service and subscribes it to invitations
+func NewCalendarService(accountService *MailAccountService, mailService *MailService) *CalendarService {
+	configDir, err := getUserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
+	}
+	dbPath := filepath.Join(configDir, "wmail", "calendar.db")
+
+	store, err := NewCalendarStore(dbPath)
+	if err != nil {
+		fmt.Printf("[CalendarService] Failed to open calendar: %v\n", err)
+		store = nil
+	} else {
+		fmt.Printf("[CalendarService] Calendar opened at %s\n", dbPath)
+	}
+
+	s := &CalendarService{store: store}
+
+	if store != nil {
+		accountService.RegisterLifecycleHook(s.lifecycleHook())
+		mailService.RegisterInvitationHook(s.invitationHook())
+	}
+
+	return s
+}
+
+func (s *CalendarService) checkStore() error {
+	if s.store == nil {
+		return fmt.Errorf("calendar is not available")
+	}
+	return nil
+}
+
+// GetEvents returns every stored event
+func (s *CalendarService) GetEvents() ([]*CalendarEvent, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	return s.store.List()
+}
+
+// GetEvent returns one event
+func (s *CalendarService) GetEvent(id string) (*CalendarEvent, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	return s.store.Get(id)
+}
+
+// DeleteEvent removes an event from the local calendar. Nothing is sent
+// to the organizer.
+func (s *CalendarService) DeleteEvent(id string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	event, err := s.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if event.RecurrenceID != "" {
+		// Removing a moved occurrence must not bring back the original one
+		if err := s.store.AddExDate(event.UID, event.RecurrenceID); err != nil {
+			return err
+		}
+	}
+	return s.store.Delete(id)
+}
+
+// GetOccurrences returns the occurrences of all events between from and
+// to (RFC3339 or YYYY-MM-DD), with recurrences expanded
+func (s *CalendarService) GetOccurrences(from, to string) ([]*EventOccurrence, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	start, end, err := parseRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if end.Sub(start) > maxOccurrenceRange {
+		return nil, fmt.Errorf("range is longer than a year")
+	}
+	return s.occurrences(start, end)
+}
+
+// GetConflicts returns the timed events overlapping start to end
+func (s *CalendarService) GetConflicts(start, end string) ([]*EventOccurrence, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	from, to, err := parseRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return s.busy(from, to, "")
+}
+
+// ExportICS returns every event as an iCalendar file
+func (s *CalendarService) ExportICS() (string, error) {
+	if err := s.checkStore(); err != nil {
+		return "", err
+	}
+	events, err := s.store.List()
+	if err != nil {
+		return "", err
+	}
+	return formatICS(events), nil
+}
+
+func parseRange(from, to string) (time.Time, time.Time, error) {
+	start, err := parseRangeTime(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseRangeTime(to)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end: %w", err)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end must be after start")
+	}
+	return start, end, nil
+}
+
+func parseRangeTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// occurrences expands every event within [from, to). Occurrences replaced
+// by an override are left out in favor of the override.
+func (s *CalendarService) occurrences(from, to time.Time) ([]*EventOccurrence, error) {
+	events, err := s.store.Candidates(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	overridden := make(map[string]map[int64]bool)
+	for _, e := range events {
+		if e.RecurrenceID == "" {
+			continue
+		}
+		if t, err := parseEventTime(e.RecurrenceID, e.AllDay); err == nil {
+			if overridden[e.UID] == nil {
+				overridden[e.UID] = make(map[int64]bool)
+			}
+			overridden[e.UID][t] = true
+		}
+	}
+
+	result := []*EventOccurrence{}
+	for _, e := range events {
+		duration := eventDuration(e)
+		for _, start := range eventOccurrences(e, from, to) {
+			if e.RecurrenceID == "" && overridden[e.UID][start.Unix()] {
+				continue
+			}
+			result = append(result, &EventOccurrence{
+				EventID:   e.ID,
+				UID:       e.UID,
+				Summary:   e.Summary,
+				Location:  e.Location,
+				Start:     formatEventTime(start.Unix(), e.AllDay),
+				End:       formatEventTime(start.Add(duration).Unix(), e.AllDay),
+				AllDay:    e.AllDay,
+				Recurring: e.Recurrence != "" || e.RecurrenceID != "",
+				Response:  e.Response,
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Start < result[j].Start })
+	return result, nil
+}
+
+// busy returns the timed occurrences overlapping [from, to), leaving out
+// the event uid. All-day events do not block time.
+func (s *CalendarService) busy(from, to time.Time, uid string) ([]*EventOccurrence, error) {
+	occurrences, err := s.occurrences(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var busy []*EventOccurrence
+	for _, o := range occurrences {
+		if o.AllDay || (uid != "" && o.UID == uid) {
+			continue
+		}
+		busy = append(busy, o)
+	}
+	return busy, nil
+}
+
+// eventOccurrences returns the starts of an event's occurrences
+// overlapping [from, to), minus its EXDATEs
+func eventOccurrences(e *CalendarEvent, from, to time.Time) []time.Time {
+	unix, err := parseEventTime(e.Start, e.AllDay)
+	if err != nil {
+		return nil
+	}
+	start := time.Unix(unix, 0)
+	duration := eventDuration(e)
+
+	rule := e.Recurrence
+	if e.RecurrenceID != "" {
+		rule = ""
+	}
+	starts := expandRecurrence(rule, start, duration, eventLocation(e), from, to)
+	if len(e.ExDates) == 0 {
+		return starts
+	}
+
+	excluded := make(map[int64]bool)
+	for _, d := range e.ExDates {
+		if t, err := parseEventTime(d, e.AllDay); err == nil {
+			excluded[t] = true
+		}
+	}
+	kept := starts[:0]
+	for _, t := range starts {
+		if !excluded[t.Unix()] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func eventDuration(e *CalendarEvent) time.Duration {
+	start, err := parseEventTime(e.Start, e.AllDay)
+	if err != nil {
+		return 0
+	}
+	end, err := parseEventTime(e.End, e.AllDay)
+	if err != nil || end < start {
+		return 0
+	}
+	if e.AllDay && end == start {
+		return 24 * time.Hour
+	}
+	return time.Duration(end-start) * time.Second
+}
+
+// eventFromInvitation builds the calendar entry for an answered invitation
+func eventFromInvitation(accountID string, inv *Invitation) *CalendarEvent {
+	return &CalendarEvent{
+		UID:          inv.UID,
+		RecurrenceID: inv.RecurrenceID,
+		AccountID:    accountID,
+		Sequence:     inv.Sequence,
+		Summary:      inv.Summary,
+		Description:  inv.Description,
+		Location:     inv.Location,
+		Start:        inv.Start,
+		End:          inv.End,
+		AllDay:       inv.AllDay,
+		TimeZone:     inv.TimeZone,
+		Recurrence:   inv.Recurrence,
+		ExDates:      inv.ExDates,
+		Organizer:    inv.Organizer,
+		Attendees:    inv.Attendees,
+		Status:       inv.Status,
+		Response:     inv.Response,
+	}
+}
+
+// invitationHook keeps the calendar in step with the invitations the user
+// opens and answers. A sync only fetches envelopes, so invitations, and the
+// conflicts they bring, are only seen once their message is opened.
+func (s *CalendarService) invitationHook() InvitationHook {
+	return InvitationHook{
+		Name: "calendar",
+		OnView: func(accountID string, email *Email) {
+			inv := email.Invitation
+			if err := s.applyInvitation(accountID, email); err != nil {
+				fmt.Printf("[CalendarService] Failed to apply %s for %s: %v\n", inv.Method, inv.UID, err)
+			}
+			if inv.Method != InviteMethodRequest {
+				return
+			}
+			conflicts, err := s.inviteConflicts(inv)
+			if err != nil {
+				fmt.Printf("[CalendarService] Failed to check conflicts: %v\n", err)
+				return
+			}
+			inv.Conflicts = conflicts
+		},
+		OnRespond: func(accountID string, email *Email) {
+			inv := email.Invitation
+			if err := s.recordResponse(accountID, inv); err != nil {
+				fmt.Printf("[CalendarService] Failed to record response to %s: %v\n", inv.UID, err)
+			}
+		},
+	}
+}
+
+// applyInvitation applies cancellations and updates to events already in
+// the calendar. New requests are only stored once accepted. Changes are
+// only taken from the organizer of the stored event; others are flagged
+// as unverified and left alone.
+func (s *CalendarService) applyInvitation(accountID string, email *Email) error {
+	inv := email.Invitation
+	if inv.UID == "" {
+		return nil
+	}
+	existing, err := s.store.Find(inv.UID, inv.RecurrenceID)
+	if err != nil {
+		existing = nil
+	}
+	// A change to one occurrence answers to the organizer of the series
+	stored := existing
+	if stored == nil && inv.RecurrenceID != "" {
+		if master, err := s.store.Find(inv.UID, ""); err == nil {
+			stored = master
+		}
+	}
+
+	cancelled := inv.Method == InviteMethodCancel || (inv.Method == InviteMethodRequest && inv.Status == "CANCELLED")
+	updated := inv.Method == InviteMethodRequest && existing != nil && inv.Sequence > existing.Sequence
+	if stored == nil || (!cancelled && !updated) {
+		return nil
+	}
+	if !fromOrganizer(email, stored.Organizer) {
+		inv.Unverified = true
+		fmt.Printf("[CalendarService] Ignoring %s for %s: not verified to come from the organizer\n", inv.Method, inv.UID)
+		return nil
+	}
+
+	if cancelled {
+		// An old cancellation must not remove an event that was re-sent since
+		if existing != nil && inv.Sequence < existing.Sequence {
+			return nil
+		}
+		if inv.RecurrenceID != "" {
+			if err := s.store.AddExDate(inv.UID, inv.RecurrenceID); err != nil {
+				return err
+			}
+		}
+		return s.store.DeleteUID(inv.UID, inv.RecurrenceID)
+	}
+
+	// The organizer changed the event: keep our answer, take the rest
+	event := eventFromInvitation(existing.AccountID, inv)
+	event.Response = existing.Response
+	return s.store.Save(event)
+}
+
+// fromOrganizer reports whether a message comes from an event's organizer:
+// its only From address is the organizer's and passed sender
+// authentication, so a forged From cannot cancel or move a meeting
+func fromOrganizer(email *Email, organizer *Attendee) bool {
+	if organizer == nil || organizer.Email == "" || len(email.From) != 1 {
+		return false
+	}
+	if !strings.EqualFold(email.From[0].Email, organizer.Email) {
+		return false
+	}
+	return email.Authentication != nil && email.Authentication.Verdict == AuthPass
+}
+
+// recordResponse stores an accepted event and forgets a declined one
+func (s *CalendarService) recordResponse(accountID string, inv *Invitation) error {
+	switch inv.Response {
+	case PartStatAccepted, PartStatTentative:
+		return s.store.Save(eventFromInvitation(accountID, inv))
+	case PartStatDeclined:
+		if inv.RecurrenceID != "" {
+			if err := s.store.AddExDate(inv.UID, inv.RecurrenceID); err != nil {
+				return err
+			}
+		}
+		return s.store.DeleteUID(inv.UID, inv.RecurrenceID)
+	}
+	return nil
+}
+
+// inviteConflicts returns the events the invitation's occurrences overlap.
+// Recurring invitations are checked over the next conflictHorizon.
+func (s *CalendarService) inviteConflicts(inv *Invitation) ([]*EventOccurrence, error) {
+	if inv.AllDay || inv.Start == "" {
+		return nil, nil
+	}
+	event := eventFromInvitation("", inv)
+	start, err := parseEventTime(event.Start, false)
+	if err != nil {
+		return nil, err
+	}
+
+	from := time.Unix(start, 0)
+	if now := time.Now(); event.Recurrence != "" && from.Before(now) {
+		from = now
+	}
+	to := from.Add(conflictHorizon)
+	if event.Recurrence == "" {
+		to = from.Add(eventDuration(event))
+	}
+	if !to.After(from) {
+		return nil, nil
+	}
+
+	duration := eventDuration(event)
+	var conflicts []*EventOccurrence
+	seen := make(map[string]bool)
+	for _, occurrence := range eventOccurrences(event, from, to) {
+		busy, err := s.busy(occurrence, occurrence.Add(duration), inv.UID)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range busy {
+			key := b.EventID + b.Start
+			if !seen[key] {
+				seen[key] = true
+				conflicts = append(conflicts, b)
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// lifecycleHook exports an account's events as calendar.ics and drops them on delete
+func (s *CalendarService) lifecycleHook() AccountLifecycleHook {
+	return AccountLifecycleHook{
+		Name: "calendar",
+		OnExport: func(account *Account, dir string) error {
+			events, err := s.store.ListAccount(account.ID)
+			if err != nil {
+				return err
+			}
+			if len(events) == 0 {
+				return nil
+			}
+			return writeFileAtomic(filepath.Join(dir, "calendar.ics"), []byte(formatICS(events)), 0600)
+		},
+		OnDelete: func(account *Account) error {
+			return s.store.DeleteAccount(account.ID)
+		},
+	}
+}
+
+// formatICS writes events as an RFC 5545 calendar. Recurring events keep
+// their time zone, with a VTIMEZONE for it, so occurrences stay at the
+// same local time across daylight saving changes; the rest are in UTC.
+func formatICS(events []*CalendarEvent) string {
+	var b strings.Builder
+	writeContentLine(&b, "BEGIN:VCALENDAR")
+	writeContentLine(&b, "PRODID:-//wmail//EN")
+	writeContentLine(&b, "VERSION:2.0")
+	writeContentLine(&b, "CALSCALE:GREGORIAN")
+
+	// Overrides follow the zone of their series
+	zones := make(map[string]*time.Location)
+	for _, e := range events {
+		if e.Recurrence == "" || e.AllDay {
+			continue
+		}
+		if loc := eventLocation(e); loc != time.UTC && loc != time.Local {
+			zones[e.UID] = loc
+		}
+	}
+	written := make(map[string]bool)
+	for _, e := range events {
+		loc := zones[e.UID]
+		if loc == nil || written[loc.String()] {
+			continue
+		}
+		written[loc.String()] = true
+		// Observances must begin before the first event they apply to
+		start, _ := parseEventTime(e.Start, false)
+		writeVTimezone(&b, loc, time.Unix(start, 0).In(loc).Year()-1)
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		loc := zones[e.UID]
+		writeContentLine(&b, "BEGIN:VEVENT")
+		writeContentLine(&b, "UID:"+e.UID)
+		writeContentLine(&b, "DTSTAMP:"+stamp)
+		if e.Sequence > 0 {
+			writeContentLine(&b, "SEQUENCE:"+strconv.Itoa(e.Sequence))
+		}
+		if e.RecurrenceID != "" {
+			writeContentLine(&b, "RECURRENCE-ID"+icsTimeValue(e.RecurrenceID, e.AllDay, loc))
+		}
+		writeContentLine(&b, "DTSTART"+icsTimeValue(e.Start, e.AllDay, loc))
+		writeContentLine(&b, "DTEND"+icsTimeValue(e.End, e.AllDay, loc))
+		if e.Recurrence != "" {
+			writeContentLine(&b, "RRULE:"+e.Recurrence)
+		}
+		for _, d := range e.ExDates {
+			writeContentLine(&b, "EXDATE"+icsTimeValue(d, e.AllDay, loc))
+		}
+		if e.Summary != "" {
+			writeContentLine(&b, "SUMMARY:"+escapeText(e.Summary))
+		}
+		if e.Description != "" {
+			writeContentLine(&b, "DESCRIPTION:"+escapeText(e.Description))
+		}
+		if e.Location != "" {
+			writeContentLine(&b, "LOCATION:"+escapeText(e.Location))
+		}
+		if e.Status != "" {
+			writeContentLine(&b, "STATUS:"+e.Status)
+		}
+		if e.Organizer != nil && e.Organizer.Email != "" {
+			writeContentLine(&b, "ORGANIZER"+icalCNParam(e.Organizer.Name)+":mailto:"+e.Organizer.Email)
+		}
+		for _, a := range e.Attendees {
+			line := "ATTENDEE"
+			if a.Role != "" {
+				line += ";ROLE=" + a.Role
+			}
+			if a.Status != "" {
+				line += ";PARTSTAT=" + a.Status
+			}
+			writeContentLine(&b, line+icalCNParam(a.Name)+":mailto:"+a.Email)
+		}
+		writeContentLine(&b, "END:VEVENT")
+	}
+
+	writeContentLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// icsTimeValue formats an event time in loc, or in UTC when loc is nil
+func icsTimeValue(s string, allDay bool, loc *time.Location) string {
+	if allDay || loc == nil {
+		return icalTimeValue(s, allDay)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return icalTimeValue(s, allDay)
+	}
+	return ";TZID=" + loc.String() + ":" + t.In(loc).Format("20060102T150405")
+}
+
+// writeVTimezone describes loc by the offset changes it has in year, each
+// repeating yearly on the same weekday of the month
+func writeVTimezone(b *strings.Builder, loc *time.Location, year int) {
+	writeContentLine(b, "BEGIN:VTIMEZONE")
+	writeContentLine(b, "TZID:"+loc.String())
+
+	t := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end := time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc)
+	transitions := 0
+	for {
+		_, next := t.ZoneBounds()
+		if next.IsZero() || !next.Before(end) {
+			break
+		}
+		_, fromOffset := next.Add(-time.Second).Zone()
+		name, toOffset := next.Zone()
+		wall := next.In(time.FixedZone("", fromOffset))
+
+		nth := strconv.Itoa((wall.Day()-1)/7 + 1)
+		if wall.Day()+7 > time.Date(wall.Year(), wall.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+			nth = "-1"
+		}
+		kind := "STANDARD"
+		if next.IsDST() {
+			kind = "DAYLIGHT"
+		}
+
+		writeContentLine(b, "BEGIN:"+kind)
+		writeContentLine(b, "DTSTART:"+wall.Format("20060102T150405"))
+		writeContentLine(b, "TZOFFSETFROM:"+formatUTCOffset(fromOffset))
+		writeContentLine(b, "TZOFFSETTO:"+formatUTCOffset(toOffset))
+		writeContentLine(b, "TZNAME:"+name)
+		writeContentLine(b, fmt.Sprintf("RRULE:FREQ=YEARLY;BYMONTH=%d;BYDAY=%s%s", int(wall.Month()), nth, strings.ToUpper(wall.Weekday().String()[:2])))
+		writeContentLine(b, "END:"+kind)
+
+		transitions++
+		t = next
+	}
+
+	if transitions == 0 {
+		// No daylight saving: one fixed offset
+		name, offset := t.Zone()
+		writeContentLine(b, "BEGIN:STANDARD")
+		writeContentLine(b, "DTSTART:19700101T000000")
+		writeContentLine(b, "TZOFFSETFROM:"+formatUTCOffset(offset))
+		writeContentLine(b, "TZOFFSETTO:"+formatUTCOffset(offset))
+		writeContentLine(b, "TZNAME:"+name)
+		writeContentLine(b, "END:STANDARD")
+	}
+	writeContentLine(b, "END:VTIMEZONE")
+}
+
+// formatUTCOffset formats seconds east of UTC as +HHMM
+func formatUTCOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
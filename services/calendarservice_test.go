@@ -0,0 +1,54 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestInvitationChangesNeedTheOrganizer(t *testing.T) {
+	store, err := NewCalendarStore(filepath.Join(t.TempDir(), "calendar.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := &CalendarService{store: store}
+	hook := s.invitationHook()
+
+	organizer := &Attendee{Email: "boss@corp.example"}
+	meeting := &Invitation{Method: InviteMethodRequest, UID: "review", Summary: "Review", Start: "2030-01-08T09:00:00Z",
+		End: "2030-01-08T10:00:00Z", Organizer: organizer, Attendees: []*Attendee{}, Response: PartStatAccepted}
+	hook.OnRespond("a", &Email{Invitation: meeting})
+
+	message := func(from, verdict string, inv *Invitation) *Email {
+		return &Email{From: []Address{{Email: from}}, Authentication: &AuthVerdict{Verdict: verdict}, Invitation: inv}
+	}
+	cases := []struct {
+		name  string
+		email *Email
+	}{
+		{"someone else", message("mallory@evil.example", AuthPass, &Invitation{Method: InviteMethodCancel, UID: "review", Sequence: 1})},
+		{"forged From", message("boss@corp.example", AuthFail, &Invitation{Method: InviteMethodCancel, UID: "review", Sequence: 1})},
+		{"unverified From", message("boss@corp.example", AuthNone, &Invitation{Method: InviteMethodRequest, UID: "review", Sequence: 1,
+			Summary: "Moved", Start: "2030-01-09T09:00:00Z", End: "2030-01-09T10:00:00Z", Organizer: organizer, Attendees: []*Attendee{}})},
+	}
+	for _, tc := range cases {
+		hook.OnView("a", tc.email)
+		if !tc.email.Invitation.Unverified {
+			t.Errorf("%s: not flagged as unverified", tc.name)
+		}
+		if e, err := store.Find("review", ""); err != nil || e.Start != meeting.Start {
+			t.Fatalf("%s: event changed: %+v, %v", tc.name, e, err)
+		}
+	}
+
+	update := message("Boss@corp.example", AuthPass, &Invitation{Method: InviteMethodRequest, UID: "review", Sequence: 1,
+		Summary: "Moved", Start: "2030-01-09T09:00:00Z", End: "2030-01-09T10:00:00Z", Organizer: organizer, Attendees: []*Attendee{}})
+	hook.OnView("a", update)
+	if e, err := store.Find("review", ""); err != nil || e.Start != "2030-01-09T09:00:00Z" || e.Response != PartStatAccepted {
+		t.Fatalf("organizer's update not applied: %+v, %v", e, err)
+	}
+	hook.OnView("a", message("boss@corp.example", AuthPass, &Invitation{Method: InviteMethodCancel, UID: "review", Sequence: 1}))
+	if _, err := store.Find("review", ""); err == nil {
+		t.Error("organizer's cancellation not applied")
+	}
+}
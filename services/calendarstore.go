@@ -0,0 +1,400 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CalendarEvent is an event the user accepted, stored from its invitation
+type CalendarEvent struct {
+	ID           string      `json:"id"`
+	UID          string      `json:"uid"`
+	RecurrenceID string      `json:"recurrenceId,omitempty"` // set on overrides of one occurrence
+	AccountID    string      `json:"accountId"`
+	Sequence     int         `json:"sequence"`
+	Summary      string      `json:"summary"`
+	Description  string      `json:"description,omitempty"`
+	Location     string      `json:"location,omitempty"`
+	Start        string      `json:"start"` // RFC3339 in UTC, or YYYY-MM-DD for all-day events
+	End          string      `json:"end"`
+	AllDay       bool        `json:"allDay"`
+	TimeZone     string      `json:"timeZone,omitempty"`
+	Recurrence   string      `json:"recurrence,omitempty"`
+	ExDates      []string    `json:"exDates,omitempty"`
+	Organizer    *Attendee   `json:"organizer,omitempty"`
+	Attendees    []*Attendee `json:"attendees"`
+	Status       string      `json:"status,omitempty"`
+	Response     string      `json:"response"` // ACCEPTED or TENTATIVE
+	CreatedAt    string      `json:"createdAt"`
+	UpdatedAt    string      `json:"updatedAt"`
+}
+
+// calendarMigrations must be sorted by version and never edited once released
+var calendarMigrations = []schemaMigration{
+	{
+		version:     1,
+		description: "events",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS events (
+					id TEXT PRIMARY KEY,
+					uid TEXT NOT NULL,
+					recurrence_id TEXT NOT NULL DEFAULT '',
+					account_id TEXT NOT NULL DEFAULT '',
+					sequence INTEGER NOT NULL DEFAULT 0,
+					summary TEXT NOT NULL DEFAULT '',
+					description TEXT NOT NULL DEFAULT '',
+					location TEXT NOT NULL DEFAULT '',
+					start_time INTEGER NOT NULL,
+					end_time INTEGER NOT NULL,
+					all_day INTEGER NOT NULL DEFAULT 0,
+					time_zone TEXT NOT NULL DEFAULT '',
+					rrule TEXT NOT NULL DEFAULT '',
+					exdates TEXT NOT NULL DEFAULT '[]',
+					organizer TEXT NOT NULL DEFAULT '',
+					attendees TEXT NOT NULL DEFAULT '[]',
+					status TEXT NOT NULL DEFAULT '',
+					response TEXT NOT NULL DEFAULT '',
+					created_at TEXT NOT NULL,
+					updated_at TEXT NOT NULL
+				);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_events_uid ON events(uid, recurrence_id);
+				CREATE INDEX IF NOT EXISTS idx_events_time ON events(start_time, end_time);
+				CREATE INDEX IF NOT EXISTS idx_events_account ON events(account_id);
+			`)
+			return err
+		},
+	},
+}
+
+// CalendarStore keeps accepted events in their own SQLite database next to
+// the email cache
+type CalendarStore struct {
+	db   *sql.DB
+	lock sync.RWMutex
+}
+
+// NewCalendarStore opens or creates the calendar database
+func NewCalendarStore(dbPath string) (*CalendarStore, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
+	}
+
+	if err := migrateSchema(db, "CalendarStore", calendarMigrations); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return &CalendarStore{db: db}, nil
+}
+
+const eventColumns = `id, uid, recurrence_id, account_id, sequence, summary, description, location,
+	start_time, end_time, all_day, time_zone, rrule, exdates, organizer, attendees, status, response,
+	created_at, updated_at`
+
+func scanEvent(row interface{ Scan(...any) error }) (*CalendarEvent, error) {
+	var e CalendarEvent
+	var start, end int64
+	var exdates, organizer, attendees string
+	err := row.Scan(&e.ID, &e.UID, &e.RecurrenceID, &e.AccountID, &e.Sequence, &e.Summary, &e.Description, &e.Location,
+		&start, &end, &e.AllDay, &e.TimeZone, &e.Recurrence, &exdates, &organizer, &attendees, &e.Status, &e.Response,
+		&e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Start = formatEventTime(start, e.AllDay)
+	e.End = formatEventTime(end, e.AllDay)
+	json.Unmarshal([]byte(exdates), &e.ExDates)
+	if organizer != "" {
+		json.Unmarshal([]byte(organizer), &e.Organizer)
+	}
+	json.Unmarshal([]byte(attendees), &e.Attendees)
+	if e.Attendees == nil {
+		e.Attendees = []*Attendee{}
+	}
+	return &e, nil
+}
+
+func (s *CalendarStore) query(where string, args ...any) ([]*CalendarEvent, error) {
+	rows, err := s.db.Query(`SELECT `+eventColumns+` FROM events `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*CalendarEvent{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// List returns every event, earliest first
+func (s *CalendarStore) List() ([]*CalendarEvent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(`ORDER BY start_time, id`)
+}
+
+// ListAccount returns the events accepted through one account
+func (s *CalendarStore) ListAccount(accountID string) ([]*CalendarEvent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(`WHERE account_id = ? ORDER BY start_time, id`, accountID)
+}
+
+// Get returns one event
+func (s *CalendarStore) Get(id string) (*CalendarEvent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.getOne(`WHERE id = ?`, id)
+}
+
+// Find returns the event, or the override of one occurrence, with a UID
+func (s *CalendarStore) Find(uid, recurrenceID string) (*CalendarEvent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.getOne(`WHERE uid = ? AND recurrence_id = ?`, uid, recurrenceID)
+}
+
+func (s *CalendarStore) getOne(where string, args ...any) (*CalendarEvent, error) {
+	events, err := s.query(where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("event not found")
+	}
+	return events[0], nil
+}
+
+// Candidates returns the events that may have occurrences in [from, to):
+// single events and overrides overlapping it, plus every recurring event
+// starting before its end together with its overrides
+func (s *CalendarStore) Candidates(from, to time.Time) ([]*CalendarEvent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(`WHERE (rrule = '' AND start_time < ? AND end_time > ?)
+		OR uid IN (SELECT uid FROM events WHERE rrule != '' AND start_time < ?)
+		ORDER BY start_time, id`,
+		to.Unix(), from.Unix(), to.Unix())
+}
+
+// Save inserts or replaces an event, matched by UID and recurrence ID
+func (s *CalendarStore) Save(e *CalendarEvent) error {
+	start, err := parseEventTime(e.Start, e.AllDay)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+	end := start
+	if e.End != "" {
+		if end, err = parseEventTime(e.End, e.AllDay); err != nil {
+			return fmt.Errorf("invalid end: %w", err)
+		}
+	}
+	if end < start {
+		end = start
+	}
+	if e.AllDay && end == start {
+		// DTEND is exclusive, so a one-day event without one ends the next day
+		end = start + 24*60*60
+	}
+	if e.ExDates == nil {
+		e.ExDates = []string{}
+	}
+	exdates, _ := json.Marshal(e.ExDates)
+	attendees, _ := json.Marshal(e.Attendees)
+	organizer := ""
+	if e.Organizer != nil {
+		data, _ := json.Marshal(e.Organizer)
+		organizer = string(data)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := getCurrentTime()
+	e.UpdatedAt = now
+	var existing string
+	err = s.db.QueryRow(`SELECT id, created_at FROM events WHERE uid = ? AND recurrence_id = ?`, e.UID, e.RecurrenceID).Scan(&existing, &e.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		e.ID = generateUUID()
+		e.CreatedAt = now
+	case err != nil:
+		return fmt.Errorf("failed to look up event: %w", err)
+	default:
+		e.ID = existing
+	}
+
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO events (`+eventColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.UID, e.RecurrenceID, e.AccountID, e.Sequence, e.Summary, e.Description, e.Location,
+		start, end, e.AllDay, e.TimeZone, e.Recurrence, string(exdates), organizer, string(attendees), e.Status, e.Response,
+		e.CreatedAt, e.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save event: %w", err)
+	}
+	return nil
+}
+
+// AddExDate cancels one occurrence of a recurring event
+func (s *CalendarStore) AddExDate(uid, recurrenceID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var id, raw string
+	err := s.db.QueryRow(`SELECT id, exdates FROM events WHERE uid = ? AND recurrence_id = ''`, uid).Scan(&id, &raw)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up event: %w", err)
+	}
+
+	var exdates []string
+	json.Unmarshal([]byte(raw), &exdates)
+	for _, d := range exdates {
+		if d == recurrenceID {
+			return nil
+		}
+	}
+	data, _ := json.Marshal(append(exdates, recurrenceID))
+	if _, err := s.db.Exec(`UPDATE events SET exdates = ?, updated_at = ? WHERE id = ?`, string(data), getCurrentTime(), id); err != nil {
+		return fmt.Errorf("failed to update event: %w", err)
+	}
+	return nil
+}
+
+// Delete removes one event
+func (s *CalendarStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.db.Exec(`DELETE FROM events WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+	return nil
+}
+
+// DeleteUID removes an event with its overrides, or only the override of
+// one occurrence when recurrenceID is set
+func (s *CalendarStore) DeleteUID(uid, recurrenceID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err error
+	if recurrenceID == "" {
+		_, err = s.db.Exec(`DELETE FROM events WHERE uid = ?`, uid)
+	} else {
+		_, err = s.db.Exec(`DELETE FROM events WHERE uid = ? AND recurrence_id = ?`, uid, recurrenceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+	return nil
+}
+
+// DeleteAccount removes the events accepted through an account
+func (s *CalendarStore) DeleteAccount(accountID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.db.Exec(`DELETE FROM events WHERE account_id = ?`, accountID); err != nil {
+		return fmt.Errorf("failed to delete events: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *CalendarStore) Close() error {
+	return s.db.Close()
+}
+
+// parseEventTime converts an Invitation time to unix seconds. All-day
+// dates are stored as midnight UTC.
+func parseEventTime(s string, allDay bool) (int64, error) {
+	if allDay || len(s) == len("2006-01-02") {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return 0, err
+		}
+		return t.Unix(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+func formatEventTime(unix int64, allDay bool) string {
+	t := time.Unix(unix, 0).UTC()
+	if allDay {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339)
+}
+
+// eventLocation is the zone recurrences of an event are expanded in
+func eventLocation(e *CalendarEvent) *time.Location {
+	if e.AllDay {
+		return time.UTC
+	}
+	if e.TimeZone == "" {
+		// Floating times follow the user
+		return time.Local
+	}
+	tzid := strings.TrimPrefix(e.TimeZone, "/")
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// windowsZones maps the Windows zone names Outlook puts in TZID to IANA names
+var windowsZones = map[string]string{
+	"UTC":                            "UTC",
+	"GMT Standard Time":              "Europe/London",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Central European Standard Time": "Europe/Warsaw",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"FLE Standard Time":              "Europe/Kiev",
+	"GTB Standard Time":              "Europe/Bucharest",
+	"Russian Standard Time":          "Europe/Moscow",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"US Mountain Standard Time":      "America/Phoenix",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"Alaskan Standard Time":          "America/Anchorage",
+	"Hawaiian Standard Time":         "Pacific/Honolulu",
+	"Atlantic Standard Time":         "America/Halifax",
+	"E. South America Standard Time": "America/Sao_Paulo",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"Korea Standard Time":            "Asia/Seoul",
+	"Singapore Standard Time":        "Asia/Singapore",
+	"Arabian Standard Time":          "Asia/Dubai",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+	"New Zealand Standard Time":      "Pacific/Auckland",
+}
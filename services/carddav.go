@@ -0,0 +1,531 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	davNamespace     = "DAV:"
+	carddavNamespace = "urn:ietf:params:xml:ns:carddav"
+	// multigetBatchSize is how many cards one addressbook-multiget asks for
+	multigetBatchSize = 50
+	// maxDAVResponse bounds how much of a response body is read
+	maxDAVResponse = 32 << 20
+)
+
+var (
+	// errInvalidSyncToken means the server forgot our sync token and a full sync is needed
+	errInvalidSyncToken = errors.New("sync token is no longer valid")
+	// errSyncUnsupported means the collection does not support sync-collection
+	errSyncUnsupported = errors.New("sync-collection is not supported")
+	// errPreconditionFailed means the card changed on the server since we fetched it
+	errPreconditionFailed = errors.New("card was changed on the server")
+)
+
+// CardDAVAddressBook is an address book collection on a CardDAV server
+type CardDAVAddressBook struct {
+	Href        string `json:"href"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// remoteCard is one vCard resource of an address book
+type remoteCard struct {
+	Href string
+	ETag string
+	Data string // empty when only the ETag was requested
+}
+
+// cardDAVChanges is what changed in an address book since a sync token
+type cardDAVChanges struct {
+	Changed   []*remoteCard // added or modified, without data
+	Deleted   []string      // hrefs
+	SyncToken string
+}
+
+// CardDAVClient speaks CardDAV (RFC 6352) and the WebDAV sync extension (RFC 6578)
+type CardDAVClient struct {
+	HTTPClient HTTPClient
+	// Authorize adds credentials to every request; nil sends none
+	Authorize func(req *http.Request) error
+}
+
+// davMultistatus mirrors a 207 Multi-Status body
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"DAV: response"`
+	SyncToken string        `xml:"DAV: sync-token"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Status    string        `xml:"DAV: status"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		AddressBook *struct{} `xml:"urn:ietf:params:xml:ns:carddav addressbook"`
+	} `xml:"DAV: resourcetype"`
+	DisplayName          string      `xml:"DAV: displayname"`
+	ETag                 string      `xml:"DAV: getetag"`
+	CurrentUserPrincipal davHrefProp `xml:"DAV: current-user-principal"`
+	AddressBookHomeSet   davHrefProp `xml:"urn:ietf:params:xml:ns:carddav addressbook-home-set"`
+	Description          string      `xml:"urn:ietf:params:xml:ns:carddav addressbook-description"`
+	AddressData          string      `xml:"urn:ietf:params:xml:ns:carddav address-data"`
+}
+
+type davHrefProp struct {
+	Href string `xml:"DAV: href"`
+}
+
+// okProp returns the properties the server found for a response
+func (r *davResponse) okProp() (*davProp, bool) {
+	for i := range r.Propstats {
+		if davStatusCode(r.Propstats[i].Status) == http.StatusOK {
+			return &r.Propstats[i].Prop, true
+		}
+	}
+	return nil, false
+}
+
+// davStatusCode parses a status line such as "HTTP/1.1 404 Not Found"
+func davStatusCode(status string) int {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
+// Discover finds the address books reachable from a server or account URL.
+// It follows RFC 6764: the well-known URL, then the current user principal
+// and its address book home set. A URL pointing straight at a home set or
+// an address book also works.
+func (c *CardDAVClient) Discover(ctx context.Context, serverURL string) ([]*CardDAVAddressBook, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid CardDAV URL: %s", serverURL)
+	}
+	if base.Scheme == "" {
+		base.Scheme = "https"
+	}
+
+	start := base
+	if base.Path == "" || base.Path == "/" {
+		start = base.ResolveReference(&url.URL{Path: "/.well-known/carddav"})
+	}
+
+	home := start
+	if principal, err := c.findHref(ctx, start, "current-user-principal", davNamespace); err == nil {
+		home = principal
+		if set, err := c.findHref(ctx, principal, "addressbook-home-set", carddavNamespace); err == nil {
+			home = set
+		}
+	} else if start != base {
+		// No well-known redirect; try the root itself
+		if principal, err := c.findHref(ctx, base, "current-user-principal", davNamespace); err == nil {
+			home = principal
+			if set, err := c.findHref(ctx, principal, "addressbook-home-set", carddavNamespace); err == nil {
+				home = set
+			}
+		}
+	}
+
+	return c.ListAddressBooks(ctx, home.String())
+}
+
+// findHref reads a property holding an href, resolved against the request URL
+func (c *CardDAVClient) findHref(ctx context.Context, u *url.URL, name, namespace string) (*url.URL, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:x="` + namespace + `"><d:prop><x:` + name + `/></d:prop></d:propfind>`
+
+	ms, final, err := c.multistatus(ctx, "PROPFIND", u, "0", body)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ms.Responses {
+		prop, ok := ms.Responses[i].okProp()
+		if !ok {
+			continue
+		}
+		href := prop.CurrentUserPrincipal.Href
+		if name == "addressbook-home-set" {
+			href = prop.AddressBookHomeSet.Href
+		}
+		if href = strings.TrimSpace(href); href != "" {
+			return final.Parse(href)
+		}
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
+
+// ListAddressBooks returns the address books in a home set, or the
+// collection itself if it is an address book
+func (c *CardDAVClient) ListAddressBooks(ctx context.Context, homeURL string) ([]*CardDAVAddressBook, error) {
+	u, err := url.Parse(homeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:prop><d:resourcetype/><d:displayname/><card:addressbook-description/></d:prop>
+</d:propfind>`
+
+	ms, final, err := c.multistatus(ctx, "PROPFIND", u, "1", body)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []*CardDAVAddressBook{}
+	for i := range ms.Responses {
+		prop, ok := ms.Responses[i].okProp()
+		if !ok || prop.ResourceType.AddressBook == nil {
+			continue
+		}
+		href, err := final.Parse(strings.TrimSpace(ms.Responses[i].Href))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(prop.DisplayName)
+		if name == "" {
+			name = lastPathSegment(href.Path)
+		}
+		books = append(books, &CardDAVAddressBook{
+			Href:        href.String(),
+			Name:        name,
+			Description: strings.TrimSpace(prop.Description),
+		})
+	}
+	if len(books) == 0 {
+		return nil, fmt.Errorf("no address books found at %s", homeURL)
+	}
+	return books, nil
+}
+
+// SyncCollection asks what changed since syncToken; an empty token lists
+// every card. It returns errInvalidSyncToken when the server no longer
+// knows the token and errSyncUnsupported when it lacks the report.
+func (c *CardDAVClient) SyncCollection(ctx context.Context, bookURL, syncToken string) (*cardDAVChanges, error) {
+	u, err := url.Parse(bookURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var token bytes.Buffer
+	xml.EscapeText(&token, []byte(syncToken))
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:">
+  <d:sync-token>` + token.String() + `</d:sync-token>
+  <d:sync-level>1</d:sync-level>
+  <d:prop><d:getetag/></d:prop>
+</d:sync-collection>`
+
+	ms, final, err := c.multistatus(ctx, "REPORT", u, "", body)
+	if err != nil {
+		var statusErr *davStatusError
+		if errors.As(err, &statusErr) {
+			switch {
+			case strings.Contains(statusErr.body, "valid-sync-token"):
+				return nil, errInvalidSyncToken
+			case statusErr.code == http.StatusForbidden, statusErr.code == http.StatusBadRequest,
+				statusErr.code == http.StatusNotImplemented, statusErr.code == http.StatusMethodNotAllowed:
+				return nil, errSyncUnsupported
+			}
+		}
+		return nil, err
+	}
+
+	changes := &cardDAVChanges{SyncToken: strings.TrimSpace(ms.SyncToken)}
+	for i := range ms.Responses {
+		resp := &ms.Responses[i]
+		href, ok := cardHref(final, resp.Href, u)
+		if !ok {
+			continue
+		}
+		if davStatusCode(resp.Status) == http.StatusNotFound {
+			changes.Deleted = append(changes.Deleted, href)
+			continue
+		}
+		if prop, ok := resp.okProp(); ok {
+			changes.Changed = append(changes.Changed, &remoteCard{Href: href, ETag: prop.ETag})
+		}
+	}
+	return changes, nil
+}
+
+// ListCards returns the href and ETag of every card, for servers without sync-collection
+func (c *CardDAVClient) ListCards(ctx context.Context, bookURL string) ([]*remoteCard, error) {
+	u, err := url.Parse(bookURL)
+	if err != nil {
+		return nil, err
+	}
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getetag/></d:prop></d:propfind>`
+
+	ms, final, err := c.multistatus(ctx, "PROPFIND", u, "1", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []*remoteCard
+	for i := range ms.Responses {
+		href, ok := cardHref(final, ms.Responses[i].Href, u)
+		if !ok {
+			continue
+		}
+		if prop, ok := ms.Responses[i].okProp(); ok {
+			cards = append(cards, &remoteCard{Href: href, ETag: prop.ETag})
+		}
+	}
+	return cards, nil
+}
+
+// MultiGet downloads cards by href with addressbook-multiget
+func (c *CardDAVClient) MultiGet(ctx context.Context, bookURL string, hrefs []string) ([]*remoteCard, error) {
+	u, err := url.Parse(bookURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []*remoteCard
+	for start := 0; start < len(hrefs); start += multigetBatchSize {
+		end := start + multigetBatchSize
+		if end > len(hrefs) {
+			end = len(hrefs)
+		}
+
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<card:addressbook-multiget xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:prop><d:getetag/><card:address-data/></d:prop>`)
+		for _, href := range hrefs[start:end] {
+			// Servers expect the path form they sent, not an absolute URL
+			if parsed, err := url.Parse(href); err == nil {
+				href = parsed.EscapedPath()
+			}
+			b.WriteString("\n  <d:href>")
+			xml.EscapeText(&b, []byte(href))
+			b.WriteString("</d:href>")
+		}
+		b.WriteString("\n</card:addressbook-multiget>")
+
+		ms, final, err := c.multistatus(ctx, "REPORT", u, "1", b.String())
+		if err != nil {
+			return nil, err
+		}
+		for i := range ms.Responses {
+			href, ok := cardHref(final, ms.Responses[i].Href, u)
+			if !ok {
+				continue
+			}
+			if prop, ok := ms.Responses[i].okProp(); ok && prop.AddressData != "" {
+				cards = append(cards, &remoteCard{Href: href, ETag: prop.ETag, Data: prop.AddressData})
+			}
+		}
+	}
+	return cards, nil
+}
+
+// GetCard downloads one card
+func (c *CardDAVClient) GetCard(ctx context.Context, href string) (*remoteCard, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDAVResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &davStatusError{code: resp.StatusCode, body: string(data)}
+	}
+	return &remoteCard{Href: u.String(), ETag: resp.Header.Get("ETag"), Data: string(data)}, nil
+}
+
+// PutCard uploads a card. With an ETag the upload only succeeds if the card
+// is unchanged on the server; without one it only succeeds if the card is
+// new. It returns the new ETag, which servers may omit.
+func (c *CardDAVClient) PutCard(ctx context.Context, href, data, etag string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+
+	headers := map[string]string{"Content-Type": "text/vcard; charset=utf-8"}
+	if etag != "" {
+		headers["If-Match"] = etag
+	} else {
+		headers["If-None-Match"] = "*"
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, u, []byte(data), headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDAVResponse))
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return resp.Header.Get("ETag"), nil
+	case http.StatusPreconditionFailed:
+		return "", errPreconditionFailed
+	default:
+		return "", &davStatusError{code: resp.StatusCode}
+	}
+}
+
+// DeleteCard removes a card if it is unchanged on the server
+func (c *CardDAVClient) DeleteCard(ctx context.Context, href, etag string) error {
+	u, err := url.Parse(href)
+	if err != nil {
+		return err
+	}
+
+	var headers map[string]string
+	if etag != "" {
+		headers = map[string]string{"If-Match": etag}
+	}
+
+	resp, err := c.do(ctx, http.MethodDelete, u, nil, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDAVResponse))
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	case http.StatusPreconditionFailed:
+		return errPreconditionFailed
+	default:
+		return &davStatusError{code: resp.StatusCode}
+	}
+}
+
+// davStatusError is an unexpected HTTP status from the server
+type davStatusError struct {
+	code int
+	body string
+}
+
+func (e *davStatusError) Error() string {
+	return fmt.Sprintf("server returned status %d", e.code)
+}
+
+// multistatus sends a WebDAV request expecting 207 Multi-Status. It also
+// returns the URL that answered, after redirects, to resolve hrefs against.
+func (c *CardDAVClient) multistatus(ctx context.Context, method string, u *url.URL, depth, body string) (*davMultistatus, *url.URL, error) {
+	headers := map[string]string{"Content-Type": "application/xml; charset=utf-8"}
+	if depth != "" {
+		headers["Depth"] = depth
+	}
+
+	resp, err := c.do(ctx, method, u, []byte(body), headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDAVResponse))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, nil, &davStatusError{code: resp.StatusCode, body: string(data)}
+	}
+
+	var ms davMultistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, nil, fmt.Errorf("invalid multistatus response: %w", err)
+	}
+
+	final := u
+	if resp.Request != nil && resp.Request.URL != nil {
+		final = resp.Request.URL
+	}
+	return &ms, final, nil
+}
+
+func (c *CardDAVClient) do(ctx context.Context, method string, u *url.URL, body []byte, headers map[string]string) (*http.Response, error) {
+	resp, err := c.send(ctx, method, u, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	// http.Client follows 301 and 302 redirects with GET, which turns the
+	// well-known PROPFIND into a useless GET; repeat the request where it landed
+	if resp.Request != nil && resp.Request.Method != method {
+		resp.Body.Close()
+		if resp, err = c.send(ctx, method, resp.Request.URL, body, headers); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("authentication failed")
+	}
+	return resp, nil
+}
+
+func (c *CardDAVClient) send(ctx context.Context, method string, u *url.URL, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if c.Authorize != nil {
+		if err := c.Authorize(req); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.HTTPClient.Do(req)
+}
+
+// cardHref resolves an href from a response, skipping the collection itself
+func cardHref(base *url.URL, href string, collection *url.URL) (string, bool) {
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	if strings.TrimSuffix(u.Path, "/") == strings.TrimSuffix(collection.Path, "/") {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// lastPathSegment returns the last non-empty element of a URL path
+func lastPathSegment(p string) string {
+	p = strings.TrimSuffix(p, "/")
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[i+1:]
+	}
+	return p
+}
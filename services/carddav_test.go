@@ -0,0 +1,341 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	bookPath   = "/dav/books/me/contacts/"
+	multistart = `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">`
+)
+
+var (
+	syncTokenPattern = regexp.MustCompile(`<d:sync-token>([^<]*)</d:sync-token>`)
+	hrefPattern      = regexp.MustCompile(`<d:href>([^<]*)</d:href>`)
+)
+
+type fakeCard struct {
+	etag    string
+	data    string
+	changed int // version of the last change
+}
+
+// fakeCardDAV is an address book server behind a well-known redirect, with
+// sync tokens of the form "v<version>"
+type fakeCardDAV struct {
+	mu       sync.Mutex
+	version  int
+	cards    map[string]*fakeCard
+	deleted  map[string]int
+	oldest   int // sync tokens before this version are invalid
+	tokens   []string
+	ifMatch  []string
+	conflict bool // the next PUT or DELETE loses a race with another client
+}
+
+func newFakeCardDAV() *fakeCardDAV {
+	f := &fakeCardDAV{cards: make(map[string]*fakeCard), deleted: make(map[string]int)}
+	f.put("alice", "Alice")
+	f.put("bob", "Bob")
+	return f
+}
+
+func vcardFor(uid, name string) string {
+	return "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:" + uid + "\r\nFN:" + name + "\r\nEMAIL:" + uid + "@example.com\r\nEND:VCARD\r\n"
+}
+
+func (f *fakeCardDAV) put(uid, name string) {
+	f.store(bookPath+uid+".vcf", vcardFor(uid, name))
+}
+
+func (f *fakeCardDAV) store(path, data string) {
+	f.version++
+	f.cards[path] = &fakeCard{etag: `"` + strconv.Itoa(f.version) + `"`, data: data, changed: f.version}
+	delete(f.deleted, path)
+}
+
+func (f *fakeCardDAV) remove(path string) {
+	f.version++
+	delete(f.cards, path)
+	f.deleted[path] = f.version
+}
+
+func (f *fakeCardDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, password, ok := r.BasicAuth(); !ok || user != "me" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/.well-known/carddav" {
+		http.Redirect(w, r, "/dav/", http.StatusMovedPermanently)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	multistatus := func(responses string) {
+		w.WriteHeader(http.StatusMultiStatus)
+		io.WriteString(w, multistart+responses+"</d:multistatus>")
+	}
+	propstat := func(href, props string) string {
+		return "<d:response><d:href>" + href + "</d:href><d:propstat><d:prop>" + props +
+			"</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>"
+	}
+
+	switch {
+	case r.Method == "PROPFIND" && r.URL.Path == "/dav/":
+		multistatus(propstat("/dav/", "<d:current-user-principal><d:href>/dav/principals/me/</d:href></d:current-user-principal>"))
+	case r.Method == "PROPFIND" && r.URL.Path == "/dav/principals/me/":
+		multistatus(propstat(r.URL.Path, "<card:addressbook-home-set><d:href>/dav/books/me/</d:href></card:addressbook-home-set>"))
+	case r.Method == "PROPFIND" && r.URL.Path == "/dav/books/me/":
+		multistatus(propstat(r.URL.Path, "<d:resourcetype><d:collection/></d:resourcetype>") +
+			propstat(bookPath, "<d:resourcetype><d:collection/><card:addressbook/></d:resourcetype><d:displayname>Contacts</d:displayname>"))
+	case r.Method == "REPORT" && strings.Contains(string(body), "sync-collection"):
+		token := syncTokenPattern.FindStringSubmatch(string(body))[1]
+		f.tokens = append(f.tokens, token)
+		since := 0
+		if token != "" {
+			since, _ = strconv.Atoi(strings.TrimPrefix(token, "v"))
+			if !strings.HasPrefix(token, "v") || since < f.oldest {
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, `<d:error xmlns:d="DAV:"><d:valid-sync-token/></d:error>`)
+				return
+			}
+		}
+		var responses strings.Builder
+		for path, card := range f.cards {
+			if card.changed > since {
+				responses.WriteString(propstat(path, "<d:getetag>"+card.etag+"</d:getetag>"))
+			}
+		}
+		for path, version := range f.deleted {
+			if since > 0 && version > since {
+				responses.WriteString("<d:response><d:href>" + path + "</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>")
+			}
+		}
+		responses.WriteString("<d:sync-token>v" + strconv.Itoa(f.version) + "</d:sync-token>")
+		multistatus(responses.String())
+	case r.Method == "REPORT":
+		var responses strings.Builder
+		for _, match := range hrefPattern.FindAllStringSubmatch(string(body), -1) {
+			if card, ok := f.cards[match[1]]; ok {
+				responses.WriteString(propstat(match[1], "<d:getetag>"+card.etag+"</d:getetag><card:address-data>"+card.data+"</card:address-data>"))
+			}
+		}
+		multistatus(responses.String())
+	case r.Method == http.MethodGet:
+		card, ok := f.cards[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", card.etag)
+		io.WriteString(w, card.data)
+	case r.Method == http.MethodPut || r.Method == http.MethodDelete:
+		f.ifMatch = append(f.ifMatch, r.Header.Get("If-Match"))
+		card, exists := f.cards[r.URL.Path]
+		if f.conflict && exists {
+			f.conflict = false
+			f.store(r.URL.Path, strings.Replace(card.data, "FN:", "FN:Server ", 1))
+			card = f.cards[r.URL.Path]
+		}
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != card.etag) ||
+			r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			f.remove(r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f.store(r.URL.Path, string(body))
+		w.Header().Set("ETag", f.cards[r.URL.Path].etag)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func contactByUID(t *testing.T, s *ContactService, uid string) *Contact {
+	t.Helper()
+	contacts, err := s.GetContacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, contact := range contacts {
+		if contact.UID == uid {
+			return contact
+		}
+	}
+	return nil
+}
+
+func TestCardDAVSync(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("HOME", configDir)
+
+	server := newFakeCardDAV()
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	store, err := NewContactStore(filepath.Join(t.TempDir(), "contacts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	secrets := newFileTokenStore(t.TempDir())
+	s := &ContactService{store: store, profiles: newCardDAVProfileStore(secrets), httpClient: srv.Client()}
+
+	// Discovery goes through the well-known URL, the principal and its home set
+	profile, err := s.SaveCardDAVProfile(&CardDAVProfile{URL: srv.URL, Username: "me", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.AddressBook != srv.URL+bookPath || profile.Name != "Contacts" {
+		t.Fatalf("discovered %s (%s)", profile.AddressBook, profile.Name)
+	}
+	if profile.Password != "" {
+		t.Error("password returned with the saved profile")
+	}
+	configFile, err := os.ReadFile(filepath.Join(configDir, "wmail", "carddav.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(configFile), "secret") {
+		t.Errorf("password stored in plaintext: %s", configFile)
+	}
+	if password, err := secrets.LoadSecret(cardDAVSecretName(profile.ID)); err != nil || password != "secret" {
+		t.Errorf("stored password %q, %v", password, err)
+	}
+
+	syncNow := func(want CardDAVSyncResult) {
+		t.Helper()
+		result, err := s.SyncCardDAV(profile.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *result != want {
+			t.Fatalf("sync result %+v, want %+v", *result, want)
+		}
+	}
+
+	syncNow(CardDAVSyncResult{Added: 2, FullSync: true})
+
+	// Changes since the token
+	server.mu.Lock()
+	server.put("alice", "Alice Liddell")
+	server.remove(bookPath + "bob.vcf")
+	server.mu.Unlock()
+	syncNow(CardDAVSyncResult{Updated: 1, Deleted: 1})
+	if got := server.tokens[len(server.tokens)-1]; got != "v2" {
+		t.Errorf("sync token sent %q, want v2", got)
+	}
+	if contactByUID(t, s, "bob") != nil || contactByUID(t, s, "alice").Name != "Alice Liddell" {
+		t.Error("incremental sync not applied")
+	}
+
+	// The server forgets the token: a full sync follows
+	server.mu.Lock()
+	server.put("carol", "Carol")
+	server.oldest = server.version
+	server.mu.Unlock()
+	syncNow(CardDAVSyncResult{Added: 1, FullSync: true})
+	if tokens := server.tokens[len(server.tokens)-2:]; tokens[0] == "" || tokens[1] != "" {
+		t.Errorf("tokens %q, want the stale one then none", tokens)
+	}
+
+	// A local edit is uploaded with If-Match
+	alice := contactByUID(t, s, "alice")
+	alice.Name = "Alice Pleasance Liddell"
+	if _, err := s.SaveContact(alice); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	etag := server.cards[bookPath+"alice.vcf"].etag
+	server.mu.Unlock()
+	syncNow(CardDAVSyncResult{Uploaded: 1})
+	if got := server.ifMatch[len(server.ifMatch)-1]; got != etag {
+		t.Errorf("If-Match %s, want %s", got, etag)
+	}
+	if !strings.Contains(server.cards[bookPath+"alice.vcf"].data, "FN:Alice Pleasance Liddell") {
+		t.Errorf("uploaded card %q", server.cards[bookPath+"alice.vcf"].data)
+	}
+
+	// Edited on the server meanwhile: 412, and the server's version wins
+	alice = contactByUID(t, s, "alice")
+	alice.Name = "Local edit"
+	if _, err := s.SaveContact(alice); err != nil {
+		t.Fatal(err)
+	}
+	server.conflict = true
+	syncNow(CardDAVSyncResult{})
+	if name := contactByUID(t, s, "alice").Name; name != "Server Alice Pleasance Liddell" {
+		t.Errorf("after a PUT conflict the contact is %q", name)
+	}
+
+	// A local deletion losing the same race is restored by the next pull
+	if err := s.DeleteContact(contactByUID(t, s, "carol").ID); err != nil {
+		t.Fatal(err)
+	}
+	server.conflict = true
+	syncNow(CardDAVSyncResult{})
+	if _, ok := server.cards[bookPath+"carol.vcf"]; !ok {
+		t.Fatal("card deleted despite the conflict")
+	}
+	syncNow(CardDAVSyncResult{Added: 1})
+	if carol := contactByUID(t, s, "carol"); carol == nil || carol.Name != "Server Carol" {
+		t.Errorf("carol = %+v", carol)
+	}
+
+	// Deleting the profile drops its password
+	if err := s.DeleteCardDAVProfile(profile.ID); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := secrets.LoadSecret(cardDAVSecretName(profile.ID)); err != nil || password != "" {
+		t.Errorf("password left behind: %q, %v", password, err)
+	}
+}
+
+func TestCardDAVPlaintextPasswordsMoved(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("HOME", configDir)
+
+	path := filepath.Join(configDir, "wmail", "carddav.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := `[{"id":"p1","name":"Work","url":"https://dav.example","username":"me","password":"hunter2","addressBook":"https://dav.example/book/"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := newFileTokenStore(t.TempDir())
+	profiles := newCardDAVProfileStore(secrets)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("password still in carddav.json: %s", data)
+	}
+	profile, err := profiles.get("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, err := profiles.password(profile); err != nil || password != "hunter2" {
+		t.Errorf("password %q, %v", password, err)
+	}
+}
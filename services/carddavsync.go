@@ -0,0 +1,814 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cardDAVSyncInterval is how often address books are synced in the background
+	cardDAVSyncInterval = 15 * time.Minute
+	// cardDAVTimeout bounds one sync
+	cardDAVTimeout = 2 * time.Minute
+)
+
+// CardDAVProfile configures syncing one CardDAV address book into the contacts.
+// With an AccountID and no Username the account's credentials are used,
+// including OAuth for providers such as Google.
+type CardDAVProfile struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	URL         string `json:"url"` // server, principal or address book URL
+	AccountID   string `json:"accountId,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"` // only set when saving; kept in the secret store
+	AddressBook string `json:"addressBook"`        // URL of the synced address book
+	LastSync    string `json:"lastSync,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+}
+
+// CardDAVSyncResult summarizes one sync
+type CardDAVSyncResult struct {
+	Added    int  `json:"added"`
+	Updated  int  `json:"updated"`
+	Deleted  int  `json:"deleted"`
+	Uploaded int  `json:"uploaded"`
+	FullSync bool `json:"fullSync"`
+}
+
+// cardDAVProfileStore persists profiles in carddav.json and their
+// passwords in the secret store
+type cardDAVProfileStore struct {
+	file     *ConfigFile
+	secrets  SecretStore
+	profiles []*CardDAVProfile
+	mu       sync.RWMutex
+}
+
+func newCardDAVProfileStore(secrets SecretStore) *cardDAVProfileStore {
+	configDir, err := getUserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
+	}
+
+	store := &cardDAVProfileStore{
+		file:    NewConfigFile(filepath.Join(configDir, "wmail", "carddav.json"), 0600),
+		secrets: secrets,
+	}
+	if err := store.file.Load(&store.profiles); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("[CardDAV] Failed to load profiles: %v\n", err)
+	}
+	if err := store.movePasswords(); err != nil {
+		fmt.Printf("[CardDAV] Failed to move passwords to the secret store: %v\n", err)
+	}
+	return store
+}
+
+// cardDAVSecretName is the secret store entry holding a profile's password
+func cardDAVSecretName(profileID string) string {
+	return "carddav:" + profileID
+}
+
+// movePasswords moves passwords saved in plaintext by older versions into
+// the secret store
+func (p *cardDAVProfileStore) movePasswords() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	moved := false
+	for _, profile := range p.profiles {
+		if profile.Password == "" {
+			continue
+		}
+		if err := p.secrets.SaveSecret(cardDAVSecretName(profile.ID), profile.Password); err != nil {
+			return err
+		}
+		profile.Password = ""
+		moved = true
+	}
+	if !moved {
+		return nil
+	}
+	return p.file.Save(p.profiles)
+}
+
+// password returns the password to use for a profile: the one it carries
+// when it is being set up or changed, otherwise the stored one
+func (p *cardDAVProfileStore) password(profile *CardDAVProfile) (string, error) {
+	if profile.Password != "" || profile.ID == "" {
+		return profile.Password, nil
+	}
+	return p.secrets.LoadSecret(cardDAVSecretName(profile.ID))
+}
+
+func (p *cardDAVProfileStore) list() []*CardDAVProfile {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	profiles := make([]*CardDAVProfile, len(p.profiles))
+	for i, profile := range p.profiles {
+		copied := *profile
+		profiles[i] = &copied
+	}
+	return profiles
+}
+
+func (p *cardDAVProfileStore) get(id string) (*CardDAVProfile, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, profile := range p.profiles {
+		if profile.ID == id {
+			copied := *profile
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("CardDAV profile not found")
+}
+
+// save adds or replaces a profile. A profile without a password keeps the
+// stored one unless it has no username either.
+func (p *cardDAVProfileStore) save(profile *CardDAVProfile) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secretName := cardDAVSecretName(profile.ID)
+	if profile.Password != "" {
+		if err := p.secrets.SaveSecret(secretName, profile.Password); err != nil {
+			return fmt.Errorf("failed to store the password: %w", err)
+		}
+	} else if profile.Username == "" {
+		if err := p.secrets.DeleteSecret(secretName); err != nil {
+			return err
+		}
+	}
+
+	copied := *profile
+	copied.Password = ""
+	profiles := make([]*CardDAVProfile, 0, len(p.profiles)+1)
+	replaced := false
+	for _, existing := range p.profiles {
+		if existing.ID == profile.ID {
+			profiles = append(profiles, &copied)
+			replaced = true
+		} else {
+			profiles = append(profiles, existing)
+		}
+	}
+	if !replaced {
+		profiles = append(profiles, &copied)
+	}
+
+	if err := p.file.Save(profiles); err != nil {
+		return err
+	}
+	p.profiles = profiles
+	return nil
+}
+
+func (p *cardDAVProfileStore) remove(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	profiles := make([]*CardDAVProfile, 0, len(p.profiles))
+	for _, existing := range p.profiles {
+		if existing.ID != id {
+			profiles = append(profiles, existing)
+		}
+	}
+
+	if err := p.file.Save(profiles); err != nil {
+		return err
+	}
+	p.profiles = profiles
+	return p.secrets.DeleteSecret(cardDAVSecretName(id))
+}
+
+// GetCardDAVProfiles returns the configured address book syncs
+func (s *ContactService) GetCardDAVProfiles() []*CardDAVProfile {
+	return s.profiles.list()
+}
+
+// DiscoverAddressBooks lists the address books a profile's URL and
+// credentials give access to
+func (s *ContactService) DiscoverAddressBooks(profile *CardDAVProfile) ([]*CardDAVAddressBook, error) {
+	client, err := s.cardDAVClient(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cardDAVTimeout)
+	defer cancel()
+	return client.Discover(ctx, profile.URL)
+}
+
+// SaveCardDAVProfile adds or updates a profile. Without an address book the
+// first one discovered is used. Switching address books drops the contacts
+// synced from the old one.
+func (s *ContactService) SaveCardDAVProfile(profile *CardDAVProfile) (*CardDAVProfile, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	if profile == nil || strings.TrimSpace(profile.URL) == "" {
+		return nil, fmt.Errorf("a CardDAV URL is required")
+	}
+	profile.URL = strings.TrimSpace(profile.URL)
+	if profile.AccountID != "" {
+		if _, err := s.accountService.GetAccount(profile.AccountID); err != nil {
+			return nil, err
+		}
+	}
+
+	if profile.AddressBook == "" {
+		books, err := s.DiscoverAddressBooks(profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find an address book: %w", err)
+		}
+		profile.AddressBook = books[0].Href
+		if profile.Name == "" {
+			profile.Name = books[0].Name
+		}
+	}
+	if profile.Name == "" {
+		profile.Name = profile.URL
+	}
+
+	if profile.ID == "" {
+		profile.ID = generateUUID()
+	} else {
+		existing, err := s.profiles.get(profile.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing.AddressBook != profile.AddressBook {
+			if err := s.store.dropCardDAVProfile(profile.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.profiles.save(profile); err != nil {
+		return nil, err
+	}
+	profile.Password = ""
+	return profile, nil
+}
+
+// DeleteCardDAVProfile stops syncing an address book and removes the
+// contacts that came from it
+func (s *ContactService) DeleteCardDAVProfile(id string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	if _, err := s.profiles.get(id); err != nil {
+		return err
+	}
+	if err := s.store.dropCardDAVProfile(id); err != nil {
+		return err
+	}
+	return s.profiles.remove(id)
+}
+
+// SyncCardDAV syncs one address book both ways now
+func (s *ContactService) SyncCardDAV(id string) (*CardDAVSyncResult, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	profile, err := s.profiles.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.syncProfile(profile)
+}
+
+// AddContactToAddressBook uploads a local contact to a profile's address
+// book; from then on it is kept in sync
+func (s *ContactService) AddContactToAddressBook(profileID, contactID string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	profile, err := s.profiles.get(profileID)
+	if err != nil {
+		return err
+	}
+	contact, err := s.store.Get(contactID)
+	if err != nil {
+		return fmt.Errorf("contact not found")
+	}
+	if linked, err := s.store.cardDAVLinked(profileID, contactID); err != nil {
+		return err
+	} else if linked {
+		return fmt.Errorf("contact is already in this address book")
+	}
+
+	client, err := s.cardDAVClient(profile)
+	if err != nil {
+		return err
+	}
+	groups, err := s.store.groupNames()
+	if err != nil {
+		return err
+	}
+
+	href := strings.TrimSuffix(profile.AddressBook, "/") + "/" + url.PathEscape(contact.UID) + ".vcf"
+
+	var b strings.Builder
+	formatVCard(&b, cardFromContact(contact, groups), VCardVersion3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cardDAVTimeout)
+	defer cancel()
+	etag, err := client.PutCard(ctx, href, b.String(), "")
+	if err != nil {
+		return fmt.Errorf("failed to upload contact: %w", err)
+	}
+
+	contact.Source = ContactSourceCardDAV
+	if err := s.store.Save(contact); err != nil {
+		return err
+	}
+	return s.store.saveCardDAVCard(profileID, &cardDAVCard{
+		Href:      href,
+		ETag:      etag,
+		ContactID: contact.ID,
+		Data:      b.String(),
+	})
+}
+
+// runCardDAVSync syncs every profile periodically
+func (s *ContactService) runCardDAVSync() {
+	ticker := time.NewTicker(cardDAVSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, profile := range s.profiles.list() {
+			if _, err := s.syncProfile(profile); err != nil {
+				fmt.Printf("[CardDAV] Failed to sync %s: %v\n", profile.Name, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// syncProfile pulls remote changes, then pushes local edits and deletions.
+// When both sides changed a card the server's version wins.
+func (s *ContactService) syncProfile(profile *CardDAVProfile) (*CardDAVSyncResult, error) {
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+
+	result, err := s.syncAddressBook(profile)
+
+	profile.LastSync = getCurrentTime()
+	profile.LastError = ""
+	if err != nil {
+		profile.LastError = err.Error()
+	}
+	if saveErr := s.profiles.save(profile); saveErr != nil {
+		fmt.Printf("[CardDAV] Failed to save profile: %v\n", saveErr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[CardDAV] Synced %s: %d added, %d updated, %d deleted, %d uploaded\n",
+		profile.Name, result.Added, result.Updated, result.Deleted, result.Uploaded)
+	return result, nil
+}
+
+func (s *ContactService) syncAddressBook(profile *CardDAVProfile) (*CardDAVSyncResult, error) {
+	if profile.AddressBook == "" {
+		return nil, fmt.Errorf("no address book selected")
+	}
+	client, err := s.cardDAVClient(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cardDAVTimeout)
+	defer cancel()
+
+	token, cards, err := s.store.cardDAVState(profile.ID, profile.AddressBook)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CardDAVSyncResult{FullSync: token == ""}
+	changes, err := client.SyncCollection(ctx, profile.AddressBook, token)
+	if errors.Is(err, errInvalidSyncToken) {
+		result.FullSync = true
+		changes, err = client.SyncCollection(ctx, profile.AddressBook, "")
+	}
+	if errors.Is(err, errSyncUnsupported) {
+		result.FullSync = true
+		changes, err = listAsChanges(ctx, client, profile.AddressBook)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.FullSync {
+		// A full listing reports every card, so anything else is gone
+		present := make(map[string]bool, len(changes.Changed))
+		for _, card := range changes.Changed {
+			present[card.Href] = true
+		}
+		for href := range cards {
+			if !present[href] {
+				changes.Deleted = append(changes.Deleted, href)
+			}
+		}
+	}
+
+	// Pull
+	var fetch []string
+	for _, card := range changes.Changed {
+		if known, ok := cards[card.Href]; ok && card.ETag != "" && known.ETag == card.ETag {
+			continue
+		}
+		fetch = append(fetch, card.Href)
+	}
+	if len(fetch) > 0 {
+		remote, err := client.MultiGet(ctx, profile.AddressBook, fetch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download cards: %w", err)
+		}
+		for _, rc := range remote {
+			created, err := s.store.applyRemoteCard(profile.ID, rc, cards[rc.Href])
+			if err != nil {
+				return nil, err
+			}
+			if created {
+				result.Added++
+			} else {
+				result.Updated++
+			}
+		}
+	}
+	for _, href := range changes.Deleted {
+		if known, ok := cards[href]; ok {
+			if err := s.store.removeCardDAVCard(profile.ID, known, true); err != nil {
+				return nil, err
+			}
+			result.Deleted++
+		}
+	}
+	if err := s.store.setCardDAVState(profile.ID, profile.AddressBook, changes.SyncToken); err != nil {
+		return nil, err
+	}
+
+	// Push
+	uploaded, err := s.pushLocalChanges(ctx, client, profile)
+	result.Uploaded = uploaded
+	return result, err
+}
+
+// listAsChanges lists every card for servers without sync-collection
+func listAsChanges(ctx context.Context, client *CardDAVClient, bookURL string) (*cardDAVChanges, error) {
+	cards, err := client.ListCards(ctx, bookURL)
+	if err != nil {
+		return nil, err
+	}
+	return &cardDAVChanges{Changed: cards}, nil
+}
+
+// pushLocalChanges uploads contacts edited since the last sync and deletes
+// the cards of contacts deleted locally
+func (s *ContactService) pushLocalChanges(ctx context.Context, client *CardDAVClient, profile *CardDAVProfile) (int, error) {
+	_, cards, err := s.store.cardDAVState(profile.ID, profile.AddressBook)
+	if err != nil {
+		return 0, err
+	}
+	groups, err := s.store.groupNames()
+	if err != nil {
+		return 0, err
+	}
+
+	uploaded := 0
+	for _, card := range cards {
+		contact, err := s.store.Get(card.ContactID)
+		if err == sql.ErrNoRows {
+			err := client.DeleteCard(ctx, card.Href, card.ETag)
+			if err != nil && !errors.Is(err, errPreconditionFailed) {
+				return uploaded, fmt.Errorf("failed to delete card: %w", err)
+			}
+			if err == nil {
+				if err := s.store.removeCardDAVCard(profile.ID, card, false); err != nil {
+					return uploaded, err
+				}
+				uploaded++
+			}
+			// Edited on the server meanwhile: the next pull restores it
+			continue
+		}
+		if err != nil {
+			return uploaded, err
+		}
+		local := cardFromContact(contact, groups)
+		if !cardDAVDirty(card.Data, local) {
+			continue
+		}
+
+		data := updateVCard(card.Data, local)
+		etag, err := client.PutCard(ctx, card.Href, data, card.ETag)
+		if errors.Is(err, errPreconditionFailed) {
+			remote, err := client.GetCard(ctx, card.Href)
+			if err != nil {
+				return uploaded, err
+			}
+			if _, err := s.store.applyRemoteCard(profile.ID, remote, card); err != nil {
+				return uploaded, err
+			}
+			continue
+		}
+		if err != nil {
+			return uploaded, fmt.Errorf("failed to upload card: %w", err)
+		}
+
+		card.ETag = etag
+		card.Data = data
+		if err := s.store.saveCardDAVCard(profile.ID, card); err != nil {
+			return uploaded, err
+		}
+		uploaded++
+	}
+	return uploaded, nil
+}
+
+// cardDAVClient builds a client authenticating as the profile says
+func (s *ContactService) cardDAVClient(profile *CardDAVProfile) (*CardDAVClient, error) {
+	if profile == nil {
+		return nil, fmt.Errorf("CardDAV profile is empty")
+	}
+
+	username := profile.Username
+	password, err := s.profiles.password(profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CardDAV password: %w", err)
+	}
+	var oauthAccount *Account
+	if profile.AccountID != "" && username == "" {
+		account, err := s.accountService.GetAccount(profile.AccountID)
+		if err != nil {
+			return nil, err
+		}
+		switch account.AuthMethod {
+		case AuthMethodXOAuth2, AuthMethodOAuthBearer:
+			oauthAccount = account
+		default:
+			username, password = account.Username, account.Password
+			if username == "" {
+				username = account.Email
+			}
+		}
+	}
+
+	return &CardDAVClient{
+		HTTPClient: s.httpClient,
+		Authorize: func(req *http.Request) error {
+			if oauthAccount != nil {
+				token, err := accessTokenFor(oauthAccount)
+				if err != nil {
+					return err
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+			} else if username != "" {
+				req.SetBasicAuth(username, password)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// cardDAVLifecycleHook drops the profiles borrowing a deleted account's credentials
+func (s *ContactService) cardDAVLifecycleHook() AccountLifecycleHook {
+	return AccountLifecycleHook{
+		Name: "CardDAV",
+		OnDelete: func(account *Account) error {
+			for _, profile := range s.profiles.list() {
+				if profile.AccountID != account.ID {
+					continue
+				}
+				if err := s.DeleteCardDAVProfile(profile.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// cardDAVCard is the local record of a synced card. ContactID deliberately
+// has no foreign key: a card whose contact is gone was deleted locally and
+// must be deleted on the server too.
+type cardDAVCard struct {
+	Href      string
+	ETag      string
+	ContactID string
+	Data      string // the vCard as last synced
+}
+
+// cardDAVDirty reports whether a contact was edited since its card was synced
+func cardDAVDirty(synced string, local *vCard) bool {
+	cards, err := parseVCards(synced)
+	if err != nil || len(cards) == 0 {
+		return true
+	}
+	for _, changed := range changedVCardProperties(cards[0], local) {
+		if changed {
+			return true
+		}
+	}
+	return false
+}
+
+// cardDAVState returns the sync token and known cards of a profile. State
+// for a different address book is discarded.
+func (s *ContactStore) cardDAVState(profileID, addressBook string) (string, map[string]*cardDAVCard, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var book, token string
+	err := s.db.QueryRow(`SELECT address_book, sync_token FROM carddav_state WHERE profile_id = ?`, profileID).Scan(&book, &token)
+	if err != nil && err != sql.ErrNoRows {
+		return "", nil, err
+	}
+	if book != addressBook {
+		token = ""
+	}
+
+	rows, err := s.db.Query(`SELECT href, etag, contact_id, data FROM carddav_cards WHERE profile_id = ?`, profileID)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	cards := make(map[string]*cardDAVCard)
+	for rows.Next() {
+		card := &cardDAVCard{}
+		if err := rows.Scan(&card.Href, &card.ETag, &card.ContactID, &card.Data); err != nil {
+			return "", nil, err
+		}
+		cards[card.Href] = card
+	}
+	return token, cards, rows.Err()
+}
+
+func (s *ContactStore) setCardDAVState(profileID, addressBook, token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`
+		INSERT INTO carddav_state (profile_id, address_book, sync_token) VALUES (?, ?, ?)
+		ON CONFLICT(profile_id) DO UPDATE SET address_book = excluded.address_book, sync_token = excluded.sync_token
+	`, profileID, addressBook, token)
+	return err
+}
+
+func (s *ContactStore) saveCardDAVCard(profileID string, card *cardDAVCard) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return saveCardDAVCard(s.db, profileID, card)
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func saveCardDAVCard(db sqlExecer, profileID string, card *cardDAVCard) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO carddav_cards (profile_id, href, etag, contact_id, data)
+		VALUES (?, ?, ?, ?, ?)
+	`, profileID, card.Href, card.ETag, card.ContactID, card.Data)
+	return err
+}
+
+// cardDAVLinked reports whether a contact is synced with a profile
+func (s *ContactStore) cardDAVLinked(profileID, contactID string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM carddav_cards WHERE profile_id = ? AND contact_id = ?`, profileID, contactID).Scan(&n)
+	return n > 0, err
+}
+
+// applyRemoteCard stores a card downloaded from the server, replacing the
+// contact it is linked to or, failing that, the contact with its UID
+func (s *ContactStore) applyRemoteCard(profileID string, rc *remoteCard, known *cardDAVCard) (created bool, err error) {
+	cards, err := parseVCards(rc.Data)
+	if err != nil {
+		return false, fmt.Errorf("invalid card %s: %w", rc.Href, err)
+	}
+	if len(cards) == 0 {
+		return false, nil
+	}
+	card := cards[0]
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var contact *Contact
+	if known != nil {
+		contact, err = s.queryOne(`WHERE id = ?`, known.ContactID)
+	} else if card.UID != "" {
+		contact, err = s.queryOne(`WHERE uid = ?`, card.UID)
+	} else {
+		err = sql.ErrNoRows
+	}
+	if err == sql.ErrNoRows {
+		contact = &Contact{UID: card.UID}
+		created = true
+	} else if err != nil {
+		return false, err
+	}
+
+	contact.Name = card.Name
+	contact.Organization = card.Organization
+	contact.Notes = card.Notes
+	contact.Emails = card.Emails
+	contact.Phones = card.Phones
+	contact.Source = ContactSourceCardDAV
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	contact.Groups = nil
+	for _, category := range card.Categories {
+		id, err := groupByName(tx, category)
+		if err != nil {
+			return false, err
+		}
+		contact.Groups = append(contact.Groups, id)
+	}
+	if err := saveContact(tx, contact); err != nil {
+		return false, err
+	}
+
+	if err := saveCardDAVCard(tx, profileID, &cardDAVCard{
+		Href:      rc.Href,
+		ETag:      rc.ETag,
+		ContactID: contact.ID,
+		Data:      rc.Data,
+	}); err != nil {
+		return false, err
+	}
+	return created, tx.Commit()
+}
+
+// removeCardDAVCard forgets a card, and deletes its contact too when the
+// card was deleted on the server
+func (s *ContactStore) removeCardDAVCard(profileID string, card *cardDAVCard, deleteContact bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM carddav_cards WHERE profile_id = ? AND href = ?`, profileID, card.Href); err != nil {
+		return err
+	}
+	if deleteContact {
+		if _, err := tx.Exec(`DELETE FROM contacts WHERE id = ?`, card.ContactID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// dropCardDAVProfile deletes a profile's sync state and the contacts synced through it
+func (s *ContactStore) dropCardDAVProfile(profileID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM contacts WHERE id IN (SELECT contact_id FROM carddav_cards WHERE profile_id = ?)`,
+		`DELETE FROM carddav_cards WHERE profile_id = ?`,
+		`DELETE FROM carddav_state WHERE profile_id = ?`,
+	} {
+		if _, err := tx.Exec(query, profileID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ConfigMigration upgrades the payload of a config file by one schema version
+type ConfigMigration func(data json.RawMessage) (json.RawMessage, error)
+
+// ConfigFile is a versioned JSON config file written atomically.
+// migrations[i] upgrades version i to i+1, so the current version is len(migrations).
+// Version 0 is the legacy layout without an envelope.
+type ConfigFile struct {
+	path       string
+	perm       os.FileMode
+	migrations []ConfigMigration
+}
+
+// configEnvelope is the on-disk layout of a versioned config file
+type configEnvelope struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// NewConfigFile creates a versioned config file handle
+func NewConfigFile(path string, perm os.FileMode, migrations ...ConfigMigration) *ConfigFile {
+	return &ConfigFile{
+		path:       path,
+		perm:       perm,
+		migrations: migrations,
+	}
+}
+
+// Version returns the current schema version
+func (f *ConfigFile) Version() int {
+	return len(f.migrations)
+}
+
+// backupPath returns the path of the rolling backup
+func (f *ConfigFile) backupPath() string {
+	return f.path + ".bak"
+}
+
+// Load reads the config into v, migrating older versions and falling back to
+// the backup if the main file is corrupted. A missing file returns an error
+// satisfying os.IsNotExist.
+func (f *ConfigFile) Load(v interface{}) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	recovered := false
+	payload, version, err := f.decode(data)
+	if err != nil {
+		fmt.Printf("[ConfigFile] %s is corrupted (%v), trying backup\n", f.path, err)
+		backup, backupErr := os.ReadFile(f.backupPath())
+		if backupErr != nil {
+			return fmt.Errorf("failed to read %s: %w", f.path, err)
+		}
+		payload, version, err = f.decode(backup)
+		if err != nil {
+			return fmt.Errorf("failed to read %s and its backup: %w", f.path, err)
+		}
+		recovered = true
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return err
+	}
+
+	if recovered || version != f.Version() {
+		if version != f.Version() {
+			fmt.Printf("[ConfigFile] Migrated %s from version %d to %d\n", f.path, version, f.Version())
+		}
+		if err := f.Save(v); err != nil {
+			fmt.Printf("[ConfigFile] Failed to save migrated %s: %v\n", f.path, err)
+		}
+	}
+	return nil
+}
+
+// decode unwraps the envelope and runs pending migrations
+func (f *ConfigFile) decode(data []byte) (json.RawMessage, int, error) {
+	payload, version, err := unwrapConfig(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > f.Version() {
+		return nil, 0, fmt.Errorf("config version %d is newer than supported version %d", version, f.Version())
+	}
+
+	for i := version; i < f.Version(); i++ {
+		payload, err = f.migrations[i](payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("migration %d -> %d failed: %w", i, i+1, err)
+		}
+	}
+	return payload, version, nil
+}
+
+// unwrapConfig detects the envelope; anything else is a version 0 payload
+func unwrapConfig(data []byte) (json.RawMessage, int, error) {
+	if !json.Valid(data) {
+		return nil, 0, fmt.Errorf("invalid JSON")
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &fields); err == nil {
+			_, hasVersion := fields["version"]
+			_, hasData := fields["data"]
+			if hasVersion && hasData && len(fields) == 2 {
+				var env configEnvelope
+				if err := json.Unmarshal(trimmed, &env); err != nil {
+					return nil, 0, err
+				}
+				return env.Data, env.Version, nil
+			}
+		}
+	}
+	return json.RawMessage(trimmed), 0, nil
+}
+
+// Save writes v at the current version, keeping the previous file as a backup
+func (f *ConfigFile) Save(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(configEnvelope{Version: f.Version(), Data: payload}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	// Only rotate a readable file into the backup slot, so a corrupted
+	// main file never overwrites the last good backup
+	if previous, err := os.ReadFile(f.path); err == nil {
+		if _, _, err := unwrapConfig(previous); err == nil {
+			if err := writeFileAtomic(f.backupPath(), previous, f.perm); err != nil {
+				fmt.Printf("[ConfigFile] Failed to write backup for %s: %v\n", f.path, err)
+			}
+		}
+	}
+
+	return writeFileAtomic(f.path, data, f.perm)
+}
+
+// writeFileAtomic writes data to a temp file, syncs it and renames it over path,
+// so readers see either the old or the new content but never a partial write
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmpPath)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Persist the rename itself; not supported on every platform
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// renameMigration renames the legacy "title" field to "name"
+func renameMigration(data json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["name"] = fields["title"]
+	delete(fields, "title")
+	return json.Marshal(fields)
+}
+
+func readEnvelope(t *testing.T, path string) configEnvelope {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env configEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("%s is not an envelope: %v", path, err)
+	}
+	return env
+}
+
+func TestConfigFileSaveIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nested", "config.json")
+	f := NewConfigFile(path, 0600, renameMigration)
+
+	var missing testConfig
+	if err := f.Load(&missing); !os.IsNotExist(err) {
+		t.Fatalf("missing file: %v, want not-exist", err)
+	}
+
+	if err := f.Save(testConfig{Name: "first", Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.backupPath()); !os.IsNotExist(err) {
+		t.Errorf("first save wrote a backup: %v", err)
+	}
+	if err := f.Save(testConfig{Name: "second", Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("mode %o, want 600", perm)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("temp file %s left behind", e.Name())
+		}
+	}
+
+	if env := readEnvelope(t, path); env.Version != 1 || !strings.Contains(string(env.Data), "second") {
+		t.Errorf("main file = %+v", env)
+	}
+	if env := readEnvelope(t, f.backupPath()); !strings.Contains(string(env.Data), "first") {
+		t.Errorf("backup = %s, want the previous save", env.Data)
+	}
+
+	var got testConfig
+	if err := f.Load(&got); err != nil || got != (testConfig{Name: "second", Count: 2}) {
+		t.Errorf("Load = %+v, %v", got, err)
+	}
+}
+
+func TestConfigFileRecoversFromBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	f := NewConfigFile(path, 0600)
+
+	f.Save(testConfig{Name: "good"})
+	f.Save(testConfig{Name: "newer"})
+
+	// A crash mid-write in an older version left the main file truncated
+	if err := os.WriteFile(path, []byte(`{"version": 0, "da`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got testConfig
+	if err := f.Load(&got); err != nil || got.Name != "good" {
+		t.Fatalf("Load = %+v, %v, want the backup", got, err)
+	}
+	// Recovery rewrites the main file
+	if env := readEnvelope(t, path); !strings.Contains(string(env.Data), "good") {
+		t.Errorf("main file after recovery = %s", env.Data)
+	}
+
+	// Saving over a corrupted main file keeps the last good backup
+	os.WriteFile(path, []byte("garbage"), 0600)
+	if err := f.Save(testConfig{Name: "latest"}); err != nil {
+		t.Fatal(err)
+	}
+	if env := readEnvelope(t, f.backupPath()); !strings.Contains(string(env.Data), "good") {
+		t.Errorf("backup = %s, want the last good file", env.Data)
+	}
+
+	// Without a usable backup the error is reported
+	os.WriteFile(path, []byte("garbage"), 0600)
+	os.WriteFile(f.backupPath(), []byte("garbage"), 0600)
+	if err := f.Load(&got); err == nil {
+		t.Error("Load succeeded with a corrupted file and backup")
+	}
+}
+
+func TestConfigFileMigratesEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    testConfig
+		wantErr bool
+	}{
+		{
+			name:    "legacy without envelope",
+			content: `{"title": "old", "count": 3}`,
+			want:    testConfig{Name: "old", Count: 3},
+		},
+		{
+			name:    "version 0 envelope",
+			content: `{"version": 0, "data": {"title": "wrapped"}}`,
+			want:    testConfig{Name: "wrapped"},
+		},
+		{
+			name:    "current version",
+			content: `{"version": 1, "data": {"name": "current"}}`,
+			want:    testConfig{Name: "current"},
+		},
+		{
+			// A legacy payload that happens to have a version field is not an envelope
+			name:    "legacy with version field",
+			content: `{"version": 7, "data": null, "title": "plain"}`,
+			want:    testConfig{Name: "plain"},
+		},
+		{
+			name:    "newer version",
+			content: `{"version": 2, "data": {"name": "future"}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			f := NewConfigFile(path, 0600, renameMigration)
+
+			var got testConfig
+			err := f.Load(&got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Load = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Load = %+v, %v, want %+v", got, err, tt.want)
+			}
+			if env := readEnvelope(t, path); env.Version != f.Version() {
+				t.Errorf("file left at version %d, want %d", env.Version, f.Version())
+			}
+		})
+	}
+}
+
+func TestAccountsMigrationSetsPasswordAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	legacy := `[{"id": "a", "email": "a@example.com"}, {"id": "b", "email": "b@example.com", "authMethod": "xoauth2"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var accounts []*Account
+	if err := NewConfigFile(path, 0600, accountsMigrations...).Load(&accounts); err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || accounts[0].AuthMethod != AuthMethodPassword || accounts[1].AuthMethod != AuthMethodXOAuth2 {
+		t.Errorf("accounts = %+v, %+v", accounts[0], accounts[1])
+	}
+}
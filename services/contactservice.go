@@ -0,0 +1,627 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// contactHarvestInterval is how often the address statistics are rebuilt
+	contactHarvestInterval = 30 * time.Minute
+	// maxSuggestions is how many recipients SuggestRecipients returns
+	maxSuggestions = 10
+)
+
+// RecipientSuggestion is a compose autocomplete entry: an address or a group
+type RecipientSuggestion struct {
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	ContactID string    `json:"contactId,omitempty"`
+	GroupID   string    `json:"groupId,omitempty"`
+	Members   []Address `json:"members,omitempty"` // addresses a group expands to
+	Score     float64   `json:"score"`
+}
+
+// ContactImportResult summarizes a vCard import
+type ContactImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
+
+// ContactService manages the address book and recipient suggestions
+type ContactService struct {
+	store          *ContactStore
+	accountService *MailAccountService
+	mailService    *MailService
+	profiles       *cardDAVProfileStore
+	httpClient     HTTPClient
+	harvestMutex   sync.Mutex
+	syncMutex      sync.Mutex
+}
+
+// NewContactService creates a new contact service
+func NewContactService(accountService *MailAccountService, mailService *MailService) *ContactService {
+	configDir, err := getUserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
+	}
+	dbPath := filepath.Join(configDir, "wmail", "contacts.db")
+
+	store, err := NewContactStore(dbPath)
+	if err != nil {
+		fmt.Printf("[ContactService] Failed to open contacts: %v\n", err)
+		store = nil
+	} else {
+		fmt.Printf("[ContactService] Contacts opened at %s\n", dbPath)
+	}
+
+	s := &ContactService{
+		store:          store,
+		accountService: accountService,
+		mailService:    mailService,
+		profiles:       newCardDAVProfileStore(getSecretStore()),
+		httpClient:     &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if store != nil {
+		accountService.RegisterLifecycleHook(s.cardDAVLifecycleHook())
+		mailService.setContactDirectory(s)
+		go s.runHarvester()
+		go s.runCardDAVSync()
+	}
+
+	return s
+}
+
+func (s *ContactService) checkStore() error {
+	if s.store == nil {
+		return fmt.Errorf("contacts are not available")
+	}
+	return nil
+}
+
+// GetContacts returns every contact
+func (s *ContactService) GetContacts() ([]*Contact, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	return s.store.List()
+}
+
+// GetContact returns one contact
+func (s *ContactService) GetContact(id string) (*Contact, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	contact, err := s.store.Get(id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("contact not found")
+	}
+	return contact, err
+}
+
+// SaveContact creates a contact, or updates it when the ID is set
+func (s *ContactService) SaveContact(contact *Contact) (*Contact, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	if contact == nil {
+		return nil, fmt.Errorf("contact is empty")
+	}
+
+	contact.Name = strings.TrimSpace(contact.Name)
+	for _, email := range contact.Emails {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return nil, fmt.Errorf("invalid email address %q", email)
+		}
+	}
+	if contact.Name == "" && len(contact.Emails) == 0 {
+		return nil, fmt.Errorf("a contact needs a name or an email address")
+	}
+
+	if contact.ID != "" {
+		existing, err := s.store.Get(contact.ID)
+		if err != nil {
+			return nil, fmt.Errorf("contact not found")
+		}
+		contact.CreatedAt = existing.CreatedAt
+		contact.Source = existing.Source
+	}
+
+	if err := s.store.Save(contact); err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+// DeleteContact removes a contact
+func (s *ContactService) DeleteContact(id string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	return s.store.Delete(id)
+}
+
+// GetGroups returns every contact group
+func (s *ContactService) GetGroups() ([]*ContactGroup, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	return s.store.ListGroups()
+}
+
+// CreateGroup adds a contact group
+func (s *ContactService) CreateGroup(name string) (*ContactGroup, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	return s.store.CreateGroup(name)
+}
+
+// RenameGroup renames a contact group
+func (s *ContactService) RenameGroup(id, name string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	return s.store.RenameGroup(id, name)
+}
+
+// DeleteGroup removes a group without deleting its contacts
+func (s *ContactService) DeleteGroup(id string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	return s.store.DeleteGroup(id)
+}
+
+// SetGroupMember adds a contact to a group or removes it
+func (s *ContactService) SetGroupMember(groupID, contactID string, member bool) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	return s.store.SetGroupMember(groupID, contactID, member)
+}
+
+// GetGroupMembers returns the contacts of a group
+func (s *ContactService) GetGroupMembers(groupID string) ([]*Contact, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	return s.store.GroupMembers(groupID)
+}
+
+// ImportVCards adds or updates contacts from vCard 3.0 or 4.0 data.
+// Cards are matched to existing contacts by UID, then by email address.
+func (s *ContactService) ImportVCards(data string) (*ContactImportResult, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+
+	cards, err := parseVCards(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vCard data: %w", err)
+	}
+
+	result := &ContactImportResult{}
+	for _, card := range cards {
+		created, err := s.importCard(card, ContactSourceImported)
+		if err != nil {
+			return result, err
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+
+	fmt.Printf("[ContactService] Imported %d new and %d updated contacts\n", result.Created, result.Updated)
+	return result, nil
+}
+
+// importCard merges one card into the address book
+func (s *ContactService) importCard(card *vCard, source string) (created bool, err error) {
+	var contact *Contact
+	if card.UID != "" {
+		contact, _ = s.store.FindByUID(card.UID)
+	}
+	for _, email := range card.Emails {
+		if contact != nil {
+			break
+		}
+		contact, _ = s.store.FindByEmail(email)
+	}
+
+	if contact == nil {
+		contact = &Contact{UID: card.UID, Source: source}
+		created = true
+	}
+	mergeCard(contact, card)
+
+	for _, category := range card.Categories {
+		group, err := s.store.CreateGroup(category)
+		if err != nil {
+			return false, err
+		}
+		contact.Groups = appendUnique(contact.Groups, group.ID)
+	}
+
+	return created, s.store.Save(contact)
+}
+
+// mergeCard copies a card's fields onto a contact, keeping anything the card lacks
+func mergeCard(contact *Contact, card *vCard) {
+	if card.Name != "" {
+		contact.Name = card.Name
+	}
+	if card.Organization != "" {
+		contact.Organization = card.Organization
+	}
+	if card.Notes != "" {
+		contact.Notes = card.Notes
+	}
+	for _, email := range card.Emails {
+		contact.Emails = appendUnique(contact.Emails, email)
+	}
+	for _, phone := range card.Phones {
+		contact.Phones = appendUnique(contact.Phones, phone)
+	}
+}
+
+// ExportVCards writes every contact as vCard 3.0 or 4.0
+func (s *ContactService) ExportVCards(version string) (string, error) {
+	if err := s.checkStore(); err != nil {
+		return "", err
+	}
+	if version == "" {
+		version = VCardVersion4
+	}
+	if version != VCardVersion3 && version != VCardVersion4 {
+		return "", fmt.Errorf("unsupported vCard version: %s", version)
+	}
+
+	contacts, err := s.store.List()
+	if err != nil {
+		return "", err
+	}
+	groups, err := s.store.groupNames()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, contact := range contacts {
+		formatVCard(&b, cardFromContact(contact, groups), version)
+	}
+	return b.String(), nil
+}
+
+// cardFromContact converts a contact for export
+func cardFromContact(contact *Contact, groups map[string]string) *vCard {
+	card := &vCard{
+		UID:          contact.UID,
+		Name:         contact.Name,
+		Organization: contact.Organization,
+		Notes:        contact.Notes,
+		Emails:       contact.Emails,
+		Phones:       contact.Phones,
+	}
+	for _, id := range contact.Groups {
+		if name, ok := groups[id]; ok {
+			card.Categories = append(card.Categories, name)
+		}
+	}
+	return card
+}
+
+// isKnownAddress reports whether an address belongs to a contact or has
+// been written to, for the mail service's sender checks
+func (s *ContactService) isKnownAddress(email string) bool {
+	if email == "" {
+		return false
+	}
+	if contact, err := s.store.FindByEmail(email); err == nil && contact != nil {
+		return true
+	}
+	stat, err := s.store.AddressStat(email)
+	return err == nil && stat.SentCount > 0
+}
+
+// addressesNamed returns the addresses of contacts, and of people the user
+// wrote to, going by a display name
+func (s *ContactService) addressesNamed(name string) []string {
+	addresses, err := s.store.AddressesNamed(name)
+	if err != nil {
+		fmt.Printf("[ContactService] Failed to look up %q: %v\n", name, err)
+	}
+	return addresses
+}
+
+// receivedFrom counts the harvested messages from an address
+func (s *ContactService) receivedFrom(email string) int {
+	stat, err := s.store.AddressStat(email)
+	if err != nil {
+		return 0
+	}
+	return stat.ReceivedCount
+}
+
+// SuggestRecipients returns the best matches for what the user typed in a
+// recipient field. Addresses are ranked by how often and how recently they
+// were written to or heard from; saved contacts get a bonus.
+func (s *ContactService) SuggestRecipients(prefix string) ([]*RecipientSuggestion, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+
+	stats, err := s.store.AddressStats(prefix, 200)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := s.store.Search(prefix, 50)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	byEmail := make(map[string]*RecipientSuggestion)
+	for _, st := range stats {
+		byEmail[normalizeEmail(st.Email)] = &RecipientSuggestion{
+			Name:  st.Name,
+			Email: st.Email,
+			Score: addressScore(st, now),
+		}
+	}
+
+	const contactBonus = 2.0
+	for _, contact := range contacts {
+		for _, email := range contact.Emails {
+			norm := normalizeEmail(email)
+			suggestion, ok := byEmail[norm]
+			if !ok {
+				suggestion = &RecipientSuggestion{Email: email}
+				byEmail[norm] = suggestion
+			}
+			suggestion.ContactID = contact.ID
+			suggestion.Score += contactBonus
+			if contact.Name != "" {
+				suggestion.Name = contact.Name
+			}
+		}
+	}
+
+	suggestions := make([]*RecipientSuggestion, 0, len(byEmail))
+	for _, suggestion := range byEmail {
+		suggestions = append(suggestions, suggestion)
+	}
+
+	groupSuggestions, err := s.suggestGroups(prefix)
+	if err != nil {
+		return nil, err
+	}
+	suggestions = append(suggestions, groupSuggestions...)
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return strings.ToLower(suggestions[i].Email) < strings.ToLower(suggestions[j].Email)
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions, nil
+}
+
+// suggestGroups offers groups whose name starts with prefix
+func (s *ContactService) suggestGroups(prefix string) ([]*RecipientSuggestion, error) {
+	if prefix == "" {
+		return nil, nil
+	}
+
+	groups, err := s.store.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var suggestions []*RecipientSuggestion
+	for _, group := range groups {
+		if group.Members == 0 || !strings.HasPrefix(strings.ToLower(group.Name), prefix) {
+			continue
+		}
+		members, err := s.store.GroupMembers(group.ID)
+		if err != nil {
+			return nil, err
+		}
+		suggestion := &RecipientSuggestion{Name: group.Name, GroupID: group.ID, Score: 3}
+		for _, member := range members {
+			if len(member.Emails) > 0 {
+				suggestion.Members = append(suggestion.Members, Address{Name: member.Name, Email: member.Emails[0]})
+			}
+		}
+		if len(suggestion.Members) > 0 {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	return suggestions, nil
+}
+
+// addressScore weighs frequency, counting mail the user sent much higher
+// than mail received, by recency with a half-life of about a month
+func addressScore(st *addressStat, now time.Time) float64 {
+	frequency := float64(5*st.SentCount + st.ReceivedCount)
+	if st.LastSeen <= 0 {
+		return math.Log1p(frequency)
+	}
+	ageDays := now.Sub(time.Unix(st.LastSeen, 0)).Hours() / 24
+	if ageDays < 0 {
+		ageDays = 0
+	}
+	return math.Log1p(frequency) * math.Pow(0.5, ageDays/30)
+}
+
+// RefreshFromMail rebuilds the address statistics from the email cache now
+func (s *ContactService) RefreshFromMail() error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	return s.harvest()
+}
+
+// runHarvester rebuilds the address statistics periodically
+func (s *ContactService) runHarvester() {
+	ticker := time.NewTicker(contactHarvestInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.harvest(); err != nil {
+			fmt.Printf("[ContactService] Failed to harvest addresses: %v\n", err)
+		}
+		<-ticker.C
+	}
+}
+
+// harvest counts every address seen in cached mail. Recipients of mail in
+// Sent folders count as written to; senders elsewhere as heard from. The
+// user's own addresses, aliases included, and Junk and Trash are left out.
+func (s *ContactService) harvest() error {
+	cache := s.mailService.cache
+	if cache == nil {
+		return nil
+	}
+
+	s.harvestMutex.Lock()
+	defer s.harvestMutex.Unlock()
+
+	accounts := s.accountService.GetAccounts()
+	own := func(email string) bool {
+		for _, acc := range accounts {
+			if acc.ownsAddress(email) {
+				return true
+			}
+		}
+		return false
+	}
+	sentFolders := make(map[mailboxRef]bool)
+	skipFolders := make(map[mailboxRef]bool)
+	for _, acc := range accounts {
+		if f := acc.folderForRole(RoleSent); f != nil {
+			sentFolders[mailboxRef{acc.ID, f.Name}] = true
+		}
+		for _, role := range []string{RoleJunk, RoleTrash} {
+			if f := acc.folderForRole(role); f != nil {
+				skipFolders[mailboxRef{acc.ID, f.Name}] = true
+			}
+		}
+	}
+
+	stats := make(map[string]*addressStat)
+	record := func(addr Address, sent bool, date int64) {
+		norm := normalizeEmail(addr.Email)
+		if norm == "" || own(norm) || !strings.Contains(norm, "@") {
+			return
+		}
+		st, ok := stats[norm]
+		if !ok {
+			st = &addressStat{Email: addr.Email}
+			stats[norm] = st
+		}
+		if sent {
+			st.SentCount++
+		} else {
+			st.ReceivedCount++
+		}
+		if date >= st.LastSeen {
+			st.LastSeen = date
+			if addr.Name != "" {
+				st.Name = addr.Name
+			}
+		} else if st.Name == "" {
+			st.Name = addr.Name
+		}
+	}
+
+	err := cache.forEachAddressRow(func(row *addressRow) {
+		ref := mailboxRef{row.accountID, row.folder}
+		if skipFolders[ref] {
+			return
+		}
+		if sentFolders[ref] {
+			for _, addr := range append(row.to, row.cc...) {
+				record(addr, true, row.date)
+			}
+			return
+		}
+		for _, addr := range row.from {
+			record(addr, false, row.date)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.ReplaceAddressStats(stats); err != nil {
+		return err
+	}
+	fmt.Printf("[ContactService] Harvested %d addresses from mail history\n", len(stats))
+	return nil
+}
+
+// addressRow is the part of a cached email the contact harvester reads
+type addressRow struct {
+	accountID string
+	folder    string
+	from      []Address
+	to        []Address
+	cc        []Address
+	date      int64
+}
+
+// forEachAddressRow streams the addresses of every cached email
+func (c *EmailCache) forEachAddressRow(fn func(row *addressRow)) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	rows, err := c.db.Query(`
+		SELECT account_id, folder, from_addr, to_addresses, cc_addresses,
+		       CASE WHEN internal_date > 0 THEN internal_date ELSE sent_date END
+		FROM emails
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := &addressRow{}
+		var from, to, cc string
+		if err := rows.Scan(&row.accountID, &row.folder, &from, &to, &cc, &row.date); err != nil {
+			return err
+		}
+		row.from = decodeAddresses(from)
+		row.to = decodeAddresses(to)
+		row.cc = decodeAddresses(cc)
+		fn(row)
+	}
+	return rows.Err()
+}
+
+// appendUnique appends s unless the slice already holds it (case-insensitively)
+func appendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if strings.EqualFold(existing, s) {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newContactTestService(t *testing.T, accounts ...*Account) *ContactService {
+	t.Helper()
+	store, err := NewContactStore(filepath.Join(t.TempDir(), "contacts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	accountService := &MailAccountService{accounts: make(map[string]*Account)}
+	for _, acc := range accounts {
+		accountService.accounts[acc.ID] = acc
+	}
+	mailService := &MailService{accountService: accountService, cache: newTestCache(t)}
+	return &ContactService{store: store, accountService: accountService, mailService: mailService}
+}
+
+// phoneDigits ignores spacing, which vCard 4.0 tel: URIs cannot carry
+func phoneDigits(phones []string) string {
+	return strings.Join(strings.Fields(strings.Join(phones, ",")), "")
+}
+
+func TestVCardRoundTrip(t *testing.T) {
+	input := "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:jane-1\r\nFN:Jane Doe\r\nN:Doe;Jane;;;\r\n" +
+		"EMAIL;TYPE=work:jane@work.example\r\nEMAIL;TYPE=home:jane@home.example\r\nTEL:+1 555 0100\r\n" +
+		"ORG:Shop\\, Inc.\r\nNOTE:Met at the fair\\nlikes tea\r\nCATEGORIES:Friends,Suppliers\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nN:Smith;Bob;;;\r\nEMAIL:bob@example.net\r\nEND:VCARD\r\n"
+
+	for _, version := range []string{VCardVersion3, VCardVersion4} {
+		s := newContactTestService(t)
+		if result, err := s.ImportVCards(input); err != nil || result.Created != 2 || result.Updated != 0 {
+			t.Fatalf("import: %+v, %v", result, err)
+		}
+		exported, err := s.ExportVCards(version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Importing the export elsewhere gives the same address book
+		other := newContactTestService(t)
+		if result, err := other.ImportVCards(exported); err != nil || result.Created != 2 {
+			t.Fatalf("%s: reimport: %+v, %v", version, result, err)
+		}
+		want, _ := s.store.List()
+		got, _ := other.store.List()
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d contacts, want %d", version, len(got), len(want))
+		}
+		groups, _ := other.store.groupNames()
+		for i := range want {
+			w, g := want[i], got[i]
+			if g.UID != w.UID || g.Name != w.Name || g.Organization != w.Organization || g.Notes != w.Notes ||
+				fmt.Sprint(g.Emails) != fmt.Sprint(w.Emails) || phoneDigits(g.Phones) != phoneDigits(w.Phones) ||
+				len(g.Groups) != len(w.Groups) {
+				t.Errorf("%s: contact %+v, want %+v", version, g, w)
+			}
+			for _, id := range g.Groups {
+				if groups[id] != "Friends" && groups[id] != "Suppliers" {
+					t.Errorf("%s: unexpected group %q", version, groups[id])
+				}
+			}
+		}
+		if jane := want[1]; jane.Organization != "Shop, Inc." || jane.Notes != "Met at the fair\nlikes tea" {
+			t.Errorf("%s: escaped values read as %q, %q", version, jane.Organization, jane.Notes)
+		}
+
+		// Importing again matches by UID or email instead of duplicating
+		if result, err := s.ImportVCards(exported); err != nil || result.Created != 0 || result.Updated != 2 {
+			t.Errorf("%s: second import: %+v, %v", version, result, err)
+		}
+	}
+}
+
+func TestHarvestCountsAddresses(t *testing.T) {
+	account := &Account{ID: "a", Email: "me@example.org", Aliases: []string{"sales@example.org"},
+		Folders: []Folder{{Name: "INBOX", Role: RoleInbox}, {Name: "Sent", Role: RoleSent}, {Name: "Junk", Role: RoleJunk}}}
+	s := newContactTestService(t, account)
+
+	jane := Address{Name: "Jane", Email: "jane@shop.example"}
+	var uid uint32
+	email := func(folder string, from Address, to, cc []Address) *Email {
+		uid++
+		return &Email{ID: generateUUID(), AccountID: "a", Folder: folder, UID: uid, From: []Address{from}, To: to, CC: cc,
+			Date: "2024-01-01T00:00:00Z", InternalDate: "2024-01-01T00:00:00Z", CreatedAt: getCurrentTime()}
+	}
+	me := Address{Email: "me@example.org"}
+	err := s.mailService.cache.CacheEmails([]*Email{
+		email("INBOX", jane, []Address{me}, nil),
+		email("INBOX", Address{Email: "JANE@shop.example"}, []Address{me}, nil),
+		email("INBOX", Address{Email: "Sales@Example.org"}, []Address{me}, nil),
+		email("Sent", me, []Address{jane}, []Address{{Email: "bob@example.net"}, {Email: "sales@example.org"}}),
+		email("Junk", Address{Email: "spam@spam.example"}, []Address{me}, nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RefreshFromMail(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		email          string
+		sent, received int
+	}{
+		{"jane@shop.example", 1, 2},
+		{"bob@example.net", 1, 0},
+		{"me@example.org", 0, 0},
+		{"sales@example.org", 0, 0},
+		{"spam@spam.example", 0, 0},
+	}
+	for _, tc := range cases {
+		st, err := s.store.AddressStat(tc.email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.SentCount != tc.sent || st.ReceivedCount != tc.received {
+			t.Errorf("%s: sent %d, received %d; want %d, %d", tc.email, st.SentCount, st.ReceivedCount, tc.sent, tc.received)
+		}
+	}
+}
+
+func TestContactSearchEscapesLike(t *testing.T) {
+	s := newContactTestService(t)
+	for _, c := range []*Contact{
+		{Name: "Underscore", Emails: []string{"a_b@example.com"}},
+		{Name: "Letter", Emails: []string{"axb@example.com"}},
+		{Name: "100% Club", Emails: []string{"club@example.com"}},
+		{Name: "1000 Club", Emails: []string{"grand@example.com"}},
+	} {
+		if err := s.store.Save(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := make(map[string]*addressStat)
+	for _, email := range []string{"a_b@example.com", "axb@example.com"} {
+		stats[email] = &addressStat{Email: email, SentCount: 1}
+	}
+	if err := s.store.ReplaceAddressStats(stats); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		prefix string
+		want   int
+	}{
+		{"a_", 1},
+		{"100%", 1},
+		{"%", 0},
+		{"a", 2},
+	}
+	for _, tc := range cases {
+		contacts, err := s.store.Search(tc.prefix, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(contacts) != tc.want {
+			t.Errorf("Search(%q): %d contacts, want %d", tc.prefix, len(contacts), tc.want)
+		}
+	}
+	if found, _ := s.store.AddressStats("a_", 10); len(found) != 1 || found[0].Email != "a_b@example.com" {
+		t.Errorf("AddressStats(a_): %+v", found)
+	}
+}
@@ -0,0 +1,644 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Contact sources
+const (
+	ContactSourceManual   = "manual"
+	ContactSourceImported = "imported"
+	ContactSourceCardDAV  = "carddav"
+)
+
+// Contact is an address book entry
+type Contact struct {
+	ID           string   `json:"id"`
+	UID          string   `json:"uid"` // vCard UID, stable across import and export
+	Name         string   `json:"name"`
+	Emails       []string `json:"emails"`
+	Phones       []string `json:"phones"`
+	Organization string   `json:"organization"`
+	Notes        string   `json:"notes"`
+	Groups       []string `json:"groups"` // group IDs
+	Source       string   `json:"source"`
+	CreatedAt    string   `json:"createdAt"`
+	UpdatedAt    string   `json:"updatedAt"`
+}
+
+// ContactGroup is a named list of contacts, exported as vCard CATEGORIES
+type ContactGroup struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+}
+
+// addressStat is what the mail history says about one address
+type addressStat struct {
+	Email         string
+	Name          string
+	SentCount     int   // messages the user sent to it
+	ReceivedCount int   // messages received from it
+	LastSeen      int64 // unix time of the latest such message
+}
+
+// contactMigrations must be sorted by version and never edited once released
+var contactMigrations = []schemaMigration{
+	{
+		version:     1,
+		description: "contacts, groups and address statistics",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS contacts (
+					id TEXT PRIMARY KEY,
+					uid TEXT NOT NULL,
+					name TEXT NOT NULL DEFAULT '',
+					organization TEXT NOT NULL DEFAULT '',
+					phones TEXT NOT NULL DEFAULT '[]',
+					notes TEXT NOT NULL DEFAULT '',
+					source TEXT NOT NULL DEFAULT 'manual',
+					created_at TEXT NOT NULL,
+					updated_at TEXT NOT NULL
+				);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_contacts_uid ON contacts(uid);
+
+				CREATE TABLE IF NOT EXISTS contact_emails (
+					contact_id TEXT NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+					email TEXT NOT NULL,
+					email_norm TEXT NOT NULL,
+					position INTEGER NOT NULL,
+					PRIMARY KEY (contact_id, email_norm)
+				);
+				CREATE INDEX IF NOT EXISTS idx_contact_emails_norm ON contact_emails(email_norm);
+
+				CREATE TABLE IF NOT EXISTS contact_groups (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL COLLATE NOCASE UNIQUE,
+					created_at TEXT NOT NULL
+				);
+
+				CREATE TABLE IF NOT EXISTS contact_group_members (
+					group_id TEXT NOT NULL REFERENCES contact_groups(id) ON DELETE CASCADE,
+					contact_id TEXT NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+					PRIMARY KEY (group_id, contact_id)
+				);
+
+				CREATE TABLE IF NOT EXISTS address_stats (
+					email_norm TEXT PRIMARY KEY,
+					email TEXT NOT NULL,
+					name TEXT NOT NULL DEFAULT '',
+					sent_count INTEGER NOT NULL DEFAULT 0,
+					received_count INTEGER NOT NULL DEFAULT 0,
+					last_seen INTEGER NOT NULL DEFAULT 0
+				);
+			`)
+			return err
+		},
+	},
+	{
+		version:     2,
+		description: "CardDAV sync state",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS carddav_state (
+					profile_id TEXT PRIMARY KEY,
+					address_book TEXT NOT NULL,
+					sync_token TEXT NOT NULL DEFAULT ''
+				);
+
+				CREATE TABLE IF NOT EXISTS carddav_cards (
+					profile_id TEXT NOT NULL,
+					href TEXT NOT NULL,
+					etag TEXT NOT NULL DEFAULT '',
+					contact_id TEXT NOT NULL,
+					data TEXT NOT NULL,
+					PRIMARY KEY (profile_id, href)
+				);
+				CREATE INDEX IF NOT EXISTS idx_carddav_cards_contact ON carddav_cards(contact_id);
+			`)
+			return err
+		},
+	},
+}
+
+// ContactStore keeps the address book in its own SQLite database, apart from
+// the email cache, since manual contacts cannot be re-downloaded
+type ContactStore struct {
+	db   *sql.DB
+	lock sync.RWMutex
+}
+
+// NewContactStore opens or creates the contacts database
+func NewContactStore(dbPath string) (*ContactStore, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
+	}
+
+	if err := migrateSchema(db, "ContactStore", contactMigrations); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return &ContactStore{db: db}, nil
+}
+
+// normalizeEmail is the form addresses are compared in
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// List returns every contact, sorted by name
+func (s *ContactStore) List() ([]*Contact, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(`ORDER BY name COLLATE NOCASE, id`)
+}
+
+// Get returns one contact
+func (s *ContactStore) Get(id string) (*Contact, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.queryOne(`WHERE id = ?`, id)
+}
+
+// FindByUID returns the contact with a vCard UID
+func (s *ContactStore) FindByUID(uid string) (*Contact, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.queryOne(`WHERE uid = ?`, uid)
+}
+
+// FindByEmail returns the first contact having an address
+func (s *ContactStore) FindByEmail(email string) (*Contact, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.queryOne(`WHERE id IN (SELECT contact_id FROM contact_emails WHERE email_norm = ?)
+		ORDER BY created_at`, normalizeEmail(email))
+}
+
+// Search returns contacts whose name (any word) or an address starts with prefix
+func (s *ContactStore) Search(prefix string, limit int) ([]*Contact, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	like := escapeLike(strings.ToLower(strings.TrimSpace(prefix))) + "%"
+	return s.query(`WHERE lower(name) LIKE ? ESCAPE '\' OR lower(name) LIKE ? ESCAPE '\'
+		OR id IN (SELECT contact_id FROM contact_emails WHERE email_norm LIKE ? ESCAPE '\')
+		ORDER BY name COLLATE NOCASE, id LIMIT ?`, like, "% "+like, like, limit)
+}
+
+// GroupMembers returns the contacts of a group
+func (s *ContactStore) GroupMembers(groupID string) ([]*Contact, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(`WHERE id IN (SELECT contact_id FROM contact_group_members WHERE group_id = ?)
+		ORDER BY name COLLATE NOCASE, id`, groupID)
+}
+
+func (s *ContactStore) queryOne(clause string, args ...interface{}) (*Contact, error) {
+	contacts, err := s.query(clause+` LIMIT 1`, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return contacts[0], nil
+}
+
+// query loads contacts matching clause along with their emails and groups.
+// Caller must hold the lock.
+func (s *ContactStore) query(clause string, args ...interface{}) ([]*Contact, error) {
+	rows, err := s.db.Query(`
+		SELECT id, uid, name, organization, phones, notes, source, created_at, updated_at
+		FROM contacts `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []*Contact
+	byID := make(map[string]*Contact)
+	for rows.Next() {
+		c := &Contact{Emails: []string{}, Groups: []string{}}
+		var phones string
+		if err := rows.Scan(&c.ID, &c.UID, &c.Name, &c.Organization, &phones, &c.Notes,
+			&c.Source, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(phones), &c.Phones); err != nil || c.Phones == nil {
+			c.Phones = []string{}
+		}
+		contacts = append(contacts, c)
+		byID[c.ID] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return contacts, nil
+	}
+
+	// Small address books make loading all emails and memberships cheaper
+	// than one query per contact
+	emailRows, err := s.db.Query(`SELECT contact_id, email FROM contact_emails ORDER BY contact_id, position`)
+	if err != nil {
+		return nil, err
+	}
+	defer emailRows.Close()
+	for emailRows.Next() {
+		var id, email string
+		if err := emailRows.Scan(&id, &email); err != nil {
+			return nil, err
+		}
+		if c, ok := byID[id]; ok {
+			c.Emails = append(c.Emails, email)
+		}
+	}
+	if err := emailRows.Err(); err != nil {
+		return nil, err
+	}
+
+	groupRows, err := s.db.Query(`SELECT contact_id, group_id FROM contact_group_members`)
+	if err != nil {
+		return nil, err
+	}
+	defer groupRows.Close()
+	for groupRows.Next() {
+		var id, group string
+		if err := groupRows.Scan(&id, &group); err != nil {
+			return nil, err
+		}
+		if c, ok := byID[id]; ok {
+			c.Groups = append(c.Groups, group)
+		}
+	}
+	return contacts, groupRows.Err()
+}
+
+// Save inserts or updates a contact with its emails and group memberships
+func (s *ContactStore) Save(c *Contact) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveContact(tx, c); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveContact writes a contact inside a transaction
+func saveContact(tx *sql.Tx, c *Contact) error {
+	now := getCurrentTime()
+	if c.ID == "" {
+		c.ID = generateUUID()
+	}
+	if c.UID == "" {
+		c.UID = generateUUID()
+	}
+	if c.Source == "" {
+		c.Source = ContactSourceManual
+	}
+	if c.CreatedAt == "" {
+		c.CreatedAt = now
+	}
+	c.UpdatedAt = now
+
+	phones, err := json.Marshal(nonNilStrings(c.Phones))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO contacts (id, uid, name, organization, phones, notes, source, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			uid = excluded.uid,
+			name = excluded.name,
+			organization = excluded.organization,
+			phones = excluded.phones,
+			notes = excluded.notes,
+			source = excluded.source,
+			updated_at = excluded.updated_at
+	`, c.ID, c.UID, c.Name, c.Organization, string(phones), c.Notes, c.Source, c.CreatedAt, c.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM contact_emails WHERE contact_id = ?`, c.ID); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	var emails []string
+	for _, email := range c.Emails {
+		email = strings.TrimSpace(email)
+		norm := normalizeEmail(email)
+		if norm == "" || seen[norm] {
+			continue
+		}
+		seen[norm] = true
+		if _, err := tx.Exec(`
+			INSERT INTO contact_emails (contact_id, email, email_norm, position) VALUES (?, ?, ?, ?)
+		`, c.ID, email, norm, len(emails)); err != nil {
+			return err
+		}
+		emails = append(emails, email)
+	}
+	c.Emails = nonNilStrings(emails)
+
+	if _, err := tx.Exec(`DELETE FROM contact_group_members WHERE contact_id = ?`, c.ID); err != nil {
+		return err
+	}
+	for _, group := range c.Groups {
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO contact_group_members (group_id, contact_id)
+			SELECT id, ? FROM contact_groups WHERE id = ?
+		`, c.ID, group); err != nil {
+			return err
+		}
+	}
+	c.Groups = nonNilStrings(c.Groups)
+
+	return nil
+}
+
+// Delete removes a contact
+func (s *ContactStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res, err := s.db.Exec(`DELETE FROM contacts WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("contact not found")
+	}
+	return nil
+}
+
+// ListGroups returns all groups with their sizes
+func (s *ContactStore) ListGroups() ([]*ContactGroup, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	rows, err := s.db.Query(`
+		SELECT g.id, g.name, COUNT(m.contact_id)
+		FROM contact_groups g
+		LEFT JOIN contact_group_members m ON m.group_id = g.id
+		GROUP BY g.id
+		ORDER BY g.name COLLATE NOCASE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*ContactGroup{}
+	for rows.Next() {
+		g := &ContactGroup{}
+		if err := rows.Scan(&g.ID, &g.Name, &g.Members); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// CreateGroup adds a group, or returns the existing one with that name
+func (s *ContactStore) CreateGroup(name string) (*ContactGroup, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, err := groupByName(tx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &ContactGroup{ID: id, Name: strings.TrimSpace(name)}, nil
+}
+
+// groupByName returns the ID of a group, creating it if needed
+func groupByName(tx *sql.Tx, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("group name is empty")
+	}
+
+	var id string
+	err := tx.QueryRow(`SELECT id FROM contact_groups WHERE name = ?`, name).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	id = generateUUID()
+	_, err = tx.Exec(`INSERT INTO contact_groups (id, name, created_at) VALUES (?, ?, ?)`, id, name, getCurrentTime())
+	return id, err
+}
+
+// groupNames maps group IDs to names
+func (s *ContactStore) groupNames() (map[string]string, error) {
+	groups, err := s.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(groups))
+	for _, g := range groups {
+		names[g.ID] = g.Name
+	}
+	return names, nil
+}
+
+// RenameGroup changes a group's name
+func (s *ContactStore) RenameGroup(id, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("group name is empty")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res, err := s.db.Exec(`UPDATE contact_groups SET name = ? WHERE id = ?`, name, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("group not found")
+	}
+	return nil
+}
+
+// DeleteGroup removes a group; its contacts are kept
+func (s *ContactStore) DeleteGroup(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`DELETE FROM contact_groups WHERE id = ?`, id)
+	return err
+}
+
+// SetGroupMember adds a contact to a group or removes it
+func (s *ContactStore) SetGroupMember(groupID, contactID string, member bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err error
+	if member {
+		_, err = s.db.Exec(`INSERT OR IGNORE INTO contact_group_members (group_id, contact_id) VALUES (?, ?)`, groupID, contactID)
+	} else {
+		_, err = s.db.Exec(`DELETE FROM contact_group_members WHERE group_id = ? AND contact_id = ?`, groupID, contactID)
+	}
+	return err
+}
+
+// ReplaceAddressStats swaps in freshly harvested address statistics
+func (s *ContactStore) ReplaceAddressStats(stats map[string]*addressStat) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM address_stats`); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO address_stats (email_norm, email, name, sent_count, received_count, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for norm, st := range stats {
+		if _, err := stmt.Exec(norm, st.Email, st.Name, st.SentCount, st.ReceivedCount, st.LastSeen); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddressStats returns the harvested statistics of addresses matching a
+// prefix of the address or of any word of the name
+func (s *ContactStore) AddressStats(prefix string, limit int) ([]*addressStat, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	query := `SELECT email, name, sent_count, received_count, last_seen FROM address_stats`
+	var args []interface{}
+	if prefix != "" {
+		like := escapeLike(strings.ToLower(prefix)) + "%"
+		query += ` WHERE email_norm LIKE ? ESCAPE '\' OR lower(name) LIKE ? ESCAPE '\' OR lower(name) LIKE ? ESCAPE '\'`
+		args = append(args, like, like, "% "+like)
+	}
+	query += ` ORDER BY sent_count DESC, last_seen DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*addressStat
+	for rows.Next() {
+		st := &addressStat{}
+		if err := rows.Scan(&st.Email, &st.Name, &st.SentCount, &st.ReceivedCount, &st.LastSeen); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// AddressStat returns the harvested statistics of one address, zero when
+// it was never seen
+func (s *ContactStore) AddressStat(email string) (*addressStat, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	st := &addressStat{Email: email}
+	err := s.db.QueryRow(`
+		SELECT email, name, sent_count, received_count, last_seen FROM address_stats WHERE email_norm = ?
+	`, normalizeEmail(email)).Scan(&st.Email, &st.Name, &st.SentCount, &st.ReceivedCount, &st.LastSeen)
+	if err == sql.ErrNoRows {
+		return st, nil
+	}
+	return st, err
+}
+
+// AddressesNamed returns the addresses of contacts with a name, and of
+// people the user wrote to under that name
+func (s *ContactStore) AddressesNamed(name string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	rows, err := s.db.Query(`
+		SELECT e.email FROM contact_emails e JOIN contacts c ON c.id = e.contact_id
+		WHERE lower(trim(c.name)) = lower(?)
+		UNION
+		SELECT email FROM address_stats WHERE lower(trim(name)) = lower(?) AND sent_count > 0
+	`, name, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, email)
+	}
+	return addresses, rows.Err()
+}
+
+// Close closes the database
+func (s *ContactStore) Close() error {
+	return s.db.Close()
+}
+
+// escapeLike escapes LIKE wildcards in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Content lines are the shared syntax of vCard (RFC 6350) and iCalendar
+// (RFC 5545): NAME;PARAM=value:value, folded at 75 octets.
+
+// contentLine is one unfolded content line
+type contentLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfoldContentLines joins continuation lines, which start with a space or tab
+func unfoldContentLines(data string) []string {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseContentLine splits `group.NAME;PARAM=value:value`
+func parseContentLine(raw string) (*contentLine, error) {
+	// The value starts at the first colon outside a quoted parameter
+	inQuotes := false
+	colon := -1
+	for i, r := range raw {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return nil, fmt.Errorf("missing ':' in %q", raw)
+	}
+
+	parts := splitContentParams(raw[:colon])
+	name := strings.ToUpper(parts[0])
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		key, value, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+
+	return &contentLine{name: name, params: params, value: raw[colon+1:]}, nil
+}
+
+// splitContentParams splits a property name and its parameters on
+// semicolons outside quoted parameter values
+func splitContentParams(s string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == ';' && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// splitContentValue splits a value on unescaped separators
+func splitContentValue(value string, sep rune) []string {
+	var parts []string
+	var current strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			current.WriteRune('\\')
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == sep:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, current.String())
+}
+
+func unescapeText(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if escaped {
+			switch r {
+			case 'n', 'N':
+				b.WriteRune('\n')
+			default:
+				b.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`).Replace(s)
+}
+
+// writeContentLine folds a content line at 75 octets without splitting characters
+func writeContentLine(b *strings.Builder, line string) {
+	const maxOctets = 75
+	width := 0
+	for _, r := range line {
+		size := utf8.RuneLen(r)
+		if width+size > maxOctets {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+}
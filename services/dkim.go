@@ -0,0 +1,414 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TXTResolver looks up the DNS TXT records holding DKIM keys.
+// *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DKIM results (RFC 8601 section 2.7.1)
+const (
+	DKIMPass      = "pass"
+	DKIMFail      = "fail"
+	DKIMNeutral   = "neutral"
+	DKIMTempError = "temperror"
+	DKIMPermError = "permerror"
+)
+
+const (
+	// maxDKIMSignatures bounds the DNS lookups one message can cause
+	maxDKIMSignatures = 5
+	dkimLookupTimeout = 5 * time.Second
+	// minDKIMRSABits is the shortest RSA key accepted (RFC 8301)
+	minDKIMRSABits = 1024
+)
+
+// DKIMResult is the outcome of checking one DKIM-Signature locally
+type DKIMResult struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector"`
+	Result   string `json:"result"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// headerField is one header field exactly as it appears in the message
+type headerField struct {
+	key string // lower-case name
+	raw string // "Name: value\r\n", folding included
+}
+
+// value returns the unfolded field value
+func (f headerField) value() string {
+	v := f.raw[strings.IndexByte(f.raw, ':')+1:]
+	return strings.TrimSpace(strings.ReplaceAll(v, "\r\n", ""))
+}
+
+// rawHeaderFields splits a CRLF message into its header fields, top first,
+// and its body
+func rawHeaderFields(raw []byte) ([]headerField, []byte) {
+	var fields []headerField
+	rest := raw
+	for len(rest) > 0 {
+		end := bytes.Index(rest, []byte("\r\n"))
+		if end < 0 {
+			end = len(rest)
+		} else {
+			end += 2
+		}
+		line := string(rest[:end])
+		rest = rest[end:]
+
+		if line == "\r\n" {
+			return fields, rest
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+		fields = append(fields, headerField{
+			key: strings.ToLower(strings.TrimRight(line[:colon], " \t")),
+			raw: line,
+		})
+	}
+	return fields, nil
+}
+
+// parseTagList reads a DKIM tag=value list (RFC 6376 section 3.2).
+// Whitespace inside values is dropped, which suits the base64 tags.
+func parseTagList(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed tag %q", part)
+		}
+		name := strings.TrimSpace(part[:eq])
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("duplicate tag %q", name)
+		}
+		tags[name] = strings.Join(strings.Fields(part[eq+1:]), "")
+	}
+	return tags, nil
+}
+
+var (
+	wspRun = regexp.MustCompile(`[ \t]+`)
+	// sigBValue matches the value of the b= tag, but not bh=
+	sigBValue = regexp.MustCompile(`(^|[;:])([ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+)
+
+// canonicalHeader applies the simple or relaxed header canonicalization
+func canonicalHeader(f headerField, relaxed bool) string {
+	if !relaxed {
+		return f.raw
+	}
+	v := f.raw[strings.IndexByte(f.raw, ':')+1:]
+	v = wspRun.ReplaceAllString(strings.ReplaceAll(v, "\r\n", ""), " ")
+	return f.key + ":" + strings.Trim(v, " ") + "\r\n"
+}
+
+// canonicalBody applies the simple or relaxed body canonicalization
+func canonicalBody(body []byte, relaxed bool) []byte {
+	if relaxed {
+		lines := strings.Split(string(body), "\r\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(wspRun.ReplaceAllString(line, " "), " ")
+		}
+		body = []byte(strings.Join(lines, "\r\n"))
+	}
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if relaxed && len(body) == 0 {
+		return nil
+	}
+	return append(append([]byte(nil), body...), '\r', '\n')
+}
+
+// dkimError carries the result a failed check ends with
+type dkimError struct {
+	result string
+	reason string
+}
+
+func (e *dkimError) Error() string { return e.reason }
+
+func dkimFailure(result, format string, args ...interface{}) error {
+	return &dkimError{result: result, reason: fmt.Sprintf(format, args...)}
+}
+
+// verifyDKIM checks the message's DKIM signatures against the keys
+// published in DNS
+func (s *MailService) verifyDKIM(fields []headerField, body []byte) []*DKIMResult {
+	var results []*DKIMResult
+	for i, f := range fields {
+		if f.key != "dkim-signature" {
+			continue
+		}
+		if len(results) == maxDKIMSignatures {
+			break
+		}
+
+		result := &DKIMResult{Result: DKIMPass}
+		if err := s.verifyDKIMSignature(fields, i, body, result); err != nil {
+			result.Result = DKIMPermError
+			var failure *dkimError
+			if errors.As(err, &failure) {
+				result.Result = failure.result
+			}
+			result.Reason = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// verifyDKIMSignature checks fields[index], a DKIM-Signature (RFC 6376
+// section 6, with rsa-sha1 refused per RFC 8301 and Ed25519 per RFC 8463)
+func (s *MailService) verifyDKIMSignature(fields []headerField, index int, body []byte, result *DKIMResult) error {
+	sig := fields[index]
+	tags, err := parseTagList(sig.value())
+	if err != nil {
+		return dkimFailure(DKIMPermError, "%v", err)
+	}
+	result.Domain = strings.ToLower(tags["d"])
+	result.Selector = tags["s"]
+
+	for _, required := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if tags[required] == "" {
+			return dkimFailure(DKIMPermError, "missing %s= tag", required)
+		}
+	}
+	if tags["v"] != "1" {
+		return dkimFailure(DKIMPermError, "unsupported version %s", tags["v"])
+	}
+	var keyType string
+	switch strings.ToLower(tags["a"]) {
+	case "rsa-sha256":
+		keyType = "rsa"
+	case "ed25519-sha256":
+		keyType = "ed25519"
+	default:
+		return dkimFailure(DKIMPermError, "unsupported algorithm %s", tags["a"])
+	}
+	if q := tags["q"]; q != "" && !strings.Contains(strings.ToLower(q), "dns/txt") {
+		return dkimFailure(DKIMPermError, "unsupported query method %s", q)
+	}
+
+	signed := strings.Split(strings.ToLower(tags["h"]), ":")
+	hasFrom := false
+	for _, name := range signed {
+		hasFrom = hasFrom || strings.TrimSpace(name) == "from"
+	}
+	if !hasFrom {
+		return dkimFailure(DKIMPermError, "From is not signed")
+	}
+
+	identity := tags["i"]
+	if identity != "" {
+		at := strings.LastIndexByte(identity, '@')
+		if at < 0 || !domainWithin(identity[at+1:], result.Domain) {
+			return dkimFailure(DKIMPermError, "i= is not within d=")
+		}
+	}
+
+	if x := tags["x"]; x != "" {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return dkimFailure(DKIMPermError, "malformed x= tag")
+		}
+		if time.Now().Unix() > expires {
+			return dkimFailure(DKIMFail, "signature expired")
+		}
+	}
+
+	headerCanon, bodyCanon := "simple", "simple"
+	if c := strings.ToLower(tags["c"]); c != "" {
+		parts := strings.SplitN(c, "/", 2)
+		headerCanon = parts[0]
+		if len(parts) == 2 {
+			bodyCanon = parts[1]
+		}
+	}
+	for _, canon := range []string{headerCanon, bodyCanon} {
+		if canon != "simple" && canon != "relaxed" {
+			return dkimFailure(DKIMPermError, "unsupported canonicalization %s", canon)
+		}
+	}
+
+	// Body hash
+	canonical := canonicalBody(body, bodyCanon == "relaxed")
+	// With l= anything may be appended to the signed part of the body
+	unsignedTail := false
+	if l := tags["l"]; l != "" {
+		length, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || length < 0 {
+			return dkimFailure(DKIMPermError, "malformed l= tag")
+		}
+		if length > int64(len(canonical)) {
+			return dkimFailure(DKIMFail, "body is shorter than l=")
+		}
+		unsignedTail = length < int64(len(canonical))
+		canonical = canonical[:length]
+	}
+	bodyHash := sha256.Sum256(canonical)
+	wantBodyHash, err := base64.StdEncoding.DecodeString(tags["bh"])
+	if err != nil {
+		return dkimFailure(DKIMPermError, "malformed bh= tag")
+	}
+	if !bytes.Equal(bodyHash[:], wantBodyHash) {
+		return dkimFailure(DKIMFail, "body hash mismatch")
+	}
+
+	// Header hash: each listed name takes the bottom-most field not yet used
+	relaxed := headerCanon == "relaxed"
+	used := make(map[int]bool)
+	var data strings.Builder
+	for _, name := range signed {
+		name = strings.TrimSpace(name)
+		for i := len(fields) - 1; i >= 0; i-- {
+			if fields[i].key == name && !used[i] {
+				used[i] = true
+				data.WriteString(canonicalHeader(fields[i], relaxed))
+				break
+			}
+		}
+	}
+	unsigned := headerField{key: sig.key, raw: sigBValue.ReplaceAllString(sig.raw, "$1$2")}
+	data.WriteString(strings.TrimSuffix(canonicalHeader(unsigned, relaxed), "\r\n"))
+	digest := sha256.Sum256([]byte(data.String()))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return dkimFailure(DKIMPermError, "malformed b= tag")
+	}
+
+	key, err := s.dkimKey(result.Selector, result.Domain, keyType, identity)
+	if err != nil {
+		return err
+	}
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return dkimFailure(DKIMFail, "signature mismatch")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest[:], signature) {
+			return dkimFailure(DKIMFail, "signature mismatch")
+		}
+	}
+	// A valid signature over part of the body does not vouch for the rest
+	if unsignedTail {
+		return dkimFailure(DKIMNeutral, "body has unsigned content past l=")
+	}
+	return nil
+}
+
+// dkimKey fetches and checks the public key at selector._domainkey.domain
+func (s *MailService) dkimKey(selector, domain, keyType, identity string) (crypto.PublicKey, error) {
+	resolver := s.TXTResolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dkimLookupTimeout)
+	defer cancel()
+
+	name := selector + "._domainkey." + domain
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, dkimFailure(DKIMPermError, "no key at %s", name)
+		}
+		return nil, dkimFailure(DKIMTempError, "key lookup failed: %v", err)
+	}
+
+	var tags map[string]string
+	for _, record := range records {
+		if parsed, err := parseTagList(record); err == nil {
+			if _, ok := parsed["p"]; ok {
+				tags = parsed
+				break
+			}
+		}
+	}
+	if tags == nil {
+		return nil, dkimFailure(DKIMPermError, "no valid key at %s", name)
+	}
+	if v := tags["v"]; v != "" && v != "DKIM1" {
+		return nil, dkimFailure(DKIMPermError, "unsupported key version %s", v)
+	}
+	if k := strings.ToLower(tags["k"]); k != keyType && !(k == "" && keyType == "rsa") {
+		return nil, dkimFailure(DKIMPermError, "key type does not match the algorithm")
+	}
+	if h := tags["h"]; h != "" && !strings.Contains(strings.ToLower(h), "sha256") {
+		return nil, dkimFailure(DKIMPermError, "key does not allow sha256")
+	}
+	for _, flag := range strings.Split(tags["t"], ":") {
+		if flag != "s" || identity == "" {
+			continue
+		}
+		if at := strings.LastIndexByte(identity, '@'); !strings.EqualFold(identity[at+1:], domain) {
+			return nil, dkimFailure(DKIMPermError, "key requires i= to match d=")
+		}
+	}
+	if tags["p"] == "" {
+		return nil, dkimFailure(DKIMPermError, "key revoked")
+	}
+	data, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, dkimFailure(DKIMPermError, "malformed key")
+	}
+
+	if keyType == "ed25519" {
+		if len(data) != ed25519.PublicKeySize {
+			return nil, dkimFailure(DKIMPermError, "malformed Ed25519 key")
+		}
+		return ed25519.PublicKey(data), nil
+	}
+	var pub *rsa.PublicKey
+	if parsed, err := x509.ParsePKIXPublicKey(data); err == nil {
+		pub, _ = parsed.(*rsa.PublicKey)
+	} else if parsed, err := x509.ParsePKCS1PublicKey(data); err == nil {
+		pub = parsed
+	}
+	if pub == nil {
+		return nil, dkimFailure(DKIMPermError, "malformed RSA key")
+	}
+	if pub.N.BitLen() < minDKIMRSABits {
+		return nil, dkimFailure(DKIMPermError, "RSA key shorter than %d bits", minDKIMRSABits)
+	}
+	return pub, nil
+}
+
+// domainWithin reports whether domain is parent or one of its subdomains
+func domainWithin(domain, parent string) bool {
+	domain, parent = strings.ToLower(domain), strings.ToLower(parent)
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fixedResolver serves DKIM key records from a map
+type fixedResolver map[string][]string
+
+func (r fixedResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records, ok := r[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// rfc8463Resolver publishes the Ed25519 key of RFC 8463 appendix A
+var rfc8463Resolver = fixedResolver{
+	"brisbane._domainkey.football.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+}
+
+// rfc8463Message is the Ed25519-signed example of RFC 8463 appendix A.3
+const rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func checkDKIM(s *MailService, raw string) []*DKIMResult {
+	fields, body := rawHeaderFields([]byte(raw))
+	return s.verifyDKIM(fields, body)
+}
+
+func TestDKIMKnownGoodSignature(t *testing.T) {
+	s := &MailService{TXTResolver: rfc8463Resolver}
+
+	cases := []struct {
+		name   string
+		raw    string
+		result string
+	}{
+		{"as signed", rfc8463Message, DKIMPass},
+		{"refolded headers", strings.Replace(rfc8463Message, "Subject: Is dinner ready?", "Subject:  Is dinner\r\n\tready?", 1), DKIMPass},
+		{"body changed", strings.Replace(rfc8463Message, "lost", "won", 1), DKIMFail},
+		{"subject changed", strings.Replace(rfc8463Message, "dinner", "lunch", 1), DKIMFail},
+		{"unknown selector", strings.Replace(rfc8463Message, "s=brisbane", "s=sydney", 1), DKIMPermError},
+	}
+	for _, tc := range cases {
+		results := checkDKIM(s, tc.raw)
+		if len(results) != 1 {
+			t.Fatalf("%s: %d results", tc.name, len(results))
+		}
+		if r := results[0]; r.Result != tc.result {
+			t.Errorf("%s: %s (%s), want %s", tc.name, r.Result, r.Reason, tc.result)
+		} else if r.Domain != "football.example.com" || r.Selector == "" {
+			t.Errorf("%s: signed by %s/%s", tc.name, r.Selector, r.Domain)
+		}
+	}
+}
+
+// signDKIM adds an Ed25519 DKIM-Signature with the given extra tags to raw
+func signDKIM(t *testing.T, key ed25519.PrivateKey, raw, tags string, bodyLength int) string {
+	t.Helper()
+	fields, body := rawHeaderFields([]byte(raw))
+	canonical := canonicalBody(body, true)
+	if bodyLength >= 0 {
+		canonical = canonical[:bodyLength]
+	}
+	bh := sha256.Sum256(canonical)
+	sig := "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d=example.org; s=sel; h=from:subject; " +
+		tags + "bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b="
+
+	var data strings.Builder
+	for _, name := range []string{"from", "subject"} {
+		for _, f := range fields {
+			if f.key == name {
+				data.WriteString(canonicalHeader(f, true))
+			}
+		}
+	}
+	data.WriteString(canonicalHeader(headerField{key: "dkim-signature", raw: sig}, true))
+	digest := sha256.Sum256([]byte(strings.TrimSuffix(data.String(), "\r\n")))
+	return sig + base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest[:])) + "\r\n" + raw
+}
+
+func TestDKIMBodyLengthLimit(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &MailService{TXTResolver: fixedResolver{
+		"sel._domainkey.example.org": {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)},
+	}}
+	raw := "From: a@example.org\r\nSubject: Offer\r\n\r\nThe real offer.\r\n"
+	signedLength := len("The real offer.\r\n")
+
+	cases := []struct {
+		name   string
+		raw    string
+		result string
+	}{
+		{"whole body", signDKIM(t, key, raw, "", -1), DKIMPass},
+		{"l= covering the body", signDKIM(t, key, raw, "l=17; ", signedLength), DKIMPass},
+		{"content appended past l=", signDKIM(t, key, raw, "l=17; ", signedLength) + "Click here instead.\r\n", DKIMNeutral},
+		{"l= past the body", signDKIM(t, key, raw, "l=99; ", signedLength), DKIMFail},
+	}
+	for _, tc := range cases {
+		results := checkDKIM(s, tc.raw)
+		if len(results) != 1 || results[0].Result != tc.result {
+			t.Errorf("%s: %+v, want %s", tc.name, results[0], tc.result)
+		}
+	}
+}
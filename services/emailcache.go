@@ -3,6 +3,9 @@ package services
 import (
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -10,8 +13,9 @@ import (
 
 // EmailCache handles email caching in SQLite
 type EmailCache struct {
-	db   *sql.DB
-	lock sync.RWMutex
+	db    *sql.DB
+	blobs *BlobStore
+	lock  sync.RWMutex
 }
 
 // NewEmailCache creates a new email cache
@@ -26,39 +30,18 @@ func NewEmailCache(dbPath string) (*EmailCache, error) {
 		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
 
-	// Create tables
-	if err := createTables(db); err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+	// Bring the schema up to date
+	if err := migrateCache(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
-	return &EmailCache{db: db}, nil
-}
+	// Raw messages live next to the database
+	blobs, err := NewBlobStore(filepath.Join(filepath.Dir(dbPath), "blobs"))
+	if err != nil {
+		return nil, err
+	}
 
-func createTables(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS emails (
-			id TEXT PRIMARY KEY,
-			account_id TEXT NOT NULL,
-			folder TEXT NOT NULL,
-			uid INTEGER NOT NULL,
-			from_addr TEXT,
-			to_addresses TEXT,
-			cc_addresses TEXT,
-			subject TEXT,
-			date TEXT,
-			body TEXT,
-			is_read INTEGER DEFAULT 0,
-			is_starred INTEGER DEFAULT 0,
-			created_at TEXT NOT NULL,
-			updated_at TEXT NOT NULL,
-			UNIQUE(account_id, folder, uid)
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_emails_account_folder ON emails(account_id, folder);
-		CREATE INDEX IF NOT EXISTS idx_emails_date ON emails(date DESC);
-		CREATE INDEX IF NOT EXISTS idx_emails_is_read ON emails(is_read);
-	`)
-	return err
+	return &EmailCache{db: db, blobs: blobs}, nil
 }
 
 // CacheEmails caches multiple emails
@@ -75,9 +58,26 @@ func (c *EmailCache) CacheEmails(emails []*Email) error {
 	now := getCurrentTime()
 
 	stmt, err := tx.Prepare(`
-		INSERT OR REPLACE INTO emails 
-		(id, account_id, folder, uid, from_addr, to_addresses, cc_addresses, subject, date, body, is_read, is_starred, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO emails
+		(id, account_id, folder, uid, message_id, from_addr, sender_addr, reply_to, to_addresses, cc_addresses, subject, date, sent_date, internal_date, body, invitation, is_read, is_starred, spam_score, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, folder, uid) DO UPDATE SET
+			message_id = excluded.message_id,
+			from_addr = excluded.from_addr,
+			sender_addr = excluded.sender_addr,
+			reply_to = excluded.reply_to,
+			to_addresses = excluded.to_addresses,
+			cc_addresses = excluded.cc_addresses,
+			subject = excluded.subject,
+			date = excluded.date,
+			sent_date = excluded.sent_date,
+			internal_date = CASE WHEN excluded.internal_date != 0 THEN excluded.internal_date ELSE emails.internal_date END,
+			body = CASE WHEN excluded.body != '' THEN excluded.body ELSE emails.body END,
+			invitation = CASE WHEN excluded.body != '' THEN excluded.invitation ELSE emails.invitation END,
+			is_read = excluded.is_read,
+			is_starred = excluded.is_starred,
+			spam_score = CASE WHEN excluded.spam_score >= 0 THEN excluded.spam_score ELSE emails.spam_score END,
+			updated_at = excluded.updated_at
 	`)
 	if err != nil {
 		return err
@@ -85,22 +85,6 @@ func (c *EmailCache) CacheEmails(emails []*Email) error {
 	defer stmt.Close()
 
 	for _, email := range emails {
-		toAddrs := ""
-		for i, addr := range email.To {
-			if i > 0 {
-				toAddrs += ","
-			}
-			toAddrs += addr
-		}
-
-		ccAddrs := ""
-		for i, addr := range email.CC {
-			if i > 0 {
-				ccAddrs += ","
-			}
-			ccAddrs += addr
-		}
-
 		isRead := 0
 		if email.IsRead {
 			isRead = 1
@@ -111,19 +95,31 @@ func (c *EmailCache) CacheEmails(emails []*Email) error {
 			isStarred = 1
 		}
 
+		spamScore := -1.0
+		if email.SpamScore != nil {
+			spamScore = *email.SpamScore
+		}
+
 		_, err := stmt.Exec(
 			email.ID,
 			email.AccountID,
 			email.Folder,
 			email.UID,
-			email.From,
-			toAddrs,
-			ccAddrs,
+			email.MessageID,
+			encodeAddresses(email.From),
+			encodeAddresses(email.Sender),
+			encodeAddresses(email.ReplyTo),
+			encodeAddresses(email.To),
+			encodeAddresses(email.CC),
 			email.Subject,
 			email.Date,
+			unixFromRFC3339(email.Date),
+			unixFromRFC3339(email.InternalDate),
 			email.Body,
+			encodeInvitation(email.Invitation),
 			isRead,
 			isStarred,
+			spamScore,
 			email.CreatedAt,
 			now,
 		)
@@ -135,101 +131,46 @@ func (c *EmailCache) CacheEmails(emails []*Email) error {
 	return tx.Commit()
 }
 
-// GetCachedEmails retrieves cached emails for an account folder
-func (c *EmailCache) GetCachedEmails(accountID, folder string, page, pageSize int) ([]*Email, error) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+// emailColumns is the column list read by scanEmail
+const emailColumns = `id, account_id, folder, uid, message_id, from_addr, sender_addr, reply_to, to_addresses, cc_addresses,
+		       subject, date, sent_date, internal_date, body, is_read, is_starred, created_at, invitation, authentication, warnings, spam_score`
 
-	offset := (page - 1) * pageSize
-	if offset < 0 {
-		offset = 0
-	}
-
-	query := `
-		SELECT id, account_id, folder, uid, from_addr, to_addresses, cc_addresses, 
-		       subject, date, body, is_read, is_starred, created_at
-		FROM emails
-		WHERE account_id = ? AND folder = ?
-		ORDER BY date DESC
-		LIMIT ? OFFSET ?
-	`
-
-	rows, err := c.db.Query(query, accountID, folder, pageSize, offset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var emails []*Email
-	for rows.Next() {
-		var email Email
-		var toAddrs, ccAddrs string
-		var isRead, isStarred int
-
-		err := rows.Scan(
-			&email.ID,
-			&email.AccountID,
-			&email.Folder,
-			&email.UID,
-			&email.From,
-			&toAddrs,
-			&ccAddrs,
-			&email.Subject,
-			&email.Date,
-			&email.Body,
-			&isRead,
-			&isStarred,
-			&email.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		email.IsRead = isRead == 1
-		email.IsStarred = isStarred == 1
-
-		// Parse addresses
-		email.To = parseAddresses(toAddrs)
-		email.CC = parseAddresses(ccAddrs)
-
-		emails = append(emails, &email)
-	}
-
-	return emails, nil
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-// GetCachedEmail retrieves a single cached email
-func (c *EmailCache) GetCachedEmail(emailID string) (*Email, error) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-
-	query := `
-		SELECT id, account_id, folder, uid, from_addr, to_addresses, cc_addresses, 
-		       subject, date, body, is_read, is_starred, created_at
-		FROM emails
-		WHERE id = ?
-	`
-
-	row := c.db.QueryRow(query, emailID)
-
+// scanEmail reads one row selected with emailColumns
+func scanEmail(row rowScanner) (*Email, error) {
 	var email Email
-	var toAddrs, ccAddrs string
+	var fromAddrs, senderAddrs, replyTo, toAddrs, ccAddrs, invitation, authentication, warnings string
 	var isRead, isStarred int
+	var internalDate int64
+	var spamScore float64
 
 	err := row.Scan(
 		&email.ID,
 		&email.AccountID,
 		&email.Folder,
 		&email.UID,
-		&email.From,
+		&email.MessageID,
+		&fromAddrs,
+		&senderAddrs,
+		&replyTo,
 		&toAddrs,
 		&ccAddrs,
 		&email.Subject,
 		&email.Date,
+		&email.sentDate,
+		&internalDate,
 		&email.Body,
 		&isRead,
 		&isStarred,
 		&email.CreatedAt,
+		&invitation,
+		&authentication,
+		&warnings,
+		&spamScore,
 	)
 	if err != nil {
 		return nil, err
@@ -237,12 +178,128 @@ func (c *EmailCache) GetCachedEmail(emailID string) (*Email, error) {
 
 	email.IsRead = isRead == 1
 	email.IsStarred = isStarred == 1
-	email.To = parseAddresses(toAddrs)
-	email.CC = parseAddresses(ccAddrs)
+	email.InternalDate = rfc3339FromUnix(internalDate)
+
+	email.From = decodeAddresses(fromAddrs)
+	email.Sender = decodeAddresses(senderAddrs)
+	email.ReplyTo = decodeAddresses(replyTo)
+	email.To = decodeAddresses(toAddrs)
+	email.CC = decodeAddresses(ccAddrs)
+	email.Invitation = decodeInvitation(invitation)
+	email.Authentication = decodeAuthVerdict(authentication)
+	email.Warnings = decodeWarnings(warnings)
+	if spamScore >= 0 {
+		email.SpamScore = &spamScore
+	}
 
 	return &email, nil
 }
 
+// GetCachedEmail retrieves a single cached email
+func (c *EmailCache) GetCachedEmail(emailID string) (*Email, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	query := `
+		SELECT ` + emailColumns + `
+		FROM emails
+		WHERE id = ?
+	`
+
+	return scanEmail(c.db.QueryRow(query, emailID))
+}
+
+// GetCachedEmailByUID retrieves a single cached email by its folder UID.
+// The lookup uses the UNIQUE(account_id, folder, uid) index.
+// It returns sql.ErrNoRows when the message is not cached.
+func (c *EmailCache) GetCachedEmailByUID(accountID, folder string, uid uint32) (*Email, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	query := `
+		SELECT ` + emailColumns + `
+		FROM emails
+		WHERE account_id = ? AND folder = ? AND uid = ?
+	`
+
+	return scanEmail(c.db.QueryRow(query, accountID, folder, uid))
+}
+
+// maxUIDsPerQuery keeps bulk lookups below SQLite's bound parameter limit
+const maxUIDsPerQuery = 500
+
+// GetCachedEmailsByUIDs retrieves the cached emails for a set of UIDs.
+// UIDs that are not cached are absent from the result.
+func (c *EmailCache) GetCachedEmailsByUIDs(accountID, folder string, uids []uint32) (map[uint32]*Email, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := make(map[uint32]*Email, len(uids))
+	for start := 0; start < len(uids); start += maxUIDsPerQuery {
+		end := start + maxUIDsPerQuery
+		if end > len(uids) {
+			end = len(uids)
+		}
+		chunk := uids[start:end]
+
+		args := make([]interface{}, 0, len(chunk)+2)
+		args = append(args, accountID, folder)
+		for _, uid := range chunk {
+			args = append(args, uid)
+		}
+
+		query := `
+			SELECT ` + emailColumns + `
+			FROM emails
+			WHERE account_id = ? AND folder = ? AND uid IN (?` + strings.Repeat(", ?", len(chunk)-1) + `)
+		`
+
+		rows, err := c.db.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			email, err := scanEmail(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			result[email.UID] = email
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetCachedUIDs returns every cached UID in a folder
+func (c *EmailCache) GetCachedUIDs(accountID, folder string) ([]uint32, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	rows, err := c.db.Query(`
+		SELECT uid FROM emails WHERE account_id = ? AND folder = ? ORDER BY uid
+	`, accountID, folder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uids []uint32
+	for rows.Next() {
+		var uid uint32
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, rows.Err()
+}
+
 // UpdateEmailBody updates the body of an email
 func (c *EmailCache) UpdateEmailBody(emailID, body string) error {
 	c.lock.Lock()
@@ -255,6 +312,189 @@ func (c *EmailCache) UpdateEmailBody(emailID, body string) error {
 	return err
 }
 
+// UpdateEmailBodyByUID updates the body and invitation of an email identified by its folder UID.
+// It returns sql.ErrNoRows when the message is not cached.
+func (c *EmailCache) UpdateEmailBodyByUID(accountID, folder string, uid uint32, body string, invitation *Invitation) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	res, err := c.db.Exec(`
+		UPDATE emails SET body = ?, invitation = ?, updated_at = ? WHERE account_id = ? AND folder = ? AND uid = ?
+	`, body, encodeInvitation(invitation), getCurrentTime(), accountID, folder, uid)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateAuthenticationByUID stores the sender authentication verdict of an email
+func (c *EmailCache) UpdateAuthenticationByUID(accountID, folder string, uid uint32, verdict *AuthVerdict) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(`
+		UPDATE emails SET authentication = ? WHERE account_id = ? AND folder = ? AND uid = ?
+	`, encodeAuthVerdict(verdict), accountID, folder, uid)
+	return err
+}
+
+// UpdateWarningsByUID stores the link warnings found in the body of an email
+func (c *EmailCache) UpdateWarningsByUID(accountID, folder string, uid uint32, warnings []*MessageWarning) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(`
+		UPDATE emails SET warnings = ? WHERE account_id = ? AND folder = ? AND uid = ?
+	`, encodeWarnings(warnings), accountID, folder, uid)
+	return err
+}
+
+// StoreRawMessage saves the raw RFC 5322 source of a cached email in the blob store
+func (c *EmailCache) StoreRawMessage(accountID, folder string, uid uint32, raw []byte) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	hash, err := c.blobs.Put(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to store raw message: %w", err)
+	}
+
+	res, err := c.db.Exec(`
+		UPDATE emails SET raw_hash = ?, raw_size = ?, updated_at = ?
+		WHERE account_id = ? AND folder = ? AND uid = ?
+	`, hash, len(raw), getCurrentTime(), accountID, folder, uid)
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// Nothing references the blob; garbage collection will reclaim it
+		return "", sql.ErrNoRows
+	}
+	return hash, nil
+}
+
+// GetRawMessage returns the raw RFC 5322 source of a cached email
+func (c *EmailCache) GetRawMessage(accountID, folder string, uid uint32) ([]byte, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var hash string
+	err := c.db.QueryRow(`
+		SELECT raw_hash FROM emails WHERE account_id = ? AND folder = ? AND uid = ?
+	`, accountID, folder, uid).Scan(&hash)
+	if err != nil {
+		return nil, err
+	}
+	if hash == "" {
+		return nil, fmt.Errorf("raw message not stored")
+	}
+	return c.blobs.Get(hash)
+}
+
+// BlobVerifyReport lists problems found by VerifyRawMessages
+type BlobVerifyReport struct {
+	Checked int      `json:"checked"`
+	Missing []string `json:"missing"`
+	Corrupt []string `json:"corrupt"`
+}
+
+// VerifyRawMessages re-hashes every referenced blob. Emails whose blob is
+// missing or corrupted lose the reference so the source is fetched again.
+func (c *EmailCache) VerifyRawMessages() (*BlobVerifyReport, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	hashes, err := c.referencedBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BlobVerifyReport{}
+	var broken []string
+	for hash := range hashes {
+		report.Checked++
+		if _, err := c.blobs.Get(hash); err != nil {
+			if os.IsNotExist(err) {
+				report.Missing = append(report.Missing, hash)
+			} else {
+				report.Corrupt = append(report.Corrupt, hash)
+				c.blobs.Delete(hash)
+			}
+			broken = append(broken, hash)
+		}
+	}
+
+	for _, hash := range broken {
+		if _, err := c.db.Exec(`
+			UPDATE emails SET raw_hash = '', raw_size = 0, updated_at = ? WHERE raw_hash = ?
+		`, getCurrentTime(), hash); err != nil {
+			return report, err
+		}
+	}
+
+	fmt.Printf("[EmailCache] Verified %d blobs: %d missing, %d corrupt\n",
+		report.Checked, len(report.Missing), len(report.Corrupt))
+	return report, nil
+}
+
+// CollectGarbage deletes blobs no longer referenced by any cached email
+func (c *EmailCache) CollectGarbage() (removed int, freed int64, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	referenced, err := c.referencedBlobs()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stored, err := c.blobs.List()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, hash := range stored {
+		if referenced[hash] {
+			continue
+		}
+		size, _ := c.blobs.Size(hash)
+		if err := c.blobs.Delete(hash); err != nil {
+			fmt.Printf("[EmailCache] Failed to delete blob %s: %v\n", hash, err)
+			continue
+		}
+		removed++
+		freed += size
+	}
+
+	if removed > 0 {
+		fmt.Printf("[EmailCache] Garbage collected %d blobs (%d bytes)\n", removed, freed)
+	}
+	return removed, freed, nil
+}
+
+// referencedBlobs returns the set of blob hashes used by the emails table.
+// Caller must hold the lock.
+func (c *EmailCache) referencedBlobs() (map[string]bool, error) {
+	rows, err := c.db.Query(`SELECT DISTINCT raw_hash FROM emails WHERE raw_hash != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes[hash] = true
+	}
+	return hashes, rows.Err()
+}
+
 // MarkAsRead marks an email as read
 func (c *EmailCache) MarkAsRead(emailID string) error {
 	c.lock.Lock()
@@ -313,20 +553,3 @@ func (c *EmailCache) GetCachedCount(accountID, folder string) (int, error) {
 func (c *EmailCache) Close() error {
 	return c.db.Close()
 }
-
-func parseAddresses(s string) []string {
-	if s == "" {
-		return []string{}
-	}
-	// Simple comma-separated parsing
-	var result []string
-	start := 0
-	for i, r := range s {
-		if r == ',' {
-			result = append(result, s[start:i])
-			start = i + 1
-		}
-	}
-	result = append(result, s[start:])
-	return result
-}
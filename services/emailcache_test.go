@@ -0,0 +1,128 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCache(tb testing.TB) *EmailCache {
+	tb.Helper()
+	cache, err := NewEmailCache(filepath.Join(tb.TempDir(), "emails.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+// fillCache caches messages with UIDs 1 to n in account "a", folder INBOX
+func fillCache(tb testing.TB, cache *EmailCache, n int) {
+	tb.Helper()
+	const batch = 5000
+	for start := 1; start <= n; start += batch {
+		var emails []*Email
+		for uid := start; uid < start+batch && uid <= n; uid++ {
+			emails = append(emails, &Email{
+				ID:           fmt.Sprintf("e%d", uid),
+				AccountID:    "a",
+				Folder:       "INBOX",
+				UID:          uint32(uid),
+				From:         []Address{{Email: "sender@example.com"}},
+				Subject:      fmt.Sprintf("message %d", uid),
+				Date:         "2024-01-01T00:00:00Z",
+				InternalDate: "2024-01-01T00:00:00Z",
+				CreatedAt:    "2024-01-01T00:00:00Z",
+			})
+		}
+		if err := cache.CacheEmails(emails); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetCachedEmailByUID(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("rows=%d", n), func(b *testing.B) {
+			cache := newTestCache(b)
+			fillCache(b, cache, n)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				uid := uint32(i*7919%n + 1)
+				email, err := cache.GetCachedEmailByUID("a", "INBOX", uid)
+				if err != nil || email.UID != uid {
+					b.Fatalf("uid %d: %v", uid, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetCachedEmailsByUIDsChunks(t *testing.T) {
+	cached := 2*maxUIDsPerQuery + 1
+	cache := newTestCache(t)
+	fillCache(t, cache, cached)
+
+	for _, n := range []int{0, 1, maxUIDsPerQuery - 1, maxUIDsPerQuery, maxUIDsPerQuery + 1, cached} {
+		// Ask for exactly n UIDs, the last of which is not cached, so a
+		// dropped or repeated chunk shows up in the count
+		var uids []uint32
+		for uid := 1; uid < n; uid++ {
+			uids = append(uids, uint32(uid))
+		}
+		if n > 0 {
+			uids = append(uids, uint32(cached+1))
+		}
+		emails, err := cache.GetCachedEmailsByUIDs("a", "INBOX", uids)
+		if err != nil {
+			t.Fatalf("%d UIDs: %v", n, err)
+		}
+		found := uids[:max(n-1, 0)]
+		if len(emails) != len(found) {
+			t.Errorf("%d UIDs: got %d emails, want %d", n, len(emails), len(found))
+		}
+		for _, uid := range found {
+			if e := emails[uid]; e == nil || e.Subject != fmt.Sprintf("message %d", uid) {
+				t.Fatalf("%d UIDs: uid %d = %+v", n, uid, e)
+			}
+		}
+		if e, ok := emails[uint32(cached+1)]; ok {
+			t.Errorf("%d UIDs: uncached uid returned %+v", n, e)
+		}
+	}
+
+	emails, err := cache.GetCachedEmailsByUIDs("a", "Archive", []uint32{1, 2})
+	if err != nil || len(emails) != 0 {
+		t.Errorf("other folder: %d emails, %v", len(emails), err)
+	}
+}
+
+func TestUpdateEmailBodyByUIDAtChunkBoundary(t *testing.T) {
+	cached := maxUIDsPerQuery + 1
+	cache := newTestCache(t)
+	fillCache(t, cache, cached)
+
+	for _, uid := range []uint32{maxUIDsPerQuery, maxUIDsPerQuery + 1} {
+		if err := cache.UpdateEmailBodyByUID("a", "INBOX", uid, fmt.Sprintf("body %d", uid), nil); err != nil {
+			t.Fatalf("uid %d: %v", uid, err)
+		}
+	}
+	if err := cache.UpdateEmailBodyByUID("a", "INBOX", uint32(cached+1), "lost", nil); err != sql.ErrNoRows {
+		t.Errorf("uncached uid: %v, want sql.ErrNoRows", err)
+	}
+
+	emails, err := cache.GetCachedEmailsByUIDs("a", "INBOX", []uint32{1, maxUIDsPerQuery, maxUIDsPerQuery + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emails[1].Body != "" {
+		t.Errorf("uid 1 changed: %q", emails[1].Body)
+	}
+	for _, uid := range []uint32{maxUIDsPerQuery, maxUIDsPerQuery + 1} {
+		if want := fmt.Sprintf("body %d", uid); emails[uid].Body != want {
+			t.Errorf("uid %d body %q, want %q", uid, emails[uid].Body, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// Special-use folder roles (RFC 6154), plus the inbox
+const (
+	RoleInbox   = "inbox"
+	RoleSent    = "sent"
+	RoleDrafts  = "drafts"
+	RoleFlagged = "flagged"
+	RoleTrash   = "trash"
+	RoleJunk    = "junk"
+	RoleArchive = "archive"
+	RoleAll     = "all"
+)
+
+// specialUseRoles maps LIST attributes to roles
+var specialUseRoles = map[string]string{
+	imap.SentAttr:    RoleSent,
+	imap.DraftsAttr:  RoleDrafts,
+	imap.FlaggedAttr: RoleFlagged,
+	imap.TrashAttr:   RoleTrash,
+	imap.JunkAttr:    RoleJunk,
+	imap.ArchiveAttr: RoleArchive,
+	imap.AllAttr:     RoleAll,
+}
+
+// wellKnownFolderNames guesses roles for servers without SPECIAL-USE.
+// Names are compared case-insensitively against the last path segment.
+var wellKnownFolderNames = map[string]string{
+	"inbox":         RoleInbox,
+	"sent":          RoleSent,
+	"sent items":    RoleSent,
+	"sent mail":     RoleSent,
+	"sent messages": RoleSent,
+	"drafts":        RoleDrafts,
+	"draft":         RoleDrafts,
+	"starred":       RoleFlagged,
+	"flagged":       RoleFlagged,
+	"trash":         RoleTrash,
+	"deleted items": RoleTrash,
+	"bin":           RoleTrash,
+	"junk":          RoleJunk,
+	"spam":          RoleJunk,
+	"junk e-mail":   RoleJunk,
+	"archive":       RoleArchive,
+	"archives":      RoleArchive,
+	"all mail":      RoleAll,
+}
+
+// mailboxRole determines the role of a listed mailbox
+func mailboxRole(m *imap.MailboxInfo) string {
+	if strings.EqualFold(m.Name, "INBOX") {
+		return RoleInbox
+	}
+	for _, attr := range m.Attributes {
+		if role, ok := specialUseRoles[attr]; ok {
+			return role
+		}
+	}
+	return guessFolderRole(m.Name, m.Delimiter)
+}
+
+// guessFolderRole derives a role from a folder name alone
+func guessFolderRole(name, delimiter string) string {
+	if strings.EqualFold(name, "INBOX") {
+		return RoleInbox
+	}
+	leaf := name
+	if delimiter != "" {
+		if i := strings.LastIndex(name, delimiter); i >= 0 {
+			leaf = name[i+len(delimiter):]
+		}
+	}
+	return wellKnownFolderNames[strings.ToLower(leaf)]
+}
+
+// folderForRole returns the account's folder with a role. Folders saved
+// before roles were recorded fall back to a name-based guess.
+func (a *Account) folderForRole(role string) *Folder {
+	for i := range a.Folders {
+		if a.Folders[i].Role == role {
+			return &a.Folders[i]
+		}
+	}
+	for i := range a.Folders {
+		f := &a.Folders[i]
+		if f.Role == "" && guessFolderRole(f.Name, "/") == role {
+			return f
+		}
+	}
+	return nil
+}
+
+// roleOf returns the role of one of the account's folders
+func (a *Account) roleOf(folder string) string {
+	for _, f := range a.Folders {
+		if f.Name == folder && f.Role != "" {
+			return f.Role
+		}
+	}
+	return guessFolderRole(folder, "/")
+}
@@ -0,0 +1,538 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// iTIP methods (RFC 5546) an invitation can carry
+const (
+	InviteMethodRequest = "REQUEST"
+	InviteMethodCancel  = "CANCEL"
+	InviteMethodReply   = "REPLY"
+)
+
+// Participation statuses of an attendee
+const (
+	PartStatNeedsAction = "NEEDS-ACTION"
+	PartStatAccepted    = "ACCEPTED"
+	PartStatTentative   = "TENTATIVE"
+	PartStatDeclined    = "DECLINED"
+)
+
+// Invitation is a meeting request, cancellation or reply found in a message
+type Invitation struct {
+	Method         string      `json:"method"`
+	UID            string      `json:"uid"`
+	Sequence       int         `json:"sequence"`
+	RecurrenceID   string      `json:"recurrenceId,omitempty"` // the occurrence this is about, for one-off changes
+	Status         string      `json:"status,omitempty"`       // CONFIRMED, TENTATIVE or CANCELLED
+	Summary        string      `json:"summary"`
+	Description    string      `json:"description,omitempty"`
+	Location       string      `json:"location,omitempty"`
+	Start          string      `json:"start"` // RFC3339 in UTC, or YYYY-MM-DD for all-day events
+	End            string      `json:"end,omitempty"`
+	AllDay         bool        `json:"allDay"`
+	TimeZone       string      `json:"timeZone,omitempty"`   // TZID the organizer used
+	Recurrence     string      `json:"recurrence,omitempty"` // RRULE value
+	RecurrenceText string      `json:"recurrenceText,omitempty"`
+	ExDates        []string    `json:"exDates,omitempty"` // cancelled occurrences
+	Organizer      *Attendee   `json:"organizer,omitempty"`
+	Attendees      []*Attendee `json:"attendees"`
+	Response       string      `json:"response,omitempty"` // our PARTSTAT once we replied
+
+	// Conflicts are calendar events the invitation overlaps, worked out
+	// each time it is opened and never cached
+	Conflicts []*EventOccurrence `json:"conflicts,omitempty"`
+	// Unverified is set, like Conflicts, when a cancellation or update was
+	// not applied because it could not be verified to come from the organizer
+	Unverified bool `json:"unverified,omitempty"`
+}
+
+// Attendee is an ORGANIZER or ATTENDEE of an event
+type Attendee struct {
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Role   string `json:"role,omitempty"`
+	Status string `json:"status,omitempty"` // PARTSTAT
+	RSVP   bool   `json:"rsvp"`
+}
+
+// icalComponent is a BEGIN/END block with its properties and subcomponents
+type icalComponent struct {
+	name       string
+	props      []*contentLine
+	components []*icalComponent
+}
+
+func (c *icalComponent) prop(name string) *contentLine {
+	for _, p := range c.props {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (c *icalComponent) text(name string) string {
+	if p := c.prop(name); p != nil {
+		return unescapeText(p.value)
+	}
+	return ""
+}
+
+func (c *icalComponent) all(name string) []*contentLine {
+	var props []*contentLine
+	for _, p := range c.props {
+		if p.name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+func (c *icalComponent) children(name string) []*icalComponent {
+	var children []*icalComponent
+	for _, child := range c.components {
+		if child.name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// parseICalendar reads the VCALENDAR object in data
+func parseICalendar(data string) (*icalComponent, error) {
+	var stack []*icalComponent
+	var root *icalComponent
+
+	for n, raw := range unfoldContentLines(data) {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		line, err := parseContentLine(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		switch line.name {
+		case "BEGIN":
+			c := &icalComponent{name: strings.ToUpper(strings.TrimSpace(line.value))}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.components = append(parent.components, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: unexpected END", n+1)
+			}
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if c.name == "VCALENDAR" && root == nil {
+				root = c
+			}
+		default:
+			if len(stack) > 0 {
+				stack[len(stack)-1].props = append(stack[len(stack)-1].props, line)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("no VCALENDAR object")
+	}
+	return root, nil
+}
+
+// parseInvitation extracts the event of an iTIP message. method comes from
+// the Content-Type parameter and is used when the calendar omits METHOD.
+func parseInvitation(data, method string) (*Invitation, error) {
+	cal, err := parseICalendar(data)
+	if err != nil {
+		return nil, err
+	}
+
+	events := cal.children("VEVENT")
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no event in calendar")
+	}
+	// Prefer the master event over overridden occurrences
+	event := events[0]
+	for _, e := range events {
+		if e.prop("RECURRENCE-ID") == nil {
+			event = e
+			break
+		}
+	}
+
+	zones := make(map[string]*icalComponent)
+	for _, tz := range cal.children("VTIMEZONE") {
+		zones[tz.text("TZID")] = tz
+	}
+
+	inv := &Invitation{
+		Method:      strings.ToUpper(cal.text("METHOD")),
+		UID:         event.text("UID"),
+		Status:      strings.ToUpper(event.text("STATUS")),
+		Summary:     event.text("SUMMARY"),
+		Description: event.text("DESCRIPTION"),
+		Location:    event.text("LOCATION"),
+		Attendees:   []*Attendee{},
+	}
+	if inv.Method == "" {
+		inv.Method = strings.ToUpper(method)
+	}
+	if inv.Method == "" {
+		inv.Method = InviteMethodRequest
+	}
+	inv.Sequence, _ = strconv.Atoi(event.text("SEQUENCE"))
+
+	if p := event.prop("DTSTART"); p != nil {
+		start, allDay, err := parseICalTime(p, zones)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTSTART: %w", err)
+		}
+		inv.AllDay = allDay
+		inv.TimeZone = p.params["TZID"]
+		inv.Start = formatICalTime(start, allDay)
+
+		if p := event.prop("DTEND"); p != nil {
+			if end, _, err := parseICalTime(p, zones); err == nil {
+				inv.End = formatICalTime(end, allDay)
+			}
+		} else if d := event.text("DURATION"); d != "" {
+			if duration, err := parseICalDuration(d); err == nil {
+				inv.End = formatICalTime(start.Add(duration), allDay)
+			}
+		}
+	}
+
+	if p := event.prop("RECURRENCE-ID"); p != nil {
+		if t, allDay, err := parseICalTime(p, zones); err == nil {
+			inv.RecurrenceID = formatICalTime(t, allDay)
+		}
+	}
+	if rule := event.text("RRULE"); rule != "" {
+		inv.Recurrence = rule
+		inv.RecurrenceText = describeRRule(rule)
+	}
+	for _, p := range event.all("EXDATE") {
+		for _, value := range strings.Split(p.value, ",") {
+			line := &contentLine{name: p.name, params: p.params, value: value}
+			if t, allDay, err := parseICalTime(line, zones); err == nil {
+				inv.ExDates = append(inv.ExDates, formatICalTime(t, allDay))
+			}
+		}
+	}
+
+	if p := event.prop("ORGANIZER"); p != nil {
+		inv.Organizer = attendeeFromLine(p)
+	}
+	for _, p := range event.all("ATTENDEE") {
+		inv.Attendees = append(inv.Attendees, attendeeFromLine(p))
+	}
+
+	return inv, nil
+}
+
+// attendeeFromLine reads a `ATTENDEE;CN=Name;PARTSTAT=...:mailto:addr` property
+func attendeeFromLine(p *contentLine) *Attendee {
+	email := strings.TrimSpace(p.value)
+	if len(email) > 7 && strings.EqualFold(email[:7], "mailto:") {
+		email = email[7:]
+	}
+	a := &Attendee{
+		Name:   p.params["CN"],
+		Email:  email,
+		Role:   strings.ToUpper(p.params["ROLE"]),
+		Status: strings.ToUpper(p.params["PARTSTAT"]),
+		RSVP:   strings.EqualFold(p.params["RSVP"], "TRUE"),
+	}
+	if a.Status == "" && p.name == "ATTENDEE" {
+		a.Status = PartStatNeedsAction
+	}
+	return a
+}
+
+// parseICalTime reads a DATE or DATE-TIME value in UTC, a time zone or floating
+func parseICalTime(p *contentLine, zones map[string]*icalComponent) (time.Time, bool, error) {
+	value := strings.TrimSpace(p.value)
+
+	if strings.EqualFold(p.params["VALUE"], "DATE") || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	local, err := time.Parse("20060102T150405", value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	tzid := p.params["TZID"]
+	if tzid == "" {
+		// Floating time: the same wall clock everywhere
+		return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.Local), false, nil
+	}
+	if loc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+		return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, loc), false, nil
+	}
+	// Not an IANA name (Outlook sends Windows zone names): use the
+	// VTIMEZONE definition shipped with the invitation
+	if tz, ok := zones[tzid]; ok {
+		if offset, ok := vtimezoneOffset(tz, local); ok {
+			return local.Add(-time.Duration(offset) * time.Second), false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("unknown time zone %q", tzid)
+}
+
+// formatICalTime formats a parsed time for Invitation
+func formatICalTime(t time.Time, allDay bool) string {
+	if allDay {
+		return t.Format("2006-01-02")
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// vtimezoneOffset returns the UTC offset in seconds that a VTIMEZONE gives
+// a wall-clock time: that of the observance which began most recently
+func vtimezoneOffset(tz *icalComponent, local time.Time) (int, bool) {
+	var best time.Time
+	offset, found := 0, false
+
+	for _, obs := range tz.components {
+		if obs.name != "STANDARD" && obs.name != "DAYLIGHT" {
+			continue
+		}
+		start, err := time.Parse("20060102T150405", strings.TrimSpace(obs.text("DTSTART")))
+		if err != nil {
+			continue
+		}
+		to, ok := parseUTCOffset(obs.text("TZOFFSETTO"))
+		if !ok {
+			continue
+		}
+
+		onsets := []time.Time{start}
+		if rule := obs.text("RRULE"); rule != "" {
+			onsets = nil
+			for _, year := range []int{local.Year() - 1, local.Year()} {
+				if onset, ok := yearlyOnset(rule, year, start); ok && !onset.Before(start) {
+					onsets = append(onsets, onset)
+				}
+			}
+		}
+		for _, onset := range onsets {
+			if onset.After(local) {
+				continue
+			}
+			if !found || onset.After(best) {
+				best, offset, found = onset, to, true
+			}
+		}
+	}
+	return offset, found
+}
+
+// yearlyOnset evaluates a FREQ=YEARLY;BYMONTH=m;BYDAY=nDD rule, the only
+// kind time zone definitions use, for one year
+func yearlyOnset(rule string, year int, start time.Time) (time.Time, bool) {
+	parts := rruleParts(rule)
+	if parts["FREQ"] != "YEARLY" {
+		return time.Time{}, false
+	}
+	month, err := strconv.Atoi(parts["BYMONTH"])
+	if err != nil || month < 1 || month > 12 {
+		month = int(start.Month())
+	}
+
+	byDay := parts["BYDAY"]
+	if byDay == "" {
+		return time.Date(year, time.Month(month), start.Day(), start.Hour(), start.Minute(), start.Second(), 0, time.UTC), true
+	}
+	m := byDayPattern.FindStringSubmatch(byDay)
+	if m == nil {
+		return time.Time{}, false
+	}
+	nth := 1
+	if m[1] != "" && m[1] != "+" {
+		nth, _ = strconv.Atoi(m[1])
+	}
+	weekday := icalWeekdays[m[2]]
+
+	var day time.Time
+	if nth > 0 {
+		first := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		day = first.AddDate(0, 0, (int(weekday)-int(first.Weekday())+7)%7+7*(nth-1))
+	} else {
+		last := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC)
+		day = last.AddDate(0, 0, -((int(last.Weekday())-int(weekday)+7)%7)+7*(nth+1))
+	}
+	if int(day.Month()) != month {
+		return time.Time{}, false
+	}
+	return time.Date(year, day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, time.UTC), true
+}
+
+// parseUTCOffset reads +HHMM, -HHMM or +HHMMSS into seconds
+func parseUTCOffset(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) != 5 && len(s) != 7 {
+		return 0, false
+	}
+	sign := 1
+	switch s[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(s[1:3])
+	m, err2 := strconv.Atoi(s[3:5])
+	sec := 0
+	var err3 error
+	if len(s) == 7 {
+		sec, err3 = strconv.Atoi(s[5:7])
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return sign * (h*3600 + m*60 + sec), true
+}
+
+// parseICalDuration reads a duration such as PT1H30M or P1D
+func parseICalDuration(s string) (time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] != "" {
+			n, _ := strconv.Atoi(m[i+2])
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+var (
+	byDayPattern    = regexp.MustCompile(`^([+-]?\d*)(MO|TU|WE|TH|FR|SA|SU)$`)
+	durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+)
+
+var icalWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// rruleParts splits FREQ=WEEKLY;BYDAY=MO into a map
+func rruleParts(rule string) map[string]string {
+	parts := make(map[string]string)
+	for _, part := range strings.Split(rule, ";") {
+		if key, value, ok := strings.Cut(part, "="); ok {
+			parts[strings.ToUpper(key)] = strings.ToUpper(value)
+		}
+	}
+	return parts
+}
+
+// describeRRule renders a recurrence rule in words, e.g. "Every 2 weeks on Mon, Thu, 10 times"
+func describeRRule(rule string) string {
+	parts := rruleParts(rule)
+	units := map[string]string{"DAILY": "day", "WEEKLY": "week", "MONTHLY": "month", "YEARLY": "year"}
+	unit, ok := units[parts["FREQ"]]
+	if !ok {
+		return ""
+	}
+
+	desc := "Every " + unit
+	if n, err := strconv.Atoi(parts["INTERVAL"]); err == nil && n > 1 {
+		desc = fmt.Sprintf("Every %d %ss", n, unit)
+	}
+	if byDay := parts["BYDAY"]; byDay != "" {
+		var days []string
+		for _, d := range strings.Split(byDay, ",") {
+			name := d
+			if len(d) >= 2 {
+				if wd, ok := icalWeekdays[d[len(d)-2:]]; ok {
+					name = d[:len(d)-2] + wd.String()[:3]
+				}
+			}
+			days = append(days, name)
+		}
+		desc += " on " + strings.Join(days, ", ")
+	}
+	if count := parts["COUNT"]; count != "" {
+		desc += ", " + count + " times"
+	} else if until := parts["UNTIL"]; len(until) >= 8 {
+		if t, err := time.Parse("20060102", until[:8]); err == nil {
+			desc += ", until " + t.Format("2006-01-02")
+		}
+	}
+	return desc
+}
+
+// buildInviteReply writes the iTIP REPLY telling the organizer how attendee responded
+func buildInviteReply(inv *Invitation, attendee Address, partStat string) string {
+	var b strings.Builder
+	writeContentLine(&b, "BEGIN:VCALENDAR")
+	writeContentLine(&b, "PRODID:-//wmail//EN")
+	writeContentLine(&b, "VERSION:2.0")
+	writeContentLine(&b, "METHOD:"+InviteMethodReply)
+	writeContentLine(&b, "BEGIN:VEVENT")
+	writeContentLine(&b, "UID:"+inv.UID)
+	if inv.Sequence > 0 {
+		writeContentLine(&b, "SEQUENCE:"+strconv.Itoa(inv.Sequence))
+	}
+	if inv.RecurrenceID != "" {
+		writeContentLine(&b, "RECURRENCE-ID"+icalTimeValue(inv.RecurrenceID, inv.AllDay))
+	}
+	writeContentLine(&b, "DTSTAMP:"+time.Now().UTC().Format("20060102T150405Z"))
+	if inv.Organizer != nil {
+		writeContentLine(&b, "ORGANIZER"+icalCNParam(inv.Organizer.Name)+":mailto:"+inv.Organizer.Email)
+	}
+	writeContentLine(&b, "ATTENDEE;PARTSTAT="+partStat+icalCNParam(attendee.Name)+":mailto:"+attendee.Email)
+	if inv.Summary != "" {
+		writeContentLine(&b, "SUMMARY:"+escapeText(inv.Summary))
+	}
+	writeContentLine(&b, "END:VEVENT")
+	writeContentLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// icalTimeValue formats an Invitation time back as `;VALUE=DATE:YYYYMMDD` or `:YYYYMMDDTHHMMSSZ`
+func icalTimeValue(s string, allDay bool) string {
+	if allDay {
+		if t, err := time.Parse("2006-01-02", s); err == nil {
+			return ";VALUE=DATE:" + t.Format("20060102")
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return ":" + t.UTC().Format("20060102T150405Z")
+	}
+	return ":" + s
+}
+
+// icalCNParam formats a CN parameter, quoted since names may hold separators
+func icalCNParam(name string) string {
+	name = strings.ReplaceAll(name, `"`, "'")
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + name + `"`
+}
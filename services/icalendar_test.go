@@ -0,0 +1,166 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// calendar wraps iCalendar lines, joined with CRLF, in a VCALENDAR
+func calendar(lines ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+}
+
+// windowsZone is an Outlook VTIMEZONE whose TZID is not an IANA name
+var windowsZone = []string{
+	"BEGIN:VTIMEZONE",
+	"TZID:W. Europe Standard Time",
+	"BEGIN:STANDARD",
+	"DTSTART:16010101T030000",
+	"TZOFFSETFROM:+0200",
+	"TZOFFSETTO:+0100",
+	"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10",
+	"END:STANDARD",
+	"BEGIN:DAYLIGHT",
+	"DTSTART:16010101T020000",
+	"TZOFFSETFROM:+0100",
+	"TZOFFSETTO:+0200",
+	"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=3",
+	"END:DAYLIGHT",
+	"END:VTIMEZONE",
+}
+
+func TestParseInvitation(t *testing.T) {
+	cases := []struct {
+		name   string
+		data   string
+		method string
+		check  func(inv *Invitation) bool
+	}{
+		{
+			"folded lines",
+			calendar("METHOD:REQUEST", "BEGIN:VEVENT", "UID:u1", "DTSTART:20300108T090000Z",
+				"SUMMARY:Quarterly", "  review", "DESCRIPTION:Agenda:\\n1. Numbers\\, as", "\tusual", "END:VEVENT"),
+			"",
+			func(inv *Invitation) bool {
+				return inv.Summary == "Quarterly review" && inv.Description == "Agenda:\n1. Numbers, asusual"
+			},
+		},
+		{
+			"IANA TZID",
+			calendar("BEGIN:VEVENT", "UID:u2", "DTSTART;TZID=Europe/Berlin:20300708T090000",
+				"DTEND;TZID=Europe/Berlin:20300708T100000", "END:VEVENT"),
+			"",
+			func(inv *Invitation) bool {
+				return inv.Start == "2030-07-08T07:00:00Z" && inv.End == "2030-07-08T08:00:00Z" && inv.TimeZone == "Europe/Berlin"
+			},
+		},
+		{
+			"VTIMEZONE in winter",
+			calendar(append(windowsZone, "BEGIN:VEVENT", "UID:u3",
+				`DTSTART;TZID="W. Europe Standard Time":20300108T090000`, "DURATION:PT1H30M", "END:VEVENT")...),
+			"",
+			func(inv *Invitation) bool {
+				return inv.Start == "2030-01-08T08:00:00Z" && inv.End == "2030-01-08T09:30:00Z"
+			},
+		},
+		{
+			"VTIMEZONE in summer",
+			calendar(append(windowsZone, "BEGIN:VEVENT", "UID:u4",
+				`DTSTART;TZID="W. Europe Standard Time":20300708T090000`, "END:VEVENT")...),
+			"",
+			func(inv *Invitation) bool { return inv.Start == "2030-07-08T07:00:00Z" },
+		},
+		{
+			"all-day event",
+			calendar("BEGIN:VEVENT", "UID:u5", "DTSTART;VALUE=DATE:20300108", "DTEND;VALUE=DATE:20300110", "END:VEVENT"),
+			"",
+			func(inv *Invitation) bool { return inv.AllDay && inv.Start == "2030-01-08" && inv.End == "2030-01-10" },
+		},
+		{
+			"master preferred over an override",
+			calendar("METHOD:REQUEST",
+				"BEGIN:VEVENT", "UID:u6", "RECURRENCE-ID:20300115T090000Z", "DTSTART:20300115T110000Z", "SUMMARY:Moved standup", "END:VEVENT",
+				"BEGIN:VEVENT", "UID:u6", "DTSTART:20300108T090000Z", "RRULE:FREQ=WEEKLY;BYDAY=TU", "EXDATE:20300122T090000Z,20300129T090000Z", "SUMMARY:Standup", "END:VEVENT"),
+			"",
+			func(inv *Invitation) bool {
+				return inv.Summary == "Standup" && inv.RecurrenceID == "" && inv.Recurrence == "FREQ=WEEKLY;BYDAY=TU" && len(inv.ExDates) == 2
+			},
+		},
+		{
+			"override alone",
+			calendar("METHOD:CANCEL", "BEGIN:VEVENT", "UID:u7", "RECURRENCE-ID:20300115T090000Z", "DTSTART:20300115T090000Z", "END:VEVENT"),
+			"",
+			func(inv *Invitation) bool {
+				return inv.Method == InviteMethodCancel && inv.RecurrenceID == "2030-01-15T09:00:00Z"
+			},
+		},
+		{
+			"method from Content-Type",
+			calendar("BEGIN:VEVENT", "UID:u8", "DTSTART:20300108T090000Z", "SEQUENCE:3",
+				`ORGANIZER;CN="Boss, The":mailto:boss@corp.example`, "ATTENDEE;RSVP=TRUE:mailto:me@example.com", "END:VEVENT"),
+			"cancel",
+			func(inv *Invitation) bool {
+				return inv.Method == InviteMethodCancel && inv.Sequence == 3 && inv.Organizer.Name == "Boss, The" &&
+					len(inv.Attendees) == 1 && inv.Attendees[0].Status == PartStatNeedsAction && inv.Attendees[0].RSVP
+			},
+		},
+	}
+	for _, tc := range cases {
+		inv, err := parseInvitation(tc.data, tc.method)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !tc.check(inv) {
+			t.Errorf("%s: parsed %+v", tc.name, inv)
+		}
+	}
+
+	for _, bad := range []string{"", "BEGIN:VEVENT\r\nEND:VEVENT\r\n", calendar("BEGIN:VTODO", "END:VTODO")} {
+		if _, err := parseInvitation(bad, ""); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestInviteReplyGolden(t *testing.T) {
+	request := calendar("METHOD:REQUEST", "BEGIN:VEVENT", "UID:weekly-42@corp.example", "SEQUENCE:2",
+		"RECURRENCE-ID:20300115T090000Z", "DTSTART:20300115T100000Z", "SUMMARY:Planning\\, Q1",
+		`ORGANIZER;CN="The Boss":mailto:boss@corp.example`,
+		"ATTENDEE;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:me@example.com", "END:VEVENT")
+	inv, err := parseInvitation(request, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := buildInviteReply(inv, Address{Name: "Me", Email: "me@example.com"}, PartStatAccepted)
+	reply = regexp.MustCompile(`DTSTAMP:\d{8}T\d{6}Z`).ReplaceAllString(reply, "DTSTAMP:20300101T000000Z")
+	golden := "BEGIN:VCALENDAR\r\n" +
+		"PRODID:-//wmail//EN\r\n" +
+		"VERSION:2.0\r\n" +
+		"METHOD:REPLY\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:weekly-42@corp.example\r\n" +
+		"SEQUENCE:2\r\n" +
+		"RECURRENCE-ID:20300115T090000Z\r\n" +
+		"DTSTAMP:20300101T000000Z\r\n" +
+		"ORGANIZER;CN=\"The Boss\":mailto:boss@corp.example\r\n" +
+		"ATTENDEE;PARTSTAT=ACCEPTED;CN=\"Me\":mailto:me@example.com\r\n" +
+		"SUMMARY:Planning\\, Q1\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	if reply != golden {
+		t.Errorf("reply:\n%s\nwant:\n%s", reply, golden)
+	}
+
+	// The organizer's client must be able to read it back
+	parsed, err := parseInvitation(reply, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method != InviteMethodReply || parsed.UID != inv.UID || parsed.Sequence != inv.Sequence ||
+		len(parsed.Attendees) != 1 || parsed.Attendees[0].Status != PartStatAccepted {
+		t.Errorf("reply parsed as %+v", parsed)
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RSVP answers accepted by RespondToInvite
+const (
+	RSVPAccept    = "accept"
+	RSVPTentative = "tentative"
+	RSVPDecline   = "decline"
+)
+
+var rsvpPartStats = map[string]string{
+	RSVPAccept:    PartStatAccepted,
+	RSVPTentative: PartStatTentative,
+	RSVPDecline:   PartStatDeclined,
+}
+
+var rsvpSubjectPrefixes = map[string]string{
+	PartStatAccepted:  "Accepted",
+	PartStatTentative: "Tentative",
+	PartStatDeclined:  "Declined",
+}
+
+var rsvpVerbs = map[string]string{
+	PartStatAccepted:  "accepted",
+	PartStatTentative: "tentatively accepted",
+	PartStatDeclined:  "declined",
+}
+
+// RespondToInvite answers a meeting request with accept, tentative or
+// decline by mailing an iTIP REPLY to the organizer
+func (s *MailService) RespondToInvite(accountID, folder string, uid uint32, response string) error {
+	partStat, ok := rsvpPartStats[strings.ToLower(response)]
+	if !ok {
+		return fmt.Errorf("unknown response: %s", response)
+	}
+
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil {
+		return err
+	}
+	email, err := s.GetEmail(accountID, folder, uid)
+	if err != nil {
+		return err
+	}
+
+	inv := email.Invitation
+	if inv == nil {
+		return fmt.Errorf("message is not an invitation")
+	}
+	if inv.Method != InviteMethodRequest {
+		return fmt.Errorf("cannot respond to a %s", strings.ToLower(inv.Method))
+	}
+	if inv.Organizer == nil || inv.Organizer.Email == "" {
+		return fmt.Errorf("invitation has no organizer")
+	}
+
+	me := inviteAttendee(inv, account)
+	reply := buildInviteReply(inv, me, partStat)
+
+	msg := &outgoingMessage{
+		From:      Address{Name: account.Name, Email: account.Email},
+		To:        []Address{{Name: inv.Organizer.Name, Email: inv.Organizer.Email}},
+		Subject:   rsvpSubjectPrefixes[partStat] + ": " + inv.Summary,
+		Text:      fmt.Sprintf("%s has %s this invitation.", me.Display(), rsvpVerbs[partStat]),
+		InReplyTo: email.MessageID,
+		Calendar:  &calendarPart{Method: InviteMethodReply, Data: reply},
+	}
+	if email.MessageID != "" {
+		msg.References = []string{email.MessageID}
+	}
+	if err := s.deliver(account, msg); err != nil {
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+
+	// Remember the answer so the invitation shows it
+	inv.Response = partStat
+	for _, a := range inv.Attendees {
+		if strings.EqualFold(a.Email, me.Email) {
+			a.Status = partStat
+		}
+	}
+	if s.cache != nil {
+		if err := s.cache.UpdateEmailBodyByUID(accountID, folder, uid, email.Body, inv); err != nil {
+			fmt.Printf("[RespondToInvite] Failed to update cache: %v\n", err)
+		}
+	}
+	s.runInvitationHooks(accountID, email, true)
+	return nil
+}
+
+// InvitationHook lets another service follow the invitations the user
+// opens and answers. Either callback may be nil.
+type InvitationHook struct {
+	Name string
+	// OnView runs each time a message with an invitation is opened and may
+	// annotate its Invitation
+	OnView func(accountID string, email *Email)
+	// OnRespond runs after the user answered a meeting request
+	OnRespond func(accountID string, email *Email)
+}
+
+// RegisterInvitationHook adds a hook that runs when invitations are opened or answered
+func (s *MailService) RegisterInvitationHook(hook InvitationHook) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.invitationHooks = append(s.invitationHooks, hook)
+}
+
+func (s *MailService) runInvitationHooks(accountID string, email *Email, responded bool) {
+	s.hooksMutex.RLock()
+	hooks := append([]InvitationHook(nil), s.invitationHooks...)
+	s.hooksMutex.RUnlock()
+
+	for _, hook := range hooks {
+		if responded && hook.OnRespond != nil {
+			hook.OnRespond(accountID, email)
+		} else if !responded && hook.OnView != nil {
+			hook.OnView(accountID, email)
+		}
+	}
+}
+
+// inviteAttendee finds the account among the attendees. If it was invited
+// through a list or alias the reply comes from the account's own address.
+func inviteAttendee(inv *Invitation, account *Account) Address {
+	for _, a := range inv.Attendees {
+		if strings.EqualFold(a.Email, account.Email) || (account.Username != "" && strings.EqualFold(a.Email, account.Username)) {
+			name := a.Name
+			if name == "" {
+				name = account.Name
+			}
+			return Address{Name: name, Email: a.Email}
+		}
+	}
+	return Address{Name: account.Name, Email: account.Email}
+}
+
+// encodeInvitation serializes an invitation for the cache
+func encodeInvitation(inv *Invitation) string {
+	if inv == nil {
+		return ""
+	}
+	stored := *inv
+	stored.Conflicts = nil
+	stored.Unverified = false
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodeInvitation reads an invitation stored by encodeInvitation
+func decodeInvitation(s string) *Invitation {
+	if s == "" {
+		return nil
+	}
+	var inv Invitation
+	if err := json.Unmarshal([]byte(s), &inv); err != nil {
+		return nil
+	}
+	return &inv
+}
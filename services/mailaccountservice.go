@@ -1,37 +1,77 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/emersion/go-imap"
 )
 
 // Account represents an email account configuration
 type Account struct {
-	ID         string   `json:"id"`
-	Name       string   `json:"name"`
-	Email      string   `json:"email"`
-	IMAPHost   string   `json:"imapHost"`
-	IMAPPort   int      `json:"imapPort"`
-	IMAPUseSSL bool     `json:"imapUseSSL"`
-	SMTPHost   string   `json:"smtpHost"`
-	SMTPPort   int      `json:"smtpPort"`
-	SMTPUseSSL bool     `json:"smtpUseSSL"`
-	Username   string   `json:"username"`
-	Password   string   `json:"password"` // In production, this should be encrypted
-	CreatedAt  string   `json:"createdAt"`
-	Folders    []Folder `json:"folders"`
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Email       string       `json:"email"`
+	IMAPHost    string       `json:"imapHost"`
+	IMAPPort    int          `json:"imapPort"`
+	IMAPUseSSL  bool         `json:"imapUseSSL"`
+	SMTPHost    string       `json:"smtpHost"`
+	SMTPPort    int          `json:"smtpPort"`
+	SMTPUseSSL  bool         `json:"smtpUseSSL"`
+	Username    string       `json:"username"`
+	Password    string       `json:"password"`   // In production, this should be encrypted
+	AuthMethod  string       `json:"authMethod"` // password, xoauth2 or oauthbearer
+	OAuth       *OAuthConfig `json:"oauth,omitempty"`
+	CacheLimits *CacheLimits `json:"cacheLimits,omitempty"`
+	AuthServID  string       `json:"authServId,omitempty"` // trusted Authentication-Results, guessed when empty
+	Aliases     []string     `json:"aliases,omitempty"`    // other addresses delivered to this account
+	CreatedAt   string       `json:"createdAt"`
+	Folders     []Folder     `json:"folders"`
+}
+
+// ownsAddress reports whether mail to an address reaches this account
+func (a *Account) ownsAddress(address string) bool {
+	if strings.EqualFold(address, a.Email) || (a.Username != "" && strings.EqualFold(address, a.Username)) {
+		return true
+	}
+	for _, alias := range a.Aliases {
+		if strings.EqualFold(address, alias) {
+			return true
+		}
+	}
+	return false
 }
 
 // MailAccountService manages email accounts
 type MailAccountService struct {
 	accounts      map[string]*Account
 	accountsMutex sync.RWMutex
-	accountsPath  string
+	accountsFile  *ConfigFile
+	hooks         []AccountLifecycleHook
+	hooksMutex    sync.RWMutex
+}
+
+// accountsMigrations upgrades accounts.json; index i migrates version i to i+1
+var accountsMigrations = []ConfigMigration{
+	// 0 -> 1: legacy bare array; accounts predating OAuth use password auth
+	func(data json.RawMessage) (json.RawMessage, error) {
+		var accounts []map[string]interface{}
+		if err := json.Unmarshal(data, &accounts); err != nil {
+			return nil, err
+		}
+		for _, acc := range accounts {
+			if method, _ := acc["authMethod"].(string); method == "" {
+				acc["authMethod"] = AuthMethodPassword
+			}
+		}
+		return json.Marshal(accounts)
+	},
 }
 
 // NewMailAccountService creates a new account service
@@ -48,8 +88,9 @@ func NewMailAccountService() *MailAccountService {
 
 	s := &MailAccountService{
 		accounts:     make(map[string]*Account),
-		accountsPath: accountsPath,
+		accountsFile: NewConfigFile(accountsPath, 0600, accountsMigrations...),
 	}
+	s.RegisterLifecycleHook(credentialsLifecycleHook())
 
 	s.loadAccounts()
 	return s
@@ -57,36 +98,28 @@ func NewMailAccountService() *MailAccountService {
 
 // loadAccounts loads accounts from file
 func (s *MailAccountService) loadAccounts() error {
-	data, err := os.ReadFile(s.accountsPath)
-	if err != nil {
+	var accounts []*Account
+	if err := s.accountsFile.Load(&accounts); err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
-		return err
-	}
-
-	var accounts []*Account
-	if err := json.Unmarshal(data, &accounts); err != nil {
+		fmt.Printf("[MailAccountService] Failed to load accounts: %v\n", err)
 		return err
 	}
 
 	s.accountsMutex.Lock()
-	defer s.accountsMutex.Unlock()
-
-	var infoChanged = false
+	var missingFolders []string
 	for _, acc := range accounts {
 		s.accounts[acc.ID] = acc
 		if len(acc.Folders) == 0 {
-			folders, err := s.fetchFoldersForAccount(acc)
-			if err == nil {
-				acc.Folders = folders
-			}
-			infoChanged = true
+			missingFolders = append(missingFolders, acc.ID)
 		}
 	}
-	
-	if infoChanged {
-		s.saveAccounts()
+	s.accountsMutex.Unlock()
+
+	// Folder discovery needs the network, so never block startup on it
+	for _, id := range missingFolders {
+		go s.refreshFoldersInBackground(id)
 	}
 
 	return nil
@@ -100,12 +133,7 @@ func (s *MailAccountService) saveAccounts() error {
 		accounts = append(accounts, acc)
 	}
 
-	data, err := json.MarshalIndent(accounts, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(s.accountsPath, data, 0600)
+	return s.accountsFile.Save(accounts)
 }
 
 // GetAccounts returns all accounts
@@ -140,23 +168,26 @@ func (s *MailAccountService) AddAccount(account *Account) (*Account, error) {
 	if account.CreatedAt == "" {
 		account.CreatedAt = getCurrentTime()
 	}
+	if account.AuthMethod == "" {
+		account.AuthMethod = AuthMethodPassword
+	}
 
 	s.accountsMutex.Lock()
+	if _, exists := s.accounts[account.ID]; exists {
+		s.accountsMutex.Unlock()
+		return nil, fmt.Errorf("account already exists")
+	}
 	s.accounts[account.ID] = account
-	
-	if len(account.Folders) == 0 {
-		f, err := s.fetchFoldersForAccount(account); 
-		if err != nil {
-			return nil, err
-		}
-		s.accounts[account.ID].Folders = f
+	if err := s.saveAccounts(); err != nil {
+		// Keep the map in sync with what is on disk
+		delete(s.accounts, account.ID)
+		s.accountsMutex.Unlock()
+		return nil, err
 	}
-	
-	err := s.saveAccounts()
 	s.accountsMutex.Unlock()
 
-	if err != nil {
-		return nil, err
+	if len(account.Folders) == 0 {
+		go s.refreshFoldersInBackground(account.ID)
 	}
 
 	return account, nil
@@ -167,28 +198,42 @@ func (s *MailAccountService) UpdateAccount(account *Account) error {
 	s.accountsMutex.Lock()
 	defer s.accountsMutex.Unlock()
 
-	if _, exists := s.accounts[account.ID]; !exists {
+	previous, exists := s.accounts[account.ID]
+	if !exists {
 		return fmt.Errorf("account not found")
 	}
 
 	s.accounts[account.ID] = account
-
-	return s.saveAccounts()
+	if err := s.saveAccounts(); err != nil {
+		s.accounts[account.ID] = previous
+		return err
+	}
+	return nil
 }
 
-// DeleteAccount deletes an account
+// DeleteAccount deletes an account and cascades through every subsystem
+// holding data for it, see RegisterLifecycleHook
 func (s *MailAccountService) DeleteAccount(id string) error {
 	s.accountsMutex.Lock()
-	defer s.accountsMutex.Unlock()
-
-	if _, exists := s.accounts[id]; !exists {
+	previous, exists := s.accounts[id]
+	if !exists {
+		s.accountsMutex.Unlock()
 		return fmt.Errorf("account not found")
 	}
 
-	fmt.Println("Before delete")
 	delete(s.accounts, id)
-	fmt.Println("After delete")
-	return s.saveAccounts()
+	if err := s.saveAccounts(); err != nil {
+		s.accounts[id] = previous
+		s.accountsMutex.Unlock()
+		return err
+	}
+	s.accountsMutex.Unlock()
+
+	// Cleanup may be slow, so it runs without the account lock
+	if err := s.runDeleteHooks(previous); err != nil {
+		return fmt.Errorf("account deleted, but cleanup failed: %w", err)
+	}
+	return nil
 }
 
 // GetFolders retrieves folders for an account
@@ -216,6 +261,7 @@ func (s *MailAccountService) GetFolders(accountID string) ([]*Folder, error) {
 	for m := range mailboxes {
 		folder := &Folder{
 			Name: m.Name,
+			Role: mailboxRole(m),
 		}
 
 		status, err := c.Status(m.Name, []imap.StatusItem{imap.StatusUnseen, imap.StatusMessages})
@@ -253,6 +299,7 @@ func (s *MailAccountService) SyncFolders(accountID string) error {
 	for i, f := range folders {
 		acc.Folders[i] = Folder{
 			Name:   f.Name,
+			Role:   f.Role,
 			Unread: f.Unread,
 			Total:  f.Total,
 		}
@@ -260,6 +307,40 @@ func (s *MailAccountService) SyncFolders(accountID string) error {
 	return s.saveAccounts()
 }
 
+// refreshFoldersInBackground discovers folders for an account and stores them.
+// The network work happens on a snapshot so the account lock is never held across it.
+func (s *MailAccountService) refreshFoldersInBackground(accountID string) {
+	s.accountsMutex.RLock()
+	acc, exists := s.accounts[accountID]
+	var snapshot Account
+	if exists {
+		snapshot = *acc
+	}
+	s.accountsMutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	folders, err := s.fetchFoldersForAccount(&snapshot)
+	if err != nil {
+		fmt.Printf("[MailAccountService] Folder discovery failed for %s: %v\n", snapshot.Email, err)
+		return
+	}
+
+	s.accountsMutex.Lock()
+	defer s.accountsMutex.Unlock()
+
+	// The account may have been deleted while we were fetching
+	acc, exists = s.accounts[accountID]
+	if !exists {
+		return
+	}
+	acc.Folders = folders
+	if err := s.saveAccounts(); err != nil {
+		fmt.Printf("[MailAccountService] Failed to save folders for %s: %v\n", snapshot.Email, err)
+	}
+}
+
 // fetchFoldersForAccount fetches folders for an account without modifying the map
 func (s *MailAccountService) fetchFoldersForAccount(account *Account) ([]Folder, error) {
 	c, err := ConnectIMAP(account)
@@ -280,6 +361,7 @@ func (s *MailAccountService) fetchFoldersForAccount(account *Account) ([]Folder,
 	for m := range mailboxes {
 		folder := Folder{
 			Name: m.Name,
+			Role: mailboxRole(m),
 		}
 
 		status, err := c.Status(m.Name, []imap.StatusItem{imap.StatusUnseen, imap.StatusMessages})
@@ -297,3 +379,63 @@ func (s *MailAccountService) fetchFoldersForAccount(account *Account) ([]Folder,
 
 	return folders, nil
 }
+
+// GetOAuthProviders returns the built-in OAuth provider presets
+func (s *MailAccountService) GetOAuthProviders() []OAuthConfig {
+	providers := make([]OAuthConfig, 0, len(oauthProviders))
+	for _, p := range oauthProviders {
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// AuthorizeOAuth runs the browser-based OAuth flow for an account and stores the token
+func (s *MailAccountService) AuthorizeOAuth(accountID string) error {
+	account, err := s.GetAccount(accountID)
+	if err != nil {
+		return err
+	}
+	if account.AuthMethod != AuthMethodXOAuth2 && account.AuthMethod != AuthMethodOAuthBearer {
+		return fmt.Errorf("account does not use OAuth")
+	}
+
+	cfg := resolveOAuthConfig(account.OAuth)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	token, err := authorizeOAuth(ctx, cfg, account.Email, openBrowser)
+	if err != nil {
+		return err
+	}
+	if err := getTokenStore().Save(accountID, token); err != nil {
+		return err
+	}
+
+	// Folder discovery was skipped at creation while there was no token
+	go s.refreshFoldersInBackground(accountID)
+	return nil
+}
+
+// resolveOAuthConfig fills missing endpoints from the provider preset
+func resolveOAuthConfig(cfg *OAuthConfig) *OAuthConfig {
+	if cfg == nil {
+		return nil
+	}
+	preset, ok := oauthProviders[cfg.Provider]
+	if !ok {
+		return cfg
+	}
+
+	resolved := *cfg
+	if resolved.AuthURL == "" {
+		resolved.AuthURL = preset.AuthURL
+	}
+	if resolved.TokenURL == "" {
+		resolved.TokenURL = preset.TokenURL
+	}
+	if len(resolved.Scopes) == 0 {
+		resolved.Scopes = preset.Scopes
+	}
+	return &resolved
+}
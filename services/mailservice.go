@@ -2,39 +2,50 @@ package services
 
 import (
 	"bytes"
-	"crypto/tls"
 	"fmt"
 	"io"
+	"mime"
 	"regexp"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-imap"
-	"github.com/emersion/go-imap/client"
 	"github.com/emersion/go-message/mail"
 )
 
 // Email represents an email message
 type Email struct {
-	ID        string   `json:"id"`
-	AccountID string   `json:"accountId"`
-	Folder    string   `json:"folder"`
-	UID       uint32   `json:"uid"`
-	From      string   `json:"from"`
-	To        []string `json:"to"`
-	CC        []string `json:"cc"`
-	Subject   string   `json:"subject"`
-	Date      string   `json:"date"`
-	Body      string   `json:"body"`
-	IsRead    bool     `json:"isRead"`
-	IsStarred bool     `json:"isStarred"`
-	CreatedAt string   `json:"createdAt"`
+	ID             string            `json:"id"`
+	AccountID      string            `json:"accountId"`
+	Folder         string            `json:"folder"`
+	UID            uint32            `json:"uid"`
+	MessageID      string            `json:"messageId"`
+	From           []Address         `json:"from"`
+	Sender         []Address         `json:"sender"` // only set when it differs from From
+	ReplyTo        []Address         `json:"replyTo"`
+	To             []Address         `json:"to"`
+	CC             []Address         `json:"cc"`
+	Subject        string            `json:"subject"`
+	Date           string            `json:"date"`
+	InternalDate   string            `json:"internalDate"` // when the server received it (RFC3339, UTC)
+	Body           string            `json:"body"`
+	Invitation     *Invitation       `json:"invitation,omitempty"`     // meeting request, cancellation or reply
+	Security       *MessageSecurity  `json:"security,omitempty"`       // set on signed or encrypted messages
+	Authentication *AuthVerdict      `json:"authentication,omitempty"` // SPF, DKIM and DMARC verdict on the sender
+	Warnings       []*MessageWarning `json:"warnings,omitempty"`       // phishing indicators for the reader view
+	SpamScore      *float64          `json:"spamScore,omitempty"`      // 0 (good) to 1 (spam), unset until the filter is trained
+	IsRead         bool              `json:"isRead"`
+	IsStarred      bool              `json:"isStarred"`
+	CreatedAt      string            `json:"createdAt"`
+
+	sentDate int64 // sent_date as cached, which can differ from Date in migrated rows; 0 when not read from the cache
 }
 
 // Folder represents a mailbox folder
 type Folder struct {
 	Name   string `json:"name"`
+	Role   string `json:"role,omitempty"` // special-use role, empty for ordinary folders
 	Unread int    `json:"unread"`
 	Total  int    `json:"total"`
 }
@@ -43,6 +54,20 @@ type Folder struct {
 type MailService struct {
 	accountService *MailAccountService
 	cache          *EmailCache
+	replayer       *offlineReplayer
+	settings       *mailSettingsStore
+	keyring        *pgpKeyring
+	smime          *smimeStore
+	// TXTResolver looks up DKIM keys; nil uses the system resolver
+	TXTResolver TXTResolver
+
+	contacts        contactDirectory
+	invitationHooks []InvitationHook
+	newMailHooks    []NewMailHook
+	hooksMutex      sync.RWMutex
+
+	backfills     map[string]bool // folders whose first sync is still fetching headers
+	backfillMutex sync.Mutex
 }
 
 // NewMailService creates a new mail service
@@ -57,31 +82,59 @@ func NewMailService(accountService *MailAccountService) *MailService {
 		cache = nil
 	} else {
 		fmt.Printf("[MailService] Cache initialized at %s\n", cachePath)
+		go cache.CollectGarbage()
 	}
 
-	return &MailService{
+	s := &MailService{
 		accountService: accountService,
 		cache:          cache,
+		settings:       newMailSettingsStore(),
 	}
+	passphraseTimeout := func() time.Duration {
+		return time.Duration(s.settings.get().PassphraseTimeout) * time.Minute
+	}
+	s.keyring = newPGPKeyring(passphraseTimeout)
+	s.smime = newSMIMEStore(passphraseTimeout)
+
+	if cache != nil {
+		accountService.RegisterLifecycleHook(cache.lifecycleHook())
+		go s.runCacheEvictor()
+
+		s.replayer = newOfflineReplayer(s)
+		go s.replayer.run()
+	}
+
+	return s
 }
 
-// GetEmails retrieves emails from a folder with cache support
-// forceRefresh: if true, bypass cache and fetch from server
-func (s *MailService) GetEmails(accountID, folder string, page, pageSize int, forceRefresh bool) ([]*Email, error) {
-	fmt.Printf("[GetEmails] ENTRY - accountID=%s, folder=%s, page=%d, pageSize=%d, forceRefresh=%t\n",
-		accountID, folder, page, pageSize, forceRefresh)
+// GetEmails returns a page of a folder, newest first, with cache support.
+// cursor is empty for the first page and NextCursor of the previous page after that.
+// forceRefresh: if true, sync the folder with the server before reading it
+func (s *MailService) GetEmails(accountID, folder, cursor string, pageSize int, forceRefresh bool) (*EmailPage, error) {
+	fmt.Printf("[GetEmails] ENTRY - accountID=%s, folder=%s, cursor=%q, pageSize=%d, forceRefresh=%t\n",
+		accountID, folder, cursor, pageSize, forceRefresh)
 
-	shouldRefresh := forceRefresh
+	after, err := parseEmailCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	sortBy := s.settings.get().SortBy
+	if after != nil {
+		sortBy = after.sortBy
+	}
 
-	// Try to get from cache first (unless force refresh is requested)
-	if !shouldRefresh && s.cache != nil {
-		fmt.Printf("[GetEmails] Checking cache...\n")
-		cachedCount, err := s.cache.GetCachedCount(accountID, folder)
-		if err == nil && cachedCount > 0 {
+	// Serve from cache unless a refresh is requested or nothing is cached yet.
+	// Later pages always come from the cache so they match the first one.
+	cachedCount := 0
+	if s.cache != nil {
+		cachedCount, _ = s.cache.GetCachedCount(accountID, folder)
+		if cachedCount > 0 && (!forceRefresh || after != nil) {
 			fmt.Printf("[GetEmails] Found %d cached emails, returning from cache\n", cachedCount)
-			return s.cache.GetCachedEmails(accountID, folder, page, pageSize)
+			return s.cache.GetEmailPage(accountID, folder, sortBy, after, pageSize)
 		}
-		fmt.Printf("[GetEmails] No cached emails found (count=%d, err=%v)\n", cachedCount, err)
 	}
 
 	account, err := s.accountService.GetAccount(accountID)
@@ -91,6 +144,27 @@ func (s *MailService) GetEmails(accountID, folder string, page, pageSize int, fo
 	}
 	fmt.Printf("[GetEmails] Found account: %s\n", account.Email)
 
+	emails, err := s.syncFolder(account, folder)
+	if err != nil {
+		if isNetworkError(err) && cachedCount > 0 {
+			// Offline: the cached listing is better than nothing
+			fmt.Printf("[GetEmails] Sync failed, serving cache: %v\n", err)
+			if s.replayer != nil {
+				s.replayer.setState(false, err.Error())
+			}
+			return s.cache.GetEmailPage(accountID, folder, sortBy, after, pageSize)
+		}
+		return nil, err
+	}
+
+	if s.cache == nil {
+		return pageEmails(emails, sortBy, after, pageSize), nil
+	}
+	return s.cache.GetEmailPage(accountID, folder, sortBy, after, pageSize)
+}
+
+// syncFolder connects to the server and syncs one folder's headers
+func (s *MailService) syncFolder(account *Account, folder string) ([]*Email, error) {
 	c, err := ConnectIMAP(account)
 	if err != nil {
 		return nil, err
@@ -99,166 +173,183 @@ func (s *MailService) GetEmails(accountID, folder string, page, pageSize int, fo
 
 	// Select mailbox
 	fmt.Printf("[GetEmails] Attempting to select folder: %s\n", folder)
-	mbox, err := c.Select(folder, false)
+	mbox, err := c.Select(folder, true)
 	if err != nil {
 		fmt.Printf("[GetEmails] Failed to select folder '%s': %v\n", folder, err)
 		return nil, err
 	}
 	fmt.Printf("[GetEmails] Successfully selected folder: %s, Messages: %d\n", mbox.Name, mbox.Messages)
 
-	// Return empty if mailbox is empty
+	if s.cache != nil {
+		reset, err := s.cache.CheckUIDValidity(account.ID, folder, mbox.UidValidity)
+		if err != nil {
+			return nil, err
+		}
+		if reset {
+			fmt.Printf("[GetEmails] UIDVALIDITY of %s changed, cleared its cache\n", folder)
+		}
+	}
+
 	if mbox.Messages == 0 {
 		fmt.Println("[GetEmails] The mailbox is empty!")
+		if s.cache != nil {
+			if err := s.cache.DeleteEmails(account.ID, folder); err != nil {
+				return nil, err
+			}
+		}
 		return []*Email{}, nil
 	}
 
-	fmt.Printf("[GetEmails] Total messages: %d\n", mbox.Messages)
-
-	// Calculate message range for pagination
-	from := uint32(1)
-	to := mbox.Messages
-	fmt.Printf("[GetEmails] Initial range: from=%d, to=%d\n", from, to)
-	fmt.Printf("[GetEmails] Pagination params: page=%d, pageSize=%d\n", page, pageSize)
-	if pageSize > 0 && page > 0 {
-		// Pagination: calculate start and end for reverse chronological order
-		offset := uint32((page - 1) * pageSize)
-		start := mbox.Messages - offset
-
-		// Calculate end using int to avoid uint32 overflow
-		endInt := int(start) - pageSize + 1
-		if endInt < 1 {
-			endInt = 1
-		}
-		end := uint32(endInt)
+	emails, rest, err := s.syncFolderHeaders(c, account.ID, folder)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		go s.backfillHeaders(account, folder, mbox.UidValidity, rest)
+	}
+	return emails, nil
+}
 
-		fmt.Printf("[GetEmails] Calculated start=%d, end=%d\n", start, end)
+// GetEmail retrieves a specific email with body, with cache support, and
+// lets invitation hooks annotate a meeting request it carries
+func (s *MailService) GetEmail(accountID, folder string, uid uint32) (*Email, error) {
+	email, err := s.getEmail(accountID, folder, uid)
+	if err != nil {
+		return nil, err
+	}
+	if email.Invitation != nil {
+		s.runInvitationHooks(accountID, email, false)
+	}
+	s.checkSender(email)
+	s.checkImpersonation(accountID, folder, email)
+	return email, nil
+}
 
-		// Also make sure start doesn't go above the total messages
-		if start > mbox.Messages {
-			start = mbox.Messages
+func (s *MailService) getEmail(accountID, folder string, uid uint32) (*Email, error) {
+	// First try to find from cache by UID
+	if s.cache != nil {
+		email, err := s.cache.GetCachedEmailByUID(accountID, folder, uid)
+		if err == nil && email.Body != "" {
+			fmt.Printf("[GetEmail] Found in cache: %s\n", email.ID)
+			if email.Authentication == nil || email.Warnings == nil {
+				// Cached before verdicts and link warnings existed
+				if raw, rawErr := s.cache.GetRawMessage(accountID, folder, uid); rawErr == nil {
+					if email.Authentication == nil {
+						email.Authentication = s.authenticateMessage(accountID, folder, uid, raw)
+					}
+					if email.Warnings == nil {
+						if content, parseErr := extractContent(raw); parseErr == nil {
+							email.Warnings = content.Warnings
+							s.cacheWarnings(accountID, folder, uid, content.Warnings)
+						}
+					}
+				}
+			}
+			s.cache.TouchEmail(accountID, folder, uid)
+			return email, nil
 		}
 
-		// If after boundary checks, end > start, set end to 1
-		if end > start {
-			end = 1
+		// Re-parse the stored source instead of downloading it again.
+		// Signed and encrypted messages always take this path: their
+		// plaintext is never cached and their signature is checked anew.
+		if err == nil {
+			if raw, rawErr := s.cache.GetRawMessage(accountID, folder, uid); rawErr == nil {
+				received, _ := time.Parse(time.RFC3339, email.InternalDate)
+				if content, parseErr := s.readContent(accountID, folder, raw, received); parseErr == nil {
+					email.Body = content.Body
+					email.Invitation = content.Invitation
+					email.Security = content.Security
+					email.Warnings = content.Warnings
+					if email.Authentication == nil {
+						email.Authentication = s.authenticateMessage(accountID, folder, uid, raw)
+					}
+					if content.Security == nil {
+						if err := s.cache.UpdateEmailBodyByUID(accountID, folder, uid, content.Body, content.Invitation); err != nil {
+							fmt.Printf("[GetEmail] Failed to update cache: %v\n", err)
+						}
+						s.cacheWarnings(accountID, folder, uid, content.Warnings)
+					}
+					fmt.Printf("[GetEmail] Parsed from stored source: %s\n", email.ID)
+					s.cache.TouchEmail(accountID, folder, uid)
+					return email, nil
+				}
+			}
 		}
-
-		from = end
-		to = start
-		fmt.Printf("[GetEmails] Final pagination range: from=%d, to=%d\n", from, to)
 	}
 
-	// Ensure from <= to
-	fmt.Printf("[GetEmails] Final range: from=%d, to=%d\n", from, to)
-	if from > to {
-		fmt.Printf("[GetEmails] ERROR: from > to, returning empty array\n")
-		return []*Email{}, nil
-	}
-
-	// Get message sequence set
-	seqset := new(imap.SeqSet)
-	seqset.AddRange(from, to)
-	fmt.Printf("[GetEmails] Fetching range: %d to %d\n", from, to)
-
-	// Get messages
-	messages := make(chan *imap.Message, pageSize)
-	done := make(chan error, 1)
-
-	go func() {
-		fetchErr := c.Fetch(seqset, []imap.FetchItem{
-			imap.FetchEnvelope,
-			imap.FetchUid,
-		}, messages)
-		fmt.Printf("[GetEmails] Fetch goroutine completed, err: %v\n", fetchErr)
-		done <- fetchErr
-	}()
-
-	var emails []*Email
-	fmt.Printf("[GetEmails] Starting to receive messages...\n")
-	count := 0
-
-	for msg := range messages {
-		count++
-		fmt.Printf("[GetEmails] Received message %d, UID: %d, Subject: %s\n", count, msg.Uid, msg.Envelope.Subject)
-		email := &Email{
-			ID:        generateUUID(),
-			AccountID: accountID,
-			Folder:    folder,
-			UID:       msg.Uid,
-			From:      formatAddress(msg.Envelope.From),
-			To:        formatAddressList(msg.Envelope.To),
-			CC:        formatAddressList(msg.Envelope.Cc),
-			Subject:   msg.Envelope.Subject,
-			Date:      msg.Envelope.Date.Format(time.RFC3339),
-			Body:      "", // Body is empty in list view, will fetch when viewing individual email
-			CreatedAt: getCurrentTime(),
+	msg, raw, err := s.fetchRawMessage(accountID, folder, uid)
+	if err != nil {
+		if isNetworkError(err) && s.replayer != nil {
+			s.replayer.setState(false, err.Error())
+			return nil, fmt.Errorf("offline and message is not cached: %w", err)
 		}
-
-		emails = append(emails, email)
+		return nil, err
 	}
 
-	fmt.Printf("[GetEmails] Fetch complete, total emails fetched: %d\n", len(emails))
-
-	if err := <-done; err != nil {
-		fmt.Printf("[GetEmails] Fetch error: %v\n", err)
+	content, err := s.readContent(accountID, folder, raw, msg.InternalDate)
+	if err != nil {
 		return nil, err
 	}
 
-	// Sort emails by date in descending order (newest first)
-	sort.Slice(emails, func(i, j int) bool {
-		dateI, _ := time.Parse(time.RFC3339, emails[i].Date)
-		dateJ, _ := time.Parse(time.RFC3339, emails[j].Date)
-		return dateJ.Before(dateI) // j before i means descending
-	})
-	fmt.Printf("[GetEmails] Sorted emails by date (descending)\n")
-
-	// Cache the fetched emails
-	if s.cache != nil && len(emails) > 0 {
-		// First delete old cached emails for this folder
-		if err := s.cache.DeleteEmails(accountID, folder); err != nil {
-			fmt.Printf("[GetEmails] Failed to delete old cache: %v\n", err)
-		}
+	email := emailFromMessage(accountID, folder, msg)
+	email.Body = content.Body
+	email.Invitation = content.Invitation
+	email.Security = content.Security
+	email.Warnings = content.Warnings
+	email.IsRead = true
 
-		// Then cache the new emails
-		if err := s.cache.CacheEmails(emails); err != nil {
-			fmt.Printf("[GetEmails] Failed to cache emails: %v\n", err)
-		} else {
-			fmt.Printf("[GetEmails] Successfully cached %d emails\n", len(emails))
-		}
-	}
+	fmt.Printf("[GetEmail] Got Email %s from server, body length: %d\n", email.ID, len(content.Body))
 
-	return emails, nil
-}
-
-// GetEmail retrieves a specific email with body, with cache support
-func (s *MailService) GetEmail(accountID, folder string, uid uint32) (*Email, error) {
-	// First try to find from cache by UID
+	// Update cache with body
 	if s.cache != nil {
-		cachedEmails, err := s.cache.GetCachedEmails(accountID, folder, 1, 1000)
+		stored := *email
+		if email.Security != nil {
+			stored.Body = ""
+			stored.Invitation = nil
+		}
+		cached, err := s.cache.GetCachedEmailByUID(accountID, folder, uid)
 		if err == nil {
-			for _, email := range cachedEmails {
-				if email.UID == uid && email.Body != "" {
-					fmt.Printf("[GetEmail] Found in cache: %s\n", email.ID)
-					return email, nil
-				}
+			if err := s.cache.UpdateEmailBodyByUID(accountID, folder, uid, stored.Body, stored.Invitation); err != nil {
+				fmt.Printf("[GetEmail] Failed to update cache: %v\n", err)
 			}
+			email.ID = cached.ID // Use cached ID
+			email.IsStarred = cached.IsStarred
+		} else if err := s.cache.CacheEmails([]*Email{&stored}); err != nil {
+			// Not in the list cache yet, e.g. opened from a search result
+			fmt.Printf("[GetEmail] Failed to cache email: %v\n", err)
+		}
+
+		if email.Security == nil {
+			s.cacheWarnings(accountID, folder, uid, email.Warnings)
+		}
+
+		// Keep the source so it can be re-parsed or exported without refetching
+		if _, err := s.cache.StoreRawMessage(accountID, folder, uid, raw); err != nil {
+			fmt.Printf("[GetEmail] Failed to store raw message: %v\n", err)
 		}
+		s.cache.TouchEmail(accountID, folder, uid)
 	}
+	email.Authentication = s.authenticateMessage(accountID, folder, uid, raw)
 
+	return email, nil
+}
+
+// fetchRawMessage downloads the envelope and full RFC 5322 source of a message
+func (s *MailService) fetchRawMessage(accountID, folder string, uid uint32) (*imap.Message, []byte, error) {
 	account, err := s.accountService.GetAccount(accountID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	c, err := ConnectIMAP(account)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer c.Close()
 
 	// Select mailbox
 	if _, err := c.Select(folder, false); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Get message sequence set using UID
@@ -269,38 +360,57 @@ func (s *MailService) GetEmail(accountID, folder string, uid uint32) (*Email, er
 	items := []imap.FetchItem{
 		imap.FetchEnvelope,
 		imap.FetchUid,
+		imap.FetchInternalDate,
+		imap.FetchFlags,
 		imap.FetchRFC822,
 	}
 
 	messages := make(chan *imap.Message, 1)
 	if err := c.UidFetch(seqset, items, messages); err != nil {
 		fmt.Printf("[GetEmail] UID Fetch error: %v\n", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	msg := <-messages
 	if msg == nil {
-		return nil, fmt.Errorf("message not found")
+		return nil, nil, fmt.Errorf("message not found")
 	}
 
 	// Get the full RFC822 message
 	r := msg.GetBody(&imap.BodySectionName{BodyPartName: imap.BodyPartName{}})
 	if r == nil {
-		return nil, fmt.Errorf("no body found")
+		return nil, nil, fmt.Errorf("no body found")
 	}
 
 	var fullMessage bytes.Buffer
 	if _, err := io.Copy(&fullMessage, r); err != nil {
-		return nil, fmt.Errorf("failed to read message: %w", err)
+		return nil, nil, fmt.Errorf("failed to read message: %w", err)
 	}
 
+	return msg, fullMessage.Bytes(), nil
+}
+
+// messageContent is what GetEmail shows of a message beyond its envelope
+type messageContent struct {
+	Body       string
+	HTML       bool // Body is the text/html part, there being no text/plain one
+	Invitation *Invitation
+	Security   *MessageSecurity
+	Warnings   []*MessageWarning
+}
+
+// extractContent returns the displayable text of a raw message, preferring
+// text/plain over text/html, and any meeting invitation it carries
+func extractContent(raw []byte) (*messageContent, error) {
 	// Parse the message
-	mr, err := mail.CreateReader(&fullMessage)
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create mail reader: %w", err)
 	}
 
-	var body strings.Builder
+	content := &messageContent{}
+	var plain, html strings.Builder
+	havePlain := false
 
 	// Iterate through parts to find text content
 	for {
@@ -309,81 +419,104 @@ func (s *MailService) GetEmail(accountID, folder string, uid uint32) (*Email, er
 			break
 		}
 		if err != nil {
-			fmt.Printf("[GetEmail] Error reading part: %v, continuing...\n", err)
+			if p == nil {
+				// A broken or truncated multipart cannot be read any further
+				fmt.Printf("[extractContent] Error reading part: %v, stopping\n", err)
+				break
+			}
+			fmt.Printf("[extractContent] Error reading part: %v, continuing...\n", err)
 			continue
 		}
 
-		// Get content type from header using Get method
-		contentType := p.Header.Get("Content-Type")
-		fmt.Printf("[GetEmail] Found part with Content-Type: %s\n", contentType)
-
 		// Parse content type (format: "text/plain; charset=utf-8" or similar)
-		mediaType := strings.ToLower(strings.Split(contentType, ";")[0])
-		mediaType = strings.TrimSpace(mediaType)
+		mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if p.Header.Get("Content-Type") == "" {
+			mediaType = "text/plain"
+		}
+		fmt.Printf("[extractContent] Found part with Content-Type: %s\n", mediaType)
 
-		// Check if this is a text/plain or text/html part
-		if mediaType == "text/plain" || mediaType == "text/html" {
-			if _, err := io.Copy(&body, p.Body); err != nil {
-				fmt.Printf("[GetEmail] Error copying body: %v\n", err)
+		switch mediaType {
+		case "text/plain":
+			if havePlain {
 				continue
 			}
-			// For plain text, we can stop here
-			if mediaType == "text/plain" {
-				break
+			if _, err := io.Copy(&plain, p.Body); err != nil {
+				// Keep what could be decoded of a truncated part
+				fmt.Printf("[extractContent] Error copying body: %v\n", err)
+				havePlain = plain.Len() > 0
+				continue
+			}
+			havePlain = true
+		case "text/html":
+			if html.Len() == 0 {
+				io.Copy(&html, p.Body)
+			}
+		case "text/calendar", "application/ics":
+			if content.Invitation != nil {
+				continue
+			}
+			data, err := io.ReadAll(p.Body)
+			if err != nil {
+				continue
+			}
+			invitation, err := parseInvitation(string(data), params["method"])
+			if err != nil {
+				fmt.Printf("[extractContent] Ignoring invalid calendar part: %v\n", err)
+				continue
 			}
+			content.Invitation = invitation
 		}
 	}
 
+	body := plain.String()
+	if !havePlain {
+		body = html.String()
+		content.HTML = html.Len() > 0
+	}
+	content.Warnings = inspectLinks(html.String())
+
 	// Clean up the body content
-	bodyText := strings.TrimSpace(body.String())
-	bodyText = strings.ReplaceAll(bodyText, "\r\n", "\n")
-
-	email := &Email{
-		ID:        generateUUID(),
-		AccountID: accountID,
-		Folder:    folder,
-		UID:       msg.Uid,
-		From:      formatAddress(msg.Envelope.From),
-		To:        formatAddressList(msg.Envelope.To),
-		CC:        formatAddressList(msg.Envelope.Cc),
-		Subject:   msg.Envelope.Subject,
-		Date:      msg.Envelope.Date.Format(time.RFC3339),
-		Body:      bodyText,
-		IsRead:    true,
-		CreatedAt: getCurrentTime(),
-	}
-
-	fmt.Printf("[GetEmail] Got Email %s from server, body length: %d\n", email.ID, len(bodyText))
+	body = strings.TrimSpace(body)
+	content.Body = strings.ReplaceAll(body, "\r\n", "\n")
 
-	// Update cache with body
-	if s.cache != nil {
-		// Find and update cached email
-		cachedEmails, err := s.cache.GetCachedEmails(accountID, folder, 1, 1000)
-		if err == nil {
-			for _, cached := range cachedEmails {
-				if cached.UID == uid {
-					if err := s.cache.UpdateEmailBody(cached.ID, email.Body); err != nil {
-						fmt.Printf("[GetEmail] Failed to update cache: %v\n", err)
-					}
-					email.ID = cached.ID // Use cached ID
-					break
-				}
-			}
-		}
+	return content, nil
+}
+
+// GetEmailSource returns the raw RFC 5322 source of an email,
+// downloading it first if it is not stored locally
+func (s *MailService) GetEmailSource(accountID, folder string, uid uint32) (string, error) {
+	if s.cache == nil {
+		_, raw, err := s.fetchRawMessage(accountID, folder, uid)
+		return string(raw), err
 	}
 
-	return email, nil
+	if raw, err := s.cache.GetRawMessage(accountID, folder, uid); err == nil {
+		return string(raw), nil
+	}
+
+	_, raw, err := s.fetchRawMessage(accountID, folder, uid)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.cache.StoreRawMessage(accountID, folder, uid, raw); err != nil {
+		fmt.Printf("[GetEmailSource] Failed to store raw message: %v\n", err)
+	}
+	return string(raw), nil
 }
 
 // SendEmail sends an email
 type SendEmailRequest struct {
-	AccountID string   `json:"accountId"`
-	To        []string `json:"to"`
-	CC        []string `json:"cc"`
-	BCC       []string `json:"bcc"`
-	Subject   string   `json:"subject"`
-	Body      string   `json:"body"`
-	IsHTML    bool     `json:"isHTML"`
+	AccountID string    `json:"accountId"`
+	To        []Address `json:"to"`
+	CC        []Address `json:"cc"`
+	BCC       []Address `json:"bcc"`
+	ReplyTo   []Address `json:"replyTo"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	IsHTML    bool      `json:"isHTML"`
+	Sign      bool      `json:"sign"`      // PGP sign with the account's key
+	Encrypt   bool      `json:"encrypt"`   // PGP encrypt to every recipient
+	SMIMESign bool      `json:"smimeSign"` // S/MIME sign with the account's identity
 }
 
 func (s *MailService) SendEmail(req *SendEmailRequest) error {
@@ -392,34 +525,24 @@ func (s *MailService) SendEmail(req *SendEmailRequest) error {
 		return err
 	}
 
-	// Connect to SMTP
-	var c *client.Client
-	if account.SMTPUseSSL {
-		c, err = client.DialTLS(
-			fmt.Sprintf("%s:%d", account.SMTPHost, account.SMTPPort),
-			&tls.Config{InsecureSkipVerify: true},
-		)
-	} else {
-		c, err = client.Dial(fmt.Sprintf("%s:%d", account.SMTPHost, account.SMTPPort))
+	msg := &outgoingMessage{
+		From:      Address{Name: account.Name, Email: account.Email},
+		To:        req.To,
+		CC:        req.CC,
+		BCC:       req.BCC,
+		ReplyTo:   req.ReplyTo,
+		Subject:   req.Subject,
+		Sign:      req.Sign,
+		Encrypt:   req.Encrypt,
+		SMIMESign: req.SMIMESign,
 	}
-	if err != nil {
-		return err
-	}
-	defer c.Close()
-
-	// Authenticate (use email as username if username is not set)
-	username := account.Username
-	if username == "" {
-		username = account.Email
-	}
-	if err := c.Login(username, account.Password); err != nil {
-		return err
+	if req.IsHTML {
+		msg.HTML = req.Body
+	} else {
+		msg.Text = req.Body
 	}
 
-	// TODO: Implement actual email sending
-	// This is a placeholder - go-smtp library should be used for actual sending
-
-	return nil
+	return s.deliver(account, msg)
 }
 
 // TestConnection tests if an account's connection works
@@ -438,25 +561,6 @@ func (s *MailService) TestConnection(accountID string) error {
 	return c.Noop()
 }
 
-// formatAddress formats an address list to string
-func formatAddress(addrs []*imap.Address) string {
-	if len(addrs) == 0 {
-		return ""
-	}
-	return addrs[0].Address()
-}
-
-// formatAddressList formats multiple addresses
-func formatAddressList(addrs []*imap.Address) []string {
-	result := make([]string, 0, len(addrs))
-	for _, addr := range addrs {
-		if addr != nil && addr.Address() != "" {
-			result = append(result, addr.Address())
-		}
-	}
-	return result
-}
-
 // extractTextBody extracts plain text from HTML
 func extractTextBody(html string) string {
 	// Remove HTML tags
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Message list orderings
+const (
+	SortByReceived = "received" // INTERNALDATE, when the server got the message
+	SortBySent     = "sent"     // the sanitized Date header
+)
+
+// maxPassphraseTimeout is the longest a PGP passphrase may stay cached, in minutes
+const maxPassphraseTimeout = 24 * 60
+
+// MailSettings holds user preferences for reading mail
+type MailSettings struct {
+	SortBy string `json:"sortBy"`
+	// PassphraseTimeout is how many minutes an unlocked PGP key stays unlocked
+	PassphraseTimeout int `json:"passphraseTimeout"`
+	// AutocryptPreferEncrypt advertises prefer-encrypt=mutual, so peers who
+	// also prefer it encrypt by default
+	AutocryptPreferEncrypt bool `json:"autocryptPreferEncrypt"`
+	// VerifyDKIM checks DKIM signatures locally, looking keys up in DNS,
+	// in addition to the results reported by the server
+	VerifyDKIM bool `json:"verifyDkim"`
+	// SpamAutoMove moves new Inbox messages scoring at least SpamThreshold
+	// to the Junk folder
+	SpamAutoMove  bool    `json:"spamAutoMove"`
+	SpamThreshold float64 `json:"spamThreshold"`
+}
+
+// defaultMailSettings are used until the user changes anything
+func defaultMailSettings() MailSettings {
+	return MailSettings{SortBy: SortByReceived, PassphraseTimeout: 10, SpamThreshold: defaultSpamThreshold}
+}
+
+// mailSettingsStore persists MailSettings in mail_settings.json
+type mailSettingsStore struct {
+	file     *ConfigFile
+	settings MailSettings
+	mu       sync.RWMutex
+}
+
+func newMailSettingsStore() *mailSettingsStore {
+	configDir, err := getUserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
+	}
+
+	store := &mailSettingsStore{
+		file:     NewConfigFile(filepath.Join(configDir, "wmail", "mail_settings.json"), 0644),
+		settings: defaultMailSettings(),
+	}
+	if err := store.file.Load(&store.settings); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("[MailSettings] Failed to load settings, using defaults: %v\n", err)
+		store.settings = defaultMailSettings()
+	}
+	if !validSortBy(store.settings.SortBy) {
+		store.settings.SortBy = SortByReceived
+	}
+	if store.settings.PassphraseTimeout < 1 || store.settings.PassphraseTimeout > maxPassphraseTimeout {
+		store.settings.PassphraseTimeout = defaultMailSettings().PassphraseTimeout
+	}
+	if !validSpamThreshold(store.settings.SpamThreshold) {
+		store.settings.SpamThreshold = defaultSpamThreshold
+	}
+	return store
+}
+
+func (m *mailSettingsStore) get() MailSettings {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.settings
+}
+
+func (m *mailSettingsStore) set(settings MailSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.file.Save(settings); err != nil {
+		return err
+	}
+	m.settings = settings
+	return nil
+}
+
+func validSortBy(sortBy string) bool {
+	return sortBy == SortByReceived || sortBy == SortBySent
+}
+
+// sortColumn returns the cache column ordering messages for sortBy
+func sortColumn(sortBy string) string {
+	if sortBy == SortBySent {
+		return "sent_date"
+	}
+	return "internal_date"
+}
+
+// GetMailSettings returns the reading preferences
+func (s *MailService) GetMailSettings() MailSettings {
+	return s.settings.get()
+}
+
+// SetSortOrder chooses whether message lists are sorted by received or sent time
+func (s *MailService) SetSortOrder(sortBy string) error {
+	if !validSortBy(sortBy) {
+		return fmt.Errorf("unknown sort order: %s", sortBy)
+	}
+	settings := s.settings.get()
+	settings.SortBy = sortBy
+	return s.settings.set(settings)
+}
+
+// SetPassphraseTimeout sets how many minutes PGP passphrases stay cached
+func (s *MailService) SetPassphraseTimeout(minutes int) error {
+	if minutes < 1 || minutes > maxPassphraseTimeout {
+		return fmt.Errorf("passphrase timeout must be between 1 and %d minutes", maxPassphraseTimeout)
+	}
+	settings := s.settings.get()
+	settings.PassphraseTimeout = minutes
+	return s.settings.set(settings)
+}
+
+// SetAutocryptPreferEncrypt sets whether our Autocrypt header asks peers to
+// encrypt by default
+func (s *MailService) SetAutocryptPreferEncrypt(mutual bool) error {
+	settings := s.settings.get()
+	settings.AutocryptPreferEncrypt = mutual
+	return s.settings.set(settings)
+}
+
+// SetVerifyDKIM sets whether DKIM signatures are also checked locally
+func (s *MailService) SetVerifyDKIM(enabled bool) error {
+	settings := s.settings.get()
+	settings.VerifyDKIM = enabled
+	return s.settings.set(settings)
+}
+
+func validSpamThreshold(threshold float64) bool {
+	return threshold > 0.5 && threshold <= 1
+}
+
+// SetSpamFilter sets whether new messages scoring at least threshold are
+// moved to Junk
+func (s *MailService) SetSpamFilter(autoMove bool, threshold float64) error {
+	if !validSpamThreshold(threshold) {
+		return fmt.Errorf("spam threshold must be above 0.5 and at most 1")
+	}
+	settings := s.settings.get()
+	settings.SpamAutoMove = autoMove
+	settings.SpamThreshold = threshold
+	return s.settings.set(settings)
+}
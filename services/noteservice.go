@@ -36,10 +36,18 @@ type NoteConfig struct {
 // NoteService manages notes and folders
 type NoteService struct {
 	config     NoteConfig
-	configPath string
+	configFile *ConfigFile
 	mu         sync.RWMutex
 }
 
+// noteConfigMigrations upgrades notes_config.json; index i migrates version i to i+1
+var noteConfigMigrations = []ConfigMigration{
+	// 0 -> 1: legacy file without an envelope, payload is unchanged
+	func(data json.RawMessage) (json.RawMessage, error) {
+		return data, nil
+	},
+}
+
 // NewNoteService creates a new note service
 func NewNoteService() *NoteService {
 	configDir, err := getUserConfigDir()
@@ -51,7 +59,7 @@ func NewNoteService() *NoteService {
 	configPath := filepath.Join(configDir, "wmail", "notes_config.json")
 	defaultDir := filepath.Join(configDir, "wmail", "notes")
 	service := &NoteService{
-		configPath: configPath,
+		configFile: NewConfigFile(configPath, 0644, noteConfigMigrations...),
 		config: NoteConfig{
 			DefaultDir: defaultDir,
 		},
@@ -81,11 +89,7 @@ func NewNoteService() *NoteService {
 
 // loadConfig loads the configuration from file
 func (s *NoteService) loadConfig() error {
-	data, err := os.ReadFile(s.configPath)
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(data, &s.config)
+	return s.configFile.Load(&s.config)
 }
 
 // saveConfig saves the configuration to file
@@ -93,17 +97,7 @@ func (s *NoteService) saveConfig() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, err := json.MarshalIndent(s.config, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	// Ensure config directory exists
-	if err := os.MkdirAll(filepath.Dir(s.configPath), 0755); err != nil {
-		return err
-	}
-
-	return os.WriteFile(s.configPath, data, 0644)
+	return s.configFile.Save(s.config)
 }
 
 // GetConfig returns the current configuration
@@ -272,7 +266,7 @@ func (s *NoteService) saveNote(path string, note Note) error {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	return writeFileAtomic(path, data, 0644)
 }
 
 // extractTitle extracts the title from TipTap content (first text node)
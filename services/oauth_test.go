@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenStoreKeepsADamagedKey(t *testing.T) {
+	store := newFileTokenStore(t.TempDir())
+	if err := store.Save("a", &OAuthToken{AccessToken: "at"}); err != nil {
+		t.Fatal(err)
+	}
+
+	damaged := []byte("too short")
+	if err := os.WriteFile(store.keyPath, damaged, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("a"); err == nil {
+		t.Error("loaded a token with a damaged key")
+	}
+	if err := store.SaveSecret("carddav:p", "secret"); err == nil {
+		t.Error("saved a secret with a damaged key")
+	}
+	if key, _ := os.ReadFile(store.keyPath); !bytes.Equal(key, damaged) {
+		t.Error("damaged key file was replaced")
+	}
+}
+
+func TestAccessTokenRefreshesOncePerAccount(t *testing.T) {
+	var refreshes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := refreshes.Add(1)
+		// Slow enough for every caller to find the token expired
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"fresh`+strconv.Itoa(int(n))+`","refresh_token":"rt`+strconv.Itoa(int(n))+`","expires_in":3600}`)
+	}))
+	defer srv.Close()
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	store := newFileTokenStore(t.TempDir())
+	previous := tokenStore
+	getTokenStore()
+	tokenStore = store
+	defer func() { tokenStore = previous }()
+
+	if err := store.Save("a", &OAuthToken{AccessToken: "stale", RefreshToken: "rt0", Expiry: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	account := &Account{ID: "a", AuthMethod: AuthMethodXOAuth2, OAuth: &OAuthConfig{ClientID: "c", TokenURL: srv.URL}}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 8)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := accessTokenFor(account)
+			if err != nil {
+				t.Error(err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	if n := refreshes.Load(); n != 1 {
+		t.Errorf("%d refreshes, want 1", n)
+	}
+	for _, token := range tokens {
+		if token != "fresh1" {
+			t.Errorf("access token %q, want fresh1", token)
+		}
+	}
+}
+
+func TestAuthorizeOAuthChecksStateAndVerifier(t *testing.T) {
+	var verifier string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "the-code" {
+			http.Error(w, "bad code", http.StatusBadRequest)
+			return
+		}
+		verifier = r.Form.Get("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"at","refresh_token":"rt","expires_in":3600}`)
+	}))
+	defer srv.Close()
+	cfg := &OAuthConfig{ClientID: "c", AuthURL: "https://auth.example.com/authorize", TokenURL: srv.URL}
+
+	tests := []struct {
+		name    string
+		state   func(sent string) string
+		wantErr bool
+	}{
+		{"matching state", func(sent string) string { return sent }, false},
+		{"wrong state", func(string) string { return "forged" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier = ""
+			var challenge string
+			open := func(authURL string) error {
+				u, err := url.Parse(authURL)
+				if err != nil {
+					return err
+				}
+				q := u.Query()
+				challenge = q.Get("code_challenge")
+				if q.Get("code_challenge_method") != "S256" || q.Get("login_hint") != "me@example.com" {
+					t.Errorf("authorization URL %s", authURL)
+				}
+				redirect := q.Get("redirect_uri") + "?" + url.Values{
+					"code":  {"the-code"},
+					"state": {tt.state(q.Get("state"))},
+				}.Encode()
+				go func() {
+					if resp, err := http.Get(redirect); err == nil {
+						resp.Body.Close()
+					}
+				}()
+				return nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			token, err := authorizeOAuth(ctx, cfg, "me@example.com", open)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("authorized with a forged state")
+				}
+				if verifier != "" {
+					t.Error("exchanged the code despite a forged state")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.AccessToken != "at" {
+				t.Errorf("access token %q", token.AccessToken)
+			}
+			if pkceChallenge(verifier) != challenge {
+				t.Error("code verifier does not match the challenge")
+			}
+		})
+	}
+}
+
+func TestSMTPOAuthRefusesPlaintext(t *testing.T) {
+	tests := []struct {
+		host     string
+		wantAuth bool
+	}{
+		{"smtp.example.com", false},
+		{"localhost", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			// A server that offers AUTH but no STARTTLS, as after a downgrade
+			sawAuth := make(chan bool, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				io.WriteString(conn, "220 smtp.example.com ESMTP\r\n")
+				auth := false
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						sawAuth <- auth
+						return
+					}
+					switch cmd := strings.ToUpper(line); {
+					case strings.HasPrefix(cmd, "EHLO"):
+						io.WriteString(conn, "250-smtp.example.com\r\n250 AUTH XOAUTH2\r\n")
+					case strings.HasPrefix(cmd, "AUTH"):
+						auth = true
+						io.WriteString(conn, "235 ok\r\n")
+					case strings.HasPrefix(cmd, "QUIT"):
+						io.WriteString(conn, "221 bye\r\n")
+						sawAuth <- auth
+						return
+					default:
+						io.WriteString(conn, "250 ok\r\n")
+					}
+				}
+			}()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := smtp.NewClient(conn, tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Auth(&smtpSASLAuth{client: &xoauth2Client{username: "me@example.com", token: "at"}})
+			if tt.wantAuth && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantAuth && err == nil {
+				t.Fatal("sent a token without TLS")
+			}
+			c.Quit()
+			conn.Close()
+
+			if auth := <-sawAuth; auth != tt.wantAuth {
+				t.Errorf("server saw AUTH: %v, want %v", auth, tt.wantAuth)
+			}
+		})
+	}
+}
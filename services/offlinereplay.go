@@ -0,0 +1,367 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+// EventConnectivityChanged is emitted with a ConnectivityState whenever the
+// replayer goes online or offline or the journal size changes
+const EventConnectivityChanged = "mail:connectivity"
+
+// ConnectivityState describes whether queued changes can reach the servers
+type ConnectivityState struct {
+	Online     bool   `json:"online"`
+	PendingOps int    `json:"pendingOps"`
+	LastError  string `json:"lastError"`
+	CheckedAt  string `json:"checkedAt"`
+}
+
+const (
+	// replayInterval is how often the journal is retried while ops are pending
+	replayInterval = 30 * time.Second
+	// maxOpAttempts drops an op the server keeps rejecting
+	maxOpAttempts = 10
+)
+
+// offlineReplayer applies journaled operations once the server is reachable
+type offlineReplayer struct {
+	service *MailService
+	wake    chan struct{}
+
+	mu    sync.Mutex
+	state ConnectivityState
+}
+
+func newOfflineReplayer(service *MailService) *offlineReplayer {
+	return &offlineReplayer{
+		service: service,
+		wake:    make(chan struct{}, 1),
+		state:   ConnectivityState{Online: true},
+	}
+}
+
+// trigger asks the replayer to run as soon as possible
+func (r *offlineReplayer) trigger() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *offlineReplayer) run() {
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+
+	for {
+		r.replay()
+		select {
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay applies pending ops account by account, in journal order
+func (r *offlineReplayer) replay() {
+	cache := r.service.cache
+	ops, err := cache.GetPendingOps("")
+	if err != nil {
+		fmt.Printf("[Replayer] Failed to read journal: %v\n", err)
+		return
+	}
+	if len(ops) == 0 {
+		r.setState(r.currentOnline(), "")
+		return
+	}
+
+	byAccount := make(map[string][]*PendingOp)
+	var order []string
+	for _, op := range ops {
+		if _, ok := byAccount[op.AccountID]; !ok {
+			order = append(order, op.AccountID)
+		}
+		byAccount[op.AccountID] = append(byAccount[op.AccountID], op)
+	}
+
+	online := true
+	lastError := ""
+	for _, accountID := range order {
+		account, err := r.service.accountService.GetAccount(accountID)
+		if err != nil {
+			// Account is gone; its journal is meaningless
+			cache.DeletePendingOps(accountID)
+			continue
+		}
+
+		if err := r.replayAccount(account, byAccount[accountID]); err != nil {
+			fmt.Printf("[Replayer] %s is unreachable: %v\n", account.Email, err)
+			online = false
+			lastError = err.Error()
+		}
+	}
+
+	r.setState(online, lastError)
+}
+
+// replayAccount applies one account's ops over a single connection.
+// It returns an error only when the server is unreachable.
+func (r *offlineReplayer) replayAccount(account *Account, ops []*PendingOp) error {
+	cache := r.service.cache
+
+	c, err := ConnectIMAP(account)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	selected := ""
+	for _, op := range ops {
+		if op.Folder != selected {
+			if _, err := c.Select(op.Folder, false); err != nil {
+				if isNetworkError(err) {
+					return err
+				}
+				// Folder was removed or renamed remotely
+				fmt.Printf("[Replayer] Dropping %s on %s/%d: %v\n", op.Op, op.Folder, op.UID, err)
+				cache.CompleteOp(op.ID)
+				selected = ""
+				continue
+			}
+			selected = op.Folder
+		}
+
+		err := applyRemoteOp(c, op)
+		switch {
+		case err == nil:
+			cache.CompleteOp(op.ID)
+		case errors.Is(err, errMessageGone):
+			// Already deleted or moved elsewhere; nothing left to do
+			fmt.Printf("[Replayer] Message %s/%d no longer exists, dropping %s\n", op.Folder, op.UID, op.Op)
+			cache.CompleteOp(op.ID)
+		case isNetworkError(err):
+			return err
+		default:
+			fmt.Printf("[Replayer] Server rejected %s on %s/%d: %v\n", op.Op, op.Folder, op.UID, err)
+			if op.Attempts+1 >= maxOpAttempts {
+				cache.CompleteOp(op.ID)
+			} else {
+				cache.FailOp(op.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+var errMessageGone = errors.New("message no longer exists")
+
+// applyRemoteOp performs one journaled op in the selected folder
+func applyRemoteOp(c *client.Client, op *PendingOp) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(op.UID)
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = seqset
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return errMessageGone
+	}
+
+	storeFlag := func(flag string, add bool) error {
+		var flagsOp imap.FlagsOp = imap.AddFlags
+		if !add {
+			flagsOp = imap.RemoveFlags
+		}
+		return c.UidStore(seqset, imap.FormatFlagsOp(flagsOp, true), []interface{}{flag}, nil)
+	}
+
+	switch op.Op {
+	case OpMarkRead:
+		return storeFlag(imap.SeenFlag, true)
+	case OpMarkUnread:
+		return storeFlag(imap.SeenFlag, false)
+	case OpStar:
+		return storeFlag(imap.FlaggedFlag, true)
+	case OpUnstar:
+		return storeFlag(imap.FlaggedFlag, false)
+	case OpMove:
+		return uidMove(c, seqset, op.TargetFolder)
+	case OpCopy:
+		return c.UidCopy(seqset, op.TargetFolder)
+	case OpAddKeyword:
+		return storeFlag(op.Keyword, true)
+	case OpDelete:
+		if err := storeFlag(imap.DeletedFlag, true); err != nil {
+			return err
+		}
+		return expungeUIDs(c, seqset)
+	default:
+		return fmt.Errorf("unknown operation: %s", op.Op)
+	}
+}
+
+// uidMove moves messages with MOVE (RFC 6851), or else copies them and
+// removes the originals with expungeUIDs
+func uidMove(c *client.Client, seqset *imap.SeqSet, dest string) error {
+	if ok, err := c.Support("MOVE"); err != nil {
+		return err
+	} else if ok {
+		return c.UidMove(seqset, dest)
+	}
+	if err := c.UidCopy(seqset, dest); err != nil {
+		return err
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := c.UidStore(seqset, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	return expungeUIDs(c, seqset)
+}
+
+// expungeUIDs removes messages already flagged \Deleted with UID EXPUNGE
+// (RFC 4315). A plain EXPUNGE would also remove every message other
+// clients flagged and chose to keep, so without UIDPLUS the messages stay
+// flagged for the server or the user's other clients to expunge.
+func expungeUIDs(c *client.Client, seqset *imap.SeqSet) error {
+	if ok, err := c.Support("UIDPLUS"); err != nil || !ok {
+		return err
+	}
+	cmd := &commands.Uid{Cmd: &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{seqset}}}
+	status, err := c.Execute(cmd, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// isNetworkError tells connectivity failures apart from server rejections
+func isNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection closed")
+}
+
+func (r *offlineReplayer) currentOnline() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.Online
+}
+
+// setState updates the connectivity state and notifies the UI on changes
+func (r *offlineReplayer) setState(online bool, lastError string) {
+	pending, _ := r.service.cache.CountPendingOps()
+
+	r.mu.Lock()
+	changed := r.state.Online != online || r.state.PendingOps != pending || r.state.LastError != lastError
+	r.state = ConnectivityState{
+		Online:     online,
+		PendingOps: pending,
+		LastError:  lastError,
+		CheckedAt:  getCurrentTime(),
+	}
+	state := r.state
+	r.mu.Unlock()
+
+	if changed {
+		emitEvent(EventConnectivityChanged, state)
+	}
+}
+
+func (r *offlineReplayer) getState() ConnectivityState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// queueOp applies an operation locally and schedules it for the server
+func (s *MailService) queueOp(op *PendingOp) error {
+	if s.cache == nil {
+		return fmt.Errorf("cache is not available")
+	}
+	if _, err := s.accountService.GetAccount(op.AccountID); err != nil {
+		return err
+	}
+
+	if err := s.cache.ApplyOp(op); err != nil {
+		return err
+	}
+	fmt.Printf("[MailService] Queued %s on %s/%d\n", op.Op, op.Folder, op.UID)
+
+	s.replayer.trigger()
+	return nil
+}
+
+// MarkAsRead marks an email as read or unread
+func (s *MailService) MarkAsRead(accountID, folder string, uid uint32, read bool) error {
+	op := OpMarkUnread
+	if read {
+		op = OpMarkRead
+	}
+	return s.queueOp(&PendingOp{AccountID: accountID, Folder: folder, UID: uid, Op: op})
+}
+
+// SetStarred stars or unstars an email
+func (s *MailService) SetStarred(accountID, folder string, uid uint32, starred bool) error {
+	op := OpUnstar
+	if starred {
+		op = OpStar
+	}
+	return s.queueOp(&PendingOp{AccountID: accountID, Folder: folder, UID: uid, Op: op})
+}
+
+// MoveEmail moves an email to another folder
+func (s *MailService) MoveEmail(accountID, folder string, uid uint32, targetFolder string) error {
+	if targetFolder == folder {
+		return nil
+	}
+	s.trainOnMove(accountID, folder, uid, targetFolder)
+	return s.queueOp(&PendingOp{AccountID: accountID, Folder: folder, UID: uid, Op: OpMove, TargetFolder: targetFolder})
+}
+
+// DeleteEmail permanently deletes an email
+func (s *MailService) DeleteEmail(accountID, folder string, uid uint32) error {
+	return s.queueOp(&PendingOp{AccountID: accountID, Folder: folder, UID: uid, Op: OpDelete})
+}
+
+// GetPendingOps returns the operations not yet applied on the server
+func (s *MailService) GetPendingOps() ([]*PendingOp, error) {
+	if s.cache == nil {
+		return []*PendingOp{}, nil
+	}
+	return s.cache.GetPendingOps("")
+}
+
+// GetConnectivityState returns the last known connectivity state
+func (s *MailService) GetConnectivityState() ConnectivityState {
+	if s.replayer == nil {
+		return ConnectivityState{Online: true}
+	}
+	return s.replayer.getState()
+}
+
+// SyncPendingOps retries the journal right away, e.g. when the UI detects the network is back
+func (s *MailService) SyncPendingOps() {
+	if s.replayer != nil {
+		s.replayer.trigger()
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// scriptedIMAP is a minimal IMAP server that records the commands it gets
+// and answers each with OK, plus any untagged lines set for its name
+type scriptedIMAP struct {
+	caps     string
+	untagged map[string]string // command name -> untagged response lines
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func (s *scriptedIMAP) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "* PREAUTH [CAPABILITY %s] ready\r\n", s.caps)
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		tag, rest, _ := strings.Cut(line, " ")
+		s.mu.Lock()
+		s.commands = append(s.commands, rest)
+		s.mu.Unlock()
+
+		name := strings.ToUpper(strings.Fields(rest)[0])
+		if name == "UID" {
+			name += " " + strings.ToUpper(strings.Fields(rest)[1])
+		}
+		switch name {
+		case "CAPABILITY":
+			fmt.Fprintf(conn, "* CAPABILITY %s\r\n", s.caps)
+		case "SELECT", "EXAMINE":
+			fmt.Fprintf(conn, "* 3 EXISTS\r\n* OK [UIDVALIDITY 7] ok\r\n")
+		case "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK done\r\n", tag)
+			return
+		}
+		if extra, ok := s.untagged[name]; ok {
+			fmt.Fprint(conn, extra)
+		}
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+	}
+}
+
+func (s *scriptedIMAP) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// dialScripted connects a client to the scripted server and selects INBOX
+func dialScripted(t *testing.T, s *scriptedIMAP) *client.Client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	go s.serve(serverConn)
+	c, err := client.New(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDeleteNeverSendsBareExpunge(t *testing.T) {
+	search := map[string]string{"UID SEARCH": "* SEARCH 5\r\n"}
+	cases := []struct {
+		caps string
+		want string // expected final command, "" for none after STORE
+	}{
+		{"IMAP4rev1 UIDPLUS", "UID EXPUNGE 5"},
+		{"IMAP4rev1", ""},
+	}
+	for _, tc := range cases {
+		srv := &scriptedIMAP{caps: tc.caps, untagged: search}
+		c := dialScripted(t, srv)
+		if err := applyRemoteOp(c, &PendingOp{Folder: "INBOX", UID: 5, Op: OpDelete}); err != nil {
+			t.Fatalf("%s: %v", tc.caps, err)
+		}
+		cmds := srv.sent()
+		for _, cmd := range cmds {
+			if strings.EqualFold(cmd, "EXPUNGE") {
+				t.Fatalf("%s: bare EXPUNGE sent: %q", tc.caps, cmds)
+			}
+		}
+		last := cmds[len(cmds)-1]
+		if tc.want != "" && last != tc.want {
+			t.Errorf("%s: last command %q, want %q", tc.caps, last, tc.want)
+		}
+		if tc.want == "" && !strings.HasPrefix(last, "UID STORE 5") {
+			t.Errorf("%s: last command %q, want the \\Deleted store", tc.caps, last)
+		}
+	}
+}
+
+func TestMoveFallbackExpungesOnlyItsUID(t *testing.T) {
+	srv := &scriptedIMAP{caps: "IMAP4rev1 UIDPLUS", untagged: map[string]string{"UID SEARCH": "* SEARCH 5\r\n"}}
+	c := dialScripted(t, srv)
+	if err := applyRemoteOp(c, &PendingOp{Folder: "INBOX", UID: 5, Op: OpMove, TargetFolder: "Archive"}); err != nil {
+		t.Fatal(err)
+	}
+	cmds := srv.sent()
+	want := []string{`UID COPY 5 "Archive"`, "UID STORE 5 +FLAGS.SILENT (" + imap.DeletedFlag + ")", "UID EXPUNGE 5"}
+	got := cmds[len(cmds)-3:]
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("commands %q, want %q", got, want)
+		}
+	}
+}
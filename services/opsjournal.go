@@ -0,0 +1,190 @@
+package services
+
+import (
+	"fmt"
+)
+
+// Operations recorded in the offline journal
+const (
+	OpMarkRead   = "mark_read"
+	OpMarkUnread = "mark_unread"
+	OpStar       = "star"
+	OpUnstar     = "unstar"
+	OpMove       = "move"
+	OpCopy       = "copy"
+	OpDelete     = "delete"
+	OpAddKeyword = "add_keyword"
+)
+
+// PendingOp is a local change waiting to be applied on the server
+type PendingOp struct {
+	ID           int64  `json:"id"`
+	AccountID    string `json:"accountId"`
+	Folder       string `json:"folder"`
+	UID          uint32 `json:"uid"`
+	Op           string `json:"op"`
+	TargetFolder string `json:"targetFolder"`
+	Keyword      string `json:"keyword,omitempty"` // the IMAP keyword of OpAddKeyword
+	Attempts     int    `json:"attempts"`
+	LastError    string `json:"lastError"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+// supersededOps lists the earlier ops made redundant by a new one on the same message
+var supersededOps = map[string][]string{
+	OpMarkRead:   {OpMarkRead, OpMarkUnread},
+	OpMarkUnread: {OpMarkRead, OpMarkUnread},
+	OpStar:       {OpStar, OpUnstar},
+	OpUnstar:     {OpStar, OpUnstar},
+}
+
+// ApplyOp changes the cached copy of a message and journals the change in
+// one transaction, so the UI reflects it immediately even when offline
+func (c *EmailCache) ApplyOp(op *PendingOp) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := getCurrentTime()
+	where := `account_id = ? AND folder = ? AND uid = ?`
+	key := []interface{}{op.AccountID, op.Folder, op.UID}
+
+	switch op.Op {
+	case OpMarkRead, OpMarkUnread:
+		read := 0
+		if op.Op == OpMarkRead {
+			read = 1
+		}
+		_, err = tx.Exec(`UPDATE emails SET is_read = ?, updated_at = ? WHERE `+where,
+			append([]interface{}{read, now}, key...)...)
+	case OpStar, OpUnstar:
+		starred := 0
+		if op.Op == OpStar {
+			starred = 1
+		}
+		_, err = tx.Exec(`UPDATE emails SET is_starred = ?, updated_at = ? WHERE `+where,
+			append([]interface{}{starred, now}, key...)...)
+	case OpMove, OpDelete:
+		// The UID in the target folder is only known after the server moved it,
+		// so the message reappears there on the next refresh
+		if op.Op == OpMove && op.TargetFolder == "" {
+			return fmt.Errorf("move requires a target folder")
+		}
+		_, err = tx.Exec(`DELETE FROM emails WHERE `+where, key...)
+	case OpCopy:
+		// The copy shows up in the target folder on its next refresh
+		if op.TargetFolder == "" {
+			return fmt.Errorf("copy requires a target folder")
+		}
+	case OpAddKeyword:
+		// Keywords are not cached; the op only reaches the server
+		if op.Keyword == "" {
+			return fmt.Errorf("add_keyword requires a keyword")
+		}
+	default:
+		return fmt.Errorf("unknown operation: %s", op.Op)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only the latest flag change on a message needs replaying
+	if superseded := supersededOps[op.Op]; len(superseded) > 0 {
+		_, err = tx.Exec(`DELETE FROM pending_ops WHERE `+where+` AND op IN (?, ?)`,
+			append(key, superseded[0], superseded[1])...)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO pending_ops (account_id, folder, uid, op, target_folder, keyword, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, op.AccountID, op.Folder, op.UID, op.Op, op.TargetFolder, op.Keyword, now)
+	if err != nil {
+		return err
+	}
+	op.ID, _ = res.LastInsertId()
+	op.CreatedAt = now
+
+	return tx.Commit()
+}
+
+// GetPendingOps returns journaled operations in the order they were made.
+// An empty accountID returns the ops of every account.
+func (c *EmailCache) GetPendingOps(accountID string) ([]*PendingOp, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	query := `
+		SELECT id, account_id, folder, uid, op, target_folder, keyword, attempts, last_error, created_at
+		FROM pending_ops
+	`
+	var args []interface{}
+	if accountID != "" {
+		query += ` WHERE account_id = ?`
+		args = append(args, accountID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*PendingOp
+	for rows.Next() {
+		var op PendingOp
+		if err := rows.Scan(&op.ID, &op.AccountID, &op.Folder, &op.UID, &op.Op,
+			&op.TargetFolder, &op.Keyword, &op.Attempts, &op.LastError, &op.CreatedAt); err != nil {
+			return nil, err
+		}
+		ops = append(ops, &op)
+	}
+	return ops, rows.Err()
+}
+
+// CountPendingOps returns how many operations wait for replay
+func (c *EmailCache) CountPendingOps() (int, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var count int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM pending_ops`).Scan(&count)
+	return count, err
+}
+
+// CompleteOp removes an operation that was applied or resolved
+func (c *EmailCache) CompleteOp(id int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(`DELETE FROM pending_ops WHERE id = ?`, id)
+	return err
+}
+
+// FailOp records a failed replay attempt
+func (c *EmailCache) FailOp(id int64, opErr error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(`
+		UPDATE pending_ops SET attempts = attempts + 1, last_error = ? WHERE id = ?
+	`, opErr.Error(), id)
+	return err
+}
+
+// DeletePendingOps drops every journaled operation of an account
+func (c *EmailCache) DeletePendingOps(accountID string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(`DELETE FROM pending_ops WHERE account_id = ?`, accountID)
+	return err
+}
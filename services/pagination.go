@@ -0,0 +1,616 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// defaultPageSize is used when the caller does not ask for a page size
+const defaultPageSize = 50
+
+// headerFetchBatch is how many messages are fetched per UID FETCH during a sync
+const headerFetchBatch = 200
+
+// EmailPage is one page of a folder, newest first
+type EmailPage struct {
+	Emails     []*Email `json:"emails"`
+	Total      int      `json:"total"`
+	HasMore    bool     `json:"hasMore"`
+	NextCursor string   `json:"nextCursor"` // pass back to get the following page
+}
+
+// emailCursor points just past the last message of a page. Ordering by
+// (date, UID) is stable, so arriving or expunged mail never shifts the pages
+// that follow. The cursor remembers its ordering so a page sequence stays
+// consistent even if the sort preference changes midway.
+type emailCursor struct {
+	sortBy string
+	date   int64
+	uid    uint32
+}
+
+func (c emailCursor) String() string {
+	return fmt.Sprintf("%s:%d:%d", c.sortBy, c.date, c.uid)
+}
+
+// parseEmailCursor decodes a cursor; an empty string means the first page
+func parseEmailCursor(s string) (*emailCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || !validSortBy(parts[0]) {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	n, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	return &emailCursor{sortBy: parts[0], date: ts, uid: uint32(n)}, nil
+}
+
+func cursorOf(email *Email, sortBy string) emailCursor {
+	if sortBy == SortBySent {
+		// The cache pages on the stored column, so its value must match
+		date := email.sentDate
+		if date == 0 {
+			date = unixFromRFC3339(email.Date)
+		}
+		return emailCursor{sortBy: sortBy, date: date, uid: email.UID}
+	}
+	return emailCursor{sortBy: sortBy, date: unixFromRFC3339(email.InternalDate), uid: email.UID}
+}
+
+// before reports whether c sorts after other in newest-first order
+func (c emailCursor) before(other emailCursor) bool {
+	if c.date != other.date {
+		return c.date < other.date
+	}
+	return c.uid < other.uid
+}
+
+// GetEmailPage returns the page of a cached folder that follows cursor
+func (c *EmailCache) GetEmailPage(accountID, folder, sortBy string, cursor *emailCursor, pageSize int) (*EmailPage, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	page := &EmailPage{Emails: []*Email{}}
+	if err := c.db.QueryRow(`
+		SELECT COUNT(*) FROM emails WHERE account_id = ? AND folder = ?
+	`, accountID, folder).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + emailColumns + `
+		FROM emails
+		WHERE account_id = ? AND folder = ?`
+	args := []interface{}{accountID, folder}
+	column := sortColumn(sortBy)
+	if cursor != nil {
+		query += ` AND (` + column + ` < ? OR (` + column + ` = ? AND uid < ?))`
+		args = append(args, cursor.date, cursor.date, cursor.uid)
+	}
+	query += `
+		ORDER BY ` + column + ` DESC, uid DESC
+		LIMIT ?`
+	// One extra row tells whether another page exists
+	args = append(args, pageSize+1)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		email, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Emails = append(page.Emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page.trim(sortBy, pageSize)
+	return page, nil
+}
+
+// DeleteEmailsByUIDs removes cached emails that no longer exist on the server
+func (c *EmailCache) DeleteEmailsByUIDs(accountID, folder string, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM emails WHERE account_id = ? AND folder = ? AND uid = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, uid := range uids {
+		if _, err := stmt.Exec(accountID, folder, uid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// trim cuts a page fetched with one extra row down to pageSize
+func (p *EmailPage) trim(sortBy string, pageSize int) {
+	if len(p.Emails) > pageSize {
+		p.Emails = p.Emails[:pageSize]
+		p.HasMore = true
+	}
+	if p.HasMore {
+		p.NextCursor = cursorOf(p.Emails[len(p.Emails)-1], sortBy).String()
+	}
+}
+
+// pageEmails paginates an in-memory folder listing the same way the cache does
+func pageEmails(emails []*Email, sortBy string, cursor *emailCursor, pageSize int) *EmailPage {
+	sort.Slice(emails, func(i, j int) bool {
+		return cursorOf(emails[j], sortBy).before(cursorOf(emails[i], sortBy))
+	})
+
+	page := &EmailPage{Emails: []*Email{}, Total: len(emails)}
+	for _, email := range emails {
+		if cursor != nil && !cursorOf(email, sortBy).before(*cursor) {
+			continue
+		}
+		page.Emails = append(page.Emails, email)
+		if len(page.Emails) > pageSize {
+			break
+		}
+	}
+	page.trim(sortBy, pageSize)
+	return page
+}
+
+// syncFolderHeaders brings the cached listing of the selected folder in line
+// with the server: expunged messages are dropped, the flags of cached ones
+// refreshed and new ones fetched. Without a cache it returns the full
+// listing instead. A folder's first sync fetches only the newest batch and
+// returns the UIDs left for backfillHeaders.
+func (s *MailService) syncFolderHeaders(c *client.Client, accountID, folder string) ([]*Email, []uint32, error) {
+	serverUIDs, err := c.UidSearch(imap.NewSearchCriteria())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list UIDs: %w", err)
+	}
+
+	onServer := make(map[uint32]bool, len(serverUIDs))
+	for _, uid := range serverUIDs {
+		onServer[uid] = true
+	}
+
+	// Messages above the highest cached UID arrived since the last sync; on
+	// a folder's first sync nothing counts as new
+	var newAbove uint32
+	firstSync := true
+	missing := serverUIDs
+	if s.cache != nil {
+		cachedUIDs, err := s.cache.GetCachedUIDs(accountID, folder)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cached := make(map[uint32]bool, len(cachedUIDs))
+		var vanished []uint32
+		firstSync = len(cachedUIDs) == 0
+		for _, uid := range cachedUIDs {
+			cached[uid] = true
+			newAbove = max(newAbove, uid)
+			if !onServer[uid] {
+				vanished = append(vanished, uid)
+			}
+		}
+		if err := s.cache.DeleteEmailsByUIDs(accountID, folder, vanished); err != nil {
+			return nil, nil, err
+		}
+		if len(cachedUIDs) > len(vanished) {
+			if err := s.refreshFlags(c, accountID, folder, newAbove); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		// A running backfill fetches the older messages itself
+		backfilling := s.backfilling(accountID, folder)
+		missing = nil
+		for _, uid := range serverUIDs {
+			if !cached[uid] && (!backfilling || uid > newAbove) {
+				missing = append(missing, uid)
+			}
+		}
+		fmt.Printf("[GetEmails] %s: %d on server, %d expunged, %d new\n", folder, len(serverUIDs), len(vanished), len(missing))
+	}
+
+	// Newest first, so an interrupted sync still has the recent mail
+	sort.Slice(missing, func(i, j int) bool { return missing[i] > missing[j] })
+
+	// The first page only needs the newest messages; the rest can follow
+	var rest []uint32
+	if s.cache != nil && firstSync && len(missing) > headerFetchBatch {
+		missing, rest = missing[:headerFetchBatch], missing[headerFetchBatch:]
+	}
+
+	all, arrived, err := s.fetchHeaders(c, accountID, folder, missing, newAbove, firstSync)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(arrived) > 0 {
+		go s.runNewMailHooks(accountID, folder, arrived)
+	}
+
+	return all, rest, nil
+}
+
+// fetchHeaders fetches the envelopes of uids in batches and caches them.
+// Without a cache it returns them instead. Unless this is the folder's
+// first sync, the kept messages above newAbove are returned as arrived.
+func (s *MailService) fetchHeaders(c *client.Client, accountID, folder string, uids []uint32, newAbove uint32, firstSync bool) (all, arrived []*Email, err error) {
+	items := []imap.FetchItem{
+		imap.FetchEnvelope,
+		imap.FetchUid,
+		imap.FetchInternalDate,
+		imap.FetchFlags,
+	}
+	// Autocrypt headers come along so peers' keys stay current
+	autocryptAccount := s.autocryptAccount(accountID, folder)
+	if autocryptAccount != nil {
+		items = append(items, autocryptSection.FetchItem())
+	}
+	// Once trained, the spam filter reads the headers and the start of the body
+	spamAccount := s.spamScoringAccount(accountID, folder)
+	if spamAccount != nil {
+		items = append(items, spamSourceSection.FetchItem())
+	}
+
+	for start := 0; start < len(uids); start += headerFetchBatch {
+		end := start + headerFetchBatch
+		if end > len(uids) {
+			end = len(uids)
+		}
+
+		seqset := new(imap.SeqSet)
+		seqset.AddNum(uids[start:end]...)
+
+		messages := make(chan *imap.Message, headerFetchBatch)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.UidFetch(seqset, items, messages)
+		}()
+
+		var batch []*Email
+		for msg := range messages {
+			if msg.Envelope == nil {
+				continue
+			}
+			email := emailFromMessage(accountID, folder, msg)
+			if spamAccount != nil {
+				s.scoreSyncedEmail(email, msg)
+			}
+			batch = append(batch, email)
+			if autocryptAccount != nil {
+				s.syncAutocrypt(autocryptAccount, msg)
+			}
+		}
+		if err := <-done; err != nil {
+			return nil, nil, err
+		}
+
+		if s.cache != nil {
+			if err := s.cache.CacheEmails(batch); err != nil {
+				return nil, nil, fmt.Errorf("failed to cache emails: %w", err)
+			}
+			kept := batch
+			if spamAccount != nil {
+				kept = s.moveSpamToJunk(spamAccount, folder, batch)
+			}
+			if !firstSync {
+				for _, email := range kept {
+					if email.UID > newAbove {
+						arrived = append(arrived, email)
+					}
+				}
+			}
+		} else {
+			all = append(all, batch...)
+		}
+	}
+	return all, arrived, nil
+}
+
+// refreshFlags updates the read and starred state of the cached messages
+// up to maxUID from the server, so changes made by other clients show up
+func (s *MailService) refreshFlags(c *client.Client, accountID, folder string, maxUID uint32) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(1, maxUID)
+
+	messages := make(chan *imap.Message, headerFetchBatch)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, messages)
+	}()
+
+	flags := make(map[uint32]messageFlags)
+	for msg := range messages {
+		flags[msg.Uid] = flagsOf(msg.Flags)
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed to fetch flags: %w", err)
+	}
+
+	changed, err := s.cache.UpdateFlagsByUIDs(accountID, folder, flags)
+	if err != nil {
+		return err
+	}
+	if changed > 0 {
+		fmt.Printf("[GetEmails] %s: flags changed on %d messages\n", folder, changed)
+	}
+	return nil
+}
+
+// EventFolderSynced tells the frontend a folder's listing grew in the background
+const EventFolderSynced = "mail:folder-synced"
+
+// FolderSynced is the payload of EventFolderSynced
+type FolderSynced struct {
+	AccountID string `json:"accountId"`
+	Folder    string `json:"folder"`
+}
+
+// backfilling reports whether a folder's first sync is still fetching headers
+func (s *MailService) backfilling(accountID, folder string) bool {
+	s.backfillMutex.Lock()
+	defer s.backfillMutex.Unlock()
+	return s.backfills[accountID+"\x00"+folder]
+}
+
+// setBackfilling marks a folder's backfill as started or finished; it
+// returns false when one was already running
+func (s *MailService) setBackfilling(accountID, folder string, running bool) bool {
+	s.backfillMutex.Lock()
+	defer s.backfillMutex.Unlock()
+
+	key := accountID + "\x00" + folder
+	if running && s.backfills[key] {
+		return false
+	}
+	if s.backfills == nil {
+		s.backfills = make(map[string]bool)
+	}
+	if running {
+		s.backfills[key] = true
+	} else {
+		delete(s.backfills, key)
+	}
+	return true
+}
+
+// backfillHeaders fetches the older messages a folder's first sync left
+// out, on a connection of its own, and tells the frontend when they are in
+func (s *MailService) backfillHeaders(account *Account, folder string, uidValidity uint32, uids []uint32) {
+	if !s.setBackfilling(account.ID, folder, true) {
+		return
+	}
+	defer s.setBackfilling(account.ID, folder, false)
+
+	c, err := ConnectIMAP(account)
+	if err != nil {
+		fmt.Printf("[GetEmails] Backfill of %s failed: %v\n", folder, err)
+		return
+	}
+	defer c.Close()
+
+	mbox, err := c.Select(folder, true)
+	if err != nil {
+		fmt.Printf("[GetEmails] Backfill of %s failed: %v\n", folder, err)
+		return
+	}
+	if mbox.UidValidity != uidValidity {
+		// The next sync starts over
+		return
+	}
+
+	if _, _, err := s.fetchHeaders(c, account.ID, folder, uids, 0, true); err != nil {
+		fmt.Printf("[GetEmails] Backfill of %s failed: %v\n", folder, err)
+		return
+	}
+	fmt.Printf("[GetEmails] Backfilled %d messages of %s\n", len(uids), folder)
+	emitEvent(EventFolderSynced, FolderSynced{AccountID: account.ID, Folder: folder})
+}
+
+// CheckUIDValidity records the UIDVALIDITY of a folder. When it differs
+// from the one seen before, the cached UIDs are meaningless: the folder's
+// cache and the journaled ops on it are dropped and reset is true.
+func (c *EmailCache) CheckUIDValidity(accountID, folder string, uidValidity uint32) (reset bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var stored uint32
+	err = tx.QueryRow(`SELECT uid_validity FROM folder_state WHERE account_id = ? AND folder = ?`,
+		accountID, folder).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if err == nil && stored == uidValidity {
+		return false, nil
+	}
+
+	// The first value seen is recorded; a cache that predates it is kept
+	reset = err == nil
+	if reset {
+		if _, err := tx.Exec(`DELETE FROM emails WHERE account_id = ? AND folder = ?`, accountID, folder); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(`DELETE FROM pending_ops WHERE account_id = ? AND folder = ?`, accountID, folder); err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO folder_state (account_id, folder, uid_validity) VALUES (?, ?, ?)
+		ON CONFLICT(account_id, folder) DO UPDATE SET uid_validity = excluded.uid_validity
+	`, accountID, folder, uidValidity)
+	if err != nil {
+		return false, err
+	}
+	return reset, tx.Commit()
+}
+
+// messageFlags is the part of a message's IMAP flags the cache keeps
+type messageFlags struct {
+	read, starred bool
+}
+
+func flagsOf(flags []string) messageFlags {
+	var f messageFlags
+	for _, flag := range flags {
+		switch flag {
+		case imap.SeenFlag:
+			f.read = true
+		case imap.FlaggedFlag:
+			f.starred = true
+		}
+	}
+	return f
+}
+
+// UpdateFlagsByUIDs applies flags fetched from the server to cached
+// messages. Messages with journaled ops keep their local state until the
+// ops are replayed. It returns how many messages changed.
+func (c *EmailCache) UpdateFlagsByUIDs(accountID, folder string, flags map[uint32]messageFlags) (int, error) {
+	if len(flags) == 0 {
+		return 0, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		UPDATE emails SET is_read = ?, is_starred = ?, updated_at = ?
+		WHERE account_id = ? AND folder = ? AND uid = ? AND (is_read != ? OR is_starred != ?)
+			AND NOT EXISTS (
+				SELECT 1 FROM pending_ops p
+				WHERE p.account_id = emails.account_id AND p.folder = emails.folder AND p.uid = emails.uid
+			)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	now := getCurrentTime()
+	changed := 0
+	for uid, f := range flags {
+		read, starred := boolToInt(f.read), boolToInt(f.starred)
+		res, err := stmt.Exec(read, starred, now, accountID, folder, uid, read, starred)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		changed += int(n)
+	}
+	return changed, tx.Commit()
+}
+
+// NewMailHook lets another service act on the messages a sync finds
+// arrived since the previous sync of a folder
+type NewMailHook struct {
+	Name      string
+	OnNewMail func(accountID, folder string, emails []*Email)
+}
+
+// RegisterNewMailHook adds a hook that runs after a sync found new messages
+func (s *MailService) RegisterNewMailHook(hook NewMailHook) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.newMailHooks = append(s.newMailHooks, hook)
+}
+
+func (s *MailService) runNewMailHooks(accountID, folder string, emails []*Email) {
+	s.hooksMutex.RLock()
+	hooks := append([]NewMailHook(nil), s.newMailHooks...)
+	s.hooksMutex.RUnlock()
+
+	for _, hook := range hooks {
+		hook.OnNewMail(accountID, folder, emails)
+	}
+}
+
+// maxClockSkew is how far a Date header may lie ahead of the time the message arrived
+const maxClockSkew = 24 * time.Hour
+
+// messageSentDate returns the Date header in UTC unless it is missing,
+// before 1970 or implausibly far in the future (a common spam trick to stay
+// on top of the list); then the received time stands in for it
+func messageSentDate(header, received time.Time) time.Time {
+	reference := received
+	if reference.IsZero() {
+		reference = time.Now()
+	}
+	if header.IsZero() || header.Unix() <= 0 || header.After(reference.Add(maxClockSkew)) {
+		return reference.UTC()
+	}
+	return header.UTC()
+}
+
+// emailFromMessage builds a list entry from a fetched envelope
+func emailFromMessage(accountID, folder string, msg *imap.Message) *Email {
+	email := &Email{
+		ID:        generateUUID(),
+		AccountID: accountID,
+		Folder:    folder,
+		UID:       msg.Uid,
+		MessageID: msg.Envelope.MessageId,
+		From:      addressesFromIMAP(msg.Envelope.From),
+		ReplyTo:   addressesFromIMAP(msg.Envelope.ReplyTo),
+		To:        addressesFromIMAP(msg.Envelope.To),
+		CC:        addressesFromIMAP(msg.Envelope.Cc),
+		Subject:   msg.Envelope.Subject,
+		Date:      messageSentDate(msg.Envelope.Date, msg.InternalDate).Format(time.RFC3339),
+		CreatedAt: getCurrentTime(),
+	}
+	if sender := addressesFromIMAP(msg.Envelope.Sender); !sameAddresses(sender, email.From) {
+		email.Sender = sender
+	}
+	if !msg.InternalDate.IsZero() {
+		email.InternalDate = msg.InternalDate.UTC().Format(time.RFC3339)
+	}
+	flags := flagsOf(msg.Flags)
+	email.IsRead, email.IsStarred = flags.read, flags.starred
+	return email
+}
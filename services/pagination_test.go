@@ -0,0 +1,140 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+)
+
+func newSyncTestService(t *testing.T) *MailService {
+	t.Helper()
+	cache := newTestCache(t)
+	accounts := &MailAccountService{accounts: map[string]*Account{"a": {ID: "a", Email: "me@example.org"}}}
+	return &MailService{accountService: accounts, cache: cache}
+}
+
+func cacheUIDs(t *testing.T, cache *EmailCache, uids ...uint32) {
+	t.Helper()
+	var emails []*Email
+	for _, uid := range uids {
+		emails = append(emails, &Email{ID: generateUUID(), AccountID: "a", Folder: "INBOX", UID: uid,
+			Date: "2024-01-01T00:00:00Z", InternalDate: "2024-01-01T00:00:00Z", CreatedAt: getCurrentTime()})
+	}
+	if err := cache.CacheEmails(emails); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncRefreshesCachedFlags(t *testing.T) {
+	s := newSyncTestService(t)
+	cacheUIDs(t, s.cache, 1, 2, 3)
+	// Marked read locally while offline: the server does not know yet
+	if err := s.cache.ApplyOp(&PendingOp{AccountID: "a", Folder: "INBOX", UID: 3, Op: OpMarkRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &scriptedIMAP{caps: "IMAP4rev1", untagged: map[string]string{
+		"UID SEARCH": "* SEARCH 1 2 3\r\n",
+		"UID FETCH":  "* 1 FETCH (UID 1 FLAGS (\\Seen))\r\n* 2 FETCH (UID 2 FLAGS (\\Flagged))\r\n* 3 FETCH (UID 3 FLAGS ())\r\n",
+	}}
+	c := dialScripted(t, srv)
+	if _, rest, err := s.syncFolderHeaders(c, "a", "INBOX"); err != nil || len(rest) != 0 {
+		t.Fatalf("sync: %v, %d left", err, len(rest))
+	}
+
+	got, err := s.cache.GetCachedEmailsByUIDs("a", "INBOX", []uint32{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got[1].IsRead || got[1].IsStarred || got[2].IsRead || !got[2].IsStarred {
+		t.Errorf("server flags not applied: %+v %+v", got[1], got[2])
+	}
+	if !got[3].IsRead {
+		t.Error("pending local change was overwritten")
+	}
+}
+
+func TestUIDValidityChangeClearsFolder(t *testing.T) {
+	s := newSyncTestService(t)
+	cacheUIDs(t, s.cache, 1, 2)
+	if err := s.cache.ApplyOp(&PendingOp{AccountID: "a", Folder: "INBOX", UID: 2, Op: OpStar}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first value seen keeps the existing cache
+	if reset, err := s.cache.CheckUIDValidity("a", "INBOX", 7); err != nil || reset {
+		t.Fatalf("first check: reset=%v err=%v", reset, err)
+	}
+	if reset, err := s.cache.CheckUIDValidity("a", "INBOX", 7); err != nil || reset {
+		t.Fatalf("same value: reset=%v err=%v", reset, err)
+	}
+	if n, _ := s.cache.GetCachedCount("a", "INBOX"); n != 2 {
+		t.Fatalf("cache cleared without a change: %d left", n)
+	}
+
+	if reset, err := s.cache.CheckUIDValidity("a", "INBOX", 8); err != nil || !reset {
+		t.Fatalf("changed value: reset=%v err=%v", reset, err)
+	}
+	if n, _ := s.cache.GetCachedCount("a", "INBOX"); n != 0 {
+		t.Errorf("%d stale messages kept", n)
+	}
+	if ops, _ := s.cache.GetPendingOps("a"); len(ops) != 0 {
+		t.Errorf("%d ops on stale UIDs kept", len(ops))
+	}
+}
+
+func TestFirstSyncLeavesOlderMessagesForBackfill(t *testing.T) {
+	s := newSyncTestService(t)
+	search := "* SEARCH"
+	for uid := 1; uid <= headerFetchBatch+10; uid++ {
+		search += " " + strconv.Itoa(uid)
+	}
+	srv := &scriptedIMAP{caps: "IMAP4rev1", untagged: map[string]string{"UID SEARCH": search + "\r\n"}}
+	c := dialScripted(t, srv)
+
+	_, rest, err := s.syncFolderHeaders(c, "a", "INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 10 || rest[0] != 10 || rest[9] != 1 {
+		t.Errorf("backfill gets %v, want UIDs 10 down to 1", rest)
+	}
+}
+
+func TestSentDatePagesFollowTheStoredColumn(t *testing.T) {
+	cache := newTestCache(t)
+	cacheUIDs(t, cache, 1, 2, 3, 4)
+	// Migration 7 replaced the far-future Date of uid 3 with its INTERNALDATE
+	if _, err := cache.db.Exec(`UPDATE emails SET sent_date = 1700000000 + uid * 100`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.db.Exec(`UPDATE emails SET date = '2099-01-01T00:00:00Z' WHERE uid = 3`); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []uint32
+	var cursor *emailCursor
+	for {
+		page, err := cache.GetEmailPage("a", "INBOX", SortBySent, cursor, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, email := range page.Emails {
+			seen = append(seen, email.UID)
+		}
+		if !page.HasMore || len(seen) > 4 {
+			break
+		}
+		if cursor, err = parseEmailCursor(page.NextCursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []uint32{4, 3, 2, 1}
+	if len(seen) != len(want) {
+		t.Fatalf("pages gave UIDs %v, want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("pages gave UIDs %v, want %v", seen, want)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// PGP key sources
+const (
+	PGPKeySourceImported  = "imported"
+	PGPKeySourceGenerated = "generated"
+	PGPKeySourceAutocrypt = "autocrypt"
+)
+
+// PGPKey describes a key in the local keyring
+type PGPKey struct {
+	Fingerprint string   `json:"fingerprint"`
+	KeyID       string   `json:"keyId"`
+	UserIDs     []string `json:"userIds"`
+	Emails      []string `json:"emails"`
+	Secret      bool     `json:"secret"`
+	Unlocked    bool     `json:"unlocked"` // the passphrase is cached
+	Revoked     bool     `json:"revoked"`
+	CanEncrypt  bool     `json:"canEncrypt"`
+	CreatedAt   string   `json:"createdAt"`
+	ExpiresAt   string   `json:"expiresAt,omitempty"`
+	Source      string   `json:"source"`
+	AddedAt     string   `json:"addedAt"`
+}
+
+// storedPGPKey is a keyring entry as saved in pgpkeys.json. Secret keys
+// stay protected by the passphrase they were imported with.
+type storedPGPKey struct {
+	Fingerprint string `json:"fingerprint"`
+	Armored     string `json:"armored"`
+	Secret      bool   `json:"secret"`
+	Source      string `json:"source"`
+	AddedAt     string `json:"addedAt"`
+}
+
+// pgpLockedError means a secret key has to be unlocked with its passphrase first
+type pgpLockedError struct {
+	Fingerprint string
+}
+
+func (e *pgpLockedError) Error() string {
+	return fmt.Sprintf("PGP key %s is locked", e.Fingerprint)
+}
+
+type cachedPassphrase struct {
+	value   []byte
+	expires time.Time
+}
+
+// pgpKeyring persists keys in pgpkeys.json and caches passphrases in memory.
+// Entities are parsed afresh for every use, so unlocking a key for one
+// operation never leaves decrypted key material behind.
+type pgpKeyring struct {
+	file        *ConfigFile
+	keys        []*storedPGPKey
+	passphrases map[string]cachedPassphrase
+	timeout     func() time.Duration
+	mu          sync.RWMutex
+}
+
+func newPGPKeyring(timeout func() time.Duration) *pgpKeyring {
+	configDir, err := getUserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
+	}
+
+	k := &pgpKeyring{
+		file:        NewConfigFile(filepath.Join(configDir, "wmail", "pgpkeys.json"), 0600),
+		passphrases: make(map[string]cachedPassphrase),
+		timeout:     timeout,
+	}
+	if err := k.file.Load(&k.keys); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("[PGP] Failed to load keyring: %v\n", err)
+	}
+	return k
+}
+
+// readKeys parses armored or binary keys
+func readKeys(data string) (openpgp.EntityList, error) {
+	if strings.Contains(data, "-----BEGIN PGP") {
+		return openpgp.ReadArmoredKeyRing(strings.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(strings.NewReader(data))
+}
+
+// armorEntity serializes a key, with its secret parts when secret is set
+func armorEntity(e *openpgp.Entity, secret bool) (string, error) {
+	blockType := openpgp.PublicKeyType
+	if secret {
+		blockType = openpgp.PrivateKeyType
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		return "", err
+	}
+	if secret {
+		// Keeps the existing self-signatures and passphrase protection
+		err = e.SerializePrivateWithoutSigning(w, nil)
+	} else {
+		err = e.Serialize(w)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func fingerprintOf(e *openpgp.Entity) string {
+	return strings.ToUpper(fmt.Sprintf("%x", e.PrimaryKey.Fingerprint))
+}
+
+// describeKey summarizes an entity for the UI
+func describeKey(e *openpgp.Entity, stored *storedPGPKey, unlocked bool) *PGPKey {
+	now := time.Now()
+	key := &PGPKey{
+		Fingerprint: fingerprintOf(e),
+		KeyID:       e.PrimaryKey.KeyIdString(),
+		UserIDs:     []string{},
+		Emails:      []string{},
+		Secret:      stored.Secret,
+		Unlocked:    unlocked,
+		Revoked:     e.Revoked(now),
+		CreatedAt:   e.PrimaryKey.CreationTime.UTC().Format(time.RFC3339),
+		Source:      stored.Source,
+		AddedAt:     stored.AddedAt,
+	}
+	_, key.CanEncrypt = e.EncryptionKey(now)
+
+	for name, identity := range e.Identities {
+		key.UserIDs = append(key.UserIDs, name)
+		if identity.UserId != nil && identity.UserId.Email != "" {
+			key.Emails = appendUnique(key.Emails, strings.ToLower(identity.UserId.Email))
+		}
+	}
+	if sig, _ := e.PrimarySelfSignature(); sig != nil && sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs > 0 {
+		expires := e.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+		key.ExpiresAt = expires.UTC().Format(time.RFC3339)
+	}
+	return key
+}
+
+// entityEmails returns the addresses of an entity's user IDs
+func entityEmails(e *openpgp.Entity) []string {
+	var emails []string
+	for _, identity := range e.Identities {
+		if identity.UserId != nil && identity.UserId.Email != "" {
+			emails = append(emails, strings.ToLower(identity.UserId.Email))
+		}
+	}
+	return emails
+}
+
+// ownPublicKeyFor returns the public half of our key for an address, even
+// while it is locked
+func (k *pgpKeyring) ownPublicKeyFor(email string) *openpgp.Entity {
+	now := time.Now()
+	for _, e := range k.entities() {
+		if e.PrivateKey == nil || e.Revoked(now) || !hasEmail(e, email) {
+			continue
+		}
+		if _, ok := e.EncryptionKey(now); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// minimalKey serializes the public parts of a key that Autocrypt needs: the
+// primary key, the user ID of email (else the primary one) and the current
+// encryption subkey
+func minimalKey(e *openpgp.Entity, email string) ([]byte, error) {
+	enc, ok := e.EncryptionKey(time.Now())
+	if !ok {
+		return nil, fmt.Errorf("key %s cannot encrypt", fingerprintOf(e))
+	}
+
+	minimal := &openpgp.Entity{
+		PrimaryKey: e.PrimaryKey,
+		Signatures: e.Signatures,
+		Identities: make(map[string]*openpgp.Identity),
+	}
+	for name, identity := range e.Identities {
+		if identity.UserId != nil && normalizeEmail(identity.UserId.Email) == normalizeEmail(email) && identity.SelfSignature != nil {
+			minimal.Identities[name] = &openpgp.Identity{
+				Name:          identity.Name,
+				UserId:        identity.UserId,
+				SelfSignature: identity.SelfSignature,
+				Signatures:    []*packet.Signature{identity.SelfSignature},
+			}
+			break
+		}
+	}
+	if len(minimal.Identities) == 0 {
+		if identity := e.PrimaryIdentity(); identity != nil && identity.SelfSignature != nil {
+			minimal.Identities[identity.Name] = &openpgp.Identity{
+				Name:          identity.Name,
+				UserId:        identity.UserId,
+				SelfSignature: identity.SelfSignature,
+				Signatures:    []*packet.Signature{identity.SelfSignature},
+			}
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PublicKey.KeyId == enc.PublicKey.KeyId {
+			minimal.Subkeys = append(minimal.Subkeys, openpgp.Subkey{PublicKey: subkey.PublicKey, Sig: subkey.Sig})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := minimal.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func hasEmail(e *openpgp.Entity, email string) bool {
+	email = normalizeEmail(email)
+	for _, addr := range entityEmails(e) {
+		if addr == email {
+			return true
+		}
+	}
+	return false
+}
+
+// list describes every key
+func (k *pgpKeyring) list() []*PGPKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := []*PGPKey{}
+	for _, stored := range k.keys {
+		entities, err := readKeys(stored.Armored)
+		if err != nil || len(entities) == 0 {
+			continue
+		}
+		keys = append(keys, describeKey(entities[0], stored, k.cachedLocked(stored.Fingerprint) != nil))
+	}
+	return keys
+}
+
+// add imports keys. A secret key replaces the public one with the same
+// fingerprint; keys found in Autocrypt headers only ever replace other
+// Autocrypt keys.
+func (k *pgpKeyring) add(data, source string) ([]*PGPKey, error) {
+	entities, err := readKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no keys found")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := append([]*storedPGPKey(nil), k.keys...)
+	var added []*PGPKey
+	for _, e := range entities {
+		secret := e.PrivateKey != nil
+		armored, err := armorEntity(e, secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store key: %w", err)
+		}
+		stored := &storedPGPKey{
+			Fingerprint: fingerprintOf(e),
+			Armored:     armored,
+			Secret:      secret,
+			Source:      source,
+			AddedAt:     getCurrentTime(),
+		}
+
+		index := -1
+		for i, existing := range keys {
+			if existing.Fingerprint == stored.Fingerprint {
+				index = i
+				break
+			}
+		}
+		switch {
+		case index < 0:
+			keys = append(keys, stored)
+		case keys[index].Secret && !secret:
+			continue
+		case source == PGPKeySourceAutocrypt && keys[index].Source != PGPKeySourceAutocrypt:
+			continue
+		default:
+			stored.AddedAt = keys[index].AddedAt
+			keys[index] = stored
+		}
+		added = append(added, describeKey(e, stored, false))
+	}
+
+	if len(added) > 0 {
+		if err := k.file.Save(keys); err != nil {
+			return nil, err
+		}
+		k.keys = keys
+	}
+	return added, nil
+}
+
+// remove deletes a key and forgets its passphrase
+func (k *pgpKeyring) remove(fingerprint string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	fingerprint = strings.ToUpper(fingerprint)
+	keys := make([]*storedPGPKey, 0, len(k.keys))
+	for _, existing := range k.keys {
+		if existing.Fingerprint != fingerprint {
+			keys = append(keys, existing)
+		}
+	}
+	if len(keys) == len(k.keys) {
+		return fmt.Errorf("PGP key not found")
+	}
+
+	if err := k.file.Save(keys); err != nil {
+		return err
+	}
+	k.keys = keys
+	delete(k.passphrases, fingerprint)
+	return nil
+}
+
+// export returns a key armored, with its secret parts if asked and present
+func (k *pgpKeyring) export(fingerprint string, secret bool) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	fingerprint = strings.ToUpper(fingerprint)
+	for _, stored := range k.keys {
+		if stored.Fingerprint != fingerprint {
+			continue
+		}
+		if secret && stored.Secret {
+			return stored.Armored, nil
+		}
+		entities, err := readKeys(stored.Armored)
+		if err != nil || len(entities) == 0 {
+			return "", fmt.Errorf("failed to read key: %w", err)
+		}
+		return armorEntity(entities[0], false)
+	}
+	return "", fmt.Errorf("PGP key not found")
+}
+
+// entities returns every key, with the secret keys whose passphrase is
+// cached already unlocked
+func (k *pgpKeyring) entities() openpgp.EntityList {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var list openpgp.EntityList
+	for _, stored := range k.keys {
+		entities, err := readKeys(stored.Armored)
+		if err != nil {
+			continue
+		}
+		for _, e := range entities {
+			if passphrase := k.cachedLocked(stored.Fingerprint); passphrase != nil && e.PrivateKey != nil && e.PrivateKey.Encrypted {
+				e.DecryptPrivateKeys(passphrase)
+			}
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// cachedLocked returns an unexpired passphrase; callers hold mu
+func (k *pgpKeyring) cachedLocked(fingerprint string) []byte {
+	cached, ok := k.passphrases[fingerprint]
+	if !ok || time.Now().After(cached.expires) {
+		return nil
+	}
+	return cached.value
+}
+
+// unlock checks a passphrase against a secret key and caches it
+func (k *pgpKeyring) unlock(fingerprint, passphrase string) error {
+	fingerprint = strings.ToUpper(fingerprint)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, stored := range k.keys {
+		if stored.Fingerprint != fingerprint {
+			continue
+		}
+		if !stored.Secret {
+			return fmt.Errorf("PGP key %s has no secret key", fingerprint)
+		}
+		entities, err := readKeys(stored.Armored)
+		if err != nil || len(entities) == 0 {
+			return fmt.Errorf("failed to read key: %w", err)
+		}
+		if entities[0].PrivateKey.Encrypted {
+			if err := entities[0].DecryptPrivateKeys([]byte(passphrase)); err != nil {
+				return fmt.Errorf("wrong passphrase")
+			}
+		}
+		k.passphrases[fingerprint] = cachedPassphrase{
+			value:   []byte(passphrase),
+			expires: time.Now().Add(k.timeout()),
+		}
+		return nil
+	}
+	return fmt.Errorf("PGP key not found")
+}
+
+// lock forgets every cached passphrase
+func (k *pgpKeyring) lock() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for fingerprint, cached := range k.passphrases {
+		for i := range cached.value {
+			cached.value[i] = 0
+		}
+		delete(k.passphrases, fingerprint)
+	}
+}
+
+// publicKeysFor returns a usable encryption key for every address, or an
+// error naming the addresses without one
+func (k *pgpKeyring) publicKeysFor(emails []string) ([]*openpgp.Entity, error) {
+	entities := k.entities()
+	now := time.Now()
+
+	var keys []*openpgp.Entity
+	var missing []string
+	for _, email := range emails {
+		var found *openpgp.Entity
+		for _, e := range entities {
+			if _, ok := e.EncryptionKey(now); ok && !e.Revoked(now) && hasEmail(e, email) {
+				found = e
+				break
+			}
+		}
+		if found == nil {
+			missing = append(missing, email)
+			continue
+		}
+		keys = append(keys, found)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no PGP key for %s", strings.Join(missing, ", "))
+	}
+	return keys, nil
+}
+
+// secretKeyFor returns the unlocked secret key of an address
+func (k *pgpKeyring) secretKeyFor(email string) (*openpgp.Entity, error) {
+	now := time.Now()
+	for _, e := range k.entities() {
+		if e.PrivateKey == nil || e.Revoked(now) || !hasEmail(e, email) {
+			continue
+		}
+		if _, ok := e.SigningKey(now); !ok {
+			continue
+		}
+		if e.PrivateKey.Encrypted {
+			return nil, &pgpLockedError{Fingerprint: fingerprintOf(e)}
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("no PGP secret key for %s", email)
+}
+
+// GetPGPKeys lists the keys in the local keyring
+func (s *MailService) GetPGPKeys() []*PGPKey {
+	return s.keyring.list()
+}
+
+// ImportPGPKeys adds armored or binary public or secret keys to the keyring
+func (s *MailService) ImportPGPKeys(data string) ([]*PGPKey, error) {
+	keys, err := s.keyring.add(data, PGPKeySourceImported)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[PGP] Imported %d keys\n", len(keys))
+	return keys, nil
+}
+
+// ExportPGPKey returns a key armored. Secret keys are exported still
+// protected by their passphrase.
+func (s *MailService) ExportPGPKey(fingerprint string, includeSecret bool) (string, error) {
+	return s.keyring.export(fingerprint, includeSecret)
+}
+
+// DeletePGPKey removes a key from the keyring
+func (s *MailService) DeletePGPKey(fingerprint string) error {
+	return s.keyring.remove(fingerprint)
+}
+
+// GeneratePGPKey creates a key pair for an address, protected by passphrase
+func (s *MailService) GeneratePGPKey(name, email, passphrase string) (*PGPKey, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required")
+	}
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := openpgp.NewEntity(name, "", email, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if err := entity.EncryptPrivateKeys([]byte(passphrase), config); err != nil {
+		return nil, fmt.Errorf("failed to protect key: %w", err)
+	}
+	armored, err := armorEntity(entity, true)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.keyring.add(armored, PGPKeySourceGenerated)
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// UnlockPGPKey caches the passphrase of a secret key for the configured timeout
+func (s *MailService) UnlockPGPKey(fingerprint, passphrase string) error {
+	return s.keyring.unlock(fingerprint, passphrase)
+}
+
+// LockPGPKeys forgets every cached passphrase
+func (s *MailService) LockPGPKeys() {
+	s.keyring.lock()
+}
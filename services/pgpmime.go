@@ -0,0 +1,480 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Message security protocols
+const (
+	SecurityPGP   = "pgp"
+	SecuritySMIME = "smime"
+)
+
+// Signature verdicts
+const (
+	SignatureValid      = "valid"
+	SignatureInvalid    = "invalid"
+	SignatureUnknownKey = "unknown-key" // the signer's key is not in the keyring
+	SignatureMismatch   = "mismatch"    // a good signature, but not by the From address
+	SignatureUntrusted  = "untrusted"   // a good S/MIME signature, by a certificate no trusted root issued
+	SignatureExpired    = "expired"     // a good S/MIME signature, by a certificate not valid at the time
+)
+
+// MessageSecurity says how a message was encrypted and signed
+type MessageSecurity struct {
+	Protocol          string `json:"protocol"`
+	Encrypted         bool   `json:"encrypted"`
+	Decrypted         bool   `json:"decrypted"`
+	LockedKey         string `json:"lockedKey,omitempty"` // fingerprint to unlock to read the message
+	Signed            bool   `json:"signed"`
+	Signature         string `json:"signature,omitempty"`
+	SignerKeyID       string `json:"signerKeyId,omitempty"`
+	SignerFingerprint string `json:"signerFingerprint,omitempty"`
+	SignerName        string `json:"signerName,omitempty"`
+	SignerEmail       string `json:"signerEmail,omitempty"`
+	SignerIssuer      string `json:"signerIssuer,omitempty"`     // S/MIME certificate issuer
+	SignerValidUntil  string `json:"signerValidUntil,omitempty"` // S/MIME certificate expiry
+	Error             string `json:"error,omitempty"`
+}
+
+// errNoDecryptionKey is returned to go-crypto when no cached passphrase
+// unlocks a key the message was encrypted to
+var errNoDecryptionKey = errors.New("no unlocked key")
+
+// readContent extracts the content of a raw message, decrypting and
+// verifying PGP/MIME or S/MIME on the way. Autocrypt headers update the
+// sender's state, and gossip inside decrypted mail that of other recipients.
+// received is the message's INTERNALDATE, zero when unknown.
+func (s *MailService) readContent(accountID, folder string, raw []byte, received time.Time) (*messageContent, error) {
+	inner, security := s.openPGPMIME(raw)
+	var decrypted []byte
+	if security != nil && security.Decrypted {
+		decrypted = inner
+	}
+	s.readAutocrypt(accountID, folder, raw, decrypted)
+	if security == nil {
+		inner, security = s.openSMIME(raw, received)
+	}
+	if security == nil {
+		return extractContent(raw)
+	}
+	if inner == nil {
+		return &messageContent{Security: security}, nil
+	}
+	content, err := extractContent(inner)
+	if err != nil {
+		return nil, err
+	}
+	content.Security = security
+	return content, nil
+}
+
+// maxPGPDepth bounds nested signed and encrypted layers
+const maxPGPDepth = 4
+
+// openPGPMIME unwraps multipart/encrypted and multipart/signed messages
+// (RFC 3156). It returns the raw message and nil when it is neither, and a
+// nil entity when it could not be decrypted.
+func (s *MailService) openPGPMIME(raw []byte) ([]byte, *MessageSecurity) {
+	return s.openPGPLayer(canonicalLineEndings(raw), "", maxPGPDepth)
+}
+
+func (s *MailService) openPGPLayer(raw []byte, from string, depth int) ([]byte, *MessageSecurity) {
+	header, body, err := splitEntity(raw)
+	if err != nil || depth == 0 {
+		return raw, nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	// Signatures are checked against the From the reader sees: the outer
+	// one, not whatever a decrypted layer claims
+	if from == "" {
+		if addrs, err := (&mail.Header{Header: message.Header{Header: header}}).AddressList("From"); err == nil && len(addrs) > 0 {
+			from = addrs[0].Address
+		}
+	}
+
+	switch {
+	case mediaType == "multipart/encrypted" && strings.EqualFold(params["protocol"], "application/pgp-encrypted"):
+		security := &MessageSecurity{Protocol: SecurityPGP, Encrypted: true}
+		parts := multipartParts(body, params["boundary"])
+		if len(parts) < 2 {
+			security.Error = "malformed encrypted message"
+			return nil, security
+		}
+		ciphertext, err := partBody(parts[1])
+		if err != nil {
+			security.Error = err.Error()
+			return nil, security
+		}
+		plaintext := s.decryptPGP(ciphertext, from, security)
+		if plaintext == nil {
+			return nil, security
+		}
+		security.Decrypted = true
+
+		// Signed, then encrypted as two layers
+		if inner, signed := s.openPGPLayer(canonicalLineEndings(plaintext), from, depth-1); signed != nil && signed.Signed {
+			security.Signed = true
+			copySigner(security, signed)
+			return inner, security
+		}
+		return canonicalLineEndings(plaintext), security
+
+	case mediaType == "multipart/signed" && strings.EqualFold(params["protocol"], "application/pgp-signature"):
+		security := &MessageSecurity{Protocol: SecurityPGP, Signed: true}
+		parts := multipartParts(body, params["boundary"])
+		if len(parts) < 2 {
+			security.Signature = SignatureInvalid
+			security.Error = "malformed signed message"
+			return raw, security
+		}
+		signature, err := partBody(parts[1])
+		if err != nil {
+			security.Signature = SignatureInvalid
+			security.Error = err.Error()
+			return parts[0], security
+		}
+		s.verifyPGP(parts[0], signature, from, security)
+		return parts[0], security
+	}
+	return raw, nil
+}
+
+// decryptPGP decrypts an OpenPGP message with the keyring, checking any
+// signature inside it. It returns nil when no key can open it.
+func (s *MailService) decryptPGP(ciphertext []byte, from string, security *MessageSecurity) []byte {
+	var r io.Reader = bytes.NewReader(ciphertext)
+	if block, err := armor.Decode(bytes.NewReader(ciphertext)); err == nil {
+		r = block.Body
+	}
+
+	var locked string
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		// Keys with a cached passphrase are unlocked already, so these
+		// are all locked
+		if len(keys) > 0 && keys[0].Entity != nil {
+			locked = fingerprintOf(keys[0].Entity)
+		}
+		return nil, errNoDecryptionKey
+	}
+
+	md, err := openpgp.ReadMessage(r, s.keyring.entities(), prompt, nil)
+	if err != nil {
+		switch {
+		case locked != "":
+			security.LockedKey = locked
+			security.Error = (&pgpLockedError{Fingerprint: locked}).Error()
+		case errors.Is(err, pgperrors.ErrKeyIncorrect):
+			security.Error = "no secret key for this message"
+		default:
+			security.Error = fmt.Sprintf("failed to decrypt: %v", err)
+		}
+		return nil
+	}
+
+	plaintext, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		// Integrity check failures surface here: never show that data
+		security.Error = fmt.Sprintf("failed to decrypt: %v", err)
+		return nil
+	}
+
+	if md.IsSigned {
+		security.Signed = true
+		security.SignerKeyID = fmt.Sprintf("%016X", md.SignedByKeyId)
+		switch {
+		case md.SignedBy == nil:
+			security.Signature = SignatureUnknownKey
+		case md.SignatureError != nil:
+			security.Signature = SignatureInvalid
+			security.Error = md.SignatureError.Error()
+		default:
+			setSigner(security, md.SignedBy.Entity, from)
+		}
+	}
+	return plaintext
+}
+
+// verifyPGP checks a detached signature over the signed entity
+func (s *MailService) verifyPGP(signed, signature []byte, from string, security *MessageSecurity) {
+	var sigReader io.Reader = bytes.NewReader(signature)
+	if block, err := armor.Decode(bytes.NewReader(signature)); err == nil {
+		sigReader = block.Body
+	}
+	sigData, err := io.ReadAll(sigReader)
+	if err != nil {
+		security.Signature = SignatureInvalid
+		security.Error = err.Error()
+		return
+	}
+	if p, err := packet.Read(bytes.NewReader(sigData)); err == nil {
+		if sig, ok := p.(*packet.Signature); ok && sig.IssuerKeyId != nil {
+			security.SignerKeyID = fmt.Sprintf("%016X", *sig.IssuerKeyId)
+		}
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(s.keyring.entities(), bytes.NewReader(signed), bytes.NewReader(sigData), nil)
+	switch {
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		security.Signature = SignatureUnknownKey
+	case err != nil:
+		security.Signature = SignatureInvalid
+		security.Error = err.Error()
+	default:
+		setSigner(security, signer, from)
+	}
+}
+
+// setSigner records a good signature, which only counts as valid when
+// the key belongs to the sender
+func setSigner(security *MessageSecurity, signer *openpgp.Entity, from string) {
+	security.SignerFingerprint = fingerprintOf(signer)
+	security.SignerKeyID = signer.PrimaryKey.KeyIdString()
+	if identity := signer.PrimaryIdentity(); identity != nil && identity.UserId != nil {
+		security.SignerName = identity.UserId.Name
+		security.SignerEmail = identity.UserId.Email
+	}
+	security.Signature = SignatureValid
+	if from != "" && !hasEmail(signer, from) {
+		security.Signature = SignatureMismatch
+	}
+}
+
+func copySigner(dst, src *MessageSecurity) {
+	dst.Signature = src.Signature
+	dst.SignerKeyID = src.SignerKeyID
+	dst.SignerFingerprint = src.SignerFingerprint
+	dst.SignerName = src.SignerName
+	dst.SignerEmail = src.SignerEmail
+	dst.SignerIssuer = src.SignerIssuer
+	dst.SignerValidUntil = src.SignerValidUntil
+	if src.Error != "" {
+		dst.Error = src.Error
+	}
+}
+
+// canonicalLineEndings converts line endings to CRLF, the form signatures
+// over MIME entities are computed on
+func canonicalLineEndings(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
+// splitEntity separates the header of a MIME entity from its body
+func splitEntity(raw []byte) (textproto.Header, []byte, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return textproto.Header{}, nil, err
+	}
+	body, err := io.ReadAll(br)
+	return header, body, err
+}
+
+// multipartParts returns the raw parts of a multipart body, headers
+// included, exactly as they were signed: without the CRLF that belongs to
+// the following boundary
+func multipartParts(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+	body = append([]byte("\r\n"), body...)
+	delimiter := []byte("\r\n--" + boundary)
+
+	var parts [][]byte
+	start := -1
+	offset := 0
+	for {
+		i := bytes.Index(body[offset:], delimiter)
+		if i < 0 {
+			return parts
+		}
+		i += offset
+		if start >= 0 {
+			parts = append(parts, body[start:i])
+		}
+		rest := body[i+len(delimiter):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			return parts
+		}
+		eol := bytes.Index(rest, []byte("\r\n"))
+		if eol < 0 {
+			return parts
+		}
+		start = i + len(delimiter) + eol + 2
+		offset = start
+	}
+}
+
+// partBody returns the decoded body of a raw MIME part
+func partBody(part []byte) ([]byte, error) {
+	entity, err := message.Read(bytes.NewReader(part))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, err
+	}
+	return io.ReadAll(entity.Body)
+}
+
+// pgpMicAlg names a hash for the micalg parameter of multipart/signed
+func pgpMicAlg(h crypto.Hash) string {
+	switch h {
+	case crypto.SHA1:
+		return "pgp-sha1"
+	case crypto.SHA224:
+		return "pgp-sha224"
+	case crypto.SHA384:
+		return "pgp-sha384"
+	case crypto.SHA512:
+		return "pgp-sha512"
+	}
+	return "pgp-sha256"
+}
+
+// buildPGPMessage renders msg as PGP/MIME: multipart/signed when only
+// signing, multipart/encrypted (signed inside when asked) otherwise. The
+// message is also encrypted to the sender so the sent copy stays readable,
+// and with several recipients it gossips their keys to each other.
+func (s *MailService) buildPGPMessage(accountID string, msg *outgoingMessage) ([]byte, error) {
+	var signer *openpgp.Entity
+	if msg.Sign {
+		var err error
+		if signer, err = s.keyring.secretKeyFor(msg.From.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	var recipients []*openpgp.Entity
+	var innerHeader mail.Header
+	if msg.Encrypt {
+		var err error
+		if recipients, err = s.recipientKeys(accountID, msg.recipients()); err != nil {
+			return nil, err
+		}
+		// Bcc recipients stay out of the gossip, which everyone can read
+		visible := make(map[string]bool)
+		for _, addr := range append(append([]Address(nil), msg.To...), msg.CC...) {
+			visible[addr.Email] = true
+		}
+		if len(visible) > 1 {
+			for i, email := range msg.recipients() {
+				if !visible[email] {
+					continue
+				}
+				if value := autocryptValue(email, "", recipients[i]); value != "" {
+					innerHeader.Add("Autocrypt-Gossip", value)
+				}
+			}
+		}
+		own, err := s.keyring.publicKeysFor([]string{msg.From.Email})
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, own...)
+	}
+
+	// The body goes in as a MIME entity of its own, with only content
+	// headers and gossip
+	var inner bytes.Buffer
+	if err := writeMessageBody(&inner, innerHeader, msg); err != nil {
+		return nil, err
+	}
+	entity := canonicalLineEndings(inner.Bytes())
+
+	h, err := messageHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	boundary := generateUUID()
+	config := &packet.Config{DefaultHash: crypto.SHA256}
+
+	var buf bytes.Buffer
+	if !msg.Encrypt {
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(entity), config); err != nil {
+			return nil, fmt.Errorf("failed to sign: %w", err)
+		}
+		micalg := pgpMicAlg(config.Hash())
+		if block, err := armor.Decode(bytes.NewReader(sig.Bytes())); err == nil {
+			if p, err := packet.Read(block.Body); err == nil {
+				if sigPacket, ok := p.(*packet.Signature); ok {
+					micalg = pgpMicAlg(sigPacket.Hash)
+				}
+			}
+		}
+
+		h.Set("MIME-Version", "1.0")
+		h.SetContentType("multipart/signed", map[string]string{
+			"protocol": "application/pgp-signature",
+			"micalg":   micalg,
+			"boundary": boundary,
+		})
+		if err := textproto.WriteHeader(&buf, h.Header.Header); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		buf.Write(entity)
+		fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+		buf.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+		buf.WriteString("Content-Description: OpenPGP digital signature\r\n")
+		buf.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+		buf.Write(canonicalLineEndings(sig.Bytes()))
+		fmt.Fprintf(&buf, "\r\n--%s--\r\n", boundary)
+		return buf.Bytes(), nil
+	}
+
+	var ciphertext bytes.Buffer
+	aw, err := armor.Encode(&ciphertext, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := openpgp.Encrypt(aw, recipients, signer, nil, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := pw.Write(entity); err != nil {
+		return nil, err
+	}
+	if err := pw.Close(); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	h.Set("MIME-Version", "1.0")
+	h.SetContentType("multipart/encrypted", map[string]string{
+		"protocol": "application/pgp-encrypted",
+		"boundary": boundary,
+	})
+	if err := textproto.WriteHeader(&buf, h.Header.Header); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	buf.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	buf.WriteString("Version: 1\r\n")
+	fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+	buf.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	buf.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	buf.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	buf.Write(canonicalLineEndings(ciphertext.Bytes()))
+	fmt.Fprintf(&buf, "\r\n--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+func newTestEntity(t *testing.T, name, email string) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", email, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// signedThenEncrypted builds a PGP/MIME message as two layers: a
+// multipart/signed entity signed by signer, encrypted to recipient
+func signedThenEncrypted(t *testing.T, from string, signer, recipient *openpgp.Entity) []byte {
+	t.Helper()
+	entity := []byte("Content-Type: text/plain; charset=utf-8\r\n\r\nhello\r\n")
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(entity), nil); err != nil {
+		t.Fatal(err)
+	}
+	signed := "Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256; boundary=\"s\"\r\n\r\n" +
+		"--s\r\n" + string(entity) + "\r\n--s\r\n" +
+		"Content-Type: application/pgp-signature\r\n\r\n" + string(canonicalLineEndings(sig.Bytes())) + "\r\n--s--\r\n"
+
+	var ciphertext bytes.Buffer
+	aw, err := armor.Encode(&ciphertext, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := openpgp.Encrypt(aw, []*openpgp.Entity{recipient}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Write([]byte(signed))
+	pw.Close()
+	aw.Close()
+
+	return []byte("From: " + from + "\r\nTo: me@example.com\r\nSubject: Hi\r\n" +
+		"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"o\"\r\n\r\n" +
+		"--o\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n" +
+		"--o\r\nContent-Type: application/octet-stream\r\n\r\n" + string(canonicalLineEndings(ciphertext.Bytes())) + "\r\n--o--\r\n")
+}
+
+func TestPGPInnerSignatureCheckedAgainstOuterFrom(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	s := &MailService{keyring: newPGPKeyring(func() time.Duration { return time.Minute })}
+
+	me := newTestEntity(t, "Me", "me@example.com")
+	alice := newTestEntity(t, "Alice", "alice@example.com")
+	bob := newTestEntity(t, "Bob", "bob@example.com")
+	mine, _ := armorEntity(me, true)
+	alicePublic, _ := armorEntity(alice, false)
+	bobPublic, _ := armorEntity(bob, false)
+	for _, key := range []string{mine, alicePublic, bobPublic} {
+		if _, err := s.keyring.add(key, PGPKeySourceImported); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		from   string
+		signer *openpgp.Entity
+		want   string
+	}{
+		{"signed by the sender", "alice@example.com", alice, SignatureValid},
+		{"signed by someone else", "alice@example.com", bob, SignatureMismatch},
+	}
+	for _, tc := range cases {
+		inner, security := s.openPGPMIME(signedThenEncrypted(t, tc.from, tc.signer, me))
+		if security == nil || !security.Decrypted || !security.Signed {
+			t.Fatalf("%s: security %+v", tc.name, security)
+		}
+		if security.Signature != tc.want {
+			t.Errorf("%s: signature %s (%s), want %s", tc.name, security.Signature, security.SignerEmail, tc.want)
+		}
+		if !bytes.Contains(inner, []byte("hello")) {
+			t.Errorf("%s: inner entity %q", tc.name, inner)
+		}
+	}
+}
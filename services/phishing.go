@@ -0,0 +1,355 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Phishing warning kinds
+const (
+	WarningLinkMismatch     = "link-mismatch"     // link text names another domain than the link goes to
+	WarningConfusableDomain = "confusable-domain" // punycode or look-alike characters
+	WarningImpersonation    = "impersonation"     // display name of a known contact on another address
+	WarningOwnDomain        = "own-domain"        // first-time sender claiming the user's own domain
+)
+
+// maxLinkWarnings bounds the warnings one message body can produce
+const maxLinkWarnings = 10
+
+// MessageWarning is a phishing indicator found in a message
+type MessageWarning struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Text    string `json:"text,omitempty"`   // link text or display name
+	Href    string `json:"href,omitempty"`   // link target
+	Domain  string `json:"domain,omitempty"` // the suspicious domain, in Unicode
+	// LooksLike is the domain or address the message imitates
+	LooksLike string `json:"looksLike,omitempty"`
+}
+
+// confusables maps characters that render like ASCII letters to them
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'ӏ': 'l', 'о': 'o',
+	'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'ԝ': 'w', 'х': 'x', 'у': 'y',
+	// Greek
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u', 'χ': 'x',
+	// Armenian
+	'հ': 'h', 'ո': 'n', 'օ': 'o', 'զ': 'q', 'ս': 'u', 'ց': 'g',
+	// Latin look-alikes
+	'ı': 'i', 'ɩ': 'i', 'ɑ': 'a', 'ɡ': 'g', 'ǀ': 'l', 'ʏ': 'y',
+}
+
+// domainScripts are the scripts whose letters are confused with each other
+var domainScripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Armenian}
+
+// asciiHost returns the punycode form of a host name
+func asciiHost(host string) string {
+	if ascii, err := idna.ToASCII(host); err == nil && ascii != "" {
+		return ascii
+	}
+	return host
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r >= unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// confusableDomain reports whether a host name uses punycode or look-alike
+// characters to pass for another domain. It returns the Unicode form and,
+// when every character has an ASCII look-alike, the domain it imitates.
+// Labels written in a single non-Latin script are ordinary IDNs and pass.
+func confusableDomain(host string) (display, looksLike string, suspicious bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	display, err := idna.ToUnicode(host)
+	if err != nil {
+		display = host
+	}
+	if isASCII(display) {
+		return display, "", false
+	}
+
+	// Fullwidth and other compatibility forms fold to plain letters first
+	folded := norm.NFKC.String(display)
+	for _, label := range strings.Split(folded, ".") {
+		scripts := 0
+		for _, table := range domainScripts {
+			for _, r := range label {
+				if unicode.Is(table, r) {
+					scripts++
+					break
+				}
+			}
+		}
+		suspicious = suspicious || scripts > 1
+	}
+
+	skeleton := strings.Map(func(r rune) rune {
+		if c, ok := confusables[r]; ok {
+			return c
+		}
+		return r
+	}, folded)
+	if isASCII(skeleton) {
+		return display, skeleton, true
+	}
+	return display, "", suspicious
+}
+
+// urlText matches link text that is itself a URL or a host name
+var urlText = regexp.MustCompile(`^(?i)(?:https?://)?([\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)*\.[\p{L}]{2,}|xn--[a-z0-9-]+(?:\.[\p{L}\p{N}-]+)+)(?::\d+)?(?:[/?#]\S*)?$`)
+
+// fileExtensions end link text that names a file rather than a host
+var fileExtensions = map[string]bool{
+	"pdf": true, "doc": true, "docx": true, "xls": true, "xlsx": true, "ppt": true, "pptx": true,
+	"txt": true, "csv": true, "jpg": true, "jpeg": true, "png": true, "gif": true,
+	"htm": true, "html": true, "php": true, "aspx": true, "ics": true, "vcf": true,
+}
+
+// htmlLink is an anchor of an HTML body
+type htmlLink struct {
+	href string
+	text string
+}
+
+// htmlLinks returns the anchors of an HTML document with their visible text
+func htmlLinks(body string) []htmlLink {
+	var links []htmlLink
+	var current *htmlLink
+	var text strings.Builder
+
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			current = nil
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					current = &htmlLink{href: strings.TrimSpace(string(val))}
+				}
+			}
+			text.Reset()
+		case html.TextToken:
+			if current != nil {
+				text.Write(z.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "a" && current != nil {
+				current.text = strings.Join(strings.Fields(text.String()), " ")
+				links = append(links, *current)
+				current = nil
+			}
+		}
+	}
+}
+
+// inspectLinks flags the links of an HTML body that show one domain and
+// lead to another, or lead to a look-alike domain
+func inspectLinks(body string) []*MessageWarning {
+	warnings := []*MessageWarning{}
+	seen := make(map[string]bool)
+	add := func(w *MessageWarning) {
+		key := w.Kind + "\x00" + w.Domain + "\x00" + w.LooksLike
+		if !seen[key] && len(warnings) < maxLinkWarnings {
+			seen[key] = true
+			warnings = append(warnings, w)
+		}
+	}
+
+	for _, link := range htmlLinks(body) {
+		target, err := url.Parse(link.href)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+			continue
+		}
+		host := asciiHost(strings.ToLower(target.Hostname()))
+
+		if display, looksLike, suspicious := confusableDomain(host); suspicious {
+			add(&MessageWarning{
+				Kind:      WarningConfusableDomain,
+				Message:   confusableMessage(display, looksLike),
+				Text:      link.text,
+				Href:      link.href,
+				Domain:    display,
+				LooksLike: looksLike,
+			})
+		}
+
+		m := urlText.FindStringSubmatch(link.text)
+		if m == nil {
+			continue
+		}
+		shown := strings.ToLower(m[1])
+		if fileExtensions[shown[strings.LastIndexByte(shown, '.')+1:]] {
+			continue
+		}
+		if display, looksLike, suspicious := confusableDomain(shown); suspicious {
+			add(&MessageWarning{
+				Kind:      WarningConfusableDomain,
+				Message:   confusableMessage(display, looksLike),
+				Text:      link.text,
+				Href:      link.href,
+				Domain:    display,
+				LooksLike: looksLike,
+			})
+		}
+		if !alignedDomain(asciiHost(shown), host) {
+			display, _, _ := confusableDomain(host)
+			add(&MessageWarning{
+				Kind:      WarningLinkMismatch,
+				Message:   fmt.Sprintf("The link shows %s but leads to %s", shown, display),
+				Text:      link.text,
+				Href:      link.href,
+				Domain:    display,
+				LooksLike: shown,
+			})
+		}
+	}
+	return warnings
+}
+
+func confusableMessage(display, looksLike string) string {
+	if looksLike != "" {
+		return fmt.Sprintf("%s uses look-alike characters to imitate %s", display, looksLike)
+	}
+	return fmt.Sprintf("%s mixes characters from different alphabets", display)
+}
+
+// cacheWarnings stores the link warnings of a message body
+func (s *MailService) cacheWarnings(accountID, folder string, uid uint32, warnings []*MessageWarning) {
+	if err := s.cache.UpdateWarningsByUID(accountID, folder, uid, warnings); err != nil {
+		fmt.Printf("[GetEmail] Failed to cache warnings: %v\n", err)
+	}
+}
+
+// embeddedAddress finds an email address written into a display name
+var embeddedAddress = regexp.MustCompile(`[^\s<>"'()@]+@[^\s<>"'()@]+\.[\p{L}]{2,}`)
+
+// checkImpersonation adds the warnings about the sender itself: a
+// look-alike domain, a display name belonging to someone the user knows, or
+// a first-time sender claiming the user's own domain
+func (s *MailService) checkImpersonation(accountID, folder string, email *Email) {
+	if len(email.From) == 0 {
+		return
+	}
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil {
+		return
+	}
+	if role := account.roleOf(folder); role == RoleSent || role == RoleDrafts {
+		return
+	}
+	from := email.From[0]
+	fromDomain := mailDomain(from.Email)
+	name := strings.Join(strings.Fields(strings.Trim(from.Name, `"' `)), " ")
+
+	if display, looksLike, suspicious := confusableDomain(fromDomain); suspicious {
+		email.Warnings = append(email.Warnings, &MessageWarning{
+			Kind:      WarningConfusableDomain,
+			Message:   confusableMessage(display, looksLike),
+			Text:      from.Email,
+			Domain:    display,
+			LooksLike: looksLike,
+		})
+	}
+
+	if addr := embeddedAddress.FindString(name); addr != "" && !strings.EqualFold(addr, from.Email) {
+		email.Warnings = append(email.Warnings, &MessageWarning{
+			Kind:      WarningImpersonation,
+			Message:   fmt.Sprintf("The sender's name shows %s but the message comes from %s", addr, from.Email),
+			Text:      from.Name,
+			Domain:    fromDomain,
+			LooksLike: addr,
+		})
+	}
+
+	s.hooksMutex.RLock()
+	contacts := s.contacts
+	s.hooksMutex.RUnlock()
+	if contacts == nil || strings.EqualFold(from.Email, account.Email) {
+		return
+	}
+
+	if len([]rune(name)) >= 3 {
+		if known := contacts.addressesNamed(name); len(known) > 0 && !containsFold(known, from.Email) {
+			email.Warnings = append(email.Warnings, &MessageWarning{
+				Kind:      WarningImpersonation,
+				Message:   fmt.Sprintf("%s is known to you as %s, not %s", name, known[0], from.Email),
+				Text:      from.Name,
+				Domain:    fromDomain,
+				LooksLike: known[0],
+			})
+		}
+	}
+
+	// Colleagues share the domain; strangers on a public provider do too
+	own := mailDomain(account.Email)
+	if _, public := lookupProvider(own); own == "" || public {
+		return
+	}
+	claimsOwn := alignedDomain(fromDomain, own) || strings.Contains(strings.ToLower(name), own)
+	if claimsOwn && !contacts.isKnownAddress(from.Email) && contacts.receivedFrom(from.Email) <= 1 {
+		email.Warnings = append(email.Warnings, &MessageWarning{
+			Kind:      WarningOwnDomain,
+			Message:   fmt.Sprintf("First message from %s, which uses your domain %s", from.Email, own),
+			Text:      from.Name,
+			Domain:    fromDomain,
+			LooksLike: own,
+		})
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeWarnings serializes the link warnings of a body for the cache.
+// An empty list is stored as "[]" so that "" still means not analyzed.
+func encodeWarnings(warnings []*MessageWarning) string {
+	if warnings == nil {
+		return ""
+	}
+	data, err := json.Marshal(warnings)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodeWarnings reads warnings stored by encodeWarnings; nil means the
+// body was never analyzed
+func decodeWarnings(s string) []*MessageWarning {
+	if s == "" {
+		return nil
+	}
+	warnings := []*MessageWarning{}
+	if err := json.Unmarshal([]byte(s), &warnings); err != nil {
+		return nil
+	}
+	return warnings
+}
@@ -0,0 +1,87 @@
+package services
+
+import "testing"
+
+func TestConfusableDomain(t *testing.T) {
+	cases := []struct {
+		name       string
+		host       string
+		suspicious bool
+		looksLike  string
+	}{
+		{"ASCII", "paypal.com", false, ""},
+		{"mixed-script label", "pаypal.com", true, "paypal.com"},
+		{"mixed-script label without a look-alike", "exаmplж.com", true, ""},
+		{"whole-script Cyrillic look-alike", "аррӏе.com", true, "apple.com"},
+		{"whole-script look-alike in punycode", asciiHost("аррӏе.com"), true, "apple.com"},
+		{"Cyrillic IDN", "пример.рф", false, ""},
+		{"Latin IDN", "münchen.de", false, ""},
+	}
+	for _, tc := range cases {
+		_, looksLike, suspicious := confusableDomain(tc.host)
+		if suspicious != tc.suspicious || looksLike != tc.looksLike {
+			t.Errorf("%s: suspicious %v, looks like %q; want %v, %q", tc.name, suspicious, looksLike, tc.suspicious, tc.looksLike)
+		}
+	}
+}
+
+func TestInspectLinks(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"text matches the target", `<a href="https://bank.example.com/login">bank.example.com</a>`, nil},
+		{"subdomain of the shown domain", `<a href="https://click.news.example.com/t?id=1">www.example.com</a>`, nil},
+		{"text and target on different domains", `<a href="https://evil.example.net/">https://bank.example.com/login</a>`, []string{WarningLinkMismatch}},
+		{"file name as text", `<a href="https://files.example.net/d/42">report.pdf</a>`, nil},
+		{"ordinary text", `<a href="https://evil.example.net/">Sign in</a>`, nil},
+		{"look-alike target", `<a href="https://` + asciiHost("аррӏе.com") + `/">Sign in</a>`, []string{WarningConfusableDomain}},
+		{"look-alike text and target", `<a href="https://` + asciiHost("pаypal.com") + `/">pаypal.com</a>`, []string{WarningConfusableDomain}},
+		{"not a web link", `<a href="mailto:help@example.net">example.com</a>`, nil},
+	}
+	for _, tc := range cases {
+		var got []string
+		for _, w := range inspectLinks(tc.body) {
+			got = append(got, w.Kind)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: warnings %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: warnings %v, want %v", tc.name, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestCheckImpersonation(t *testing.T) {
+	account := &Account{ID: "a", Email: "me@example.org", Folders: []Folder{{Name: "INBOX", Role: RoleInbox}, {Name: "Sent", Role: RoleSent}}}
+	s := &MailService{accountService: &MailAccountService{accounts: map[string]*Account{"a": account}}}
+
+	cases := []struct {
+		name   string
+		folder string
+		from   Address
+		want   []string
+	}{
+		{"ordinary sender", "INBOX", Address{Name: "Bank", Email: "alerts@bank.example"}, nil},
+		{"name embeds another address", "INBOX", Address{Name: "support@bank.example", Email: "x@evil.example"}, []string{WarningImpersonation}},
+		{"name embeds the same address", "INBOX", Address{Name: "Alerts@Bank.example", Email: "alerts@bank.example"}, nil},
+		{"look-alike domain", "INBOX", Address{Name: "PayPal", Email: "service@pаypal.com"}, []string{WarningConfusableDomain}},
+		{"mail the user sent", "Sent", Address{Name: "support@bank.example", Email: "me@example.org"}, nil},
+	}
+	for _, tc := range cases {
+		email := &Email{From: []Address{tc.from}}
+		s.checkImpersonation("a", tc.folder, email)
+		var got []string
+		for _, w := range email.Warnings {
+			got = append(got, w.Kind)
+		}
+		if len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Errorf("%s: warnings %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRecurrencePeriods bounds how many periods (days, weeks, ...) an
+	// expansion walks, so a rule that never matches cannot loop forever
+	maxRecurrencePeriods = 50000
+)
+
+// recurrenceRule is a parsed RRULE (RFC 5545 section 3.3.10), limited to
+// the parts invitations use in practice
+type recurrenceRule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []int
+}
+
+// weekdayNum is a BYDAY entry such as MO, 2TU or -1FR; nth 0 means every such day
+type weekdayNum struct {
+	nth int
+	day time.Weekday
+}
+
+func parseRecurrenceRule(rule string) (*recurrenceRule, bool) {
+	parts := rruleParts(rule)
+	r := &recurrenceRule{freq: parts["FREQ"], interval: 1}
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, false
+	}
+
+	if n, err := strconv.Atoi(parts["INTERVAL"]); err == nil && n > 0 {
+		r.interval = n
+	}
+	if n, err := strconv.Atoi(parts["COUNT"]); err == nil && n > 0 {
+		r.count = n
+	}
+	if until := parts["UNTIL"]; until != "" {
+		if t, err := time.Parse("20060102T150405Z", until); err == nil {
+			r.until = t
+		} else if t, err := time.Parse("20060102T150405", until); err == nil {
+			r.until = t
+		} else if t, err := time.Parse("20060102", until); err == nil {
+			r.until = t.Add(24*time.Hour - time.Second)
+		}
+	}
+	for _, d := range splitList(parts["BYDAY"]) {
+		m := byDayPattern.FindStringSubmatch(d)
+		if m == nil {
+			continue
+		}
+		nth := 0
+		if m[1] != "" && m[1] != "+" {
+			nth, _ = strconv.Atoi(m[1])
+		}
+		r.byDay = append(r.byDay, weekdayNum{nth: nth, day: icalWeekdays[m[2]]})
+	}
+	for _, d := range splitList(parts["BYMONTHDAY"]) {
+		if n, err := strconv.Atoi(d); err == nil && n != 0 {
+			r.byMonthDay = append(r.byMonthDay, n)
+		}
+	}
+	for _, d := range splitList(parts["BYMONTH"]) {
+		if n, err := strconv.Atoi(d); err == nil && n >= 1 && n <= 12 {
+			r.byMonth = append(r.byMonth, n)
+		}
+	}
+	return r, true
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// expandRecurrence returns the start times of the occurrences of a
+// recurring event that overlap [from, to), given the first start and the
+// event duration. Wall-clock times are kept in loc so occurrences stay at
+// the same local time across daylight saving changes.
+func expandRecurrence(rule string, start time.Time, duration time.Duration, loc *time.Location, from, to time.Time) []time.Time {
+	r, ok := parseRecurrenceRule(rule)
+	if !ok {
+		if start.Before(to) && start.Add(duration).After(from) {
+			return []time.Time{start}
+		}
+		return nil
+	}
+
+	local := start.In(loc)
+	var result []time.Time
+	seen := 0
+
+	for period := 0; period < maxRecurrencePeriods; period++ {
+		candidates := r.candidates(local, period*r.interval, loc)
+		for _, c := range candidates {
+			if c.Before(start) {
+				continue
+			}
+			if !r.until.IsZero() && c.After(r.until) {
+				return result
+			}
+			if !c.Before(to) {
+				return result
+			}
+			seen++
+			if r.count > 0 && seen > r.count {
+				return result
+			}
+			if c.Add(duration).After(from) {
+				result = append(result, c)
+			}
+		}
+	}
+	return result
+}
+
+// candidates lists the occurrence starts in the period offset periods after
+// the one holding the first occurrence, in order
+func (r *recurrenceRule) candidates(first time.Time, offset int, loc *time.Location) []time.Time {
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, first.Hour(), first.Minute(), first.Second(), 0, loc)
+	}
+
+	var days []time.Time
+	switch r.freq {
+	case "DAILY":
+		day := at(first.Year(), first.Month(), first.Day()+offset)
+		if r.matchesMonth(day.Month()) && r.matchesWeekday(day.Weekday()) {
+			days = append(days, day)
+		}
+
+	case "WEEKLY":
+		// Weeks start on Monday (the RFC 5545 default WKST)
+		back := (int(first.Weekday()) + 6) % 7
+		monday := at(first.Year(), first.Month(), first.Day()-back+7*offset)
+		if len(r.byDay) == 0 {
+			days = append(days, monday.AddDate(0, 0, back))
+			break
+		}
+		for _, wd := range r.byDay {
+			days = append(days, at(monday.Year(), monday.Month(), monday.Day()+(int(wd.day)+6)%7))
+		}
+
+	case "MONTHLY":
+		month := time.Date(first.Year(), first.Month()+time.Month(offset), 1, 0, 0, 0, 0, loc)
+		if r.matchesMonth(month.Month()) {
+			days = r.daysInMonth(month.Year(), month.Month(), first, at)
+		}
+
+	case "YEARLY":
+		year := first.Year() + offset
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []int{int(first.Month())}
+		}
+		for _, m := range months {
+			days = append(days, r.daysInMonth(year, time.Month(m), first, at)...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// daysInMonth applies BYMONTHDAY or BYDAY within one month, defaulting to
+// the day of the month of the first occurrence
+func (r *recurrenceRule) daysInMonth(year int, month time.Month, first time.Time, at func(int, time.Month, int) time.Time) []time.Time {
+	length := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	var days []time.Time
+
+	switch {
+	case len(r.byMonthDay) > 0:
+		for _, d := range r.byMonthDay {
+			if d < 0 {
+				d = length + d + 1
+			}
+			if d >= 1 && d <= length {
+				days = append(days, at(year, month, d))
+			}
+		}
+	case len(r.byDay) > 0:
+		for _, wd := range r.byDay {
+			firstOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+			firstMatch := 1 + (int(wd.day)-int(firstOfMonth.Weekday())+7)%7
+			var matches []int
+			for d := firstMatch; d <= length; d += 7 {
+				matches = append(matches, d)
+			}
+			switch {
+			case wd.nth == 0:
+				for _, d := range matches {
+					days = append(days, at(year, month, d))
+				}
+			case wd.nth > 0 && wd.nth <= len(matches):
+				days = append(days, at(year, month, matches[wd.nth-1]))
+			case wd.nth < 0 && -wd.nth <= len(matches):
+				days = append(days, at(year, month, matches[len(matches)+wd.nth]))
+			}
+		}
+	default:
+		if first.Day() <= length {
+			days = append(days, at(year, month, first.Day()))
+		}
+	}
+	return days
+}
+
+func (r *recurrenceRule) matchesMonth(m time.Month) bool {
+	if len(r.byMonth) == 0 {
+		return true
+	}
+	for _, bm := range r.byMonth {
+		if time.Month(bm) == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recurrenceRule) matchesWeekday(d time.Weekday) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, wd := range r.byDay {
+		if wd.day == d {
+			return true
+		}
+	}
+	return false
+}
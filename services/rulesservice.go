@@ -0,0 +1,926 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// Rule condition fields
+const (
+	RuleFieldFrom          = "from"
+	RuleFieldTo            = "to" // To and Cc
+	RuleFieldSubject       = "subject"
+	RuleFieldHeader        = "header"
+	RuleFieldBody          = "body"
+	RuleFieldSize          = "size"
+	RuleFieldHasAttachment = "has-attachment"
+)
+
+// Rule condition operators
+const (
+	RuleOpContains = "contains" // case-insensitive substring
+	RuleOpMatches  = "matches"  // case-insensitive regular expression
+	RuleOpLarger   = "larger"   // size in bytes
+	RuleOpSmaller  = "smaller"
+)
+
+// How the conditions of a rule combine
+const (
+	RuleMatchAll = "all"
+	RuleMatchAny = "any"
+)
+
+// Rule actions
+const (
+	RuleActionMove       = "move"
+	RuleActionCopy       = "copy"
+	RuleActionFlag       = "flag"
+	RuleActionMarkRead   = "mark-read"
+	RuleActionAddKeyword = "add-keyword"
+	RuleActionForward    = "forward"
+	RuleActionAutoReply  = "auto-reply"
+	RuleActionNotify     = "notify"
+)
+
+// EventRuleNotification is emitted with a RuleNotification by the notify action
+const EventRuleNotification = "mail:rule-notification"
+
+const (
+	// autoReplyInterval is how long a rule waits before answering the same
+	// sender again (RFC 3834, section 2)
+	autoReplyInterval = 7 * 24 * time.Hour
+	// maxDryRunMatches bounds the messages DryRunRule returns
+	maxDryRunMatches = 200
+)
+
+// RuleCondition tests one property of a message
+type RuleCondition struct {
+	Field    string `json:"field"`
+	Header   string `json:"header,omitempty"` // the header name when Field is header
+	Operator string `json:"operator"`         // contains or matches; larger or smaller for size
+	Value    string `json:"value"`            // text or pattern; a byte count for size
+	Negate   bool   `json:"negate"`
+}
+
+// RuleAction is what a rule does to a matching message
+type RuleAction struct {
+	Type    string    `json:"type"`
+	Folder  string    `json:"folder,omitempty"`  // move and copy
+	Keyword string    `json:"keyword,omitempty"` // add-keyword
+	To      []Address `json:"to,omitempty"`      // forward
+	Subject string    `json:"subject,omitempty"` // auto-reply; empty answers "Re: <subject>"
+	Body    string    `json:"body,omitempty"`    // auto-reply text, or the notification message
+}
+
+// Rule files new mail: when the conditions match, the actions run
+type Rule struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	AccountID string `json:"accountId"` // empty applies to every account
+	Folder    string `json:"folder"`    // folder watched; empty watches the Inbox
+	Enabled   bool   `json:"enabled"`
+	Match     string `json:"match"` // all or any of the conditions
+	// Stop keeps later rules from seeing messages this one matched
+	Stop       bool            `json:"stop"`
+	Conditions []RuleCondition `json:"conditions"`
+	Actions    []RuleAction    `json:"actions"`
+	Position   int             `json:"position"`
+	CreatedAt  string          `json:"createdAt"`
+	UpdatedAt  string          `json:"updatedAt"`
+}
+
+// RuleNotification is the payload of EventRuleNotification
+type RuleNotification struct {
+	RuleID    string    `json:"ruleId"`
+	RuleName  string    `json:"ruleName"`
+	AccountID string    `json:"accountId"`
+	Folder    string    `json:"folder"`
+	UID       uint32    `json:"uid"`
+	From      []Address `json:"from"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+}
+
+// RuleDryRun reports which cached messages a rule would match
+type RuleDryRun struct {
+	Matches []*Email `json:"matches"` // newest first, at most maxDryRunMatches
+	Matched int      `json:"matched"`
+	Scanned int      `json:"scanned"`
+	// Unevaluated counts messages the rule needs the source of that is not
+	// stored locally
+	Unevaluated int `json:"unevaluated"`
+}
+
+// RulesService runs the user's rules on mail that arrives during a sync
+type RulesService struct {
+	store          *RulesStore
+	mailService    *MailService
+	accountService *MailAccountService
+	runMutex       sync.Mutex // one batch of new mail at a time
+}
+
+// NewRulesService creates the rules service and subscribes it to new mail
+func NewRulesService(accountService *MailAccountService, mailService *MailService) *RulesService {
+	configDir, err := getUserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
+	}
+	dbPath := filepath.Join(configDir, "wmail", "rules.db")
+
+	store, err := NewRulesStore(dbPath)
+	if err != nil {
+		fmt.Printf("[RulesService] Failed to open rules: %v\n", err)
+		store = nil
+	} else {
+		fmt.Printf("[RulesService] Rules opened at %s\n", dbPath)
+	}
+
+	s := &RulesService{store: store, mailService: mailService, accountService: accountService}
+
+	if store != nil {
+		accountService.RegisterLifecycleHook(s.lifecycleHook())
+		mailService.RegisterNewMailHook(NewMailHook{Name: "rules", OnNewMail: s.runRules})
+	}
+
+	return s
+}
+
+func (s *RulesService) checkStore() error {
+	if s.store == nil {
+		return fmt.Errorf("rules are not available")
+	}
+	return nil
+}
+
+// GetRules returns every rule in the order they run
+func (s *RulesService) GetRules() ([]*Rule, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	return s.store.List()
+}
+
+// SaveRule creates a rule, or updates the one with the same ID
+func (s *RulesService) SaveRule(rule *Rule) (*Rule, error) {
+	if err := s.checkStore(); err != nil {
+		return nil, err
+	}
+	if rule.Match == "" {
+		rule.Match = RuleMatchAll
+	}
+	if _, err := compileRule(rule); err != nil {
+		return nil, err
+	}
+
+	if rule.ID == "" {
+		rule.ID = generateUUID()
+		rule.CreatedAt = ""
+	} else {
+		existing, err := s.store.Get(rule.ID)
+		if err != nil {
+			return nil, err
+		}
+		rule.CreatedAt = existing.CreatedAt
+		rule.Position = existing.Position
+	}
+	if err := s.store.Save(rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// DeleteRule removes a rule
+func (s *RulesService) DeleteRule(id string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	return s.store.Delete(id)
+}
+
+// ReorderRules sets the order rules run in
+func (s *RulesService) ReorderRules(ids []string) error {
+	if err := s.checkStore(); err != nil {
+		return err
+	}
+	return s.store.Reorder(ids)
+}
+
+// DryRunRule reports the cached messages of the rule's folder a rule would
+// match, without running its actions. The rule need not be saved.
+// Conditions on headers, size and attachments only see messages whose
+// source is stored; nothing is downloaded.
+func (s *RulesService) DryRunRule(rule *Rule) (*RuleDryRun, error) {
+	if rule.Match == "" {
+		rule.Match = RuleMatchAll
+	}
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	cache := s.mailService.cache
+	if cache == nil {
+		return nil, fmt.Errorf("cache is not available")
+	}
+
+	result := &RuleDryRun{Matches: []*Email{}}
+	for _, account := range s.accountService.GetAccounts() {
+		if rule.AccountID != "" && rule.AccountID != account.ID {
+			continue
+		}
+		folder := rule.Folder
+		if folder == "" {
+			folder = inboxName(account)
+		}
+		uids, err := cache.GetCachedUIDs(account.ID, folder)
+		if err != nil {
+			return nil, err
+		}
+		emails, err := cache.GetCachedEmailsByUIDs(account.ID, folder, uids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, email := range emails {
+			result.Scanned++
+			accountID, uid := account.ID, email.UID
+			msg := &ruleMessage{email: email, load: func() ([]byte, error) {
+				raw, err := cache.GetRawMessage(accountID, folder, uid)
+				if err != nil || len(raw) == 0 {
+					return nil, errNoSource
+				}
+				return raw, nil
+			}}
+			matched, err := compiled.matches(msg)
+			switch {
+			case errors.Is(err, errNoSource):
+				result.Unevaluated++
+			case err != nil:
+				return nil, err
+			case matched:
+				result.Matched++
+				result.Matches = append(result.Matches, email)
+			}
+		}
+	}
+
+	sort.Slice(result.Matches, func(i, j int) bool { return result.Matches[i].Date > result.Matches[j].Date })
+	if len(result.Matches) > maxDryRunMatches {
+		result.Matches = result.Matches[:maxDryRunMatches]
+	}
+	return result, nil
+}
+
+// inboxName returns the name of an account's Inbox
+func inboxName(account *Account) string {
+	if inbox := account.folderForRole(RoleInbox); inbox != nil {
+		return inbox.Name
+	}
+	return "INBOX"
+}
+
+// runRules applies the enabled rules watching folder to new messages, in
+// order; a message moved away or matched by a stopping rule is done
+func (s *RulesService) runRules(accountID, folder string, emails []*Email) {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil {
+		return
+	}
+	rules, err := s.store.ListAccount(accountID)
+	if err != nil {
+		fmt.Printf("[RulesService] Failed to load rules: %v\n", err)
+		return
+	}
+
+	var compiled []*compiledRule
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Folder != folder && (rule.Folder != "" || account.roleOf(folder) != RoleInbox) {
+			continue
+		}
+		c, err := compileRule(rule)
+		if err != nil {
+			fmt.Printf("[RulesService] Skipping invalid rule %q: %v\n", rule.Name, err)
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+	if len(compiled) == 0 {
+		return
+	}
+
+	for _, email := range emails {
+		uid := email.UID
+		msg := &ruleMessage{email: email, load: func() ([]byte, error) {
+			source, err := s.mailService.GetEmailSource(accountID, folder, uid)
+			return []byte(source), err
+		}}
+		for _, rule := range compiled {
+			matched, err := rule.matches(msg)
+			if err != nil {
+				fmt.Printf("[RulesService] Rule %q could not read %s/%d: %v\n", rule.Name, folder, uid, err)
+				continue
+			}
+			if !matched {
+				continue
+			}
+			fmt.Printf("[RulesService] Rule %q matched %s/%d\n", rule.Name, folder, uid)
+			moved := s.applyActions(account, folder, rule.Rule, msg)
+			if moved || rule.Stop {
+				break
+			}
+		}
+	}
+}
+
+// applyActions runs a rule's actions on a message, moving it last, and
+// reports whether it was moved
+func (s *RulesService) applyActions(account *Account, folder string, rule *Rule, msg *ruleMessage) bool {
+	email := msg.email
+	queue := func(op *PendingOp) {
+		op.AccountID, op.Folder, op.UID = account.ID, folder, email.UID
+		if err := s.mailService.queueOp(op); err != nil {
+			fmt.Printf("[RulesService] Rule %q failed to %s %s/%d: %v\n", rule.Name, op.Op, folder, email.UID, err)
+		}
+	}
+
+	var moveTo string
+	for _, action := range rule.Actions {
+		var err error
+		switch action.Type {
+		case RuleActionMove:
+			if moveTo == "" && action.Folder != folder {
+				moveTo = action.Folder
+			}
+		case RuleActionCopy:
+			queue(&PendingOp{Op: OpCopy, TargetFolder: action.Folder})
+		case RuleActionFlag:
+			if !email.IsStarred {
+				queue(&PendingOp{Op: OpStar})
+			}
+		case RuleActionMarkRead:
+			if !email.IsRead {
+				queue(&PendingOp{Op: OpMarkRead})
+			}
+		case RuleActionAddKeyword:
+			queue(&PendingOp{Op: OpAddKeyword, Keyword: action.Keyword})
+		case RuleActionForward:
+			err = s.forward(account, action, msg)
+		case RuleActionAutoReply:
+			err = s.autoReply(account, rule, action, msg)
+		case RuleActionNotify:
+			emitEvent(EventRuleNotification, RuleNotification{
+				RuleID:    rule.ID,
+				RuleName:  rule.Name,
+				AccountID: account.ID,
+				Folder:    folder,
+				UID:       email.UID,
+				From:      email.From,
+				Subject:   email.Subject,
+				Message:   action.Body,
+			})
+		}
+		if err != nil {
+			fmt.Printf("[RulesService] Rule %q failed to %s %s/%d: %v\n", rule.Name, action.Type, folder, email.UID, err)
+		}
+	}
+
+	if moveTo == "" {
+		return false
+	}
+	queue(&PendingOp{Op: OpMove, TargetFolder: moveTo})
+	return true
+}
+
+// forward sends the message on inline, below a block with its headers.
+// The copy is marked as automatic so no rule, ours or another's, forwards it back.
+func (s *RulesService) forward(account *Account, action RuleAction, msg *ruleMessage) error {
+	raw, err := msg.source()
+	if err != nil {
+		return err
+	}
+	if reason := forwardRefusal(account, raw, msg.email); reason != "" {
+		return fmt.Errorf("not forwarding: %s", reason)
+	}
+	content, err := msg.content()
+	if err != nil {
+		return err
+	}
+	email := msg.email
+	intro := []string{
+		"---------- Forwarded message ----------",
+		"From: " + formatAddressHeader(email.From),
+		"Date: " + email.Date,
+		"Subject: " + email.Subject,
+		"To: " + formatAddressHeader(email.To),
+	}
+	if len(email.CC) > 0 {
+		intro = append(intro, "Cc: "+formatAddressHeader(email.CC))
+	}
+
+	out := &outgoingMessage{
+		To:            action.To,
+		Subject:       prefixSubject("Fwd: ", email.Subject),
+		AutoSubmitted: "auto-generated",
+		Loop:          account.Email,
+	}
+	if content.HTML {
+		out.HTML = "<p>" + strings.ReplaceAll(html.EscapeString(strings.Join(intro, "\n")), "\n", "<br>\n") + "</p>\n" + content.Body
+	} else {
+		out.Text = strings.Join(intro, "\n") + "\n\n" + content.Body
+	}
+	return s.mailService.deliver(account, out)
+}
+
+// forwardRefusal returns why a message must not be forwarded, or "".
+// Encrypted messages would be sent on in the clear; automatic ones, our own
+// and those we already forwarded could loop between two forwarding rules.
+func forwardRefusal(account *Account, raw []byte, email *Email) string {
+	if isEncryptedMessage(raw) {
+		return "it is encrypted"
+	}
+	fields, _ := rawHeaderFields(canonicalLineEndings(raw))
+	for _, f := range fields {
+		switch f.key {
+		case "auto-submitted":
+			if v := strings.ToLower(f.value()); v != "" && v != "no" {
+				return "it was sent automatically"
+			}
+		case "x-loop":
+			if account.ownsAddress(strings.Trim(f.value(), "<> ")) {
+				return "this account already forwarded it"
+			}
+		}
+	}
+	for _, from := range email.From {
+		if account.ownsAddress(from.Email) {
+			return "it is from this account"
+		}
+	}
+	return ""
+}
+
+// isEncryptedMessage reports whether a message is PGP/MIME or S/MIME
+// encrypted, whether or not it can be decrypted here
+func isEncryptedMessage(raw []byte) bool {
+	header, _, err := splitEntity(canonicalLineEndings(raw))
+	if err != nil {
+		return false
+	}
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "multipart/encrypted" {
+		return true
+	}
+	if !isSMIMEType(mediaType, "pkcs7-mime") {
+		return false
+	}
+	// Opaque signed messages use the same type; encrypted ones may omit smime-type
+	return !strings.EqualFold(params["smime-type"], "signed-data")
+}
+
+// autoReply answers a message unless it was sent automatically or to a
+// list, or the rule answered its sender recently (RFC 3834)
+func (s *RulesService) autoReply(account *Account, rule *Rule, action RuleAction, msg *ruleMessage) error {
+	raw, err := msg.source()
+	if err != nil {
+		return err
+	}
+	to, reason := autoReplyRecipient(account, raw, msg.email)
+	if reason != "" {
+		fmt.Printf("[RulesService] Not auto-replying to %s/%d: %s\n", msg.email.Folder, msg.email.UID, reason)
+		return nil
+	}
+
+	key := strings.ToLower(to.Email)
+	last, err := s.store.LastAutoReply(rule.ID, key)
+	if err != nil {
+		return err
+	}
+	if time.Since(last) < autoReplyInterval {
+		return nil
+	}
+
+	subject := action.Subject
+	if subject == "" {
+		subject = prefixSubject("Re: ", msg.email.Subject)
+	}
+	out := &outgoingMessage{
+		To:            []Address{to},
+		Subject:       subject,
+		Text:          action.Body,
+		InReplyTo:     msg.email.MessageID,
+		AutoSubmitted: "auto-replied",
+	}
+	if msg.email.MessageID != "" {
+		out.References = []string{msg.email.MessageID}
+	}
+	if err := s.mailService.deliver(account, out); err != nil {
+		return err
+	}
+	return s.store.RecordAutoReply(rule.ID, key, time.Now())
+}
+
+// automatedSenders are local parts of addresses that must not get automatic replies
+var automatedSenders = regexp.MustCompile(`(?i)^(mailer-daemon|postmaster|listserv|majordomo|no-?reply|do-?not-?reply|bounces?|owner-.*|.*-request|.*-bounces?)$`)
+
+// autoReplyRecipient returns where an automatic reply to a message goes,
+// or why there must be none
+func autoReplyRecipient(account *Account, raw []byte, email *Email) (Address, string) {
+	fields, _ := rawHeaderFields(canonicalLineEndings(raw))
+	header := func(name string) string {
+		for _, f := range fields {
+			if f.key == name {
+				return f.value()
+			}
+		}
+		return ""
+	}
+
+	if v := strings.ToLower(header("auto-submitted")); v != "" && v != "no" {
+		return Address{}, "it was sent automatically"
+	}
+	switch strings.ToLower(header("precedence")) {
+	case "bulk", "list", "junk":
+		return Address{}, "it is bulk mail"
+	}
+	if header("list-id") != "" || header("list-unsubscribe") != "" {
+		return Address{}, "it came from a mailing list"
+	}
+	if !addressedTo(account, email) {
+		return Address{}, "the account is not in To or Cc"
+	}
+
+	// Replies go to the envelope sender, as vacation responders do
+	returnPath := strings.Trim(header("return-path"), "<> ")
+	if header("return-path") != "" && returnPath == "" {
+		return Address{}, "it is a bounce"
+	}
+	to := Address{Email: returnPath}
+	if to.Email == "" && len(email.From) > 0 {
+		to = email.From[0]
+	}
+	if to.Email == "" {
+		return Address{}, "it has no sender"
+	}
+	if account.ownsAddress(to.Email) {
+		return Address{}, "it is from this account"
+	}
+	if local, _, _ := strings.Cut(to.Email, "@"); automatedSenders.MatchString(local) {
+		return Address{}, "the sender is automated"
+	}
+	return to, ""
+}
+
+// addressedTo reports whether the account or one of its aliases is an
+// explicit To or Cc recipient, as RFC 3834 section 2 requires for replies
+func addressedTo(account *Account, email *Email) bool {
+	for _, list := range [][]Address{email.To, email.CC} {
+		for _, addr := range list {
+			if account.ownsAddress(addr.Email) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prefixSubject adds a reply or forward prefix unless it is already there
+func prefixSubject(prefix, subject string) string {
+	if strings.HasPrefix(strings.ToLower(subject), strings.ToLower(prefix)) {
+		return subject
+	}
+	return prefix + subject
+}
+
+// lifecycleHook exports the rules of an account as rules.json and drops them on delete
+func (s *RulesService) lifecycleHook() AccountLifecycleHook {
+	return AccountLifecycleHook{
+		Name: "rules",
+		OnExport: func(account *Account, dir string) error {
+			rules, err := s.store.ListAccount(account.ID)
+			if err != nil {
+				return err
+			}
+			if len(rules) == 0 {
+				return nil
+			}
+			data, err := json.MarshalIndent(rules, "", "  ")
+			if err != nil {
+				return err
+			}
+			return writeFileAtomic(filepath.Join(dir, "rules.json"), data, 0600)
+		},
+		OnDelete: func(account *Account) error {
+			return s.store.DeleteAccount(account.ID)
+		},
+	}
+}
+
+// errNoSource means a condition needs the message source and it is not stored
+var errNoSource = errors.New("message source is not stored")
+
+// ruleMessage is a message as rules see it. The source is read only when
+// a condition or action needs more than the envelope.
+type ruleMessage struct {
+	email *Email
+	load  func() ([]byte, error)
+
+	loaded  bool
+	raw     []byte
+	loadErr error
+	parsed  *messageContent
+}
+
+func (m *ruleMessage) source() ([]byte, error) {
+	if !m.loaded {
+		m.raw, m.loadErr = m.load()
+		m.loaded = true
+	}
+	return m.raw, m.loadErr
+}
+
+// content returns the displayable body of the source
+func (m *ruleMessage) content() (*messageContent, error) {
+	if m.parsed != nil {
+		return m.parsed, nil
+	}
+	raw, err := m.source()
+	if err != nil {
+		return nil, err
+	}
+	if m.parsed, err = extractContent(raw); err != nil {
+		return nil, err
+	}
+	return m.parsed, nil
+}
+
+// bodyText returns the body as plain text, falling back to the cached body
+// when the source is not stored
+func (m *ruleMessage) bodyText() (string, error) {
+	content, err := m.content()
+	if errors.Is(err, errNoSource) && m.email.Body != "" {
+		return m.email.Body, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if content.HTML {
+		return extractTextBody(content.Body), nil
+	}
+	return content.Body, nil
+}
+
+// headerValues returns the decoded values of a header field
+func (m *ruleMessage) headerValues(name string) ([]string, error) {
+	raw, err := m.source()
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := rawHeaderFields(canonicalLineEndings(raw))
+	var values []string
+	for _, f := range fields {
+		if f.key == name {
+			values = append(values, decodeHeaderText(f.value()))
+		}
+	}
+	return values, nil
+}
+
+// hasAttachment reports whether any part of the message is an attachment
+func (m *ruleMessage) hasAttachment() (bool, error) {
+	raw, err := m.source()
+	if err != nil {
+		return false, err
+	}
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		return false, nil
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			continue
+		}
+		if _, ok := p.Header.(*mail.AttachmentHeader); ok {
+			return true, nil
+		}
+	}
+}
+
+// compiledRule is a validated rule with its patterns compiled
+type compiledRule struct {
+	*Rule
+	conditions []compiledCondition
+}
+
+type compiledCondition struct {
+	RuleCondition
+	pattern *regexp.Regexp
+	size    int64
+}
+
+// validKeyword matches an IMAP flag keyword (RFC 3501 atom, no backslash)
+var validKeyword = regexp.MustCompile(`^[^\s(){%*"\\\]\x00-\x1f\x7f]+$`)
+
+// compileRule checks a rule and compiles its patterns
+func compileRule(rule *Rule) (*compiledRule, error) {
+	if strings.TrimSpace(rule.Name) == "" {
+		return nil, fmt.Errorf("rule needs a name")
+	}
+	if rule.Match != RuleMatchAll && rule.Match != RuleMatchAny {
+		return nil, fmt.Errorf("unknown match mode: %s", rule.Match)
+	}
+	if len(rule.Conditions) == 0 {
+		return nil, fmt.Errorf("rule needs at least one condition")
+	}
+	if len(rule.Actions) == 0 {
+		return nil, fmt.Errorf("rule needs at least one action")
+	}
+
+	compiled := &compiledRule{Rule: rule}
+	for _, cond := range rule.Conditions {
+		c := compiledCondition{RuleCondition: cond}
+		switch cond.Field {
+		case RuleFieldFrom, RuleFieldTo, RuleFieldSubject, RuleFieldHeader, RuleFieldBody:
+			if cond.Field == RuleFieldHeader && strings.TrimSpace(cond.Header) == "" {
+				return nil, fmt.Errorf("header condition needs a header name")
+			}
+			switch cond.Operator {
+			case RuleOpContains:
+				if cond.Value == "" {
+					return nil, fmt.Errorf("%s condition needs a value", cond.Field)
+				}
+			case RuleOpMatches:
+				pattern, err := regexp.Compile("(?i)" + cond.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern %q: %w", cond.Value, err)
+				}
+				c.pattern = pattern
+			default:
+				return nil, fmt.Errorf("unknown operator for %s: %s", cond.Field, cond.Operator)
+			}
+		case RuleFieldSize:
+			if cond.Operator != RuleOpLarger && cond.Operator != RuleOpSmaller {
+				return nil, fmt.Errorf("unknown operator for size: %s", cond.Operator)
+			}
+			size, err := strconv.ParseInt(strings.TrimSpace(cond.Value), 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("size must be a number of bytes")
+			}
+			c.size = size
+		case RuleFieldHasAttachment:
+		default:
+			return nil, fmt.Errorf("unknown condition field: %s", cond.Field)
+		}
+		compiled.conditions = append(compiled.conditions, c)
+	}
+
+	moves := 0
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case RuleActionMove, RuleActionCopy:
+			if action.Folder == "" {
+				return nil, fmt.Errorf("%s needs a target folder", action.Type)
+			}
+			if action.Type == RuleActionMove {
+				moves++
+			}
+		case RuleActionAddKeyword:
+			if !validKeyword.MatchString(action.Keyword) {
+				return nil, fmt.Errorf("invalid keyword: %q", action.Keyword)
+			}
+		case RuleActionForward:
+			if len(action.To) == 0 {
+				return nil, fmt.Errorf("forward needs a recipient")
+			}
+			for _, to := range action.To {
+				if !strings.Contains(to.Email, "@") {
+					return nil, fmt.Errorf("invalid forward address: %q", to.Email)
+				}
+			}
+		case RuleActionAutoReply:
+			if strings.TrimSpace(action.Body) == "" {
+				return nil, fmt.Errorf("auto-reply needs a message")
+			}
+		case RuleActionFlag, RuleActionMarkRead, RuleActionNotify:
+		default:
+			return nil, fmt.Errorf("unknown action: %s", action.Type)
+		}
+	}
+	if moves > 1 {
+		return nil, fmt.Errorf("a rule can move a message to one folder only")
+	}
+	return compiled, nil
+}
+
+// matches evaluates the conditions. A condition that cannot read what it
+// needs fails the rule with that error unless the other conditions decide
+// the result on their own.
+func (r *compiledRule) matches(msg *ruleMessage) (bool, error) {
+	var firstErr error
+	for _, c := range r.conditions {
+		matched, err := c.matches(msg)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if r.Match == RuleMatchAny && matched {
+			return true, nil
+		}
+		if r.Match == RuleMatchAll && !matched {
+			return false, nil
+		}
+	}
+	if firstErr != nil {
+		return false, firstErr
+	}
+	return r.Match == RuleMatchAll, nil
+}
+
+func (c *compiledCondition) matches(msg *ruleMessage) (bool, error) {
+	var matched bool
+	switch c.Field {
+	case RuleFieldSize:
+		raw, err := msg.source()
+		if err != nil {
+			return false, err
+		}
+		size := int64(len(raw))
+		matched = c.Operator == RuleOpLarger && size > c.size || c.Operator == RuleOpSmaller && size < c.size
+	case RuleFieldHasAttachment:
+		has, err := msg.hasAttachment()
+		if err != nil {
+			return false, err
+		}
+		matched = has
+	default:
+		values, err := c.values(msg)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range values {
+			if c.pattern != nil && c.pattern.MatchString(v) ||
+				c.pattern == nil && strings.Contains(strings.ToLower(v), strings.ToLower(c.Value)) {
+				matched = true
+				break
+			}
+		}
+	}
+	return matched != c.Negate, nil
+}
+
+// values returns the texts a condition is tested against
+func (c *compiledCondition) values(msg *ruleMessage) ([]string, error) {
+	email := msg.email
+	switch c.Field {
+	case RuleFieldFrom:
+		return addressTexts(email.From), nil
+	case RuleFieldTo:
+		return addressTexts(append(append([]Address(nil), email.To...), email.CC...)), nil
+	case RuleFieldSubject:
+		return []string{email.Subject}, nil
+	case RuleFieldHeader:
+		return msg.headerValues(strings.ToLower(strings.TrimSpace(c.Header)))
+	case RuleFieldBody:
+		body, err := msg.bodyText()
+		if err != nil {
+			return nil, err
+		}
+		return []string{body}, nil
+	}
+	return nil, nil
+}
+
+// addressTexts formats addresses as `Name <email>` for matching
+func addressTexts(addrs []Address) []string {
+	texts := make([]string, len(addrs))
+	for i, a := range addrs {
+		if a.Name != "" {
+			texts[i] = a.Name + " <" + a.Email + ">"
+		} else {
+			texts[i] = a.Email
+		}
+	}
+	return texts
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAutoReplyNeedsExplicitRecipient(t *testing.T) {
+	account := &Account{Email: "me@example.com", Aliases: []string{"sales@example.com"}}
+	raw := []byte("From: jane@shop.example\r\nSubject: Hi\r\n\r\nhello\r\n")
+	jane := []Address{{Email: "jane@shop.example"}}
+
+	cases := []struct {
+		name   string
+		to, cc []Address
+		answer bool
+	}{
+		{"To the account", []Address{{Email: "Me@Example.com"}}, nil, true},
+		{"Cc an alias", []Address{{Email: "team@example.com"}}, []Address{{Email: "sales@example.com"}}, true},
+		{"Bcc or a list", []Address{{Email: "team@example.com"}}, nil, false},
+		{"no recipients", nil, nil, false},
+	}
+	for _, tc := range cases {
+		to, reason := autoReplyRecipient(account, raw, &Email{From: jane, To: tc.to, CC: tc.cc})
+		if answered := reason == ""; answered != tc.answer {
+			t.Errorf("%s: answered %v (%s), want %v", tc.name, answered, reason, tc.answer)
+		} else if answered && to.Email != "jane@shop.example" {
+			t.Errorf("%s: reply to %s", tc.name, to.Email)
+		}
+	}
+}
+
+func TestForwardRefusesEncryptedMessages(t *testing.T) {
+	s := &RulesService{}
+	account := &Account{Email: "me@example.com"}
+	action := RuleAction{Type: RuleActionForward, To: []Address{{Email: "elsewhere@example.net"}}}
+
+	for _, contentType := range []string{
+		`multipart/encrypted; protocol="application/pgp-encrypted"; boundary="b"`,
+		`application/pkcs7-mime; smime-type=enveloped-data; name="smime.p7m"`,
+		`application/x-pkcs7-mime; name="smime.p7m"`,
+	} {
+		raw := "From: jane@shop.example\r\nTo: me@example.com\r\nContent-Type: " + contentType + "\r\n\r\n--b--\r\n"
+		msg := &ruleMessage{email: &Email{}, load: func() ([]byte, error) { return []byte(raw), nil }}
+		if err := s.forward(account, action, msg); err == nil || !strings.Contains(err.Error(), "encrypted") {
+			t.Errorf("%s: %v", contentType, err)
+		}
+	}
+
+	if isEncryptedMessage([]byte("Content-Type: application/pkcs7-mime; smime-type=signed-data\r\n\r\n")) {
+		t.Error("opaque signed message taken for encrypted")
+	}
+}
+
+func TestForwardDoesNotLoop(t *testing.T) {
+	account := &Account{Email: "me@example.com", Aliases: []string{"sales@example.com"}}
+	jane := []Address{{Email: "jane@shop.example"}}
+
+	cases := []struct {
+		name    string
+		headers string
+		from    []Address
+		forward bool
+	}{
+		{"ordinary mail", "", jane, true},
+		{"Auto-Submitted: no", "Auto-Submitted: no\r\n", jane, true},
+		{"auto-replied", "Auto-Submitted: auto-replied\r\n", jane, false},
+		{"forwarded by another rule", "Auto-Submitted: auto-generated\r\n", jane, false},
+		{"our own X-Loop", "X-Loop: me@example.com\r\n", jane, false},
+		{"another X-Loop", "X-Loop: list@lists.example\r\n", jane, true},
+		{"from an alias", "", []Address{{Email: "Sales@Example.com"}}, false},
+	}
+	for _, tc := range cases {
+		raw := []byte("From: someone\r\n" + tc.headers + "Subject: Hi\r\n\r\nhello\r\n")
+		reason := forwardRefusal(account, raw, &Email{From: tc.from})
+		if forwarded := reason == ""; forwarded != tc.forward {
+			t.Errorf("%s: forwarded %v (%s), want %v", tc.name, forwarded, reason, tc.forward)
+		}
+	}
+
+	// The forwarded copy carries the marks that stop it coming back
+	h, err := messageHeader(&outgoingMessage{From: Address{Email: "me@example.com"}, AutoSubmitted: "auto-generated", Loop: "me@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := []byte("X-Loop: " + h.Get("X-Loop") + "\r\nAuto-Submitted: " + h.Get("Auto-Submitted") + "\r\n\r\n")
+	if forwardRefusal(account, raw, &Email{From: jane}) == "" {
+		t.Error("a forwarded copy would be forwarded again")
+	}
+}
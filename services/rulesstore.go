@@ -0,0 +1,269 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rulesMigrations must be sorted by version and never edited once released
+var rulesMigrations = []schemaMigration{
+	{
+		version:     1,
+		description: "mail rules and auto-reply history",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS rules (
+					id TEXT PRIMARY KEY,
+					account_id TEXT NOT NULL DEFAULT '',
+					folder TEXT NOT NULL DEFAULT '',
+					name TEXT NOT NULL,
+					enabled INTEGER NOT NULL DEFAULT 1,
+					position INTEGER NOT NULL DEFAULT 0,
+					match_mode TEXT NOT NULL DEFAULT 'all',
+					conditions TEXT NOT NULL DEFAULT '[]',
+					actions TEXT NOT NULL DEFAULT '[]',
+					stop INTEGER NOT NULL DEFAULT 0,
+					created_at TEXT NOT NULL,
+					updated_at TEXT NOT NULL
+				);
+				CREATE INDEX IF NOT EXISTS idx_rules_position ON rules(position);
+				CREATE TABLE IF NOT EXISTS auto_replies (
+					rule_id TEXT NOT NULL,
+					address TEXT NOT NULL,
+					replied_at INTEGER NOT NULL,
+					PRIMARY KEY (rule_id, address)
+				);
+			`)
+			return err
+		},
+	},
+}
+
+// RulesStore keeps mail rules in their own SQLite database next to the
+// email cache
+type RulesStore struct {
+	db   *sql.DB
+	lock sync.RWMutex
+}
+
+// NewRulesStore opens or creates the rules database
+func NewRulesStore(dbPath string) (*RulesStore, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
+	}
+
+	if err := migrateSchema(db, "RulesStore", rulesMigrations); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return &RulesStore{db: db}, nil
+}
+
+const ruleColumns = `id, account_id, folder, name, enabled, position, match_mode, conditions, actions, stop,
+	created_at, updated_at`
+
+func scanRule(row interface{ Scan(...any) error }) (*Rule, error) {
+	var r Rule
+	var conditions, actions string
+	err := row.Scan(&r.ID, &r.AccountID, &r.Folder, &r.Name, &r.Enabled, &r.Position, &r.Match,
+		&conditions, &actions, &r.Stop, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	json.Unmarshal([]byte(conditions), &r.Conditions)
+	json.Unmarshal([]byte(actions), &r.Actions)
+	if r.Conditions == nil {
+		r.Conditions = []RuleCondition{}
+	}
+	if r.Actions == nil {
+		r.Actions = []RuleAction{}
+	}
+	return &r, nil
+}
+
+func (s *RulesStore) query(where string, args ...any) ([]*Rule, error) {
+	rows, err := s.db.Query(`SELECT `+ruleColumns+` FROM rules `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []*Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// List returns every rule in the order they run
+func (s *RulesStore) List() ([]*Rule, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(`ORDER BY position, created_at`)
+}
+
+// ListAccount returns the rules that apply to an account, including the
+// ones for every account
+func (s *RulesStore) ListAccount(accountID string) ([]*Rule, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(`WHERE account_id = '' OR account_id = ? ORDER BY position, created_at`, accountID)
+}
+
+// Get returns one rule
+func (s *RulesStore) Get(id string) (*Rule, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	rules, err := s.query(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("rule not found")
+	}
+	return rules[0], nil
+}
+
+// Save inserts or updates a rule. New rules run after the existing ones.
+func (s *RulesStore) Save(r *Rule) error {
+	conditions, err := json.Marshal(r.Conditions)
+	if err != nil {
+		return err
+	}
+	actions, err := json.Marshal(r.Actions)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := getCurrentTime()
+	r.UpdatedAt = now
+	if r.CreatedAt == "" {
+		r.CreatedAt = now
+		if err := s.db.QueryRow(`SELECT COALESCE(MAX(position) + 1, 0) FROM rules`).Scan(&r.Position); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO rules (`+ruleColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			account_id = excluded.account_id,
+			folder = excluded.folder,
+			name = excluded.name,
+			enabled = excluded.enabled,
+			match_mode = excluded.match_mode,
+			conditions = excluded.conditions,
+			actions = excluded.actions,
+			stop = excluded.stop,
+			updated_at = excluded.updated_at
+	`, r.ID, r.AccountID, r.Folder, r.Name, r.Enabled, r.Position, r.Match,
+		string(conditions), string(actions), r.Stop, r.CreatedAt, r.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save rule: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a rule and its auto-reply history
+func (s *RulesStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res, err := s.db.Exec(`DELETE FROM rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("rule not found")
+	}
+	_, err = s.db.Exec(`DELETE FROM auto_replies WHERE rule_id = ?`, id)
+	return err
+}
+
+// Reorder sets the order rules run in; rules not listed keep running after
+// the listed ones
+func (s *RulesStore) Reorder(ids []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE rules SET position = position + ?`, len(ids)); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.Exec(`UPDATE rules SET position = ? WHERE id = ?`, i, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteAccount removes the rules of one account
+func (s *RulesStore) DeleteAccount(accountID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`DELETE FROM auto_replies WHERE rule_id IN (SELECT id FROM rules WHERE account_id = ?)`, accountID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`DELETE FROM rules WHERE account_id = ?`, accountID)
+	return err
+}
+
+// LastAutoReply returns when a rule last answered an address
+func (s *RulesStore) LastAutoReply(ruleID, address string) (time.Time, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var at int64
+	err := s.db.QueryRow(`SELECT replied_at FROM auto_replies WHERE rule_id = ? AND address = ?`,
+		ruleID, address).Scan(&at)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(at, 0), nil
+}
+
+// RecordAutoReply remembers that a rule answered an address
+func (s *RulesStore) RecordAutoReply(ruleID, address string, at time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`
+		INSERT INTO auto_replies (rule_id, address, replied_at) VALUES (?, ?, ?)
+		ON CONFLICT(rule_id, address) DO UPDATE SET replied_at = excluded.replied_at
+	`, ruleID, address, at.Unix())
+	return err
+}
+
+// Close closes the database
+func (s *RulesStore) Close() error {
+	return s.db.Close()
+}
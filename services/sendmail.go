@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// outgoingMessage is a message on its way to the SMTP server
+type outgoingMessage struct {
+	From       Address
+	To         []Address
+	CC         []Address
+	BCC        []Address // envelope recipients only, never written to the header
+	ReplyTo    []Address
+	Subject    string
+	Text       string
+	HTML       string
+	InReplyTo  string   // Message-ID of the message answered
+	References []string // Message-IDs of the thread
+	Calendar   *calendarPart
+	Sign       bool   // PGP/MIME sign with the sender's key
+	Encrypt    bool   // PGP/MIME encrypt to every recipient
+	SMIMESign  bool   // S/MIME sign with the sender's identity
+	Autocrypt  string // our Autocrypt header, when we have a key
+	// AutoSubmitted marks a message sent without the user (RFC 3834)
+	AutoSubmitted string
+	// Loop is the X-Loop address of an account forwarding automatically
+	Loop string
+}
+
+// calendarPart is an iTIP (RFC 5546) text/calendar alternative
+type calendarPart struct {
+	Method string
+	Data   string
+}
+
+// recipients returns every envelope recipient
+func (m *outgoingMessage) recipients() []string {
+	var rcpts []string
+	for _, list := range [][]Address{m.To, m.CC, m.BCC} {
+		for _, addr := range list {
+			if addr.Email != "" {
+				rcpts = append(rcpts, addr.Email)
+			}
+		}
+	}
+	return rcpts
+}
+
+// buildMessage renders an outgoing message as RFC 5322. Plain text comes
+// first, then HTML and calendar data as alternatives.
+func buildMessage(msg *outgoingMessage) ([]byte, error) {
+	h, err := messageHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeMessageBody(&buf, h, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// messageHeader returns the RFC 5322 header fields of a message, without
+// its content type
+func messageHeader(msg *outgoingMessage) (mail.Header, error) {
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetAddressList("From", mailAddresses([]Address{msg.From}))
+	if len(msg.To) > 0 {
+		h.SetAddressList("To", mailAddresses(msg.To))
+	}
+	if len(msg.CC) > 0 {
+		h.SetAddressList("Cc", mailAddresses(msg.CC))
+	}
+	if len(msg.ReplyTo) > 0 {
+		h.SetAddressList("Reply-To", mailAddresses(msg.ReplyTo))
+	}
+	h.SetSubject(msg.Subject)
+	if msg.Autocrypt != "" {
+		h.Set("Autocrypt", msg.Autocrypt)
+	}
+	if msg.AutoSubmitted != "" {
+		h.Set("Auto-Submitted", msg.AutoSubmitted)
+	}
+	if msg.Loop != "" {
+		h.Set("X-Loop", msg.Loop)
+	}
+	if err := h.GenerateMessageIDWithHostname(addressDomain(msg.From.Email)); err != nil {
+		return h, err
+	}
+	if msg.InReplyTo != "" {
+		h.SetMsgIDList("In-Reply-To", []string{trimMsgID(msg.InReplyTo)})
+	}
+	if len(msg.References) > 0 {
+		refs := make([]string, len(msg.References))
+		for i, ref := range msg.References {
+			refs[i] = trimMsgID(ref)
+		}
+		h.SetMsgIDList("References", refs)
+	}
+	return h, nil
+}
+
+// writeMessageBody writes h followed by the body of msg. Text is
+// quoted-printable so it survives transport byte for byte, which
+// signatures over it depend on.
+func writeMessageBody(w io.Writer, h mail.Header, msg *outgoingMessage) error {
+	text := msg.Text
+	if text == "" && msg.HTML != "" {
+		text = extractTextBody(msg.HTML)
+	}
+
+	if msg.HTML == "" && msg.Calendar == nil {
+		h.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		tw, err := mail.CreateSingleInlineWriter(w, h)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, text); err != nil {
+			return err
+		}
+		return tw.Close()
+	}
+
+	mw, err := mail.CreateInlineWriter(w, h)
+	if err != nil {
+		return err
+	}
+	if err := writeInlinePart(mw, "text/plain", map[string]string{"charset": "utf-8"}, text); err != nil {
+		return err
+	}
+	if msg.HTML != "" {
+		if err := writeInlinePart(mw, "text/html", map[string]string{"charset": "utf-8"}, msg.HTML); err != nil {
+			return err
+		}
+	}
+	if msg.Calendar != nil {
+		params := map[string]string{"charset": "utf-8", "method": msg.Calendar.Method}
+		if err := writeInlinePart(mw, "text/calendar", params, msg.Calendar.Data); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func writeInlinePart(mw *mail.InlineWriter, contentType string, params map[string]string, body string) error {
+	var ph mail.InlineHeader
+	ph.SetContentType(contentType, params)
+	ph.Set("Content-Transfer-Encoding", "quoted-printable")
+	w, err := mw.CreatePart(ph)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// deliver sends a message through the account's SMTP server
+func (s *MailService) deliver(account *Account, msg *outgoingMessage) error {
+	if msg.From.Email == "" {
+		msg.From = Address{Name: account.Name, Email: account.Email}
+	}
+	rcpts := msg.recipients()
+	if len(rcpts) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+	if msg.Autocrypt == "" {
+		msg.Autocrypt = s.autocryptHeader(msg.From.Email)
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case msg.SMIMESign && (msg.Sign || msg.Encrypt):
+		return fmt.Errorf("cannot combine S/MIME and PGP")
+	case msg.SMIMESign:
+		data, err = s.buildSMIMEMessage(msg)
+	case msg.Sign || msg.Encrypt:
+		data, err = s.buildPGPMessage(account.ID, msg)
+	default:
+		data, err = buildMessage(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	c, err := ConnectSMTP(account)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Mail(msg.From.Email); err != nil {
+		return fmt.Errorf("sender rejected: %w", err)
+	}
+	for _, rcpt := range rcpts {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", rcpt, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected: %w", err)
+	}
+
+	fmt.Printf("[SendEmail] Sent %q to %d recipients\n", msg.Subject, len(rcpts))
+	return c.Quit()
+}
+
+// mailAddresses converts addresses for go-message headers
+func mailAddresses(addrs []Address) []*mail.Address {
+	result := make([]*mail.Address, len(addrs))
+	for i, addr := range addrs {
+		result[i] = &mail.Address{Name: addr.Name, Address: addr.Email}
+	}
+	return result
+}
+
+// addressDomain returns the domain of an email address, for Message-IDs
+func addressDomain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 && i < len(email)-1 {
+		return email[i+1:]
+	}
+	return "localhost"
+}
+
+// trimMsgID strips the angle brackets around a Message-ID
+func trimMsgID(id string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">")
+}
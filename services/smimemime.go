@@ -0,0 +1,292 @@
+package services
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/smallstep/pkcs7"
+)
+
+// maxSMIMEDepth bounds nested signed and enveloped layers
+const maxSMIMEDepth = 4
+
+// isSMIMEType reports whether a media type is one of the S/MIME types,
+// with or without the legacy x- prefix
+func isSMIMEType(mediaType, subtype string) bool {
+	return strings.EqualFold(mediaType, "application/"+subtype) ||
+		strings.EqualFold(mediaType, "application/x-"+subtype)
+}
+
+// openSMIME unwraps S/MIME (RFC 8551) signed and enveloped messages. Like
+// openPGPMIME it returns the raw message and nil when it is neither, and a
+// nil entity when it could not be decrypted. received is when the server
+// got the message, zero when unknown.
+func (s *MailService) openSMIME(raw []byte, received time.Time) ([]byte, *MessageSecurity) {
+	return s.openSMIMELayer(canonicalLineEndings(raw), "", received, maxSMIMEDepth)
+}
+
+func (s *MailService) openSMIMELayer(raw []byte, from string, received time.Time, depth int) ([]byte, *MessageSecurity) {
+	header, body, err := splitEntity(raw)
+	if err != nil || depth == 0 {
+		return raw, nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	// Inner layers carry no From of their own; the outer one counts
+	if addrs, err := (&mail.Header{Header: message.Header{Header: header}}).AddressList("From"); err == nil && len(addrs) > 0 {
+		from = addrs[0].Address
+	}
+
+	switch {
+	case mediaType == "multipart/signed" && isSMIMEType(params["protocol"], "pkcs7-signature"):
+		security := &MessageSecurity{Protocol: SecuritySMIME, Signed: true}
+		parts := multipartParts(body, params["boundary"])
+		if len(parts) < 2 {
+			security.Signature = SignatureInvalid
+			security.Error = "malformed signed message"
+			return raw, security
+		}
+		signature, err := partBody(parts[1])
+		if err != nil {
+			security.Signature = SignatureInvalid
+			security.Error = err.Error()
+			return parts[0], security
+		}
+		s.verifySMIME(signature, parts[0], from, received, security)
+		return s.openSMIMEInner(parts[0], from, received, depth, security), security
+
+	case isSMIMEType(mediaType, "pkcs7-mime"):
+		der, err := decodeBody(header, body)
+		if err != nil {
+			return nil, &MessageSecurity{Protocol: SecuritySMIME, Error: err.Error()}
+		}
+		p7, err := pkcs7.Parse(der)
+		if err != nil {
+			return nil, &MessageSecurity{Protocol: SecuritySMIME, Error: fmt.Sprintf("malformed S/MIME message: %v", err)}
+		}
+
+		smimeType := strings.ToLower(params["smime-type"])
+		if smimeType == "signed-data" || (smimeType == "" && len(p7.Signers) > 0) {
+			// Opaque signing: the signed entity is inside the signature
+			security := &MessageSecurity{Protocol: SecuritySMIME, Signed: true}
+			s.verifySMIME(der, nil, from, received, security)
+			inner := canonicalLineEndings(p7.Content)
+			return s.openSMIMEInner(inner, from, received, depth, security), security
+		}
+
+		security := &MessageSecurity{Protocol: SecuritySMIME, Encrypted: true}
+		plaintext := s.decryptSMIME(p7, security)
+		if plaintext == nil {
+			return nil, security
+		}
+		security.Decrypted = true
+		return s.openSMIMEInner(canonicalLineEndings(plaintext), from, received, depth, security), security
+	}
+	return raw, nil
+}
+
+// openSMIMEInner unwraps a nested layer, such as a signed message inside
+// an enveloped one, merging what it says into security
+func (s *MailService) openSMIMEInner(entity []byte, from string, received time.Time, depth int, security *MessageSecurity) []byte {
+	inner, nested := s.openSMIMELayer(entity, from, received, depth-1)
+	if nested == nil {
+		return entity
+	}
+	if nested.Encrypted {
+		security.Encrypted = true
+		security.Decrypted = nested.Decrypted
+		security.LockedKey = nested.LockedKey
+	}
+	if nested.Signed && !security.Signed {
+		security.Signed = true
+		copySigner(security, nested)
+	} else if nested.Error != "" && security.Error == "" {
+		security.Error = nested.Error
+	}
+	return inner
+}
+
+// decodeBody undoes the transfer encoding of a single part body
+func decodeBody(header textproto.Header, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := textproto.WriteHeader(&buf, header); err != nil {
+		return nil, err
+	}
+	buf.Write(body)
+	return partBody(buf.Bytes())
+}
+
+// verifySMIME checks a CMS signature, detached over content when it is not
+// nil, then the signer's certificate against the trusted roots
+func (s *MailService) verifySMIME(der, content []byte, from string, received time.Time, security *MessageSecurity) {
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		security.Signature = SignatureInvalid
+		security.Error = fmt.Sprintf("malformed signature: %v", err)
+		return
+	}
+	if content != nil {
+		p7.Content = content
+	}
+
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		security.Signature = SignatureInvalid
+		security.Error = "signature has no single signer certificate"
+		return
+	}
+	security.SignerFingerprint = certFingerprint(signer)
+	security.SignerName = signer.Subject.CommonName
+	security.SignerIssuer = signer.Issuer.CommonName
+	security.SignerValidUntil = signer.NotAfter.UTC().Format(time.RFC3339)
+	if emails := certEmails(signer); len(emails) > 0 {
+		security.SignerEmail = emails[0]
+	}
+
+	// The signature itself, without any chain
+	if err := p7.Verify(); err != nil {
+		var expired *pkcs7.SigningTimeNotValidError
+		if errors.As(err, &expired) {
+			security.Signature = SignatureExpired
+			security.Error = "signed outside the certificate's validity period"
+			return
+		}
+		security.Signature = SignatureInvalid
+		security.Error = err.Error()
+		return
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range p7.Certificates {
+		intermediates.AddCert(cert)
+	}
+	verify := func(at time.Time) error {
+		_, err := signer.Verify(x509.VerifyOptions{
+			Roots:         s.smime.trustPool(),
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+			CurrentTime:   at,
+		})
+		return err
+	}
+
+	// The chain is judged now. Mail signed before the certificate expired
+	// stays valid, but the signing time is whatever the signer claims, so
+	// it only counts when the server received the message after it.
+	err = verify(time.Now())
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Reason == x509.Expired && !received.IsZero() {
+		var signingTime time.Time
+		if p7.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime, &signingTime) == nil &&
+			!signingTime.After(received.Add(maxClockSkew)) && verify(signingTime) == nil {
+			err = nil
+		}
+	}
+	switch {
+	case err == nil:
+		security.Signature = SignatureValid
+		if from != "" && !certHasEmail(signer, from) {
+			security.Signature = SignatureMismatch
+		}
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		security.Signature = SignatureExpired
+		security.Error = err.Error()
+	default:
+		security.Signature = SignatureUntrusted
+		security.Error = err.Error()
+	}
+}
+
+// decryptSMIME opens CMS enveloped data with an unlocked identity. It
+// returns nil, naming a locked identity to unlock when there is one.
+func (s *MailService) decryptSMIME(p7 *pkcs7.PKCS7, security *MessageSecurity) []byte {
+	unlocked, locked := s.smime.identities()
+	var lastErr error
+	for _, identity := range unlocked {
+		plaintext, err := p7.Decrypt(identity.cert, identity.key)
+		if err == nil {
+			return plaintext
+		}
+		lastErr = err
+	}
+	if len(locked) > 0 {
+		security.LockedKey = locked[0]
+		return nil
+	}
+	if lastErr != nil && len(unlocked) == 1 {
+		security.Error = fmt.Sprintf("failed to decrypt: %v", lastErr)
+	} else {
+		security.Error = "no S/MIME identity can decrypt this message"
+	}
+	return nil
+}
+
+// buildSMIMEMessage renders msg as an S/MIME multipart/signed message,
+// signed with the sender's identity
+func (s *MailService) buildSMIMEMessage(msg *outgoingMessage) ([]byte, error) {
+	identity, err := s.smime.identityFor(msg.From.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	var inner bytes.Buffer
+	if err := writeMessageBody(&inner, mail.Header{}, msg); err != nil {
+		return nil, err
+	}
+	entity := canonicalLineEndings(inner.Bytes())
+
+	signed, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signed.AddSignerChain(identity.cert, identity.key, identity.chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	signed.Detach()
+	signature, err := signed.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	h, err := messageHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	boundary := generateUUID()
+
+	var buf bytes.Buffer
+	h.Set("MIME-Version", "1.0")
+	h.SetContentType("multipart/signed", map[string]string{
+		"protocol": "application/pkcs7-signature",
+		"micalg":   "sha-256",
+		"boundary": boundary,
+	})
+	if err := textproto.WriteHeader(&buf, h.Header.Header); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.Write(entity)
+	fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+	buf.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n")
+	buf.WriteString("Content-Description: S/MIME Cryptographic Signature\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(signature)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
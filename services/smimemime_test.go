@@ -0,0 +1,70 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smallstep/pkcs7"
+)
+
+func TestSMIMESignerNeedsEmailProtection(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	s := &MailService{smime: newSMIMEStore(func() time.Duration { return time.Minute })}
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test CA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	if _, err := s.TrustSMIMECertificates(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		usages []x509.ExtKeyUsage
+		want   string
+	}{
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}, SignatureValid},
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, SignatureUntrusted},
+	}
+	for i, tc := range cases {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{SerialNumber: big.NewInt(int64(i + 2)), Subject: pkix.Name{CommonName: "Alice"},
+			EmailAddresses: []string{"alice@example.com"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+			KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: tc.usages}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+
+		sd, err := pkcs7.NewSignedData([]byte("Content-Type: text/plain\r\n\r\nhello\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		signature, err := sd.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		security := &MessageSecurity{Protocol: SecuritySMIME, Signed: true}
+		s.verifySMIME(signature, nil, "alice@example.com", time.Now(), security)
+		if security.Signature != tc.want {
+			t.Errorf("usages %v: %s (%s), want %s", tc.usages, security.Signature, security.Error, tc.want)
+		}
+	}
+}
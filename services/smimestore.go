@@ -0,0 +1,471 @@
+package services
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// oidEmailAddress is the PKCS#9 emailAddress attribute older certificates
+// carry in their subject instead of a SAN
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// SMIMECertificate describes an S/MIME identity or trusted certificate
+type SMIMECertificate struct {
+	Fingerprint string   `json:"fingerprint"` // SHA-256 of the certificate
+	Subject     string   `json:"subject"`
+	Emails      []string `json:"emails"`
+	Issuer      string   `json:"issuer"`
+	NotBefore   string   `json:"notBefore"`
+	NotAfter    string   `json:"notAfter"`
+	Identity    bool     `json:"identity"` // has a private key
+	Unlocked    bool     `json:"unlocked"`
+	AddedAt     string   `json:"addedAt"`
+}
+
+// smimeIdentity is an imported PKCS#12 file, kept encrypted with its password
+type smimeIdentity struct {
+	Fingerprint string `json:"fingerprint"`
+	Certificate string `json:"certificate"` // base64 DER, readable while locked
+	PKCS12      string `json:"pkcs12"`      // base64
+	AddedAt     string `json:"addedAt"`
+}
+
+// smimeTrusted is a root certificate the user chose to trust
+type smimeTrusted struct {
+	Fingerprint string `json:"fingerprint"`
+	PEM         string `json:"pem"`
+	AddedAt     string `json:"addedAt"`
+}
+
+type smimeData struct {
+	Identities []*smimeIdentity `json:"identities"`
+	Trusted    []*smimeTrusted  `json:"trusted"`
+}
+
+// unlockedIdentity is a decoded identity, held while its password is cached
+type unlockedIdentity struct {
+	key     crypto.PrivateKey
+	cert    *x509.Certificate
+	chain   []*x509.Certificate
+	expires time.Time
+}
+
+// smimeStore persists identities and trust anchors in smime.json and keeps
+// unlocked identities in memory for the passphrase timeout
+type smimeStore struct {
+	file     *ConfigFile
+	data     smimeData
+	unlocked map[string]*unlockedIdentity
+	timeout  func() time.Duration
+	mu       sync.RWMutex
+}
+
+func newSMIMEStore(timeout func() time.Duration) *smimeStore {
+	configDir, err := getUserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
+	}
+
+	store := &smimeStore{
+		file:     NewConfigFile(filepath.Join(configDir, "wmail", "smime.json"), 0600),
+		unlocked: make(map[string]*unlockedIdentity),
+		timeout:  timeout,
+	}
+	if err := store.file.Load(&store.data); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("[SMIME] Failed to load identities: %v\n", err)
+	}
+	return store
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return strings.ToUpper(fmt.Sprintf("%x", sum))
+}
+
+// certEmails returns the addresses a certificate is issued to
+func certEmails(cert *x509.Certificate) []string {
+	var emails []string
+	for _, email := range cert.EmailAddresses {
+		emails = appendUnique(emails, strings.ToLower(email))
+	}
+	for _, name := range cert.Subject.Names {
+		if name.Type.Equal(oidEmailAddress) {
+			if email, ok := name.Value.(string); ok {
+				emails = appendUnique(emails, strings.ToLower(email))
+			}
+		}
+	}
+	return emails
+}
+
+func certHasEmail(cert *x509.Certificate, email string) bool {
+	email = normalizeEmail(email)
+	for _, e := range certEmails(cert) {
+		if e == email {
+			return true
+		}
+	}
+	return false
+}
+
+func describeCertificate(cert *x509.Certificate) *SMIMECertificate {
+	emails := certEmails(cert)
+	if emails == nil {
+		emails = []string{}
+	}
+	return &SMIMECertificate{
+		Fingerprint: certFingerprint(cert),
+		Subject:     cert.Subject.CommonName,
+		Emails:      emails,
+		Issuer:      cert.Issuer.CommonName,
+		NotBefore:   cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:    cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+// decodeIdentity opens a PKCS#12 file
+func decodeIdentity(data []byte, password string) (*unlockedIdentity, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		if err == pkcs12.ErrIncorrectPassword {
+			return nil, fmt.Errorf("wrong password")
+		}
+		return nil, fmt.Errorf("failed to read PKCS#12 file: %w", err)
+	}
+	return &unlockedIdentity{key: key, cert: cert, chain: chain}, nil
+}
+
+// identityCertificate returns the certificate of a stored identity
+func identityCertificate(identity *smimeIdentity) *x509.Certificate {
+	der, err := base64.StdEncoding.DecodeString(identity.Certificate)
+	if err != nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// importIdentity stores a PKCS#12 file and unlocks it
+func (s *smimeStore) importIdentity(data []byte, password string) (*SMIMECertificate, error) {
+	identity, err := decodeIdentity(data, password)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := certFingerprint(identity.cert)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := &smimeIdentity{
+		Fingerprint: fingerprint,
+		Certificate: base64.StdEncoding.EncodeToString(identity.cert.Raw),
+		PKCS12:      base64.StdEncoding.EncodeToString(data),
+		AddedAt:     getCurrentTime(),
+	}
+	next := s.data
+	next.Identities = []*smimeIdentity{stored}
+	for _, existing := range s.data.Identities {
+		if existing.Fingerprint != fingerprint {
+			next.Identities = append(next.Identities, existing)
+		}
+	}
+	if err := s.file.Save(next); err != nil {
+		return nil, err
+	}
+	s.data = next
+
+	identity.expires = time.Now().Add(s.timeout())
+	s.unlocked[fingerprint] = identity
+
+	described := describeCertificate(identity.cert)
+	described.Identity = true
+	described.Unlocked = true
+	described.AddedAt = stored.AddedAt
+	return described, nil
+}
+
+// unlock caches a decoded identity for the passphrase timeout
+func (s *smimeStore) unlock(fingerprint, password string) error {
+	fingerprint = strings.ToUpper(fingerprint)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.data.Identities {
+		if stored.Fingerprint != fingerprint {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(stored.PKCS12)
+		if err != nil {
+			return fmt.Errorf("identity is corrupted: %w", err)
+		}
+		identity, err := decodeIdentity(data, password)
+		if err != nil {
+			return err
+		}
+		identity.expires = time.Now().Add(s.timeout())
+		s.unlocked[fingerprint] = identity
+		return nil
+	}
+	return fmt.Errorf("S/MIME identity not found")
+}
+
+// lock forgets every unlocked identity
+func (s *smimeStore) lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unlocked = make(map[string]*unlockedIdentity)
+}
+
+// cachedIdentity returns an identity whose password is still cached;
+// callers hold mu
+func (s *smimeStore) cachedIdentity(fingerprint string) *unlockedIdentity {
+	identity, ok := s.unlocked[fingerprint]
+	if !ok || time.Now().After(identity.expires) {
+		return nil
+	}
+	return identity
+}
+
+// identities returns the unlocked identities and the fingerprints of the locked ones
+func (s *smimeStore) identities() ([]*unlockedIdentity, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var unlocked []*unlockedIdentity
+	var locked []string
+	for _, stored := range s.data.Identities {
+		if identity := s.cachedIdentity(stored.Fingerprint); identity != nil {
+			unlocked = append(unlocked, identity)
+		} else {
+			locked = append(locked, stored.Fingerprint)
+		}
+	}
+	return unlocked, locked
+}
+
+// identityFor returns the unlocked identity of an address
+func (s *smimeStore) identityFor(email string) (*unlockedIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, stored := range s.data.Identities {
+		if identity := s.cachedIdentity(stored.Fingerprint); identity != nil {
+			if certHasEmail(identity.cert, email) && now.Before(identity.cert.NotAfter) {
+				return identity, nil
+			}
+			continue
+		}
+		if cert := identityCertificate(stored); cert != nil && certHasEmail(cert, email) {
+			return nil, fmt.Errorf("S/MIME identity %s is locked", stored.Fingerprint)
+		}
+	}
+	return nil, fmt.Errorf("no S/MIME identity for %s", email)
+}
+
+// trustPool returns the system roots plus the certificates the user trusts
+func (s *smimeStore) trustPool() *x509.CertPool {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, trusted := range s.data.Trusted {
+		pool.AppendCertsFromPEM([]byte(trusted.PEM))
+	}
+	return pool
+}
+
+// addTrusted adds PEM or DER certificates to the user trust store
+func (s *smimeStore) addTrusted(data string) ([]*SMIMECertificate, error) {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.data
+	next.Trusted = append([]*smimeTrusted(nil), s.data.Trusted...)
+	var added []*SMIMECertificate
+	for _, cert := range certs {
+		fingerprint := certFingerprint(cert)
+		known := false
+		for _, existing := range next.Trusted {
+			known = known || existing.Fingerprint == fingerprint
+		}
+		if known {
+			continue
+		}
+		next.Trusted = append(next.Trusted, &smimeTrusted{
+			Fingerprint: fingerprint,
+			PEM:         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+			AddedAt:     getCurrentTime(),
+		})
+		described := describeCertificate(cert)
+		described.AddedAt = getCurrentTime()
+		added = append(added, described)
+	}
+
+	if err := s.file.Save(next); err != nil {
+		return nil, err
+	}
+	s.data = next
+	return added, nil
+}
+
+// parseCertificates reads PEM certificates, or a single base64 DER one
+func parseCertificates(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+		if err != nil {
+			return nil, fmt.Errorf("no certificates found")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// list describes every identity and trusted certificate
+func (s *smimeStore) list() []*SMIMECertificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []*SMIMECertificate{}
+	for _, stored := range s.data.Identities {
+		var described *SMIMECertificate
+		if identity := s.cachedIdentity(stored.Fingerprint); identity != nil {
+			described = describeCertificate(identity.cert)
+			described.Unlocked = true
+		} else if cert := identityCertificate(stored); cert != nil {
+			described = describeCertificate(cert)
+		} else {
+			described = &SMIMECertificate{Fingerprint: stored.Fingerprint, Emails: []string{}}
+		}
+		described.Identity = true
+		described.AddedAt = stored.AddedAt
+		result = append(result, described)
+	}
+	for _, trusted := range s.data.Trusted {
+		certs, err := parseCertificates(trusted.PEM)
+		if err != nil || len(certs) == 0 {
+			continue
+		}
+		described := describeCertificate(certs[0])
+		described.AddedAt = trusted.AddedAt
+		result = append(result, described)
+	}
+	return result
+}
+
+// remove deletes an identity or trusted certificate
+func (s *smimeStore) remove(fingerprint string) error {
+	fingerprint = strings.ToUpper(fingerprint)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := smimeData{}
+	for _, identity := range s.data.Identities {
+		if identity.Fingerprint != fingerprint {
+			next.Identities = append(next.Identities, identity)
+		}
+	}
+	for _, trusted := range s.data.Trusted {
+		if trusted.Fingerprint != fingerprint {
+			next.Trusted = append(next.Trusted, trusted)
+		}
+	}
+	if len(next.Identities) == len(s.data.Identities) && len(next.Trusted) == len(s.data.Trusted) {
+		return fmt.Errorf("certificate not found")
+	}
+
+	if err := s.file.Save(next); err != nil {
+		return err
+	}
+	s.data = next
+	delete(s.unlocked, fingerprint)
+	return nil
+}
+
+// GetSMIMECertificates lists S/MIME identities and trusted certificates
+func (s *MailService) GetSMIMECertificates() []*SMIMECertificate {
+	return s.smime.list()
+}
+
+// ImportSMIMEIdentity adds a PKCS#12 (.p12/.pfx) file, given base64
+// encoded, and unlocks it with password
+func (s *MailService) ImportSMIMEIdentity(data, password string) (*SMIMECertificate, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#12 data: %w", err)
+	}
+	cert, err := s.smime.importIdentity(raw, password)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[SMIME] Imported identity %s\n", cert.Subject)
+	return cert, nil
+}
+
+// UnlockSMIMEIdentity caches the password of an identity for the
+// configured timeout
+func (s *MailService) UnlockSMIMEIdentity(fingerprint, password string) error {
+	return s.smime.unlock(fingerprint, password)
+}
+
+// LockSMIMEIdentities forgets every cached identity password
+func (s *MailService) LockSMIMEIdentities() {
+	s.smime.lock()
+}
+
+// TrustSMIMECertificates adds root certificates (PEM) to trust for S/MIME
+// on top of the system ones
+func (s *MailService) TrustSMIMECertificates(data string) ([]*SMIMECertificate, error) {
+	return s.smime.addTrusted(data)
+}
+
+// DeleteSMIMECertificate removes an identity or trusted certificate
+func (s *MailService) DeleteSMIMECertificate(fingerprint string) error {
+	return s.smime.remove(fingerprint)
+}
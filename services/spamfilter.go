@@ -0,0 +1,651 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+)
+
+// The spam filter is a token classifier in the style of SpamBayes: token
+// probabilities are smoothed as described by Gary Robinson and combined
+// with Fisher's chi-square method.
+const (
+	spamMinTraining      = 5     // messages of each class needed before scoring
+	spamTextPrefix       = 4096  // bytes of decoded body text read for tokens
+	spamSourcePrefix     = 65536 // bytes of source fetched to score a synced message
+	spamMaxTokens        = 1000  // distinct tokens taken from one message
+	spamMaxClues         = 150   // strongest tokens combined into a score
+	spamMinClueStrength  = 0.1   // distance from 0.5 a token needs to count
+	spamPriorStrength    = 1.0   // weight of the 0.5 prior of a rare token
+	spamMaxExplainClues  = 20    // clues per direction returned by ExplainSpamScore
+	spamMinTokenLength   = 3
+	spamMaxTokenLength   = 24
+	defaultSpamThreshold = 0.9
+	spamHamCutoff        = 0.2 // scores below are reported as ham
+)
+
+// Spam verdicts reported by ExplainSpamScore
+const (
+	SpamVerdictSpam   = "spam"
+	SpamVerdictUnsure = "unsure"
+	SpamVerdictHam    = "ham"
+)
+
+// spamHeaderFields are the headers tokenized besides From and Subject
+var spamHeaderFields = []string{
+	"Content-Type", "Reply-To", "Return-Path", "Message-ID", "X-Mailer", "User-Agent",
+	"List-Unsubscribe", "Precedence", "Received-SPF", "X-Spam-Flag", "X-Spam-Status",
+}
+
+// spamSourceSection fetches what the classifier reads during a sync: the
+// start of the source, decoded like a stored one
+var spamSourceSection = &imap.BodySectionName{
+	Peek:    true,
+	Partial: []int{0, spamSourcePrefix},
+}
+
+// SpamClue is a token that moved a message's spam score
+type SpamClue struct {
+	Token       string  `json:"token"`
+	Probability float64 `json:"probability"` // spam probability of the token
+	SpamCount   int     `json:"spamCount"`   // trained spam messages containing it
+	HamCount    int     `json:"hamCount"`    // trained good messages containing it
+}
+
+// SpamExplanation tells why a message got its spam score
+type SpamExplanation struct {
+	Score float64 `json:"score"` // with the model as trained now
+	// CachedScore is the score given when the message was synced
+	CachedScore *float64    `json:"cachedScore,omitempty"`
+	Verdict     string      `json:"verdict"`
+	SpamTrained int         `json:"spamTrained"`
+	HamTrained  int         `json:"hamTrained"`
+	SpamClues   []*SpamClue `json:"spamClues"` // strongest first
+	HamClues    []*SpamClue `json:"hamClues"`  // strongest first
+}
+
+// spamFeatures is the part of a message the classifier reads
+type spamFeatures struct {
+	from    []Address
+	subject string
+	header  []headerField
+	text    []byte
+}
+
+// spamFeaturesFromRaw reads the features of a message source, which may be
+// cut short. Training and scoring both come through here.
+func spamFeaturesFromRaw(email *Email, raw []byte) *spamFeatures {
+	raw = canonicalLineEndings(raw)
+	fields, _ := rawHeaderFields(raw)
+	return &spamFeatures{from: email.From, subject: email.Subject, header: spamFields(fields), text: spamText(raw)}
+}
+
+// spamFeaturesFromFetch reads the features of a message fetched by a sync
+func spamFeaturesFromFetch(email *Email, msg *imap.Message) *spamFeatures {
+	var raw []byte
+	if r := msg.GetBody(spamSourceSection); r != nil {
+		raw, _ = io.ReadAll(r)
+	}
+	return spamFeaturesFromRaw(email, raw)
+}
+
+// spamText returns the start of the decoded body text. HTML is reduced to
+// its text, keeping the links for their hosts.
+func spamText(raw []byte) []byte {
+	content, err := extractContent(raw)
+	if err != nil {
+		return nil
+	}
+	text := content.Body
+	if content.HTML {
+		text = strings.Join(append(spamURL.FindAllString(text, -1), extractTextBody(text)), "\n")
+	}
+	if len(text) > spamTextPrefix {
+		text = text[:spamTextPrefix]
+	}
+	return []byte(text)
+}
+
+// spamFields keeps the header fields the classifier looks at
+func spamFields(fields []headerField) []headerField {
+	var kept []headerField
+	for _, f := range fields {
+		for _, name := range spamHeaderFields {
+			if f.key == strings.ToLower(name) {
+				kept = append(kept, f)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// spamWords splits text into lower-case words of a useful length
+func spamWords(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '$' && r != '€' && r != '\''
+	}) {
+		w = strings.Trim(w, "'")
+		if n := utf8.RuneCountInString(w); n >= spamMinTokenLength && n <= spamMaxTokenLength {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// spamURL finds the hosts of links in body text
+var spamURL = regexp.MustCompile(`(?i)https?://[^\s"'<>()]+`)
+
+// tokens returns the distinct tokens of a message. Tokens from the
+// envelope and headers carry a prefix so "free" in a subject and in a body
+// are learned separately.
+func (f *spamFeatures) tokens() []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) {
+		if !seen[token] && len(tokens) < spamMaxTokens {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	for _, w := range spamWords(f.subject) {
+		add("subject:" + w)
+	}
+	for _, a := range f.from {
+		add("from:" + strings.ToLower(a.Email))
+		if domain := mailDomain(a.Email); domain != "" {
+			add("from-domain:" + domain)
+		}
+		for _, w := range spamWords(a.Name) {
+			add("from-name:" + w)
+		}
+	}
+	for _, field := range f.header {
+		add("header:" + field.key)
+		value := field.value()
+		switch field.key {
+		case "message-id", "return-path", "reply-to":
+			if domain := mailDomain(strings.Trim(value, "<> ")); domain != "" {
+				add(field.key + ":" + domain)
+			}
+		default:
+			for _, w := range spamWords(value) {
+				add(field.key + ":" + w)
+			}
+		}
+	}
+
+	text := string(f.text)
+	for _, link := range spamURL.FindAllString(text, -1) {
+		if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+			add("url:" + strings.ToLower(u.Hostname()))
+		}
+	}
+	for _, w := range spamWords(text) {
+		add(w)
+	}
+	return tokens
+}
+
+// key identifies a trained message so that training it again, or as the
+// other class, replaces the earlier training
+func (f *spamFeatures) key(messageID string) string {
+	if messageID = strings.Trim(messageID, "<> "); messageID != "" {
+		return messageID
+	}
+	h := sha256.New()
+	for _, a := range f.from {
+		io.WriteString(h, strings.ToLower(a.Email)+"\n")
+	}
+	io.WriteString(h, f.subject+"\n")
+	h.Write(f.text)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// spamProbability is the Robinson-smoothed spam probability of a token seen
+// in spam and ham of the trained messages
+func spamProbability(spam, ham, nSpam, nHam int) float64 {
+	spamRatio := float64(spam) / float64(max(nSpam, 1))
+	hamRatio := float64(ham) / float64(max(nHam, 1))
+	p := 0.5
+	if spamRatio+hamRatio > 0 {
+		p = spamRatio / (spamRatio + hamRatio)
+	}
+	n := float64(spam + ham)
+	return (spamPriorStrength*0.5 + n*p) / (spamPriorStrength + n)
+}
+
+// chi2Q is the probability that a chi-square variable with v (even)
+// degrees of freedom is at least x2
+func chi2Q(x2 float64, v int) float64 {
+	m := x2 / 2
+	term := math.Exp(-m)
+	sum := term
+	for i := 1; i < v/2; i++ {
+		term *= m / float64(i)
+		sum += term
+	}
+	return math.Min(sum, 1)
+}
+
+// combineSpam folds clue probabilities into a score between 0 and 1 with
+// Fisher's method: evidence for spam and for ham are tested separately and
+// a message with neither, or both, lands near 0.5
+func combineSpam(clues []*SpamClue) float64 {
+	if len(clues) == 0 {
+		return 0.5
+	}
+	var lnSpam, lnHam float64
+	for _, c := range clues {
+		lnSpam += math.Log(1 - c.Probability)
+		lnHam += math.Log(c.Probability)
+	}
+	n := 2 * len(clues)
+	spamminess := 1 - chi2Q(-2*lnSpam, n)
+	hamminess := 1 - chi2Q(-2*lnHam, n)
+	return (spamminess - hamminess + 1) / 2
+}
+
+// spamClues returns the tokens far enough from 0.5 to count, strongest first
+func spamClues(tokens []string, counts map[string][2]int, nSpam, nHam int) []*SpamClue {
+	var clues []*SpamClue
+	for _, token := range tokens {
+		c := counts[token]
+		p := spamProbability(c[0], c[1], nSpam, nHam)
+		if math.Abs(p-0.5) < spamMinClueStrength {
+			continue
+		}
+		clues = append(clues, &SpamClue{Token: token, Probability: p, SpamCount: c[0], HamCount: c[1]})
+	}
+	sort.SliceStable(clues, func(i, j int) bool {
+		return math.Abs(clues[i].Probability-0.5) > math.Abs(clues[j].Probability-0.5)
+	})
+	if len(clues) > spamMaxClues {
+		clues = clues[:spamMaxClues]
+	}
+	return clues
+}
+
+func spamVerdict(score, threshold float64) string {
+	switch {
+	case score >= threshold:
+		return SpamVerdictSpam
+	case score < spamHamCutoff:
+		return SpamVerdictHam
+	default:
+		return SpamVerdictUnsure
+	}
+}
+
+// spamScore scores tokens with the stored model; ok is false until enough
+// of both classes has been trained
+func (c *EmailCache) spamScore(tokens []string) (score float64, clues []*SpamClue, ok bool, err error) {
+	nSpam, nHam, err := c.SpamTrainingCounts()
+	if err != nil || nSpam < spamMinTraining || nHam < spamMinTraining {
+		return 0, nil, false, err
+	}
+	counts, err := c.spamTokenCounts(tokens)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	clues = spamClues(tokens, counts, nSpam, nHam)
+	return combineSpam(clues), clues, true, nil
+}
+
+// SpamTrainingCounts returns how many spam and good messages were trained
+func (c *EmailCache) SpamTrainingCounts() (spam, ham int, err error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	err = c.db.QueryRow(`
+		SELECT COALESCE(SUM(is_spam), 0), COUNT(*) - COALESCE(SUM(is_spam), 0) FROM spam_trained
+	`).Scan(&spam, &ham)
+	return spam, ham, err
+}
+
+// spamTokenCounts looks up the spam and ham counts of tokens
+func (c *EmailCache) spamTokenCounts(tokens []string) (map[string][2]int, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	counts := make(map[string][2]int, len(tokens))
+	for start := 0; start < len(tokens); start += maxUIDsPerQuery {
+		end := start + maxUIDsPerQuery
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		chunk := tokens[start:end]
+		args := make([]interface{}, len(chunk))
+		for i, token := range chunk {
+			args[i] = token
+		}
+		rows, err := c.db.Query(`SELECT token, spam_count, ham_count FROM spam_tokens
+			WHERE token IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var token string
+			var spam, ham int
+			if err := rows.Scan(&token, &spam, &ham); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts[token] = [2]int{spam, ham}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// TrainSpam teaches the model one message. A message trained before as
+// the other class is untrained first; training it again as the same class
+// changes nothing.
+func (c *EmailCache) TrainSpam(accountID, key string, isSpam bool, tokens []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasSpam int
+	var oldTokens string
+	err = tx.QueryRow(`SELECT is_spam, tokens FROM spam_trained WHERE account_id = ? AND message_key = ?`,
+		accountID, key).Scan(&wasSpam, &oldTokens)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case (wasSpam == 1) == isSpam:
+		return nil
+	default:
+		if err := countSpamTokens(tx, strings.Split(oldTokens, "\n"), wasSpam == 1, -1); err != nil {
+			return err
+		}
+	}
+
+	if err := countSpamTokens(tx, tokens, isSpam, 1); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO spam_trained (account_id, message_key, is_spam, tokens, trained_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, message_key) DO UPDATE SET
+			is_spam = excluded.is_spam,
+			tokens = excluded.tokens,
+			trained_at = excluded.trained_at
+	`, accountID, key, boolToInt(isSpam), strings.Join(tokens, "\n"), time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// countSpamTokens adds delta to the spam or ham count of tokens
+func countSpamTokens(tx *sql.Tx, tokens []string, isSpam bool, delta int) error {
+	column := "ham_count"
+	if isSpam {
+		column = "spam_count"
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO spam_tokens (token, ` + column + `) VALUES (?, MAX(?, 0))
+		ON CONFLICT(token) DO UPDATE SET ` + column + ` = MAX(` + column + ` + ?, 0)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		if _, err := stmt.Exec(token, delta, delta); err != nil {
+			return err
+		}
+	}
+	if delta < 0 {
+		_, err = tx.Exec(`DELETE FROM spam_tokens WHERE spam_count = 0 AND ham_count = 0`)
+	}
+	return err
+}
+
+// deleteSpamTraining untrains an account's messages; callers hold the lock
+func (c *EmailCache) deleteSpamTraining(accountID string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT is_spam, tokens FROM spam_trained WHERE account_id = ?`, accountID)
+	if err != nil {
+		return err
+	}
+	type trained struct {
+		isSpam bool
+		tokens string
+	}
+	var messages []trained
+	for rows.Next() {
+		var isSpam int
+		var tokens string
+		if err := rows.Scan(&isSpam, &tokens); err != nil {
+			rows.Close()
+			return err
+		}
+		messages = append(messages, trained{isSpam == 1, tokens})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		if err := countSpamTokens(tx, strings.Split(m.tokens, "\n"), m.isSpam, -1); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM spam_trained WHERE account_id = ?`, accountID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// spamScoringAccount returns the account when messages synced into folder
+// should be scored: the model is trained and the folder holds received mail
+func (s *MailService) spamScoringAccount(accountID, folder string) *Account {
+	if s.cache == nil {
+		return nil
+	}
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil {
+		return nil
+	}
+	if role := account.roleOf(folder); role == RoleSent || role == RoleDrafts {
+		return nil
+	}
+	nSpam, nHam, err := s.cache.SpamTrainingCounts()
+	if err != nil || nSpam < spamMinTraining || nHam < spamMinTraining {
+		return nil
+	}
+	return account
+}
+
+// scoreSyncedEmail sets the spam score of a message fetched by a sync
+func (s *MailService) scoreSyncedEmail(email *Email, msg *imap.Message) {
+	score, _, ok, err := s.cache.spamScore(spamFeaturesFromFetch(email, msg).tokens())
+	if err != nil {
+		fmt.Printf("[SpamFilter] Failed to score %s/%d: %v\n", email.Folder, email.UID, err)
+		return
+	}
+	if ok {
+		email.SpamScore = &score
+	}
+}
+
+// moveSpamToJunk moves freshly synced Inbox messages scoring above the
+// threshold to the Junk folder, when the user asked for it, and returns
+// the messages left in place. The move does not train the model.
+func (s *MailService) moveSpamToJunk(account *Account, folder string, emails []*Email) []*Email {
+	settings := s.settings.get()
+	if !settings.SpamAutoMove || account.roleOf(folder) != RoleInbox {
+		return emails
+	}
+	junk := account.folderForRole(RoleJunk)
+	if junk == nil || junk.Name == folder {
+		return emails
+	}
+	var kept []*Email
+	for _, email := range emails {
+		if email.SpamScore == nil || *email.SpamScore < settings.SpamThreshold {
+			kept = append(kept, email)
+			continue
+		}
+		op := &PendingOp{AccountID: account.ID, Folder: folder, UID: email.UID, Op: OpMove, TargetFolder: junk.Name}
+		if err := s.queueOp(op); err != nil {
+			fmt.Printf("[SpamFilter] Failed to move %s/%d to %s: %v\n", folder, email.UID, junk.Name, err)
+			kept = append(kept, email)
+			continue
+		}
+		fmt.Printf("[SpamFilter] Moved %s/%d to %s (score %.2f)\n", folder, email.UID, junk.Name, *email.SpamScore)
+	}
+	return kept
+}
+
+// trainOnMove learns from the user filing a message: moving it to Junk
+// marks it as spam, moving it out of Junk to anywhere but Trash as good
+// mail. It reads the cached copy, so it runs before the move is queued.
+func (s *MailService) trainOnMove(accountID, folder string, uid uint32, targetFolder string) {
+	if s.cache == nil {
+		return
+	}
+	account, err := s.accountService.GetAccount(accountID)
+	if err != nil {
+		return
+	}
+	from, to := account.roleOf(folder), account.roleOf(targetFolder)
+	var isSpam bool
+	switch {
+	case to == RoleJunk && from != RoleJunk:
+		isSpam = true
+	case from == RoleJunk && to != RoleJunk && to != RoleTrash:
+		isSpam = false
+	default:
+		return
+	}
+
+	features, messageID, err := s.cachedSpamFeatures(accountID, folder, uid)
+	if err != nil {
+		fmt.Printf("[SpamFilter] Cannot train on %s/%d: %v\n", folder, uid, err)
+		return
+	}
+	if err := s.cache.TrainSpam(accountID, features.key(messageID), isSpam, features.tokens()); err != nil {
+		fmt.Printf("[SpamFilter] Failed to train on %s/%d: %v\n", folder, uid, err)
+		return
+	}
+	fmt.Printf("[SpamFilter] Trained %s/%d as spam=%v\n", folder, uid, isSpam)
+}
+
+// cachedSpamFeatures reads the features of a cached message, from its
+// stored source when there is one and its cached body otherwise
+func (s *MailService) cachedSpamFeatures(accountID, folder string, uid uint32) (*spamFeatures, string, error) {
+	email, err := s.cache.GetCachedEmailByUID(accountID, folder, uid)
+	if err != nil {
+		return nil, "", err
+	}
+	if email == nil {
+		return nil, "", fmt.Errorf("message is not cached")
+	}
+	if raw, err := s.cache.GetRawMessage(accountID, folder, uid); err == nil && len(raw) > 0 {
+		return spamFeaturesFromRaw(email, raw), email.MessageID, nil
+	}
+	text := email.Body
+	if len(text) > spamTextPrefix {
+		text = text[:spamTextPrefix]
+	}
+	return &spamFeatures{from: email.From, subject: email.Subject, text: []byte(text)}, email.MessageID, nil
+}
+
+// ExplainSpamScore scores a message with the current model and lists the
+// tokens that pushed the score towards spam and towards good mail
+func (s *MailService) ExplainSpamScore(accountID, folder string, uid uint32) (*SpamExplanation, error) {
+	if s.cache == nil {
+		return nil, fmt.Errorf("cache is not available")
+	}
+	email, err := s.cache.GetCachedEmailByUID(accountID, folder, uid)
+	if err != nil {
+		return nil, err
+	}
+	if email == nil {
+		return nil, fmt.Errorf("message is not cached")
+	}
+
+	// Score the same header fields and body prefix a sync reads
+	raw, err := s.cache.GetRawMessage(accountID, folder, uid)
+	if err != nil || len(raw) == 0 {
+		if _, raw, err = s.fetchRawMessage(accountID, folder, uid); err != nil {
+			return nil, fmt.Errorf("failed to fetch message: %w", err)
+		}
+	}
+	tokens := spamFeaturesFromRaw(email, raw).tokens()
+
+	nSpam, nHam, err := s.cache.SpamTrainingCounts()
+	if err != nil {
+		return nil, err
+	}
+	explanation := &SpamExplanation{
+		CachedScore: email.SpamScore,
+		SpamTrained: nSpam,
+		HamTrained:  nHam,
+		SpamClues:   []*SpamClue{},
+		HamClues:    []*SpamClue{},
+	}
+	score, clues, ok, err := s.cache.spamScore(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("the spam filter needs at least %d spam and %d good messages moved in and out of Junk (has %d and %d)",
+			spamMinTraining, spamMinTraining, nSpam, nHam)
+	}
+
+	explanation.Score = score
+	explanation.Verdict = spamVerdict(score, s.settings.get().SpamThreshold)
+	for _, clue := range clues {
+		if clue.Probability > 0.5 && len(explanation.SpamClues) < spamMaxExplainClues {
+			explanation.SpamClues = append(explanation.SpamClues, clue)
+		} else if clue.Probability < 0.5 && len(explanation.HamClues) < spamMaxExplainClues {
+			explanation.HamClues = append(explanation.HamClues, clue)
+		}
+	}
+	return explanation, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// spamMessage is a multipart message whose words only appear once decoded
+const spamMessage = "From: Shop <deals@shop.example>\r\n" +
+	"Subject: Offer\r\n" +
+	"X-Mailer: BulkSender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"b\"\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"Q2hlYXAgcGlsbHMsIGxpbWl0ZWQgb2ZmZXIh\r\n" + // "Cheap pills, limited offer!"
+	"--b\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p>Cheap =\r\npills</p>\r\n" +
+	"--b--\r\n"
+
+func TestSpamTokensComeFromDecodedText(t *testing.T) {
+	email := &Email{From: []Address{{Name: "Shop", Email: "deals@shop.example"}}, Subject: "Offer"}
+	trained := spamFeaturesFromRaw(email, []byte(spamMessage)).tokens()
+
+	joined := " " + strings.Join(trained, " ") + " "
+	for _, want := range []string{" cheap ", " pills ", " limited ", " x-mailer:bulksender "} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("token %q missing from %v", strings.TrimSpace(want), trained)
+		}
+	}
+	if strings.Contains(joined, "q2hlyxa") || strings.Contains(joined, " content-transfer-encoding ") {
+		t.Errorf("undecoded body tokenized: %v", trained)
+	}
+
+	// A sync scores from the fetched source the same way
+	msg := imap.NewMessage(1, []imap.FetchItem{spamSourceSection.FetchItem()})
+	section, _ := imap.ParseBodySectionName("BODY[]<0>")
+	msg.Body = map[*imap.BodySectionName]imap.Literal{section: bytes.NewBufferString(spamMessage)}
+	scored := spamFeaturesFromFetch(email, msg).tokens()
+	if strings.Join(scored, " ") != strings.Join(trained, " ") {
+		t.Errorf("scoring tokens %v differ from training tokens %v", scored, trained)
+	}
+}
+
+func TestSpamTextOfTruncatedSource(t *testing.T) {
+	// A fetched prefix can end inside a part, without the closing boundary
+	truncated := spamMessage[:strings.Index(spamMessage, "<p>")+5]
+	done := make(chan []byte)
+	go func() { done <- spamText(canonicalLineEndings([]byte(truncated))) }()
+	select {
+	case text := <-done:
+		if !strings.Contains(string(text), "Cheap pills") {
+			t.Errorf("text %q", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading a truncated message does not finish")
+	}
+}
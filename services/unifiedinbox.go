@@ -0,0 +1,259 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// UnifiedEmail is a message in a unified view together with its account
+type UnifiedEmail struct {
+	Email        *Email `json:"email"`
+	AccountName  string `json:"accountName"`
+	AccountEmail string `json:"accountEmail"`
+}
+
+// UnifiedEmailPage is one page of a unified view
+type UnifiedEmailPage struct {
+	Role            string          `json:"role"`
+	Emails          []*UnifiedEmail `json:"emails"`
+	Total           int             `json:"total"`
+	HasMore         bool            `json:"hasMore"`
+	NextCursor      string          `json:"nextCursor"` // pass back to get the following page
+	Unread          int             `json:"unread"`
+	UnreadByAccount map[string]int  `json:"unreadByAccount"`
+}
+
+// unifiedRoles are the views offered across accounts
+var unifiedRoles = []string{RoleInbox, RoleSent, RoleDrafts, RoleFlagged}
+
+// mailboxRef identifies one folder of one account
+type mailboxRef struct {
+	accountID string
+	folder    string
+}
+
+// unifiedCursor points just past the last message of a unified page. Beside
+// the date it carries account and folder, since UIDs only order messages
+// within one folder.
+type unifiedCursor struct {
+	sortBy    string
+	date      int64
+	accountID string
+	folder    string
+	uid       uint32
+}
+
+func (c unifiedCursor) String() string {
+	return fmt.Sprintf("%s:%d:%s:%s:%d", c.sortBy, c.date, url.QueryEscape(c.accountID), url.QueryEscape(c.folder), c.uid)
+}
+
+// parseUnifiedCursor decodes a cursor; an empty string means the first page
+func parseUnifiedCursor(s string) (*unifiedCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 5 || !validSortBy(parts[0]) {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	accountID, err := url.QueryUnescape(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	folder, err := url.QueryUnescape(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	n, err := strconv.ParseUint(parts[4], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %q", s)
+	}
+	return &unifiedCursor{sortBy: parts[0], date: ts, accountID: accountID, folder: folder, uid: uint32(n)}, nil
+}
+
+func unifiedCursorOf(email *Email, sortBy string) unifiedCursor {
+	c := cursorOf(email, sortBy)
+	return unifiedCursor{sortBy: sortBy, date: c.date, accountID: email.AccountID, folder: email.Folder, uid: email.UID}
+}
+
+// GetUnifiedEmails merges a folder role (inbox, sent, drafts or flagged)
+// across every account, newest first, from the cache. Pages follow cursor,
+// which is empty for the first page.
+func (s *MailService) GetUnifiedEmails(role, cursor string, pageSize int) (*UnifiedEmailPage, error) {
+	if s.cache == nil {
+		return nil, fmt.Errorf("cache is not available")
+	}
+	role = strings.ToLower(role)
+	if !isUnifiedRole(role) {
+		return nil, fmt.Errorf("unsupported unified view: %s", role)
+	}
+	after, err := parseUnifiedCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	result := &UnifiedEmailPage{
+		Role:            role,
+		Emails:          []*UnifiedEmail{},
+		UnreadByAccount: make(map[string]int),
+	}
+
+	accounts := make(map[string]*Account)
+	var folders []mailboxRef
+	for _, acc := range s.accountService.GetAccounts() {
+		accounts[acc.ID] = acc
+		folders = append(folders, unifiedFolders(acc, role)...)
+
+		unread := unifiedUnread(acc, role)
+		result.UnreadByAccount[acc.ID] = unread
+		result.Unread += unread
+	}
+	if len(folders) == 0 {
+		return result, nil
+	}
+
+	sortBy := s.settings.get().SortBy
+	if after != nil {
+		sortBy = after.sortBy
+	}
+	// One extra row tells whether another page exists
+	emails, total, err := s.cache.GetEmailsAcrossFolders(folders, role == RoleFlagged, sortBy, after, pageSize+1)
+	if err != nil {
+		return nil, err
+	}
+	result.Total = total
+	if len(emails) > pageSize {
+		emails = emails[:pageSize]
+		result.HasMore = true
+		result.NextCursor = unifiedCursorOf(emails[len(emails)-1], sortBy).String()
+	}
+
+	for _, email := range emails {
+		item := &UnifiedEmail{Email: email}
+		if acc, ok := accounts[email.AccountID]; ok {
+			item.AccountName = acc.Name
+			item.AccountEmail = acc.Email
+		}
+		result.Emails = append(result.Emails, item)
+	}
+
+	return result, nil
+}
+
+// GetUnifiedUnreadCounts returns the unread count of every unified view,
+// keyed by role, as last reported by the servers
+func (s *MailService) GetUnifiedUnreadCounts() map[string]int {
+	counts := make(map[string]int)
+	accounts := s.accountService.GetAccounts()
+	for _, role := range unifiedRoles {
+		for _, acc := range accounts {
+			counts[role] += unifiedUnread(acc, role)
+		}
+	}
+	return counts
+}
+
+func isUnifiedRole(role string) bool {
+	for _, r := range unifiedRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// unifiedFolders returns the folders of an account that feed a unified view.
+// Flagged mail is gathered from every real folder; virtual folders such as
+// All Mail or Starred would only add duplicates.
+func unifiedFolders(acc *Account, role string) []mailboxRef {
+	if role != RoleFlagged {
+		if f := acc.folderForRole(role); f != nil {
+			return []mailboxRef{{accountID: acc.ID, folder: f.Name}}
+		}
+		return nil
+	}
+
+	var refs []mailboxRef
+	for _, f := range acc.Folders {
+		r := f.Role
+		if r == "" {
+			r = guessFolderRole(f.Name, "/")
+		}
+		switch r {
+		case RoleTrash, RoleJunk, RoleAll, RoleFlagged:
+			continue
+		}
+		refs = append(refs, mailboxRef{accountID: acc.ID, folder: f.Name})
+	}
+	return refs
+}
+
+// unifiedUnread returns an account's unread count for a unified view
+func unifiedUnread(acc *Account, role string) int {
+	if f := acc.folderForRole(role); f != nil {
+		return f.Unread
+	}
+	return 0
+}
+
+// GetEmailsAcrossFolders returns cached emails from several folders merged
+// newest first and following cursor, along with how many there are in total.
+// Ties on date are broken by account, folder and UID so pages never overlap.
+func (c *EmailCache) GetEmailsAcrossFolders(folders []mailboxRef, starredOnly bool, sortBy string, cursor *unifiedCursor, limit int) ([]*Email, int, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	clauses := make([]string, len(folders))
+	args := make([]interface{}, 0, len(folders)*2)
+	for i, f := range folders {
+		clauses[i] = `(account_id = ? AND folder = ?)`
+		args = append(args, f.accountID, f.folder)
+	}
+	where := `(` + strings.Join(clauses, ` OR `) + `)`
+	if starredOnly {
+		where += ` AND is_starred = 1`
+	}
+
+	var total int
+	if err := c.db.QueryRow(`SELECT COUNT(*) FROM emails WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + emailColumns + `
+		FROM emails
+		WHERE ` + where
+	column := sortColumn(sortBy)
+	if cursor != nil {
+		query += ` AND (` + column + ` < ? OR (` + column + ` = ? AND (account_id > ? OR (account_id = ? AND (folder > ? OR (folder = ? AND uid < ?))))))`
+		args = append(args, cursor.date, cursor.date, cursor.accountID, cursor.accountID, cursor.folder, cursor.folder, cursor.uid)
+	}
+	query += `
+		ORDER BY ` + column + ` DESC, account_id, folder, uid DESC
+		LIMIT ?`
+
+	rows, err := c.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var emails []*Email
+	for rows.Next() {
+		email, err := scanEmail(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, total, rows.Err()
+}
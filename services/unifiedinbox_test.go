@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newUnifiedTestService(t *testing.T) *MailService {
+	t.Helper()
+	inbox := []Folder{{Name: "INBOX", Role: RoleInbox}}
+	accounts := &MailAccountService{accounts: map[string]*Account{
+		"a": {ID: "a", Email: "a@example.org", Folders: inbox},
+		"b": {ID: "b", Email: "b@example.org", Folders: inbox},
+	}}
+	return &MailService{
+		accountService: accounts,
+		cache:          newTestCache(t),
+		settings:       &mailSettingsStore{settings: defaultMailSettings()},
+	}
+}
+
+// cacheAt caches one inbox message per "account/uid" key, received at the given hour
+func cacheAt(t *testing.T, cache *EmailCache, received map[string]int) {
+	t.Helper()
+	var emails []*Email
+	for key, hour := range received {
+		var accountID string
+		var uid uint32
+		if _, err := fmt.Sscanf(key, "%1s/%d", &accountID, &uid); err != nil {
+			t.Fatal(err)
+		}
+		date := fmt.Sprintf("2024-01-01T%02d:00:00Z", hour)
+		emails = append(emails, &Email{ID: generateUUID(), AccountID: accountID, Folder: "INBOX", UID: uid,
+			Date: date, InternalDate: date, CreatedAt: getCurrentTime()})
+	}
+	if err := cache.CacheEmails(emails); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func unifiedKeys(page *UnifiedEmailPage) []string {
+	var keys []string
+	for _, item := range page.Emails {
+		keys = append(keys, fmt.Sprintf("%s/%d", item.Email.AccountID, item.Email.UID))
+	}
+	return keys
+}
+
+func TestUnifiedInboxMergeOrder(t *testing.T) {
+	s := newUnifiedTestService(t)
+	cacheAt(t, s.cache, map[string]int{"a/1": 1, "a/2": 3, "b/1": 2, "b/5": 3})
+
+	page, err := s.GetUnifiedEmails(RoleInbox, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first; the tie at 03:00 is broken by account
+	want := []string{"a/2", "b/5", "b/1", "a/1"}
+	if got := unifiedKeys(page); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+	if page.Total != 4 || page.HasMore || page.NextCursor != "" {
+		t.Errorf("total %d, hasMore %v, cursor %q", page.Total, page.HasMore, page.NextCursor)
+	}
+	if page.Emails[0].AccountEmail != "a@example.org" {
+		t.Errorf("account email %q", page.Emails[0].AccountEmail)
+	}
+}
+
+func TestUnifiedInboxPageBoundaryIsStable(t *testing.T) {
+	s := newUnifiedTestService(t)
+	cacheAt(t, s.cache, map[string]int{"a/1": 1, "a/2": 3, "b/1": 2, "b/5": 3})
+
+	first, err := s.GetUnifiedEmails(RoleInbox, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unifiedKeys(first); fmt.Sprint(got) != "[a/2 b/5]" || !first.HasMore {
+		t.Fatalf("first page %v, hasMore %v", got, first.HasMore)
+	}
+
+	// New mail arriving between pages must not shift the next one
+	cacheAt(t, s.cache, map[string]int{"b/6": 9, "a/3": 4})
+
+	second, err := s.GetUnifiedEmails(RoleInbox, first.NextCursor, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unifiedKeys(second); fmt.Sprint(got) != "[b/1 a/1]" || second.HasMore {
+		t.Errorf("second page %v, hasMore %v", got, second.HasMore)
+	}
+}
+
+func TestUnifiedCursorRoundTrip(t *testing.T) {
+	want := unifiedCursor{sortBy: SortBySent, date: 1700000000, accountID: "a:1", folder: "Archive:2024/Q1", uid: 42}
+	got, err := parseUnifiedCursor(want.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("round trip gave %+v, want %+v", *got, want)
+	}
+	for _, bad := range []string{"received:1:a:INBOX", "newest:1:a:INBOX:2", "received:x:a:INBOX:2", "received:1:%zz:INBOX:2"} {
+		if _, err := parseUnifiedCursor(bad); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
@@ -2,14 +2,37 @@ package services
 
 import (
 	"crypto/tls"
-	"fmt"
+	"net"
+	"net/smtp"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/emersion/go-imap/client"
 	"github.com/google/uuid"
 )
 
+// dialTimeout bounds how long connecting to a mail server may take
+const dialTimeout = 15 * time.Second
+
+// eventEmitter holds the function that sends events to the frontend. Main
+// wires it to the Wails event bus only after the services have started their
+// background goroutines, so it is swapped atomically.
+var eventEmitter atomic.Value
+
+// emitEvent sends an event to the frontend, dropping it until an emitter is set
+func emitEvent(name string, data interface{}) {
+	if emit, ok := eventEmitter.Load().(func(string, interface{})); ok {
+		emit(name, data)
+	}
+}
+
+// SetEventEmitter sets the function used to send events to the frontend
+func SetEventEmitter(emit func(name string, data interface{})) {
+	eventEmitter.Store(emit)
+}
+
 // generateUUID generates a unique ID
 func generateUUID() string {
 	return uuid.New().String()
@@ -20,6 +43,23 @@ func getCurrentTime() string {
 	return time.Now().Format(time.RFC3339)
 }
 
+// unixFromRFC3339 converts an RFC3339 time to a unix timestamp, 0 if unset or invalid
+func unixFromRFC3339(s string) int64 {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// rfc3339FromUnix formats a unix timestamp in UTC, "" for 0
+func rfc3339FromUnix(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
 // getUserConfigDir returns the user config directory
 func getUserConfigDir() (string, error) {
 	configDir, err := os.UserConfigDir()
@@ -31,30 +71,114 @@ func getUserConfigDir() (string, error) {
 
 // ConnectIMAP connects to an IMAP server
 func ConnectIMAP(account *Account) (*client.Client, error) {
-	var c *client.Client
-	var err error
+	c, err := dialIMAP(account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authenticateIMAP(c, account); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// dialIMAP opens an unauthenticated IMAP connection
+func dialIMAP(account *Account) (*client.Client, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	addr := net.JoinHostPort(account.IMAPHost, strconv.Itoa(account.IMAPPort))
 
 	if account.IMAPUseSSL {
-		c, err = client.DialTLS(
-			fmt.Sprintf("%s:%d", account.IMAPHost, account.IMAPPort),
-			&tls.Config{InsecureSkipVerify: true},
-		)
-	} else {
-		c, err = client.Dial(fmt.Sprintf("%s:%d", account.IMAPHost, account.IMAPPort))
+		return client.DialWithDialerTLS(dialer, addr, &tls.Config{InsecureSkipVerify: true})
 	}
+	return client.DialWithDialer(dialer, addr)
+}
 
+// authenticateIMAP logs in with a password or the account's SASL mechanism
+func authenticateIMAP(c *client.Client, account *Account) error {
+	auth, err := saslClientFor(account, account.IMAPHost, account.IMAPPort)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if auth != nil {
+		return c.Authenticate(auth)
 	}
 
 	username := account.Username
 	if username == "" {
 		username = account.Email
 	}
-	if err := c.Login(username, account.Password); err != nil {
+	return c.Login(username, account.Password)
+}
+
+// ConnectSMTP connects and authenticates to an SMTP server
+func ConnectSMTP(account *Account) (*smtp.Client, error) {
+	c, err := dialSMTP(account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authenticateSMTP(c, account); err != nil {
 		c.Close()
 		return nil, err
 	}
 
 	return c, nil
 }
+
+// dialSMTP opens an unauthenticated SMTP connection, upgrading with STARTTLS when offered
+func dialSMTP(account *Account) (*smtp.Client, error) {
+	addr := net.JoinHostPort(account.SMTPHost, strconv.Itoa(account.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: account.SMTPHost, InsecureSkipVerify: true}
+
+	if account.SMTPUseSSL {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		c, err := smtp.NewClient(conn, account.SMTPHost)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c, err := smtp.NewClient(conn, account.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// authenticateSMTP authenticates with a password or the account's SASL mechanism
+func authenticateSMTP(c *smtp.Client, account *Account) error {
+	auth, err := saslClientFor(account, account.SMTPHost, account.SMTPPort)
+	if err != nil {
+		return err
+	}
+	if auth != nil {
+		return c.Auth(&smtpSASLAuth{client: auth})
+	}
+
+	if ok, _ := c.Extension("AUTH"); !ok {
+		return nil
+	}
+	username := account.Username
+	if username == "" {
+		username = account.Email
+	}
+	return c.Auth(smtp.PlainAuth("", username, account.Password, account.SMTPHost))
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// vCard versions supported for import and export
+const (
+	VCardVersion3 = "3.0"
+	VCardVersion4 = "4.0"
+)
+
+// vCard is the subset of a vCard (RFC 2426 / RFC 6350) the address book understands
+type vCard struct {
+	UID          string
+	Name         string
+	Organization string
+	Notes        string
+	Emails       []string
+	Phones       []string
+	Categories   []string
+}
+
+// parseVCards reads every vCard in data. Cards without a name or an email
+// address are skipped since they are useless to the address book.
+func parseVCards(data string) ([]*vCard, error) {
+	var cards []*vCard
+	var card *vCard
+	var structuredName string
+
+	for n, raw := range unfoldContentLines(data) {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		line, err := parseContentLine(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		switch line.name {
+		case "BEGIN":
+			if strings.EqualFold(line.value, "VCARD") {
+				card = &vCard{}
+				structuredName = ""
+			}
+			continue
+		case "END":
+			if strings.EqualFold(line.value, "VCARD") && card != nil {
+				if card.Name == "" {
+					card.Name = nameFromStructured(structuredName)
+				}
+				if card.Name != "" || len(card.Emails) > 0 {
+					cards = append(cards, card)
+				}
+				card = nil
+			}
+			continue
+		}
+		if card == nil {
+			continue
+		}
+
+		switch line.name {
+		case "UID":
+			card.UID = line.value
+		case "FN":
+			card.Name = unescapeText(line.value)
+		case "N":
+			structuredName = line.value
+		case "ORG":
+			card.Organization = unescapeText(splitContentValue(line.value, ';')[0])
+		case "NOTE":
+			card.Notes = unescapeText(line.value)
+		case "EMAIL":
+			if email := strings.TrimPrefix(unescapeText(line.value), "mailto:"); email != "" {
+				card.Emails = append(card.Emails, email)
+			}
+		case "TEL":
+			if phone := strings.TrimPrefix(unescapeText(line.value), "tel:"); phone != "" {
+				card.Phones = append(card.Phones, phone)
+			}
+		case "CATEGORIES":
+			for _, category := range splitContentValue(line.value, ',') {
+				if category = strings.TrimSpace(unescapeText(category)); category != "" {
+					card.Categories = append(card.Categories, category)
+				}
+			}
+		}
+	}
+
+	return cards, nil
+}
+
+// nameFromStructured turns N (Family;Given;Additional;Prefix;Suffix) into a display name
+func nameFromStructured(n string) string {
+	parts := splitContentValue(n, ';')
+	for len(parts) < 5 {
+		parts = append(parts, "")
+	}
+	var words []string
+	for _, i := range []int{3, 1, 2, 0, 4} {
+		if w := strings.TrimSpace(unescapeText(parts[i])); w != "" {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// structuredFromName guesses N from a display name: the last word is the family name
+func structuredFromName(name string) string {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return ";;;;"
+	}
+	family := words[len(words)-1]
+	given := strings.Join(words[:len(words)-1], " ")
+	return escapeText(family) + ";" + escapeText(given) + ";;;"
+}
+
+// formatVCard writes one card in the requested version
+func formatVCard(b *strings.Builder, card *vCard, version string) {
+	writeContentLine(b, "BEGIN:VCARD")
+	writeContentLine(b, "VERSION:"+version)
+	writeContentLine(b, "UID:"+card.UID)
+	writeVCardProperties(b, card, version, nil)
+	writeContentLine(b, "END:VCARD")
+}
+
+// writeVCardProperties writes the properties the address book manages.
+// A non-nil only restricts it to those property names.
+func writeVCardProperties(b *strings.Builder, card *vCard, version string, only map[string]bool) {
+	include := func(name string) bool {
+		return only == nil || only[name]
+	}
+
+	if include("FN") {
+		writeContentLine(b, "FN:"+escapeText(card.Name))
+	}
+	if include("N") {
+		writeContentLine(b, "N:"+structuredFromName(card.Name))
+	}
+	if include("ORG") && card.Organization != "" {
+		writeContentLine(b, "ORG:"+escapeText(card.Organization))
+	}
+	if include("EMAIL") {
+		for _, email := range card.Emails {
+			if version == VCardVersion3 {
+				writeContentLine(b, "EMAIL;TYPE=INTERNET:"+escapeText(email))
+			} else {
+				writeContentLine(b, "EMAIL:"+escapeText(email))
+			}
+		}
+	}
+	if include("TEL") {
+		for _, phone := range card.Phones {
+			if version == VCardVersion3 {
+				writeContentLine(b, "TEL:"+escapeText(phone))
+			} else {
+				writeContentLine(b, "TEL;VALUE=uri:tel:"+strings.Join(strings.Fields(phone), ""))
+			}
+		}
+	}
+	if include("CATEGORIES") && len(card.Categories) > 0 {
+		escaped := make([]string, len(card.Categories))
+		for i, c := range card.Categories {
+			escaped[i] = escapeText(c)
+		}
+		writeContentLine(b, "CATEGORIES:"+strings.Join(escaped, ","))
+	}
+	if include("NOTE") && card.Notes != "" {
+		writeContentLine(b, "NOTE:"+escapeText(card.Notes))
+	}
+}
+
+// updateVCard rewrites the properties that changed in card onto raw, a card
+// as the server sent it, so properties the address book does not understand
+// (photos, postal addresses, ...) survive the round trip
+func updateVCard(raw string, card *vCard) string {
+	var b strings.Builder
+	cards, err := parseVCards(raw)
+	if err != nil || len(cards) == 0 {
+		formatVCard(&b, card, VCardVersion3)
+		return b.String()
+	}
+	changed := changedVCardProperties(cards[0], card)
+
+	version := VCardVersion3
+	for _, raw := range unfoldContentLines(raw) {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		line, err := parseContentLine(raw)
+		if err != nil {
+			writeContentLine(&b, raw)
+			continue
+		}
+		switch {
+		case line.name == "VERSION":
+			version = strings.TrimSpace(line.value)
+		case line.name == "END" && strings.EqualFold(line.value, "VCARD"):
+			writeVCardProperties(&b, card, version, changed)
+		case changed[line.name]:
+			continue
+		}
+		writeContentLine(&b, raw)
+	}
+	return b.String()
+}
+
+// changedVCardProperties maps the managed property names to whether they
+// differ between two cards
+func changedVCardProperties(old, card *vCard) map[string]bool {
+	return map[string]bool{
+		"FN":         old.Name != card.Name,
+		"N":          old.Name != card.Name,
+		"ORG":        old.Organization != card.Organization,
+		"NOTE":       old.Notes != card.Notes,
+		"EMAIL":      !sameStrings(old.Emails, card.Emails),
+		"TEL":        !sameStrings(old.Phones, card.Phones),
+		"CATEGORIES": !sameStringSet(old.Categories, card.Categories),
+	}
+}
+
+// sameStringSet reports whether two lists hold the same strings in any order, ignoring case
+func sameStringSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[strings.ToLower(s)]++
+	}
+	for _, s := range b {
+		counts[strings.ToLower(s)]--
+		if counts[strings.ToLower(s)] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sameStrings reports whether two lists are equal, ignoring case
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}